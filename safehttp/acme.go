@@ -0,0 +1,90 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig configures a Server to obtain and renew its TLS certificates
+// from a certificate authority supporting the ACME protocol, such as Let's
+// Encrypt. Certificates are obtained on the first TLS handshake for each host
+// and renewed before they expire.
+//
+// The TLS-ALPN-01 challenge is always enabled. The HTTP-01 challenge requires
+// Server.RedirectAddr to be set.
+type ACMEConfig struct {
+	// Hosts are the host names the server obtains certificates for. TLS
+	// handshakes for other host names fail. It must not be empty.
+	Hosts []string
+
+	// Cache stores the certificates and the account key, so that they survive
+	// restarts of the server and the certificate authority's rate limits are
+	// not hit. Use autocert.DirCache to store them in a directory. It must not
+	// be nil.
+	Cache autocert.Cache
+
+	// AcceptTOS must be set to agree to the terms of service of the
+	// certificate authority.
+	AcceptTOS bool
+
+	// Email is the optional contact email address of the account, used by
+	// the certificate authority to notify about problems with certificates.
+	Email string
+
+	// DirectoryURL is the ACME directory URL of the certificate authority. If
+	// empty, the Let's Encrypt production directory is used.
+	DirectoryURL string
+}
+
+// manager returns an autocert.Manager for the configuration.
+func (c *ACMEConfig) manager() (*autocert.Manager, error) {
+	if len(c.Hosts) == 0 {
+		return nil, errors.New("ACME requires at least one host")
+	}
+	if c.Cache == nil {
+		return nil, errors.New("ACME requires a certificate cache")
+	}
+	if !c.AcceptTOS {
+		return nil, errors.New("ACME requires accepting the terms of service of the certificate authority")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      c.Cache,
+		HostPolicy: autocert.HostWhitelist(c.Hosts...),
+		Email:      c.Email,
+	}
+	if c.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: c.DirectoryURL}
+	}
+	return m, nil
+}
+
+// configure makes cfg get its certificates from m.
+func configureACME(cfg *tls.Config, m *autocert.Manager) error {
+	if len(cfg.Certificates) > 0 || cfg.GetCertificate != nil {
+		return errors.New("TLSConfig must not provide certificates when ACME is enabled")
+	}
+	cfg.GetCertificate = m.GetCertificate
+	if len(cfg.NextProtos) == 0 {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+	cfg.NextProtos = append(cfg.NextProtos, acme.ALPNProto)
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestServerACME(t *testing.T) {
+	s := Server{
+		Mux: hstsMux(),
+		ACME: &ACMEConfig{
+			Hosts:     []string{"example.com"},
+			Cache:     autocert.DirCache(t.TempDir()),
+			AcceptTOS: true,
+		},
+		RedirectAddr: ":http",
+	}
+	if err := s.buildStd(); err != nil {
+		t.Fatalf("s.buildStd(): got error %v", err)
+	}
+	cfg := s.srv.TLSConfig
+	if cfg.GetCertificate == nil {
+		t.Error("TLSConfig.GetCertificate: got nil, want ACME certificates")
+	}
+	if diff := cmp.Diff([]string{"h2", "http/1.1", "acme-tls/1"}, cfg.NextProtos); diff != "" {
+		t.Errorf("NextProtos mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := cfg.GetCertificate(&tls.ClientHelloInfo{ServerName: "evil.com"}); err == nil {
+		t.Error("GetCertificate for a host not in Hosts: got nil error, want error")
+	}
+
+	if s.redirectSrv == nil {
+		t.Fatal("redirect server: got nil, want server")
+	}
+	rec := httptest.NewRecorder()
+	s.redirectSrv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/.well-known/acme-challenge/token", nil))
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("unknown ACME challenge status: got %d, want %d", got, want)
+	}
+	rec = httptest.NewRecorder()
+	s.redirectSrv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/admin?x=y", nil))
+	if got, want := rec.Code, http.StatusMovedPermanently; got != want {
+		t.Errorf("redirect server status: got %d, want %d", got, want)
+	}
+}
+
+func TestServerACMEInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		acme ACMEConfig
+		tls  *tls.Config
+	}{
+		{
+			name: "No hosts",
+			acme: ACMEConfig{Cache: autocert.DirCache("certs"), AcceptTOS: true},
+		},
+		{
+			name: "No cache",
+			acme: ACMEConfig{Hosts: []string{"example.com"}, AcceptTOS: true},
+		},
+		{
+			name: "TOS not accepted",
+			acme: ACMEConfig{Hosts: []string{"example.com"}, Cache: autocert.DirCache("certs")},
+		},
+		{
+			name: "Certificates",
+			acme: ACMEConfig{Hosts: []string{"example.com"}, Cache: autocert.DirCache("certs"), AcceptTOS: true},
+			tls:  &tls.Config{Certificates: []tls.Certificate{{}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Server{
+				Mux:       NewServeMuxConfig(nil).Mux(),
+				ACME:      &tt.acme,
+				TLSConfig: tt.tls,
+			}
+			if err := s.buildStd(); err == nil {
+				t.Error("s.buildStd(): got nil error, want error")
+			}
+		})
+	}
+}
+
+func TestServerACMECertFile(t *testing.T) {
+	s := Server{
+		Mux: hstsMux(),
+		ACME: &ACMEConfig{
+			Hosts:     []string{"example.com"},
+			Cache:     autocert.DirCache(t.TempDir()),
+			AcceptTOS: true,
+		},
+	}
+	if err := s.ListenAndServeTLS("cert.pem", "key.pem"); err == nil {
+		t.Error("s.ListenAndServeTLS: got nil error, want error")
+	}
+}
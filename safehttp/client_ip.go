@@ -0,0 +1,202 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ProxyPolicy determines how the IP address of the client is resolved when the
+// server is deployed behind reverse proxies or load balancers, which report
+// the address of the client in forwarding headers.
+//
+// Forwarding headers are only taken into account if the direct peer is one of
+// the trusted proxies, as anyone else can set them to arbitrary values. The
+// zero value trusts no proxies, so the address of the direct peer is always
+// used.
+type ProxyPolicy struct {
+	// TrustedProxies are the networks of the proxies whose forwarding headers
+	// are trusted. If empty and Hops is positive, the direct peer is trusted
+	// to be a proxy regardless of its address.
+	TrustedProxies []*net.IPNet
+	// Hops, if positive, is the number of proxies in front of the server. The
+	// address of the client is the one added by the furthest proxy, i.e. the
+	// Hops-th address from the right of the forwarding header, and requests
+	// with fewer addresses are rejected. Otherwise, the addresses are walked
+	// from the right skipping trusted proxies, and the first untrusted one is
+	// the address of the client.
+	Hops int
+	// Header is the forwarding header set by the proxies: "X-Forwarded-For",
+	// "Forwarded" (RFC 7239) or "X-Real-IP". If empty, X-Forwarded-For or
+	// Forwarded is used, whichever is present.
+	Header string
+}
+
+// validate returns an error if the policy is misconfigured.
+func (p ProxyPolicy) validate() error {
+	if p.Hops < 0 {
+		return fmt.Errorf("negative number of hops %d", p.Hops)
+	}
+	switch http.CanonicalHeaderKey(p.Header) {
+	case "", "X-Forwarded-For", "Forwarded", "X-Real-Ip":
+		return nil
+	}
+	return fmt.Errorf("unsupported forwarding header %q", p.Header)
+}
+
+// ClientIP returns the IP address of the client that sent the request
+// according to the policy.
+//
+// An error is returned if the addresses can't be parsed, if there are fewer
+// forwarded addresses than Hops or if Header is empty and trusted proxies
+// sent both X-Forwarded-For and Forwarded headers, since it can't be
+// determined which one was set by the proxies.
+func (p ProxyPolicy) ClientIP(r *IncomingRequest) (net.IP, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	ip := peerIP(r.req.RemoteAddr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid remote address %q", r.req.RemoteAddr)
+	}
+	if !p.trustsPeer(ip) {
+		return ip, nil
+	}
+
+	hops, err := p.hops(r.req.Header)
+	if err != nil {
+		return nil, err
+	}
+	if p.Hops > 0 {
+		if len(hops) < p.Hops {
+			return nil, fmt.Errorf("got %d forwarded addresses, want at least %d", len(hops), p.Hops)
+		}
+		hop := strings.TrimSpace(hops[len(hops)-p.Hops])
+		if ip = parseHopIP(hop); ip == nil {
+			return nil, fmt.Errorf("invalid forwarded address %q", hop)
+		}
+		return ip, nil
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		ip = parseHopIP(hop)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid forwarded address %q", hop)
+		}
+		if !trusted(ip, p.TrustedProxies) {
+			return ip, nil
+		}
+	}
+	// All the hops are trusted proxies; the furthest one is the client.
+	return ip, nil
+}
+
+// FromTrustedProxy reports whether the direct peer of the request is a proxy
+// trusted by the ProxyPolicy configured with ServeMuxConfig.TrustProxies. Only
+// headers set by trusted proxies, e.g. request or trace IDs, should be relied
+// upon.
+func (r *IncomingRequest) FromTrustedProxy() bool {
+	ip := peerIP(r.req.RemoteAddr)
+	return ip != nil && r.proxyPolicy.trustsPeer(ip)
+}
+
+// peerIP parses the IP address of the direct peer from the remote address of
+// a request. It returns nil if the address is invalid.
+func peerIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// trustsPeer reports whether the forwarding headers sent by the direct peer
+// with the given address should be trusted.
+func (p ProxyPolicy) trustsPeer(ip net.IP) bool {
+	if len(p.TrustedProxies) == 0 {
+		return p.Hops > 0
+	}
+	return trusted(ip, p.TrustedProxies)
+}
+
+// hops returns the forwarded addresses from the header of the policy, from the
+// furthest to the closest hop.
+func (p ProxyPolicy) hops(h http.Header) ([]string, error) {
+	var xff, fwd []string
+	switch http.CanonicalHeaderKey(p.Header) {
+	case "X-Real-Ip":
+		v := h.Values("X-Real-Ip")
+		if len(v) > 1 {
+			return nil, errors.New("multiple X-Real-IP headers are present")
+		}
+		return v, nil
+	case "X-Forwarded-For":
+		xff = h.Values("X-Forwarded-For")
+	case "Forwarded":
+		fwd = h.Values("Forwarded")
+	default:
+		xff = h.Values("X-Forwarded-For")
+		fwd = h.Values("Forwarded")
+		if len(xff) > 0 && len(fwd) > 0 {
+			return nil, errors.New("both X-Forwarded-For and Forwarded headers are present")
+		}
+	}
+
+	var hops []string
+	for _, v := range xff {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for _, v := range fwd {
+		for _, elem := range strings.Split(v, ",") {
+			hops = append(hops, forwardedFor(elem))
+		}
+	}
+	return hops, nil
+}
+
+// trusted reports whether ip belongs to one of the given networks.
+func trusted(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the value of the "for" parameter of an element of a
+// Forwarded header (RFC 7239), or the empty string if not present.
+func forwardedFor(elem string) string {
+	for _, pair := range strings.Split(elem, ";") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+			return strings.Trim(kv[1], `"`)
+		}
+	}
+	return ""
+}
+
+// parseHopIP parses an IP address from forwarding headers, optionally with a
+// port and IPv6 brackets. It returns nil if the address is invalid.
+func parseHopIP(hop string) net.IP {
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+}
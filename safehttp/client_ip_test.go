@@ -0,0 +1,297 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp_test
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-safeweb/safehttp"
+)
+
+func mustParseCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func TestProxyPolicyClientIP(t *testing.T) {
+	trusted := mustParseCIDRs(t, "10.0.0.0/8", "2001:db8::/32")
+
+	tests := []struct {
+		name       string
+		policy     safehttp.ProxyPolicy
+		remoteAddr string
+		header     map[string][]string
+		want       string
+	}{
+		{
+			name:       "No trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "Direct connection",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted},
+			remoteAddr: "192.0.2.1:1234",
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "Untrusted peer ignoring XFF",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted},
+			remoteAddr: "192.0.2.1:1234",
+			header:     map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "Trusted proxy with XFF",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "Trusted proxies chain with spoofed XFF",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string][]string{"X-Forwarded-For": {"1.2.3.4, 203.0.113.7", "10.0.0.2"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "Trusted proxy with Forwarded",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted},
+			remoteAddr: "[2001:db8::1]:1234",
+			header:     map[string][]string{"Forwarded": {`for=1.2.3.4, for="[2001:db8:cafe::17]:4711";proto=https, For=203.0.113.7:80`}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "Only trusted proxies",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "Hops without trusted proxies",
+			policy:     safehttp.ProxyPolicy{Hops: 2},
+			remoteAddr: "192.0.2.1:1234",
+			header:     map[string][]string{"X-Forwarded-For": {"1.2.3.4, 203.0.113.7, 198.51.100.1"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "Hops with untrusted peer",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted, Hops: 1},
+			remoteAddr: "192.0.2.1:1234",
+			header:     map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "Hops ignoring trusted addresses",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted, Hops: 1},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string][]string{"X-Forwarded-For": {"203.0.113.7, 10.0.0.2"}},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "Header choice ignoring other headers",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted, Header: "Forwarded"},
+			remoteAddr: "10.0.0.1:1234",
+			header: map[string][]string{
+				"X-Forwarded-For": {"1.2.3.4"},
+				"Forwarded":       {"for=203.0.113.7"},
+			},
+			want: "203.0.113.7",
+		},
+		{
+			name:       "X-Real-IP",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted, Header: "X-Real-IP"},
+			remoteAddr: "10.0.0.1:1234",
+			header: map[string][]string{
+				"X-Forwarded-For": {"1.2.3.4"},
+				"X-Real-Ip":       {"203.0.113.7"},
+			},
+			want: "203.0.113.7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(safehttp.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			ir := safehttp.NewIncomingRequest(req)
+
+			got, err := tt.policy.ClientIP(ir)
+			if err != nil {
+				t.Fatalf("tt.policy.ClientIP() got err: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("tt.policy.ClientIP() got: %v want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyPolicyClientIPInvalid(t *testing.T) {
+	trusted := mustParseCIDRs(t, "10.0.0.0/8")
+
+	tests := []struct {
+		name       string
+		policy     safehttp.ProxyPolicy
+		remoteAddr string
+		header     map[string][]string
+	}{
+		{
+			name:       "Invalid remote address",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted},
+			remoteAddr: "foo",
+		},
+		{
+			name:       "Invalid XFF",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string][]string{"X-Forwarded-For": {"unknown"}},
+		},
+		{
+			name:       "Both XFF and Forwarded",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:1234",
+			header: map[string][]string{
+				"X-Forwarded-For": {"203.0.113.7"},
+				"Forwarded":       {"for=203.0.113.8"},
+			},
+		},
+		{
+			name:       "Fewer addresses than hops",
+			policy:     safehttp.ProxyPolicy{Hops: 2},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+		},
+		{
+			name:       "Multiple X-Real-IP",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted, Header: "X-Real-IP"},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string][]string{"X-Real-Ip": {"203.0.113.7", "203.0.113.8"}},
+		},
+		{
+			name:       "Unsupported header",
+			policy:     safehttp.ProxyPolicy{TrustedProxies: trusted, Header: "X-Client-IP"},
+			remoteAddr: "10.0.0.1:1234",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(safehttp.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			ir := safehttp.NewIncomingRequest(req)
+
+			if got, err := tt.policy.ClientIP(ir); err == nil {
+				t.Errorf("tt.policy.ClientIP() got: %v, want error", got)
+			}
+		})
+	}
+}
+
+func TestIncomingRequestClientIP(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.TrustProxies(safehttp.ProxyPolicy{TrustedProxies: mustParseCIDRs(t, "10.0.0.0/8")})
+	var got []string
+	mb.PreFilter(func(r *safehttp.IncomingRequest) safehttp.StatusCode {
+		ip, err := r.ClientIP()
+		if err != nil {
+			t.Fatalf("r.ClientIP() got err: %v", err)
+		}
+		got = append(got, "prefilter "+ip.String())
+		return safehttp.StatusOK
+	})
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		ip, err := r.ClientIP()
+		if err != nil {
+			t.Fatalf("r.ClientIP() got err: %v", err)
+		}
+		got = append(got, "handler "+ip.String())
+		return w.Write(safehttp.NoContentResponse{})
+	}))
+
+	req := httptest.NewRequest(safehttp.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := []string{"prefilter 203.0.113.7", "handler 203.0.113.7"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("client IPs mismatch (-want +got):\n%s", diff)
+	}
+
+	// Outside of a ServeMux, forwarding headers are ignored.
+	ip, err := safehttp.NewIncomingRequest(req).ClientIP()
+	if err != nil {
+		t.Fatalf("ClientIP() got err: %v", err)
+	}
+	if got, want := ip.String(), "10.0.0.1"; got != want {
+		t.Errorf("ClientIP() got: %v want: %v", got, want)
+	}
+}
+
+func TestIncomingRequestFromTrustedProxy(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.TrustProxies(safehttp.ProxyPolicy{TrustedProxies: mustParseCIDRs(t, "10.0.0.0/8")})
+	var got bool
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		got = r.FromTrustedProxy()
+		return w.Write(safehttp.NoContentResponse{})
+	}))
+
+	for _, tt := range []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{remoteAddr: "10.0.0.1:1234", want: true},
+		{remoteAddr: "192.0.2.1:1234", want: false},
+		{remoteAddr: "invalid", want: false},
+	} {
+		req := httptest.NewRequest(safehttp.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+		if got != tt.want {
+			t.Errorf("FromTrustedProxy() with remote address %q: got %v, want %v", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
+
+func TestTrustProxiesInvalid(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("TrustProxies() expected panic")
+		}
+	}()
+	safehttp.NewServeMuxConfig(nil).TrustProxies(safehttp.ProxyPolicy{Hops: -1})
+}
@@ -0,0 +1,292 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Encoder compresses response bodies with a content-coding.
+//
+// Brotli is not supported by the standard library, an Encoder for it can be
+// implemented with a third-party package, e.g.:
+//
+//	type brotliEncoder struct{}
+//
+//	func (brotliEncoder) Encoding() string { return "br" }
+//
+//	func (brotliEncoder) NewWriter(w io.Writer) io.WriteCloser {
+//		return brotli.NewWriter(w)
+//	}
+type Encoder interface {
+	// Encoding returns the content-coding, e.g. "gzip", used in the
+	// Accept-Encoding and Content-Encoding headers.
+	Encoding() string
+	// NewWriter returns a writer compressing the data written to it into w.
+	// Close must write any pending data to w. If the returned writer has a
+	// Flush() error method, it's called when the response is flushed, e.g. by
+	// StreamResponses.
+	NewWriter(w io.Writer) io.WriteCloser
+}
+
+// GzipEncoder is an Encoder for the gzip content-coding.
+type GzipEncoder struct {
+	// Level is the compression level, as defined by compress/gzip. The zero
+	// value is gzip.DefaultCompression.
+	Level int
+}
+
+// Encoding returns "gzip".
+func (GzipEncoder) Encoding() string {
+	return "gzip"
+}
+
+// NewWriter returns a gzip.Writer writing to w.
+func (e GzipEncoder) NewWriter(w io.Writer) io.WriteCloser {
+	level := e.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	gw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		// The level has been validated by ServeMuxConfig.Compress.
+		panic(err)
+	}
+	return gw
+}
+
+// CompressionPolicy configures the compression of responses, see
+// ServeMuxConfig.Compress.
+type CompressionPolicy struct {
+	// Encoders are the supported content-codings, in order of preference.
+	// If empty, only gzip with the default compression level is supported.
+	Encoders []Encoder
+	// ProtectSecrets disables the compression of responses to requests marked
+	// with MarkSecret, e.g. pages containing XSRF tokens, to mitigate the
+	// BREACH attack. Compression allows attackers that can reflect data in
+	// a response to guess the secrets it contains by observing its size.
+	ProtectSecrets bool
+}
+
+func (p CompressionPolicy) validate() error {
+	for _, e := range p.Encoders {
+		if e == nil {
+			return fmt.Errorf("nil Encoder")
+		}
+		if e.Encoding() == "" || e.Encoding() == "identity" {
+			return fmt.Errorf("invalid encoding %q", e.Encoding())
+		}
+		if g, ok := e.(GzipEncoder); ok && g.Level != 0 && (g.Level < gzip.HuffmanOnly || g.Level > gzip.BestCompression) {
+			return fmt.Errorf("invalid gzip level %d", g.Level)
+		}
+	}
+	return nil
+}
+
+type secretKey struct{}
+
+// MarkSecret records that the response to the given request contains a
+// secret, e.g. an XSRF token, so that it's not compressed if
+// CompressionPolicy.ProtectSecrets is enabled. It should be called by
+// interceptors during their Commit phase.
+func MarkSecret(r *IncomingRequest) {
+	FlightValues(r.Context()).Put(secretKey{}, true)
+}
+
+// hasSecret reports whether MarkSecret has been called for the request.
+func hasSecret(r *IncomingRequest) bool {
+	secret, _ := FlightValues(r.Context()).Get(secretKey{}).(bool)
+	return secret
+}
+
+// compressor returns a compressWriter wrapping rw for the response, or nil if
+// the response should not be compressed.
+func (f *flight) compressor(rw http.ResponseWriter, resp Response) *compressWriter {
+	p := f.cfg.Compression
+	if p == nil {
+		return nil
+	}
+	switch resp.(type) {
+	case NoContentResponse, RedirectResponse, WebSocketResponse:
+		return nil
+	}
+	if p.ProtectSecrets && hasSecret(f.req) {
+		return nil
+	}
+	encs := p.Encoders
+	if len(encs) == 0 {
+		encs = []Encoder{GzipEncoder{}}
+	}
+	return &compressWriter{ResponseWriter: rw, enc: negotiateEncoding(f.req, encs)}
+}
+
+// negotiateEncoding returns the first of the encoders accepted by the
+// Accept-Encoding header of the request, or nil if there is none.
+func negotiateEncoding(r *IncomingRequest, encs []Encoder) Encoder {
+	accepted := map[string]float64{}
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, c := range strings.Split(v, ",") {
+			params := strings.Split(c, ";")
+			q := 1.0
+			for _, p := range params[1:] {
+				p = strings.TrimSpace(p)
+				if !strings.HasPrefix(p, "q=") {
+					continue
+				}
+				var err error
+				if q, err = strconv.ParseFloat(p[len("q="):], 64); err != nil {
+					q = 0
+				}
+			}
+			accepted[strings.ToLower(strings.TrimSpace(params[0]))] = q
+		}
+	}
+	for _, e := range encs {
+		q, ok := accepted[e.Encoding()]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > 0 {
+			return e
+		}
+	}
+	return nil
+}
+
+// compressWriter compresses the body written to the underlying
+// http.ResponseWriter, if it's compressible. This is decided when the header
+// is written, after the Dispatcher has set the Content-Type.
+type compressWriter struct {
+	http.ResponseWriter
+	// enc is the negotiated Encoder, or nil if the client doesn't accept
+	// any.
+	enc     Encoder
+	w       io.WriteCloser
+	decided bool
+}
+
+// writer returns an http.ResponseWriter writing to w, which implements
+// http.Flusher only if the underlying http.ResponseWriter does.
+func (w *compressWriter) writer() http.ResponseWriter {
+	if _, ok := w.ResponseWriter.(http.Flusher); ok {
+		return flushCompressor{w}
+	}
+	return w
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if !w.decided {
+		w.decided = true
+		w.start(code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressWriter) start(code int) {
+	h := w.Header()
+	if !compressible(code, h) {
+		return
+	}
+	if !hasToken(h.Values("Vary"), "Accept-Encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if w.enc == nil {
+		return
+	}
+	h.Set("Content-Encoding", w.enc.Encoding())
+	h.Del("Content-Length")
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		// The compressed representation is not byte-for-byte identical.
+		h.Set("ETag", "W/"+etag)
+	}
+	w.w = w.enc.NewWriter(w.ResponseWriter)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.w == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.w.Write(b)
+}
+
+func (w *compressWriter) flush() {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.w.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+// close writes any pending compressed data.
+func (w *compressWriter) close() error {
+	if w.w == nil {
+		return nil
+	}
+	return w.w.Close()
+}
+
+type flushCompressor struct{ *compressWriter }
+
+func (w flushCompressor) Flush() { w.flush() }
+
+// compressible reports whether a response with the given status code and
+// headers can be compressed.
+func compressible(code int, h http.Header) bool {
+	switch {
+	case code < 200, code == http.StatusNoContent, code == http.StatusNotModified, code == http.StatusPartialContent:
+		return false
+	case h.Get("Content-Encoding") != "", h.Get("Content-Range") != "":
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch {
+	case mt == "image/svg+xml":
+		return true
+	case strings.HasPrefix(mt, "image/"), strings.HasPrefix(mt, "video/"), strings.HasPrefix(mt, "audio/"), strings.HasPrefix(mt, "font/woff"):
+		return false
+	}
+	switch mt {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/x-bzip2", "application/x-xz", "application/x-7z-compressed", "application/zstd", "application/pdf":
+		return false
+	}
+	return true
+}
+
+// hasToken reports whether the comma-separated header values contain the
+// given token, ignoring case.
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp_test
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/safehtml"
+)
+
+// upperEncoder is a fake Encoder which upper-cases the body.
+type upperEncoder struct{}
+
+func (upperEncoder) Encoding() string {
+	return "upper"
+}
+
+func (upperEncoder) NewWriter(w io.Writer) io.WriteCloser {
+	return upperWriter{w}
+}
+
+type upperWriter struct{ io.Writer }
+
+func (w upperWriter) Write(b []byte) (int, error) {
+	return w.Writer.Write([]byte(strings.ToUpper(string(b))))
+}
+
+func (upperWriter) Close() error {
+	return nil
+}
+
+type secretInterceptor struct{}
+
+func (secretInterceptor) Before(w safehttp.ResponseWriter, _ *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	return safehttp.NotWritten()
+}
+
+func (secretInterceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+	safehttp.MarkSecret(r)
+}
+
+func (secretInterceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
+
+func TestMuxCompress(t *testing.T) {
+	html := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehtml.HTMLEscaped("hello world"))
+	})
+	tests := []struct {
+		name           string
+		policy         safehttp.CompressionPolicy
+		interceptors   []safehttp.Interceptor
+		handler        safehttp.Handler
+		acceptEncoding string
+		wantEncoding   string
+		wantVary       string
+		wantBody       string
+		wantETag       string
+	}{
+		{
+			name:           "Gzip",
+			handler:        html,
+			acceptEncoding: "gzip, deflate, br",
+			wantEncoding:   "gzip",
+			wantVary:       "Accept-Encoding",
+			wantBody:       "hello world",
+		},
+		{
+			name:     "Not accepted",
+			handler:  html,
+			wantVary: "Accept-Encoding",
+			wantBody: "hello world",
+		},
+		{
+			name:           "Refused",
+			handler:        html,
+			acceptEncoding: "gzip;q=0, *",
+			wantVary:       "Accept-Encoding",
+			wantBody:       "hello world",
+		},
+		{
+			name:           "Wildcard",
+			handler:        html,
+			acceptEncoding: "*",
+			wantEncoding:   "gzip",
+			wantVary:       "Accept-Encoding",
+			wantBody:       "hello world",
+		},
+		{
+			name:           "Encoders preference",
+			policy:         safehttp.CompressionPolicy{Encoders: []safehttp.Encoder{upperEncoder{}, safehttp.GzipEncoder{}}},
+			handler:        html,
+			acceptEncoding: "gzip, upper;q=0.5",
+			wantEncoding:   "upper",
+			wantVary:       "Accept-Encoding",
+			wantBody:       "HELLO WORLD",
+		},
+		{
+			name: "Already compressed content type",
+			handler: safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return safehttp.WriteStream(w, "image/png", func(sw safehttp.StreamWriter) error {
+					_, err := sw.Write([]byte("png"))
+					return err
+				})
+			}),
+			acceptEncoding: "gzip",
+			wantBody:       "png",
+		},
+		{
+			name: "Strong ETag made weak",
+			handler: safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				w.Header().Set("ETag", `"abc"`)
+				return safehttp.WriteString(w, "hello world")
+			}),
+			acceptEncoding: "gzip",
+			wantEncoding:   "gzip",
+			wantVary:       "Accept-Encoding",
+			wantBody:       "hello world",
+			wantETag:       `W/"abc"`,
+		},
+		{
+			name:           "Secret not protected",
+			interceptors:   []safehttp.Interceptor{secretInterceptor{}},
+			handler:        html,
+			acceptEncoding: "gzip",
+			wantEncoding:   "gzip",
+			wantVary:       "Accept-Encoding",
+			wantBody:       "hello world",
+		},
+		{
+			name:           "Secret protected",
+			policy:         safehttp.CompressionPolicy{ProtectSecrets: true},
+			interceptors:   []safehttp.Interceptor{secretInterceptor{}},
+			handler:        html,
+			acceptEncoding: "gzip",
+			wantBody:       "hello world",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.Compress(tt.policy)
+			mb.Intercept(tt.interceptors...)
+			mux := mb.Mux()
+			mux.Handle("/", safehttp.MethodGet, tt.handler)
+
+			req := httptest.NewRequest(safehttp.MethodGet, "http://foo.com/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, req)
+
+			if got := rw.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding: got %q, want %q", got, tt.wantEncoding)
+			}
+			if got := rw.Header().Get("Vary"); got != tt.wantVary {
+				t.Errorf("Vary: got %q, want %q", got, tt.wantVary)
+			}
+			if got := rw.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("ETag: got %q, want %q", got, tt.wantETag)
+			}
+			body := rw.Body.String()
+			if tt.wantEncoding == "gzip" {
+				gr, err := gzip.NewReader(rw.Body)
+				if err != nil {
+					t.Fatalf("gzip.NewReader: %v", err)
+				}
+				b, err := ioutil.ReadAll(gr)
+				if err != nil {
+					t.Fatalf("ioutil.ReadAll: %v", err)
+				}
+				body = string(b)
+			}
+			if body != tt.wantBody {
+				t.Errorf("response body: got %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMuxCompressStream(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Compress(safehttp.CompressionPolicy{})
+	mux := mb.Mux()
+	var flushed []byte
+	rw := httptest.NewRecorder()
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.WriteStream(w, "text/csv", func(sw safehttp.StreamWriter) error {
+			if _, err := sw.Write([]byte("a,b\n")); err != nil {
+				return err
+			}
+			if err := sw.Flush(); err != nil {
+				return err
+			}
+			flushed = append(flushed, rw.Body.Bytes()...)
+			return nil
+		})
+	}))
+
+	req := httptest.NewRequest(safehttp.MethodGet, "http://foo.com/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	mux.ServeHTTP(rw, req)
+
+	// The flushed data must be decompressable before the stream is closed.
+	gr, err := gzip.NewReader(strings.NewReader(string(flushed)))
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(gr, b); err != nil {
+		t.Fatalf("io.ReadFull: %v", err)
+	}
+	if got, want := string(b), "a,b\n"; got != want {
+		t.Errorf("flushed body: got %q, want %q", got, want)
+	}
+}
+
+func TestMuxCompressInvalidPolicy(t *testing.T) {
+	for _, p := range []safehttp.CompressionPolicy{
+		{Encoders: []safehttp.Encoder{nil}},
+		{Encoders: []safehttp.Encoder{safehttp.GzipEncoder{Level: 42}}},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("Compress(%+v) got no panic, want panic", p)
+				}
+			}()
+			safehttp.NewServeMuxConfig(nil).Compress(p)
+		}()
+	}
+}
@@ -15,7 +15,9 @@
 package safehttp
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 )
 
 // A Cookie represents an HTTP cookie as sent in the Set-Cookie header of an
@@ -23,7 +25,8 @@ import (
 //
 // See https://tools.ietf.org/html/rfc6265 for details.
 type Cookie struct {
-	wrapped *http.Cookie
+	wrapped     *http.Cookie
+	partitioned bool
 }
 
 // NewCookie creates a new Cookie with safe default settings.
@@ -35,7 +38,7 @@ type Cookie struct {
 // https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Set-Cookie
 func NewCookie(name, value string) *Cookie {
 	return &Cookie{
-		&http.Cookie{
+		wrapped: &http.Cookie{
 			Name:     name,
 			Value:    value,
 			Secure:   !isLocalDev,
@@ -45,6 +48,50 @@ func NewCookie(name, value string) *Cookie {
 	}
 }
 
+const (
+	// HostPrefix is the cookie name prefix that makes browsers only accept
+	// the cookie if it is Secure, has Path=/ and no Domain, so that it can't
+	// be set by other subdomains or insecure origins.
+	HostPrefix = "__Host-"
+	// SecurePrefix is the cookie name prefix that makes browsers only accept
+	// the cookie if it is Secure.
+	SecurePrefix = "__Secure-"
+)
+
+// NewHostCookie creates a new Cookie with the safe defaults of NewCookie,
+// prefixing the name with HostPrefix if it doesn't have it and setting Path=/.
+//
+// Adding the cookie to a response fails if its Domain or Path is changed, or
+// if it is made insecure. In local development mode, prefixed cookies are not
+// Secure and might be rejected by browsers.
+func NewHostCookie(name, value string) *Cookie {
+	if !hasPrefixFold(name, HostPrefix) {
+		name = HostPrefix + name
+	}
+	c := NewCookie(name, value)
+	c.Path("/")
+	return c
+}
+
+// NewSecureCookie creates a new Cookie with the safe defaults of NewCookie,
+// prefixing the name with SecurePrefix if it doesn't have it.
+//
+// Adding the cookie to a response fails if it is made insecure. In local
+// development mode, prefixed cookies are not Secure and might be rejected by
+// browsers.
+func NewSecureCookie(name, value string) *Cookie {
+	if !hasPrefixFold(name, SecurePrefix) {
+		name = SecurePrefix + name
+	}
+	return NewCookie(name, value)
+}
+
+// hasPrefixFold reports whether s starts with prefix, ignoring case, as
+// browsers do when matching cookie prefixes.
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
 // SameSite allows a server to define a cookie attribute making it impossible for
 // the browser to send this cookie along with cross-site requests. The main
 // goal is to mitigate the risk of cross-origin information leakage, and provide
@@ -105,6 +152,16 @@ func (c *Cookie) DisableHTTPOnly() {
 	c.wrapped.HttpOnly = false
 }
 
+// SetPartitioned sets the Partitioned attribute, which makes browsers store the
+// cookie in a jar keyed by the top-level site (CHIPS). Partitioned cookies must
+// also be Secure and have SameSite=None, otherwise adding them to a response
+// fails.
+//
+// See https://developer.mozilla.org/en-US/docs/Web/Privacy/Partitioned_cookies
+func (c *Cookie) SetPartitioned(partitioned bool) {
+	c.partitioned = partitioned
+}
+
 // Name returns the name of the cookie.
 func (c *Cookie) Name() string {
 	return c.wrapped.Name
@@ -117,7 +174,40 @@ func (c *Cookie) Value() string {
 
 // String returns the serialization of the cookie for use in a Set-Cookie
 // response header. If c is nil or c.Name() is invalid, the empty string is
-// returned.
+// returned. The Partitioned attribute is only serialized if the cookie is
+// also Secure and has SameSite=None.
 func (c *Cookie) String() string {
-	return c.wrapped.String()
+	v := c.wrapped.String()
+	if v != "" && c.partitioned && c.validate() == nil {
+		v += "; Partitioned"
+	}
+	return v
+}
+
+// validate checks that the attributes of the cookie are consistent, including
+// with the prefix of its name. The Secure attribute is not required for
+// prefixed and partitioned cookies in local development mode, where NewCookie
+// disables it.
+func (c *Cookie) validate() error {
+	if c.partitioned && (!c.wrapped.Secure && !isLocalDev || c.wrapped.SameSite != http.SameSiteNoneMode) {
+		return errors.New("partitioned cookies must be Secure and have SameSite=None")
+	}
+	name := c.wrapped.Name
+	switch {
+	case hasPrefixFold(name, HostPrefix):
+		if !c.wrapped.Secure && !isLocalDev {
+			return errors.New("__Host- cookies must be Secure")
+		}
+		if c.wrapped.Path != "/" {
+			return errors.New("__Host- cookies must have Path=/")
+		}
+		if c.wrapped.Domain != "" {
+			return errors.New("__Host- cookies must not have a Domain")
+		}
+	case hasPrefixFold(name, SecurePrefix):
+		if !c.wrapped.Secure && !isLocalDev {
+			return errors.New("__Secure- cookies must be Secure")
+		}
+	}
+	return nil
 }
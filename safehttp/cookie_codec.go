@@ -0,0 +1,120 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// maxSealedCookieSize is the maximum size of sealed cookie values, so that
+// cookies are not dropped by browsers, which limit them to about 4096 bytes
+// including the name and attributes.
+const maxSealedCookieSize = 4000
+
+// ErrInvalidCookie is returned when opening a cookie value that has not been
+// sealed by the CookieCodec, with any of its keys, for the same cookie name.
+var ErrInvalidCookie = errors.New("invalid sealed cookie")
+
+// CookieCodec seals cookie values, encrypting and authenticating them with
+// AES-GCM, so that state can be stored on the client without it being able to
+// read or tamper with it. Values are bound to the name of the cookie they are
+// sealed for, so they can't be moved to other cookies.
+//
+// Sealed values are not bound to a session or a point in time: clients can
+// replay them, so expiries must be part of the value where relevant.
+type CookieCodec struct {
+	// aeads holds the ciphers of the keys, the first one is used for sealing.
+	aeads []cipher.AEAD
+}
+
+// NewCookieCodec creates a CookieCodec using the given keys, which must be 16,
+// 24 or 32 bytes long. Values are sealed with the first key and opened with
+// any of them, so that keys can be rotated by adding a new key in front and
+// removing the old one once the cookies sealed with it have expired.
+func NewCookieCodec(keys ...[]byte) (*CookieCodec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no cookie keys")
+	}
+	c := &CookieCodec{}
+	for i, k := range keys {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cookie key %d: %v", i, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+// Seal encrypts the value for the cookie with the given name, returning a
+// string that is safe to use as a cookie value. It fails if the result
+// doesn't fit in a cookie.
+func (c *CookieCodec) Seal(name string, value []byte) (string, error) {
+	aead := c.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("crypto/rand.Read: %v", err)
+	}
+	v := base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, value, []byte(name)))
+	if len(v) > maxSealedCookieSize {
+		return "", fmt.Errorf("sealed cookie value too large: %d bytes", len(v))
+	}
+	return v, nil
+}
+
+// Open decrypts a value returned by Seal for the cookie with the given name.
+// It returns ErrInvalidCookie if the value has been tampered with, has been
+// sealed for another cookie or with an unknown key.
+func (c *CookieCodec) Open(name, sealed string) ([]byte, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+	for _, aead := range c.aeads {
+		if len(buf) < aead.NonceSize() {
+			continue
+		}
+		nonce, ciphertext := buf[:aead.NonceSize()], buf[aead.NonceSize():]
+		if plain, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, ErrInvalidCookie
+}
+
+// NewCookie creates a Cookie with the safe defaults of NewCookie, holding the
+// sealed value.
+func (c *CookieCodec) NewCookie(name string, value []byte) (*Cookie, error) {
+	v, err := c.Seal(name, value)
+	if err != nil {
+		return nil, err
+	}
+	return NewCookie(name, v), nil
+}
+
+// OpenCookie decrypts the value of a cookie created by NewCookie, e.g. one
+// returned by IncomingRequest.Cookie.
+func (c *CookieCodec) OpenCookie(cookie *Cookie) ([]byte, error) {
+	return c.Open(cookie.Name(), cookie.Value())
+}
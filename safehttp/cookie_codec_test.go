@@ -0,0 +1,132 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var (
+	testCookieKey1 = []byte("0123456789abcdef")
+	testCookieKey2 = []byte("fedcba9876543210")
+)
+
+func TestCookieCodec(t *testing.T) {
+	c, err := NewCookieCodec(testCookieKey1)
+	if err != nil {
+		t.Fatalf("NewCookieCodec() got err: %v", err)
+	}
+	ck, err := c.NewCookie("state", []byte("secret"))
+	if err != nil {
+		t.Fatalf("c.NewCookie() got err: %v", err)
+	}
+	if bytes.Contains([]byte(ck.Value()), []byte("secret")) {
+		t.Errorf("ck.Value(): got %q, want it encrypted", ck.Value())
+	}
+	if want := "HttpOnly; Secure; SameSite=Lax"; !bytes.HasSuffix([]byte(ck.String()), []byte(want)) {
+		t.Errorf("ck.String(): got %q, want safe defaults %q", ck.String(), want)
+	}
+	got, err := c.OpenCookie(ck)
+	if err != nil {
+		t.Fatalf("c.OpenCookie() got err: %v", err)
+	}
+	if want := []byte("secret"); !bytes.Equal(got, want) {
+		t.Errorf("c.OpenCookie(): got %q, want %q", got, want)
+	}
+}
+
+func TestCookieCodecInvalid(t *testing.T) {
+	c, err := NewCookieCodec(testCookieKey1)
+	if err != nil {
+		t.Fatalf("NewCookieCodec() got err: %v", err)
+	}
+	v, err := c.Seal("state", []byte("secret"))
+	if err != nil {
+		t.Fatalf("c.Seal() got err: %v", err)
+	}
+	tampered := []byte(v)
+	tampered[len(tampered)/2] ^= 1
+	other, err := NewCookieCodec(testCookieKey2)
+	if err != nil {
+		t.Fatalf("NewCookieCodec() got err: %v", err)
+	}
+	otherV, err := other.Seal("state", []byte("secret"))
+	if err != nil {
+		t.Fatalf("other.Seal() got err: %v", err)
+	}
+
+	tests := []struct {
+		name, cookie, value string
+	}{
+		{name: "Tampered", cookie: "state", value: string(tampered)},
+		{name: "Other cookie", cookie: "other", value: v},
+		{name: "Other key", cookie: "state", value: otherV},
+		{name: "Malformed", cookie: "state", value: "not base64!"},
+		{name: "Short", cookie: "state", value: "abc"},
+		{name: "Empty", cookie: "state", value: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.Open(tt.cookie, tt.value); !errors.Is(err, ErrInvalidCookie) {
+				t.Errorf("c.Open(): got err %v, want ErrInvalidCookie", err)
+			}
+		})
+	}
+}
+
+func TestCookieCodecKeyRotation(t *testing.T) {
+	old, err := NewCookieCodec(testCookieKey1)
+	if err != nil {
+		t.Fatalf("NewCookieCodec() got err: %v", err)
+	}
+	v, err := old.Seal("state", []byte("secret"))
+	if err != nil {
+		t.Fatalf("old.Seal() got err: %v", err)
+	}
+	rotated, err := NewCookieCodec(testCookieKey2, testCookieKey1)
+	if err != nil {
+		t.Fatalf("NewCookieCodec() got err: %v", err)
+	}
+	if _, err := rotated.Open("state", v); err != nil {
+		t.Errorf("rotated.Open(value sealed with old key): got err %v", err)
+	}
+	newV, err := rotated.Seal("state", []byte("secret"))
+	if err != nil {
+		t.Fatalf("rotated.Seal() got err: %v", err)
+	}
+	if _, err := old.Open("state", newV); !errors.Is(err, ErrInvalidCookie) {
+		t.Errorf("old.Open(value sealed with new key): got err %v, want ErrInvalidCookie", err)
+	}
+}
+
+func TestCookieCodecTooLarge(t *testing.T) {
+	c, err := NewCookieCodec(testCookieKey1)
+	if err != nil {
+		t.Fatalf("NewCookieCodec() got err: %v", err)
+	}
+	if _, err := c.Seal("state", make([]byte, 4096)); err == nil {
+		t.Error("c.Seal(): got nil err, want error")
+	}
+}
+
+func TestNewCookieCodecInvalidKeys(t *testing.T) {
+	for _, keys := range [][][]byte{nil, {[]byte("short")}, {testCookieKey1, []byte("short")}} {
+		if _, err := NewCookieCodec(keys...); err == nil {
+			t.Errorf("NewCookieCodec(%q): got nil err, want error", keys)
+		}
+	}
+}
@@ -14,7 +14,10 @@
 
 package safehttp
 
-import "testing"
+import (
+	"net/http"
+	"testing"
+)
 
 func TestCookie(t *testing.T) {
 	tests := []struct {
@@ -109,6 +112,36 @@ func TestCookie(t *testing.T) {
 			}(),
 			want: "foo=bar; Secure; SameSite=Lax",
 		},
+		{
+			name: "Partitioned",
+			cookie: func() *Cookie {
+				c := NewCookie("foo", "bar")
+				c.SameSite(SameSiteNoneMode)
+				c.SetPartitioned(true)
+				return c
+			}(),
+			want: "foo=bar; HttpOnly; Secure; SameSite=None; Partitioned",
+		},
+		{
+			name: "Partitioned without SameSite none",
+			cookie: func() *Cookie {
+				c := NewCookie("foo", "bar")
+				c.SetPartitioned(true)
+				return c
+			}(),
+			want: "foo=bar; HttpOnly; Secure; SameSite=Lax",
+		},
+		{
+			name: "Partitioned not Secure",
+			cookie: func() *Cookie {
+				c := NewCookie("foo", "bar")
+				c.SameSite(SameSiteNoneMode)
+				c.DisableSecure()
+				c.SetPartitioned(true)
+				return c
+			}(),
+			want: "foo=bar; HttpOnly; SameSite=None",
+		},
 	}
 
 	for _, tt := range tests {
@@ -133,3 +166,153 @@ func TestCookieValue(t *testing.T) {
 		t.Errorf("c.Value() got: %v want: %v", got, want)
 	}
 }
+
+func TestAddPartitionedCookieInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Cookie)
+	}{
+		{
+			name:   "SameSite Lax",
+			modify: func(c *Cookie) {},
+		},
+		{
+			name: "Not Secure",
+			modify: func(c *Cookie) {
+				c.SameSite(SameSiteNoneMode)
+				c.DisableSecure()
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCookie("foo", "bar")
+			tt.modify(c)
+			c.SetPartitioned(true)
+			h := NewHeader(http.Header{})
+			if err := h.addCookie(c); err == nil {
+				t.Error("h.addCookie(c) got nil, want error")
+			}
+			if got := h.Values("Set-Cookie"); len(got) != 0 {
+				t.Errorf(`h.Values("Set-Cookie") got %v, want empty`, got)
+			}
+		})
+	}
+}
+
+func TestPartitionedCookieLocalDev(t *testing.T) {
+	isLocalDev = true
+	defer func() { isLocalDev = false }()
+
+	c := NewCookie("foo", "bar")
+	c.SameSite(SameSiteNoneMode)
+	c.SetPartitioned(true)
+	h := NewHeader(http.Header{})
+	if err := h.addCookie(c); err != nil {
+		t.Fatalf("h.addCookie(c) got err: %v", err)
+	}
+	if got, want := h.Get("Set-Cookie"), "foo=bar; HttpOnly; SameSite=None; Partitioned"; got != want {
+		t.Errorf(`h.Get("Set-Cookie") got: %q want: %q`, got, want)
+	}
+}
+
+func TestPrefixedCookie(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie *Cookie
+		want   string
+	}{
+		{
+			name:   "Host",
+			cookie: NewHostCookie("foo", "bar"),
+			want:   "__Host-foo=bar; Path=/; HttpOnly; Secure; SameSite=Lax",
+		},
+		{
+			name:   "Host already prefixed",
+			cookie: NewHostCookie("__Host-foo", "bar"),
+			want:   "__Host-foo=bar; Path=/; HttpOnly; Secure; SameSite=Lax",
+		},
+		{
+			name:   "Secure",
+			cookie: NewSecureCookie("foo", "bar"),
+			want:   "__Secure-foo=bar; HttpOnly; Secure; SameSite=Lax",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHeader(http.Header{})
+			if err := h.addCookie(tt.cookie); err != nil {
+				t.Fatalf("h.addCookie(tt.cookie) got err: %v", err)
+			}
+			if got := h.Get("Set-Cookie"); got != tt.want {
+				t.Errorf(`h.Get("Set-Cookie") got: %q want: %q`, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddPrefixedCookieInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie func() *Cookie
+	}{
+		{
+			name: "Host with Domain",
+			cookie: func() *Cookie {
+				c := NewHostCookie("foo", "bar")
+				c.Domain("example.com")
+				return c
+			},
+		},
+		{
+			name: "Host with other Path",
+			cookie: func() *Cookie {
+				c := NewHostCookie("foo", "bar")
+				c.Path("/foo")
+				return c
+			},
+		},
+		{
+			name: "Host without Path",
+			cookie: func() *Cookie {
+				return NewCookie("__Host-foo", "bar")
+			},
+		},
+		{
+			name: "Host not Secure",
+			cookie: func() *Cookie {
+				c := NewHostCookie("foo", "bar")
+				c.DisableSecure()
+				return c
+			},
+		},
+		{
+			name: "Host prefix case insensitive",
+			cookie: func() *Cookie {
+				c := NewCookie("__HOST-foo", "bar")
+				c.Path("/")
+				c.Domain("example.com")
+				return c
+			},
+		},
+		{
+			name: "Secure not Secure",
+			cookie: func() *Cookie {
+				c := NewSecureCookie("foo", "bar")
+				c.DisableSecure()
+				return c
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHeader(http.Header{})
+			if err := h.addCookie(tt.cookie()); err == nil {
+				t.Error("h.addCookie(c) got nil, want error")
+			}
+			if got := h.Values("Set-Cookie"); len(got) != 0 {
+				t.Errorf(`h.Values("Set-Cookie") got %v, want empty`, got)
+			}
+		})
+	}
+}
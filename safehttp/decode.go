@@ -0,0 +1,249 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"strings"
+)
+
+const (
+	// DefaultMaxBodySize is the maximum number of bytes of the request body
+	// read by IncomingRequest.JSON and IncomingRequest.XML, unless overridden
+	// with MaxBodySize.
+	DefaultMaxBodySize = 1 << 20 // 1 MiB
+	// DefaultMaxDepth is the maximum nesting depth of the documents decoded
+	// by IncomingRequest.JSON and IncomingRequest.XML, unless overridden with
+	// MaxDepth.
+	DefaultMaxDepth = 32
+)
+
+// ErrBodyTooLarge is returned when decoding a request body larger than the
+// configured maximum size.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// DecodeOption configures how request bodies are decoded.
+type DecodeOption func(*decodeOptions)
+
+type decodeOptions struct {
+	maxBodySize   int64
+	maxDepth      int
+	disallowExtra bool
+}
+
+func newDecodeOptions(opts []DecodeOption) decodeOptions {
+	o := decodeOptions{maxBodySize: DefaultMaxBodySize, maxDepth: DefaultMaxDepth}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// MaxBodySize sets the maximum number of bytes of the request body that are
+// read. Larger bodies are rejected with ErrBodyTooLarge.
+func MaxBodySize(n int64) DecodeOption {
+	return func(o *decodeOptions) { o.maxBodySize = n }
+}
+
+// MaxDepth sets the maximum nesting depth of the decoded document, i.e. of
+// JSON objects and arrays or of XML elements.
+func MaxDepth(n int) DecodeOption {
+	return func(o *decodeOptions) { o.maxDepth = n }
+}
+
+// DisallowUnknownFields rejects JSON objects with keys that don't match any
+// exported field of the destination struct. It has no effect on XML.
+func DisallowUnknownFields() DecodeOption {
+	return func(o *decodeOptions) { o.disallowExtra = true }
+}
+
+// readBody validates the request method and reads up to maxBodySize bytes of
+// the request body.
+func (r *IncomingRequest) readBody(maxBodySize int64) ([]byte, error) {
+	if m := r.req.Method; m != MethodPost && m != MethodPatch && m != MethodPut {
+		return nil, fmt.Errorf("got request method %s, want POST/PATCH/PUT", m)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.req.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxBodySize {
+		return nil, ErrBodyTooLarge
+	}
+	return b, nil
+}
+
+// JSON decodes the JSON body of a POST, PATCH or PUT request into v, as
+// json.Unmarshal does.
+//
+// The request must have an application/json Content-Type, or another JSON
+// media type such as application/problem+json, and its body must contain a
+// single JSON value. Bodies larger than DefaultMaxBodySize and documents
+// nested deeper than DefaultMaxDepth are rejected, unless overridden with
+// MaxBodySize and MaxDepth.
+func (r *IncomingRequest) JSON(v interface{}, opts ...DecodeOption) error {
+	o := newDecodeOptions(opts)
+	if ct := r.req.Header.Get("Content-Type"); !isJSONContentType(ct) {
+		return fmt.Errorf("invalid method called for Content-Type: %s", ct)
+	}
+	b, err := r.readBody(o.maxBodySize)
+	if err != nil {
+		return err
+	}
+	if d := jsonDepth(b); d > o.maxDepth {
+		return fmt.Errorf("JSON nesting depth %d exceeds the maximum of %d", d, o.maxDepth)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if o.disallowExtra {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("request body must contain a single JSON value")
+	}
+	return nil
+}
+
+// isJSONContentType reports whether ct is application/json or a structured
+// syntax JSON media type, with no charset or a UTF-8 one.
+func isJSONContentType(ct string) bool {
+	mt, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	if cs, ok := params["charset"]; ok && !strings.EqualFold(cs, "utf-8") {
+		return false
+	}
+	return mt == "application/json" || strings.HasPrefix(mt, "application/") && strings.HasSuffix(mt, "+json")
+}
+
+// jsonDepth returns the maximum nesting depth of objects and arrays in b. It
+// doesn't validate b, which is left to the decoder.
+func jsonDepth(b []byte) int {
+	var depth, max int
+	inString, escaped := false, false
+	for _, c := range b {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+			if depth > max {
+				max = depth
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return max
+}
+
+// XML decodes the XML body of a POST, PATCH or PUT request into v, as
+// xml.Unmarshal does.
+//
+// The request must have an application/xml or text/xml Content-Type, or
+// another XML media type such as application/soap+xml, and its body must
+// contain a single UTF-8 encoded root element. Documents with a DOCTYPE are
+// rejected, so no entity can be declared, and external entities are never
+// resolved. Bodies larger than DefaultMaxBodySize and documents nested deeper
+// than DefaultMaxDepth are rejected, unless overridden with MaxBodySize and
+// MaxDepth.
+func (r *IncomingRequest) XML(v interface{}, opts ...DecodeOption) error {
+	o := newDecodeOptions(opts)
+	if ct := r.req.Header.Get("Content-Type"); !isXMLContentType(ct) {
+		return fmt.Errorf("invalid method called for Content-Type: %s", ct)
+	}
+	b, err := r.readBody(o.maxBodySize)
+	if err != nil {
+		return err
+	}
+	if err := checkXML(b, o.maxDepth); err != nil {
+		return err
+	}
+	return newXMLDecoder(b).Decode(v)
+}
+
+// newXMLDecoder returns a strict decoder of b that only knows the predefined
+// XML entities and doesn't support encodings other than UTF-8.
+func newXMLDecoder(b []byte) *xml.Decoder {
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	dec.Strict = true
+	dec.Entity = nil
+	dec.CharsetReader = nil
+	return dec
+}
+
+// checkXML verifies that b is a well-formed XML document without a DOCTYPE,
+// with a single root element and nested at most maxDepth elements deep.
+func checkXML(b []byte, maxDepth int) error {
+	dec := newXMLDecoder(b)
+	depth, roots := 0, 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				roots++
+			}
+			depth++
+			if depth > maxDepth {
+				return fmt.Errorf("XML nesting depth exceeds the maximum of %d", maxDepth)
+			}
+		case xml.EndElement:
+			depth--
+		case xml.Directive:
+			if bytes.HasPrefix(bytes.TrimSpace(t), []byte("DOCTYPE")) {
+				return errors.New("XML documents with a DOCTYPE are not allowed")
+			}
+		}
+	}
+	if roots != 1 {
+		return fmt.Errorf("XML document has %d root elements, want 1", roots)
+	}
+	return nil
+}
+
+// isXMLContentType reports whether ct is application/xml, text/xml or a
+// structured syntax XML media type, with no charset or a UTF-8 one.
+func isXMLContentType(ct string) bool {
+	mt, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	if cs, ok := params["charset"]; ok && !strings.EqualFold(cs, "utf-8") {
+		return false
+	}
+	return mt == "application/xml" || mt == "text/xml" || strings.HasPrefix(mt, "application/") && strings.HasSuffix(mt, "+xml")
+}
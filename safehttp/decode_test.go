@@ -0,0 +1,292 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp_test
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-safeweb/safehttp"
+)
+
+type order struct {
+	Name     string   `json:"name"`
+	Toppings []string `json:"toppings"`
+}
+
+func newBodyRequest(method, ct, body string) *safehttp.IncomingRequest {
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", ct)
+	return safehttp.NewIncomingRequest(req)
+}
+
+func TestIncomingRequestJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		ct   string
+		body string
+		opts []safehttp.DecodeOption
+		want order
+	}{
+		{
+			name: "Basic",
+			ct:   "application/json",
+			body: `{"name": "margherita", "toppings": ["tomato", "mozzarella"]}`,
+			want: order{Name: "margherita", Toppings: []string{"tomato", "mozzarella"}},
+		},
+		{
+			name: "Charset and trailing whitespace",
+			ct:   "application/json; charset=UTF-8",
+			body: "{\"name\": \"marinara\"}\n",
+			want: order{Name: "marinara"},
+		},
+		{
+			name: "Structured syntax suffix",
+			ct:   "application/merge-patch+json",
+			body: `{"name": "diavola"}`,
+			want: order{Name: "diavola"},
+		},
+		{
+			name: "Unknown fields allowed by default",
+			ct:   "application/json",
+			body: `{"name": "diavola", "price": 10}`,
+			want: order{Name: "diavola"},
+		},
+		{
+			name: "Brackets in strings don't count towards depth",
+			ct:   "application/json",
+			body: `{"name": "[[[{{{\"[["}`,
+			opts: []safehttp.DecodeOption{safehttp.MaxDepth(1)},
+			want: order{Name: `[[[{{{"[[`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newBodyRequest(safehttp.MethodPost, tt.ct, tt.body)
+			var got order
+			if err := r.JSON(&got, tt.opts...); err != nil {
+				t.Fatalf("r.JSON: got error %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("r.JSON: mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIncomingRequestJSONInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		ct     string
+		body   string
+		opts   []safehttp.DecodeOption
+	}{
+		{
+			name:   "Wrong method",
+			method: safehttp.MethodGet,
+			ct:     "application/json",
+			body:   `{}`,
+		},
+		{
+			name:   "Wrong Content-Type",
+			method: safehttp.MethodPost,
+			ct:     "text/plain",
+			body:   `{}`,
+		},
+		{
+			name:   "Wrong charset",
+			method: safehttp.MethodPost,
+			ct:     "application/json; charset=iso-8859-1",
+			body:   `{}`,
+		},
+		{
+			name:   "Unknown fields",
+			method: safehttp.MethodPost,
+			ct:     "application/json",
+			body:   `{"name": "diavola", "price": 10}`,
+			opts:   []safehttp.DecodeOption{safehttp.DisallowUnknownFields()},
+		},
+		{
+			name:   "Too deep",
+			method: safehttp.MethodPost,
+			ct:     "application/json",
+			body:   `{"toppings": [[["tomato"]]]}`,
+			opts:   []safehttp.DecodeOption{safehttp.MaxDepth(3)},
+		},
+		{
+			name:   "Too deep by default",
+			method: safehttp.MethodPost,
+			ct:     "application/json",
+			body:   strings.Repeat("[", 33) + strings.Repeat("]", 33),
+		},
+		{
+			name:   "Trailing data",
+			method: safehttp.MethodPost,
+			ct:     "application/json",
+			body:   `{"name": "diavola"} {"name": "marinara"}`,
+		},
+		{
+			name:   "Malformed",
+			method: safehttp.MethodPost,
+			ct:     "application/json",
+			body:   `{"name": `,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newBodyRequest(tt.method, tt.ct, tt.body)
+			var got order
+			if err := r.JSON(&got, tt.opts...); err == nil {
+				t.Errorf("r.JSON: got nil, want error")
+			}
+		})
+	}
+}
+
+func TestIncomingRequestJSONTooLarge(t *testing.T) {
+	r := newBodyRequest(safehttp.MethodPost, "application/json", `{"name": "margherita"}`)
+	var got order
+	if err := r.JSON(&got, safehttp.MaxBodySize(10)); !errors.Is(err, safehttp.ErrBodyTooLarge) {
+		t.Errorf("r.JSON: got error %v, want %v", err, safehttp.ErrBodyTooLarge)
+	}
+}
+
+type xmlOrder struct {
+	XMLName  xml.Name `xml:"order"`
+	Name     string   `xml:"name"`
+	Toppings []string `xml:"topping"`
+}
+
+func TestIncomingRequestXML(t *testing.T) {
+	tests := []struct {
+		name string
+		ct   string
+		body string
+		want xmlOrder
+	}{
+		{
+			name: "Basic",
+			ct:   "application/xml",
+			body: `<?xml version="1.0" encoding="UTF-8"?><order><name>margherita</name><topping>tomato</topping><topping>mozzarella</topping></order>`,
+			want: xmlOrder{XMLName: xml.Name{Local: "order"}, Name: "margherita", Toppings: []string{"tomato", "mozzarella"}},
+		},
+		{
+			name: "Text XML with predefined entities",
+			ct:   "text/xml; charset=utf-8",
+			body: `<order><name>a &amp; b</name></order>`,
+			want: xmlOrder{XMLName: xml.Name{Local: "order"}, Name: "a & b"},
+		},
+		{
+			name: "SOAP",
+			ct:   "application/soap+xml",
+			body: "<!-- comment --><order><name>diavola</name></order>\n",
+			want: xmlOrder{XMLName: xml.Name{Local: "order"}, Name: "diavola"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newBodyRequest(safehttp.MethodPost, tt.ct, tt.body)
+			var got xmlOrder
+			if err := r.XML(&got); err != nil {
+				t.Fatalf("r.XML: got error %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("r.XML: mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIncomingRequestXMLInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		ct     string
+		body   string
+		opts   []safehttp.DecodeOption
+	}{
+		{
+			name:   "Wrong method",
+			method: safehttp.MethodGet,
+			ct:     "application/xml",
+			body:   `<order/>`,
+		},
+		{
+			name:   "Wrong Content-Type",
+			method: safehttp.MethodPost,
+			ct:     "text/html",
+			body:   `<order/>`,
+		},
+		{
+			name:   "External entity",
+			method: safehttp.MethodPost,
+			ct:     "application/xml",
+			body:   `<?xml version="1.0"?><!DOCTYPE order [<!ENTITY xxe SYSTEM "file:///etc/passwd">]><order><name>&xxe;</name></order>`,
+		},
+		{
+			name:   "Undeclared entity",
+			method: safehttp.MethodPost,
+			ct:     "application/xml",
+			body:   `<order><name>&xxe;</name></order>`,
+		},
+		{
+			name:   "Non UTF-8 encoding",
+			method: safehttp.MethodPost,
+			ct:     "application/xml",
+			body:   `<?xml version="1.0" encoding="ISO-8859-1"?><order/>`,
+		},
+		{
+			name:   "Too deep",
+			method: safehttp.MethodPost,
+			ct:     "application/xml",
+			body:   `<order><name><b>x</b></name></order>`,
+			opts:   []safehttp.DecodeOption{safehttp.MaxDepth(2)},
+		},
+		{
+			name:   "Multiple roots",
+			method: safehttp.MethodPost,
+			ct:     "application/xml",
+			body:   `<order/><order/>`,
+		},
+		{
+			name:   "Malformed",
+			method: safehttp.MethodPost,
+			ct:     "application/xml",
+			body:   `<order><name></order>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newBodyRequest(tt.method, tt.ct, tt.body)
+			var got xmlOrder
+			if err := r.XML(&got, tt.opts...); err == nil {
+				t.Errorf("r.XML: got nil, want error")
+			}
+		})
+	}
+}
+
+func TestIncomingRequestXMLTooLarge(t *testing.T) {
+	r := newBodyRequest(safehttp.MethodPost, "application/xml", `<order><name>margherita</name></order>`)
+	var got xmlOrder
+	if err := r.XML(&got, safehttp.MaxBodySize(10)); !errors.Is(err, safehttp.ErrBodyTooLarge) {
+		t.Errorf("r.XML: got error %v, want %v", err, safehttp.ErrBodyTooLarge)
+	}
+}
@@ -15,37 +15,108 @@
 package safehttp
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/safehtml"
 	"github.com/google/safehtml/template"
+	"golang.org/x/net/http/httpguts"
 )
 
 // DefaultDispatcher is responsible for writing safe responses.
-type DefaultDispatcher struct{}
+//
+// The zero value is ready to use. A DefaultDispatcher with custom options can
+// be passed to NewServeMuxConfig to configure all the handlers of a ServeMux.
+type DefaultDispatcher struct {
+	// JSON configures how JSONResponses are written.
+	JSON JSONOptions
+}
+
+// JSONOptions configure how the DefaultDispatcher writes JSONResponses.
+//
+// Regardless of the options, JSON responses are always served with the
+// X-Content-Type-Options: nosniff header and HTML characters are escaped, so
+// that they can't be rendered as HTML, and they are rejected if they contain
+// invalid UTF-8.
+type JSONOptions struct {
+	// OmitXSSIPrefix disables the )]}',\n prefix written before JSON responses
+	// to break their parsing as JavaScript and prevent Cross-Site Script
+	// Inclusion. It should only be set for APIs whose clients can't strip the
+	// prefix, and only if the responses don't contain sensitive data or are
+	// protected by other means against being loaded cross-site, e.g. by the
+	// fetchmetadata plugin.
+	OmitXSSIPrefix bool
+}
+
+// JSONStream can be used as the Data of a JSONResponse to write a large JSON
+// array without holding it in memory: its elements are encoded and written as
+// they are received, until the channel is closed.
+//
+// If an element can't be encoded, the response is left incomplete, so
+// clients fail to parse it, and the remaining elements are received and
+// discarded in the background until the channel is closed, so that the
+// producer isn't blocked forever. Producers should stop sending and close the
+// channel once the context of the request is done, which also happens when the
+// client goes away.
+type JSONStream <-chan interface{}
+
+// xssiPrefix is written before JSON responses to break their parsing as
+// JavaScript in order to prevent XSSI.
+const xssiPrefix = ")]}',\n"
 
 // Write writes the response to the http.ResponseWriter if it's deemed safe. It
 // returns a non-nil error if the response is deemed unsafe or if the writing
 // operation fails.
 //
 // For JSONResponses, the underlying object is serialised and written if it's a
-// valid JSON.
+// valid JSON, according to the JSON options. JSONStreams are written as
+// arrays.
 //
 // For TemplateResponses, the parsed template is applied to the provided data
 // object. If the funcMap is non-nil, its elements override the  existing names
 // to functions mappings in the template. An attempt to define a new name to
 // function mapping that is not already in the template will result in a panic.
 //
+// For StreamResponses, the body is written by the stream function, as long as
+// the content type can't be rendered as active content (e.g. HTML, XML or
+// JavaScript). Their declared trailers are only set if the stream function
+// succeeds. HTMLStreamResponses can only write safehtml.HTML chunks.
+//
+// For EventStreamResponses, each event is encoded as a Server-Sent Event and
+// flushed to the client. Events with IDs or types containing newlines are
+// rejected.
+//
+// ProblemDetails are written as application/problem+json, without the XSSI
+// prefix of JSONResponses.
+//
+// For WebSocketResponses, the connection is upgraded to the WebSocket protocol
+// if the request is a valid handshake from an allowed origin.
+//
 // Write sets the Content-Type accordingly.
-func (DefaultDispatcher) Write(rw http.ResponseWriter, resp Response) error {
+func (d DefaultDispatcher) Write(rw http.ResponseWriter, resp Response) error {
 	switch x := resp.(type) {
 	case JSONResponse:
-		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
-		io.WriteString(rw, ")]}',\n") // Break parsing of JavaScript in order to prevent XSSI.
-		return json.NewEncoder(rw).Encode(x.Data)
+		return d.writeJSON(rw, x)
+	case ProblemDetails:
+		b, err := encodeJSON(x)
+		if err != nil {
+			return err
+		}
+		rw.Header().Set("Content-Type", "application/problem+json")
+		rw.Header().Set("X-Content-Type-Options", "nosniff")
+		_, err = rw.Write(b)
+		return err
 	case StringResponse:
 		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		_, err := rw.Write([]byte(x.Data))
@@ -75,8 +146,51 @@ func (DefaultDispatcher) Write(rw http.ResponseWriter, resp Response) error {
 		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
 		_, err := io.WriteString(rw, x.String())
 		return err
+	case StreamResponse:
+		ct := x.ContentType
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		if !safeStreamContentType(ct) {
+			return fmt.Errorf("%q is not a safe content type for streaming", ct)
+		}
+		trailers, err := declareTrailers(x.Trailers)
+		if err != nil {
+			return err
+		}
+		rw.Header().Set("Content-Type", ct)
+		rw.Header().Set("X-Content-Type-Options", "nosniff")
+		for _, name := range x.Trailers {
+			rw.Header().Add("Trailer", textproto.CanonicalMIMEHeaderKey(name))
+		}
+		if x.Stream == nil {
+			return nil
+		}
+		sw := streamWriter{rw: rw, trailers: trailers}
+		if err := x.Stream(sw); err != nil {
+			return err
+		}
+		for name, v := range trailers {
+			if v != nil {
+				rw.Header().Set(name, *v)
+			}
+		}
+		return nil
+	case HTMLStreamResponse:
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if x.Stream == nil {
+			return nil
+		}
+		return x.Stream(htmlStreamWriter{rw: rw})
+	case EventStreamResponse:
+		return writeEventStream(rw, x)
+	case WebSocketResponse:
+		return writeWebSocket(rw, x)
 	case FileServerResponse:
 		rw.Header().Set("Content-Type", x.ContentType())
+		if code := x.StatusCode(); code != StatusOK {
+			rw.WriteHeader(int(code))
+		}
 		// The http package will take care of writing the file body.
 		return nil
 	case RedirectResponse:
@@ -90,6 +204,261 @@ func (DefaultDispatcher) Write(rw http.ResponseWriter, resp Response) error {
 	}
 }
 
+// writeJSON writes resp to rw according to the JSON options of d. Nothing is
+// written if the first value can't be encoded, so that an error response can
+// still be written.
+func (d DefaultDispatcher) writeJSON(rw http.ResponseWriter, resp JSONResponse) error {
+	h := rw.Header()
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	prefix := xssiPrefix
+	if d.JSON.OmitXSSIPrefix {
+		prefix = ""
+	}
+	stream, ok := resp.Data.(JSONStream)
+	if !ok {
+		b, err := encodeJSON(resp.Data)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(rw, prefix+string(b))
+		return err
+	}
+
+	sep := prefix + "["
+	for v := range stream {
+		b, err := encodeJSON(v)
+		if err == nil {
+			_, err = io.WriteString(rw, sep)
+		}
+		if err == nil {
+			// Drop the newline added by the encoder.
+			_, err = rw.Write(b[:len(b)-1])
+		}
+		if err != nil {
+			go drain(stream)
+			return err
+		}
+		sep = ","
+	}
+	if sep != "," {
+		_, err := io.WriteString(rw, sep+"]\n")
+		return err
+	}
+	_, err := io.WriteString(rw, "]\n")
+	return err
+}
+
+// drain receives and discards the values of stream until it's closed.
+func drain(stream JSONStream) {
+	for range stream {
+	}
+}
+
+// encodeJSON encodes v as JSON followed by a newline, escaping HTML
+// characters. It returns an error if the result contains invalid UTF-8, which
+// can be produced by types implementing json.Marshaler.
+func encodeJSON(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(b.Bytes()) {
+		return nil, errors.New("the JSON response contains invalid UTF-8")
+	}
+	return b.Bytes(), nil
+}
+
+// safeStreamContentType reports whether ct can't be rendered by browsers as
+// active content, such as HTML, XML or scripts.
+func safeStreamContentType(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	switch mt {
+	case "text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml":
+		return false
+	}
+	return !strings.Contains(mt, "script") && !strings.HasSuffix(mt, "+xml")
+}
+
+// forbiddenTrailers are the headers that can't be sent as trailers, since
+// they are needed before the body to frame, route or authenticate the message,
+// or are hop-by-hop. See RFC 7230, Section 4.1.2.
+var forbiddenTrailers = map[string]bool{
+	"Authorization":       true,
+	"Cache-Control":       true,
+	"Connection":          true,
+	"Content-Encoding":    true,
+	"Content-Length":      true,
+	"Content-Range":       true,
+	"Content-Type":        true,
+	"Cookie":              true,
+	"Expect":              true,
+	"Host":                true,
+	"Keep-Alive":          true,
+	"Max-Forwards":        true,
+	"Pragma":              true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Range":               true,
+	"Set-Cookie":          true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Www-Authenticate":    true,
+}
+
+// declareTrailers validates the trailer names and returns a map from their
+// canonical form to their, not yet set, values.
+func declareTrailers(names []string) (map[string]*string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	trailers := make(map[string]*string, len(names))
+	for _, n := range names {
+		if !httpguts.ValidHeaderFieldName(n) {
+			return nil, fmt.Errorf("invalid trailer name %q", n)
+		}
+		n = textproto.CanonicalMIMEHeaderKey(n)
+		if forbiddenTrailers[n] {
+			return nil, fmt.Errorf("%q can't be sent as a trailer", n)
+		}
+		trailers[n] = nil
+	}
+	return trailers, nil
+}
+
+// streamWriter implements StreamWriter.
+type streamWriter struct {
+	rw http.ResponseWriter
+	// trailers are the declared trailers and their values, which are only
+	// written to rw once the stream is complete.
+	trailers map[string]*string
+}
+
+func (s streamWriter) Write(p []byte) (int, error) {
+	return s.rw.Write(p)
+}
+
+func (s streamWriter) SetTrailer(name, value string) error {
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	if _, ok := s.trailers[name]; !ok {
+		return fmt.Errorf("trailer %q wasn't declared", name)
+	}
+	if !httpguts.ValidHeaderFieldValue(value) {
+		return fmt.Errorf("invalid value for trailer %q", name)
+	}
+	s.trailers[name] = &value
+	return nil
+}
+
+func (s streamWriter) Flush() error {
+	return flush(s.rw)
+}
+
+// htmlStreamWriter implements HTMLStreamWriter. Unlike streamWriter, it doesn't
+// implement io.Writer, so that handlers can't write unsafe HTML by asserting
+// the HTMLStreamWriter to an io.Writer.
+type htmlStreamWriter struct {
+	rw http.ResponseWriter
+}
+
+func (s htmlStreamWriter) WriteHTML(h safehtml.HTML) error {
+	_, err := io.WriteString(s.rw, h.String())
+	return err
+}
+
+func (s htmlStreamWriter) Flush() error {
+	return flush(s.rw)
+}
+
+// flush sends the data written to rw so far to the client.
+func flush(rw http.ResponseWriter) error {
+	f, ok := rw.(http.Flusher)
+	if !ok {
+		return errors.New("the http.ResponseWriter doesn't support flushing")
+	}
+	f.Flush()
+	return nil
+}
+
+// writeEventStream writes the events of resp to rw until the Events channel is
+// closed or the request context is done.
+func writeEventStream(rw http.ResponseWriter, resp EventStreamResponse) error {
+	f, ok := rw.(http.Flusher)
+	if !ok {
+		return errors.New("the http.ResponseWriter doesn't support flushing")
+	}
+	ctx := context.Background()
+	if resp.Request != nil {
+		ctx = resp.Request.Context()
+	}
+	var heartbeat <-chan time.Time
+	if resp.Heartbeat > 0 {
+		t := time.NewTicker(resp.Heartbeat)
+		defer t.Stop()
+		heartbeat = t.C
+	}
+
+	h := rw.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	rw.WriteHeader(int(StatusOK))
+	f.Flush()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat:
+			if _, err := io.WriteString(rw, ": heartbeat\n\n"); err != nil {
+				return err
+			}
+		case e, ok := <-resp.Events:
+			if !ok {
+				return nil
+			}
+			b, err := encodeEvent(e)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(rw, b); err != nil {
+				return err
+			}
+		}
+		f.Flush()
+	}
+}
+
+// encodeEvent serializes a Server-Sent Event.
+func encodeEvent(e Event) (string, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return "", fmt.Errorf("invalid event ID %q", e.ID)
+	}
+	if strings.ContainsAny(e.Type, "\r\n") {
+		return "", fmt.Errorf("invalid event type %q", e.Type)
+	}
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Type != "" {
+		b.WriteString("event: " + e.Type + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(e.Data)
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return b.String(), nil
+}
+
 // Error writes the error response to the http.ResponseWriter.
 //
 // Error sets the Content-Type to "text/plain; charset=utf-8" through calling
@@ -15,11 +15,18 @@
 package safehttp_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"html/template"
+	"io"
+	"io/ioutil"
 	"math"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-safeweb/safehttp"
@@ -204,7 +211,7 @@ func TestDefaultDispatcherInvalidResponse(t *testing.T) {
 				d := &safehttp.DefaultDispatcher{}
 				return d.Write(w, safehttp.JSONResponse{math.Inf(1)})
 			},
-			want: ")]}',\n",
+			want: "",
 		},
 	}
 	for _, tt := range tests {
@@ -221,3 +228,367 @@ func TestDefaultDispatcherInvalidResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestDefaultDispatcherStream(t *testing.T) {
+	tests := []struct {
+		name        string
+		resp        safehttp.Response
+		wantHeaders map[string][]string
+		wantBody    string
+	}{
+		{
+			name: "Default content type",
+			resp: safehttp.StreamResponse{Stream: func(w safehttp.StreamWriter) error {
+				w.Write([]byte("chunk1,"))
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				_, err := w.Write([]byte("chunk2"))
+				return err
+			}},
+			wantHeaders: map[string][]string{"Content-Type": {"application/octet-stream"}, "X-Content-Type-Options": {"nosniff"}},
+			wantBody:    "chunk1,chunk2",
+		},
+		{
+			name: "Event stream",
+			resp: safehttp.StreamResponse{ContentType: "text/event-stream", Stream: func(w safehttp.StreamWriter) error {
+				_, err := w.Write([]byte("data: hello\n\n"))
+				return err
+			}},
+			wantHeaders: map[string][]string{"Content-Type": {"text/event-stream"}, "X-Content-Type-Options": {"nosniff"}},
+			wantBody:    "data: hello\n\n",
+		},
+		{
+			name: "HTML",
+			resp: safehttp.HTMLStreamResponse{Stream: func(w safehttp.HTMLStreamWriter) error {
+				if err := w.WriteHTML(safehtml.HTMLEscaped("<h1>")); err != nil {
+					return err
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				return w.WriteHTML(safehtml.HTMLEscaped("Hello"))
+			}},
+			wantHeaders: map[string][]string{"Content-Type": {"text/html; charset=utf-8"}},
+			wantBody:    "&lt;h1&gt;Hello",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			d := &safehttp.DefaultDispatcher{}
+			if err := d.Write(rw, tt.resp); err != nil {
+				t.Fatalf("d.Write(rw, tt.resp): got error %v", err)
+			}
+			if diff := cmp.Diff(tt.wantHeaders, map[string][]string(rw.Header())); diff != "" {
+				t.Errorf("rw.Header() mismatch (-want +got):\n%s", diff)
+			}
+			if got := rw.Body.String(); got != tt.wantBody {
+				t.Errorf("response body: got %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestDefaultDispatcherHTMLStreamNotWriter(t *testing.T) {
+	rw := httptest.NewRecorder()
+	d := &safehttp.DefaultDispatcher{}
+	err := d.Write(rw, safehttp.HTMLStreamResponse{Stream: func(w safehttp.HTMLStreamWriter) error {
+		if _, ok := w.(io.Writer); ok {
+			return errors.New("the HTMLStreamWriter is an io.Writer")
+		}
+		return nil
+	}})
+	if err != nil {
+		t.Errorf("d.Write(rw, resp): got error %v", err)
+	}
+}
+
+func TestDefaultDispatcherStreamTrailers(t *testing.T) {
+	d := &safehttp.DefaultDispatcher{}
+	h := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		err := d.Write(rw, safehttp.StreamResponse{
+			Trailers: []string{"x-checksum", "Grpc-Status"},
+			Stream: func(w safehttp.StreamWriter) error {
+				if _, err := w.Write([]byte("data")); err != nil {
+					return err
+				}
+				if err := w.SetTrailer("X-Checksum", "abc"); err != nil {
+					return err
+				}
+				return w.SetTrailer("grpc-status", "0")
+			},
+		})
+		if err != nil {
+			t.Errorf("d.Write: got error %v", err)
+		}
+	}))
+	defer h.Close()
+
+	resp, err := h.Client().Get(h.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll: %v", err)
+	}
+	if got, want := string(body), "data"; got != want {
+		t.Errorf("response body: got %q, want %q", got, want)
+	}
+	want := http.Header{"X-Checksum": {"abc"}, "Grpc-Status": {"0"}}
+	if diff := cmp.Diff(want, resp.Trailer); diff != "" {
+		t.Errorf("resp.Trailer mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDefaultDispatcherStreamTrailersFailedStream(t *testing.T) {
+	rw := httptest.NewRecorder()
+	d := &safehttp.DefaultDispatcher{}
+	err := d.Write(rw, safehttp.StreamResponse{
+		Trailers: []string{"X-Checksum"},
+		Stream: func(w safehttp.StreamWriter) error {
+			if err := w.SetTrailer("X-Checksum", "abc"); err != nil {
+				return err
+			}
+			return errors.New("download interrupted")
+		},
+	})
+	if err == nil {
+		t.Fatal("d.Write: got nil error, want error")
+	}
+	if got := rw.Result().Trailer.Get("X-Checksum"); got != "" {
+		t.Errorf("X-Checksum trailer: got %q, want none", got)
+	}
+}
+
+func TestDefaultDispatcherStreamInvalidTrailers(t *testing.T) {
+	tests := []struct {
+		name     string
+		trailers []string
+		set      string
+		value    string
+	}{
+		{name: "Forbidden", trailers: []string{"content-length"}},
+		{name: "Set-Cookie", trailers: []string{"Set-Cookie"}},
+		{name: "Invalid name", trailers: []string{"X Checksum"}},
+		{name: "Undeclared", trailers: []string{"X-Checksum"}, set: "X-Other", value: "abc"},
+		{name: "Invalid value", trailers: []string{"X-Checksum"}, set: "X-Checksum", value: "abc\r\nSet-Cookie: x=y"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			d := &safehttp.DefaultDispatcher{}
+			err := d.Write(rw, safehttp.StreamResponse{
+				Trailers: tt.trailers,
+				Stream: func(w safehttp.StreamWriter) error {
+					return w.SetTrailer(tt.set, tt.value)
+				},
+			})
+			if err == nil {
+				t.Error("d.Write: got nil error, want error")
+			}
+		})
+	}
+}
+
+func TestDefaultDispatcherStreamUnsafeContentType(t *testing.T) {
+	for _, ct := range []string{"text/html", "text/html; charset=utf-8", "image/svg+xml", "application/javascript", "application/rss+xml", "invalid;;"} {
+		t.Run(ct, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			d := &safehttp.DefaultDispatcher{}
+			called := false
+			err := d.Write(rw, safehttp.StreamResponse{ContentType: ct, Stream: func(w safehttp.StreamWriter) error {
+				called = true
+				return nil
+			}})
+			if err == nil {
+				t.Error("d.Write(rw, resp): got nil, want error")
+			}
+			if called {
+				t.Error("Stream was called for an unsafe content type")
+			}
+		})
+	}
+}
+
+func TestDefaultDispatcherEventStream(t *testing.T) {
+	events := make(chan safehttp.Event, 3)
+	events <- safehttp.Event{Data: "hello"}
+	events <- safehttp.Event{ID: "2", Type: "update", Data: "line1\nline2\r\ndata: injected", Retry: 3 * time.Second}
+	events <- safehttp.Event{}
+	close(events)
+
+	rw := httptest.NewRecorder()
+	d := &safehttp.DefaultDispatcher{}
+	if err := d.Write(rw, safehttp.EventStreamResponse{Events: events}); err != nil {
+		t.Fatalf("d.Write(rw, resp): got error %v", err)
+	}
+
+	wantHeaders := map[string][]string{
+		"Content-Type":  {"text/event-stream"},
+		"Cache-Control": {"no-cache"},
+	}
+	if diff := cmp.Diff(wantHeaders, map[string][]string(rw.Header())); diff != "" {
+		t.Errorf("rw.Header() mismatch (-want +got):\n%s", diff)
+	}
+	want := "data: hello\n\n" +
+		"id: 2\nevent: update\nretry: 3000\ndata: line1\ndata: line2\ndata: data: injected\n\n" +
+		"data: \n\n"
+	if got := rw.Body.String(); got != want {
+		t.Errorf("response body: got %q, want %q", got, want)
+	}
+}
+
+func TestDefaultDispatcherEventStreamInvalidEvent(t *testing.T) {
+	tests := []safehttp.Event{
+		{ID: "1\nevent: injected"},
+		{ID: "1\x00"},
+		{Type: "update\rdata: injected"},
+	}
+	for _, e := range tests {
+		events := make(chan safehttp.Event, 1)
+		events <- e
+		close(events)
+		rw := httptest.NewRecorder()
+		d := &safehttp.DefaultDispatcher{}
+		if err := d.Write(rw, safehttp.EventStreamResponse{Events: events}); err == nil {
+			t.Errorf("d.Write(rw, %#v): got nil, want error", e)
+		}
+	}
+}
+
+func TestDefaultDispatcherEventStreamDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req := safehttp.NewIncomingRequest(httptest.NewRequest(http.MethodGet, "/", nil)).WithContext(ctx)
+	rw := httptest.NewRecorder()
+	d := &safehttp.DefaultDispatcher{}
+	resp := safehttp.EventStreamResponse{
+		Events:    make(chan safehttp.Event),
+		Heartbeat: time.Millisecond,
+		Request:   req,
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	if err := d.Write(rw, resp); err != nil {
+		t.Fatalf("d.Write(rw, resp): got error %v", err)
+	}
+	if got, want := rw.Body.String(), ": heartbeat\n\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("response body: got %q, want prefix %q", got, want)
+	}
+}
+
+func TestDefaultDispatcherJSON(t *testing.T) {
+	stream := func(vs ...interface{}) safehttp.JSONStream {
+		c := make(chan interface{}, len(vs))
+		for _, v := range vs {
+			c <- v
+		}
+		close(c)
+		return c
+	}
+	tests := []struct {
+		name     string
+		d        safehttp.DefaultDispatcher
+		data     interface{}
+		wantBody string
+	}{
+		{
+			name:     "Default",
+			data:     map[string]string{"field": "<script>"},
+			wantBody: ")]}',\n{\"field\":\"\\u003cscript\\u003e\"}\n",
+		},
+		{
+			name:     "Omit XSSI prefix",
+			d:        safehttp.DefaultDispatcher{JSON: safehttp.JSONOptions{OmitXSSIPrefix: true}},
+			data:     []int{1, 2},
+			wantBody: "[1,2]\n",
+		},
+		{
+			name:     "Stream",
+			data:     stream(1, "two", map[string]int{"three": 3}),
+			wantBody: ")]}',\n[1,\"two\",{\"three\":3}]\n",
+		},
+		{
+			name:     "Empty stream",
+			d:        safehttp.DefaultDispatcher{JSON: safehttp.JSONOptions{OmitXSSIPrefix: true}},
+			data:     stream(),
+			wantBody: "[]\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			if err := tt.d.Write(rw, safehttp.JSONResponse{Data: tt.data}); err != nil {
+				t.Fatalf("tt.d.Write(rw, resp): got error %v", err)
+			}
+			wantHeaders := map[string][]string{
+				"Content-Type":           {"application/json; charset=utf-8"},
+				"X-Content-Type-Options": {"nosniff"},
+			}
+			if diff := cmp.Diff(wantHeaders, map[string][]string(rw.Header())); diff != "" {
+				t.Errorf("rw.Header() mismatch (-want +got):\n%s", diff)
+			}
+			if got := rw.Body.String(); got != tt.wantBody {
+				t.Errorf("response body: got %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestDefaultDispatcherJSONInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data interface{}
+	}{
+		{name: "Invalid UTF-8", data: json.RawMessage("\"\xff\"")},
+		{name: "Invalid stream element", data: safehttp.JSONStream(func() chan interface{} {
+			c := make(chan interface{}, 1)
+			c <- math.Inf(1)
+			close(c)
+			return c
+		}())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			d := safehttp.DefaultDispatcher{}
+			if err := d.Write(rw, safehttp.JSONResponse{Data: tt.data}); err == nil {
+				t.Error("d.Write(rw, resp): got nil, want error")
+			}
+			if got := rw.Body.String(); got != "" {
+				t.Errorf("response body: got %q, want empty", got)
+			}
+		})
+	}
+}
+
+func TestDefaultDispatcherJSONStreamInvalidDrained(t *testing.T) {
+	c := make(chan interface{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(c)
+		for _, v := range []interface{}{1, math.Inf(1), 3, 4} {
+			c <- v
+		}
+	}()
+
+	rw := httptest.NewRecorder()
+	d := safehttp.DefaultDispatcher{}
+	if err := d.Write(rw, safehttp.JSONResponse{Data: safehttp.JSONStream(c)}); err == nil {
+		t.Error("d.Write(rw, resp): got nil, want error")
+	}
+	if got, want := rw.Body.String(), ")]}',\n[1"; got != want {
+		t.Errorf("response body: got %q, want %q", got, want)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("the producer of the stream is still blocked")
+	}
+}
@@ -14,7 +14,13 @@
 
 package safehttp
 
-import "net/http"
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
 
 // Dispatcher is responsible for writing a response received from the
 // ResponseWriter to the underlying http.ResponseWriter.
@@ -49,3 +55,49 @@ type Dispatcher interface {
 func writeTextError(rw http.ResponseWriter, resp ErrorResponse) {
 	http.Error(rw, http.StatusText(int(resp.Code())), int(resp.Code()))
 }
+
+// writeJSONError writes a JSON error response containing only the status code
+// and its name, e.g. {"error":"forbidden","code":403}.
+func writeJSONError(rw http.ResponseWriter, resp ErrorResponse) {
+	code := int(resp.Code())
+	name := strings.ReplaceAll(strings.ToLower(http.StatusText(code)), " ", "_")
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}{Error: name, Code: code})
+}
+
+// acceptsJSON reports whether the Accept header of the request prefers
+// application/json over text/html and text/plain. Only media types explicitly
+// listed are considered, so wildcards never select JSON.
+func acceptsJSON(r *IncomingRequest) bool {
+	var jsonQ, textQ float64
+	for _, v := range r.Header.Values("Accept") {
+		for _, mr := range strings.Split(v, ",") {
+			mt, params, err := mime.ParseMediaType(mr)
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if qs, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(qs, 64); err != nil {
+					continue
+				}
+			}
+			switch mt {
+			case "application/json":
+				if q > jsonQ {
+					jsonQ = q
+				}
+			case "text/html", "text/plain":
+				if q > textQ {
+					textQ = q
+				}
+			}
+		}
+	}
+	return jsonQ > 0 && jsonQ >= textQ
+}
@@ -68,6 +68,11 @@
 //
 // When the request reaches the server, the following happens:
 //
+// 0. [Pre-filter phase] The request is passed to all installed pre-filters, in
+// order of installation. A pre-filter that returns a status code other than
+// StatusOK causes an error response to be written and stops the processing of
+// the request: it is not routed and no Interceptor is called.
+//
 // 1. The ServeMux routes the request (i.e. picks the appropriate Handler that
 // will be eventually called). The HTTP method of the request is checked whether
 // it matches any registered Handler.
@@ -102,6 +107,7 @@
 //  Stack trace of the flow:
 //
 //  Mux.ServeHTTP()
+//  --+ Pre-filters are called.
 //  --+ Mux routes the request and checks the method.
 //  --+ InterceptorFoo.Before()
 //  --+ InterceptorBar.Before()
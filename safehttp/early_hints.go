@@ -0,0 +1,132 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/safehtml"
+)
+
+// Link is a link to a resource for browsers to fetch early, sent with
+// ResponseWriter.WriteEarlyHints.
+type Link struct {
+	// URL is the URL of the resource. It's a TrustedResourceURL since
+	// preloaded resources, such as scripts, are executed with the origin's
+	// privileges when they are used.
+	URL safehtml.TrustedResourceURL
+	// Rel is the relation type of the link, one of "preload", "modulepreload"
+	// or "preconnect". If empty, "preload" is used.
+	Rel string
+	// As is the type of content of the resource, e.g. "script", "style" or
+	// "font". It's required for "preload" links.
+	As string
+	// CrossOrigin, if set, is the CORS mode of the request, either
+	// "anonymous" or "use-credentials". Fonts must be preloaded in CORS mode.
+	CrossOrigin string
+	// Type, if set, is the MIME type of the resource, e.g. "font/woff2".
+	Type string
+}
+
+var (
+	linkRels         = map[string]bool{"preload": true, "modulepreload": true, "preconnect": true}
+	linkDestinations = map[string]bool{
+		"audio": true, "document": true, "embed": true, "fetch": true, "font": true, "image": true,
+		"object": true, "script": true, "style": true, "track": true, "video": true, "worker": true,
+	}
+)
+
+// value returns the Link header value of the link, or an error if the link is
+// invalid.
+func (l Link) value() (string, error) {
+	u := l.URL.String()
+	if u == "" || strings.IndexFunc(u, func(r rune) bool { return r <= ' ' || r == 0x7f || r == '<' || r == '>' }) >= 0 {
+		return "", fmt.Errorf("invalid link URL %q", u)
+	}
+	rel := l.Rel
+	if rel == "" {
+		rel = "preload"
+	}
+	if !linkRels[rel] {
+		return "", fmt.Errorf("invalid link rel %q", rel)
+	}
+	var b strings.Builder
+	b.WriteString("<" + u + ">; rel=" + rel)
+	switch {
+	case l.As != "" && !linkDestinations[l.As]:
+		return "", fmt.Errorf("invalid link as %q", l.As)
+	case l.As == "" && rel == "preload":
+		return "", errors.New("preload links require as")
+	case l.As != "":
+		b.WriteString("; as=" + l.As)
+	}
+	switch l.CrossOrigin {
+	case "":
+	case "anonymous":
+		b.WriteString("; crossorigin")
+	case "use-credentials":
+		b.WriteString("; crossorigin=use-credentials")
+	default:
+		return "", fmt.Errorf("invalid link crossorigin %q", l.CrossOrigin)
+	}
+	if l.Type != "" {
+		if strings.IndexFunc(l.Type, func(r rune) bool { return r <= ' ' || r == 0x7f || strings.ContainsRune(`"\,;`, r) }) >= 0 {
+			return "", fmt.Errorf("invalid link type %q", l.Type)
+		}
+		b.WriteString(`; type="` + l.Type + `"`)
+	}
+	return b.String(), nil
+}
+
+// WriteEarlyHints sends a 103 Early Hints informational response with Link
+// headers for the given links, so that browsers can start fetching them while
+// the final response is being produced, e.g. while a template is executed.
+// The Link headers are also added to the final response.
+//
+// The 103 response only contains the Link headers. It's only sent for
+// HTTP/1.1 and later requests and it requires a net/http server built with
+// Go 1.19 or later; otherwise only the Link headers of the final response are
+// set.
+//
+// WriteEarlyHints returns an error if a link is invalid, if the Link header has
+// been claimed or if the response has already been written.
+func (f *flight) WriteEarlyHints(links ...Link) error {
+	if f.written {
+		return errors.New("ResponseWriter was already written to")
+	}
+	if f.header.IsClaimed("Link") {
+		return errors.New("claimed header: Link")
+	}
+	values := make([]string, len(links))
+	for i, l := range links {
+		v, err := l.value()
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	if f.req.req.ProtoAtLeast(1, 1) {
+		f.sendEarlyHints(values)
+	}
+	for _, v := range values {
+		f.rw.Header().Add("Link", v)
+	}
+	return nil
+}
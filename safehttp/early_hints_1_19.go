@@ -0,0 +1,34 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.19
+// +build go1.19
+
+package safehttp
+
+// sendEarlyHints writes a 103 Early Hints response with the given Link header
+// values and without any of the headers set so far.
+func (f *flight) sendEarlyHints(links []string) {
+	h := f.rw.Header()
+	final := h.Clone()
+	for k := range h {
+		delete(h, k)
+	}
+	h["Link"] = links
+	f.rw.WriteHeader(int(StatusEarlyHints))
+	delete(h, "Link")
+	for k, v := range final {
+		h[k] = v
+	}
+}
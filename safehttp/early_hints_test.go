@@ -0,0 +1,165 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.19
+// +build go1.19
+
+package safehttp_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/safehtml"
+	"github.com/google/safehtml/testconversions"
+)
+
+func TestWriteEarlyHints(t *testing.T) {
+	mux := safehttp.NewServeMuxConfig(nil).Mux()
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		w.Header().Set("X-Final", "yes")
+		err := w.WriteEarlyHints(
+			safehttp.Link{URL: testconversions.MakeTrustedResourceURLForTest("/static/app.js"), As: "script"},
+			safehttp.Link{URL: testconversions.MakeTrustedResourceURLForTest("https://fonts.example.com/a.woff2"), As: "font", CrossOrigin: "anonymous", Type: "font/woff2"},
+		)
+		if err != nil {
+			t.Errorf("WriteEarlyHints: got err %v", err)
+		}
+		return w.Write(safehtml.HTMLEscaped("hello"))
+	}))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var hints []textproto.MIMEHeader
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				hints = append(hints, header)
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest: %v", err)
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll: %v", err)
+	}
+
+	wantLinks := []string{
+		"</static/app.js>; rel=preload; as=script",
+		`<https://fonts.example.com/a.woff2>; rel=preload; as=font; crossorigin; type="font/woff2"`,
+	}
+	if len(hints) != 1 {
+		t.Fatalf("103 responses: got %d, want 1", len(hints))
+	}
+	if diff := cmp.Diff(wantLinks, hints[0]["Link"]); diff != "" {
+		t.Errorf("103 Link headers mismatch (-want +got):\n%s", diff)
+	}
+	if got := hints[0].Get("X-Final"); got != "" {
+		t.Errorf("103 X-Final header: got %q, want none", got)
+	}
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("resp.StatusCode: got %v, want %v", got, want)
+	}
+	if diff := cmp.Diff(wantLinks, resp.Header["Link"]); diff != "" {
+		t.Errorf("Link headers mismatch (-want +got):\n%s", diff)
+	}
+	if got, want := resp.Header.Get("X-Final"), "yes"; got != want {
+		t.Errorf("X-Final header: got %q, want %q", got, want)
+	}
+	if got, want := string(body), "hello"; got != want {
+		t.Errorf("response body: got %q, want %q", got, want)
+	}
+}
+
+func TestWriteEarlyHintsInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		links []safehttp.Link
+		claim bool
+	}{
+		{
+			name:  "Missing as",
+			links: []safehttp.Link{{URL: testconversions.MakeTrustedResourceURLForTest("/app.js")}},
+		},
+		{
+			name:  "Unknown as",
+			links: []safehttp.Link{{URL: testconversions.MakeTrustedResourceURLForTest("/app.js"), As: "scripts"}},
+		},
+		{
+			name:  "Unknown rel",
+			links: []safehttp.Link{{URL: testconversions.MakeTrustedResourceURLForTest("/app.js"), Rel: "stylesheet"}},
+		},
+		{
+			name:  "Empty URL",
+			links: []safehttp.Link{{As: "script"}},
+		},
+		{
+			name:  "URL breaking out of the header",
+			links: []safehttp.Link{{URL: testconversions.MakeTrustedResourceURLForTest("/a.js>; rel=preload"), As: "script"}},
+		},
+		{
+			name:  "Invalid crossorigin",
+			links: []safehttp.Link{{URL: testconversions.MakeTrustedResourceURLForTest("/a.js"), As: "script", CrossOrigin: "yes"}},
+		},
+		{
+			name:  "Invalid type",
+			links: []safehttp.Link{{URL: testconversions.MakeTrustedResourceURLForTest("/a.js"), As: "script", Type: `text/javascript"; foo`}},
+		},
+		{
+			name:  "Claimed Link header",
+			links: []safehttp.Link{{URL: testconversions.MakeTrustedResourceURLForTest("/a.js"), As: "script"}},
+			claim: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := safehttp.NewServeMuxConfig(nil).Mux()
+			mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				if tt.claim {
+					w.Header().Claim("Link")
+				}
+				if err := w.WriteEarlyHints(tt.links...); err == nil {
+					t.Error("WriteEarlyHints: got nil, want error")
+				}
+				return safehttp.WriteString(w, "hello")
+			}))
+
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com/", nil))
+
+			if got, want := rw.Code, http.StatusOK; got != want {
+				t.Errorf("rw.Code: got %v, want %v", got, want)
+			}
+			if got := rw.Header().Values("Link"); len(got) != 0 {
+				t.Errorf("Link headers: got %q, want none", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import "net/http"
+
+// ErrorHandler renders the error responses written with
+// ResponseWriter.WriteError, by handlers and interceptors alike, e.g. as
+// branded HTML error pages or JSON problem details. Install it with
+// ServeMuxConfig.HandleErrors.
+type ErrorHandler interface {
+	// HandleError returns the response to write for the error resp, e.g. a
+	// TemplateResponse or a ProblemDetails. The response is written by the
+	// Dispatcher with the status code of resp, after the Commit phases of the
+	// interceptors have been run with it, so that, for example, CSP nonces
+	// can be injected in templates.
+	//
+	// If HandleError returns nil, or the Dispatcher refuses to write the
+	// response, the error is written as if there was no ErrorHandler.
+	HandleError(r *IncomingRequest, resp ErrorResponse) Response
+}
+
+// ErrorHandlerFunc is a function implementing ErrorHandler.
+type ErrorHandlerFunc func(r *IncomingRequest, resp ErrorResponse) Response
+
+// HandleError calls f(r, resp).
+func (f ErrorHandlerFunc) HandleError(r *IncomingRequest, resp ErrorResponse) Response {
+	return f(r, resp)
+}
+
+// StatusError is an ErrorResponse carrying the error that caused it, e.g. for
+// an ErrorHandler to log it or to render a more specific page. The error is
+// never written to the client by the DefaultDispatcher.
+type StatusError struct {
+	Status StatusCode
+	Err    error
+}
+
+// Code returns the status code of the error response.
+func (e StatusError) Code() StatusCode {
+	return e.Status
+}
+
+func (e StatusError) Error() string {
+	if e.Err == nil {
+		return e.Status.String()
+	}
+	return e.Status.String() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the error that caused the error response.
+func (e StatusError) Unwrap() error {
+	return e.Err
+}
+
+// ProblemDetails is a problem details object, as specified by RFC 7807. The
+// DefaultDispatcher writes it as application/problem+json. It should only
+// contain information that can be disclosed to the client.
+type ProblemDetails struct {
+	// Type is a URI reference identifying the problem type.
+	Type string `json:"type,omitempty"`
+	// Title is a short, human-readable summary of the problem type.
+	Title string `json:"title,omitempty"`
+	// Status is the HTTP status code.
+	Status int `json:"status,omitempty"`
+	// Detail is a human-readable explanation of this occurrence of the
+	// problem.
+	Detail string `json:"detail,omitempty"`
+	// Instance is a URI reference identifying this occurrence of the problem.
+	Instance string `json:"instance,omitempty"`
+}
+
+// statusWriter writes the status code of an error response produced by an
+// ErrorHandler, instead of the one set by the Dispatcher, if any.
+type statusWriter struct {
+	http.ResponseWriter
+	code    int
+	written bool
+}
+
+func (w *statusWriter) WriteHeader(int) {
+	if !w.written {
+		w.written = true
+		w.ResponseWriter.WriteHeader(w.code)
+	}
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(w.code)
+	return w.ResponseWriter.Write(b)
+}
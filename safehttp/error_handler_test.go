@@ -0,0 +1,153 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-safeweb/safehttp"
+	safetemplate "github.com/google/safehtml/template"
+)
+
+var errorPage = safetemplate.Must(safetemplate.New("error").Parse(`<h1>{{ .Code }} - {{ .Text }}</h1>`))
+
+func TestMuxErrorHandler(t *testing.T) {
+	errSecret := errors.New("secret database error")
+	tests := []struct {
+		name            string
+		handler         safehttp.ErrorHandler
+		err             safehttp.ErrorResponse
+		wantCode        safehttp.StatusCode
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name: "Template page",
+			handler: safehttp.ErrorHandlerFunc(func(r *safehttp.IncomingRequest, resp safehttp.ErrorResponse) safehttp.Response {
+				return &safehttp.TemplateResponse{
+					Template: errorPage,
+					Data: struct {
+						Code int
+						Text string
+					}{int(resp.Code()), resp.Code().String()},
+				}
+			}),
+			err:             safehttp.StatusNotFound,
+			wantCode:        safehttp.StatusNotFound,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "<h1>404 - Not Found</h1>",
+		},
+		{
+			name: "Problem details",
+			handler: safehttp.ErrorHandlerFunc(func(r *safehttp.IncomingRequest, resp safehttp.ErrorResponse) safehttp.Response {
+				return safehttp.ProblemDetails{
+					Title:    resp.Code().String(),
+					Status:   int(resp.Code()),
+					Instance: r.URL().Path(),
+				}
+			}),
+			err:             safehttp.StatusForbidden,
+			wantCode:        safehttp.StatusForbidden,
+			wantContentType: "application/problem+json",
+			wantBody:        `{"title":"Forbidden","status":403,"instance":"/bar"}` + "\n",
+		},
+		{
+			name: "Fallback on nil",
+			handler: safehttp.ErrorHandlerFunc(func(r *safehttp.IncomingRequest, resp safehttp.ErrorResponse) safehttp.Response {
+				return nil
+			}),
+			err:             safehttp.StatusForbidden,
+			wantCode:        safehttp.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden\n",
+		},
+		{
+			name: "Fallback on unsafe response",
+			handler: safehttp.ErrorHandlerFunc(func(r *safehttp.IncomingRequest, resp safehttp.ErrorResponse) safehttp.Response {
+				return "<h1>Forbidden</h1>"
+			}),
+			err:             safehttp.StatusForbidden,
+			wantCode:        safehttp.StatusForbidden,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden\n",
+		},
+		{
+			name: "Status error",
+			handler: safehttp.ErrorHandlerFunc(func(r *safehttp.IncomingRequest, resp safehttp.ErrorResponse) safehttp.Response {
+				if se, ok := resp.(safehttp.StatusError); !ok || !errors.Is(se, errSecret) {
+					t.Errorf("HandleError: got %#v, want a StatusError wrapping errSecret", resp)
+				}
+				return nil
+			}),
+			err:             safehttp.StatusError{Status: safehttp.StatusInternalServerError, Err: errSecret},
+			wantCode:        safehttp.StatusInternalServerError,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Internal Server Error\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.HandleErrors(tt.handler)
+			mux := mb.Mux()
+			mux.Handle("/bar", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return w.WriteError(tt.err)
+			}))
+
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com/bar", nil))
+
+			if got, want := rw.Code, int(tt.wantCode); got != want {
+				t.Errorf("rw.Code: got %v, want %v", got, want)
+			}
+			if got := rw.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type: got %q, want %q", got, tt.wantContentType)
+			}
+			if got := rw.Body.String(); got != tt.wantBody {
+				t.Errorf("response body: got %q, want %q", got, tt.wantBody)
+			}
+			if strings.Contains(rw.Body.String(), errSecret.Error()) {
+				t.Errorf("response body: got %q, must not contain the error", rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestMuxErrorHandlerPreFilter(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.PreFilter(func(r *safehttp.IncomingRequest) safehttp.StatusCode {
+		return safehttp.StatusBadRequest
+	})
+	mb.HandleErrors(safehttp.ErrorHandlerFunc(func(r *safehttp.IncomingRequest, resp safehttp.ErrorResponse) safehttp.Response {
+		return safehttp.ProblemDetails{Status: int(resp.Code())}
+	}))
+	mux := mb.Mux()
+	mux.Handle("/bar", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.WriteString(w, "bar")
+	}))
+
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com/bar", nil))
+
+	if got, want := rw.Code, int(safehttp.StatusBadRequest); got != want {
+		t.Errorf("rw.Code: got %v, want %v", got, want)
+	}
+	if got, want := rw.Body.String(), `{"status":400}`+"\n"; got != want {
+		t.Errorf("response body: got %q, want %q", got, want)
+	}
+}
@@ -17,6 +17,7 @@ package safehttp
 import (
 	"errors"
 	"net/http"
+	"strings"
 )
 
 // FileServer returns a handler that serves HTTP requests with the contents of
@@ -40,10 +41,15 @@ type fileServerResponseWriter struct {
 	// of the Content-Type header.
 	header http.Header
 
+	// contentType, if set, overrides the Content-Type set by the
+	// http.FileServer.
+	contentType string
+
 	// Once WriteHeader is called, any subsequent calls to it are no-ops.
 	committed bool
 
-	// If the first call to WriteHeader is not a 200 OK, we call
+	// If the first call to WriteHeader is not a 200 OK or one of the statuses
+	// used to answer range and conditional requests, we call
 	// flight.WriteError with a 404 StatusCode and make further calls to Write
 	// no-ops in order to not leak information about the filesystem.
 	errored bool
@@ -78,6 +84,14 @@ func (fsrw *fileServerResponseWriter) WriteHeader(statusCode int) {
 	if len(fsrw.header["Content-Type"]) > 0 {
 		ct = fsrw.header["Content-Type"][0]
 	}
+	if fsrw.contentType != "" {
+		ct = fsrw.contentType
+	}
+	if statusCode == int(StatusPartialContent) && strings.HasPrefix(fsrw.header.Get("Content-Type"), "multipart/byteranges;") {
+		// Responses to requests for multiple ranges need the boundary set by
+		// the http.FileServer.
+		ct = fsrw.header.Get("Content-Type")
+	}
 	// Content-Type should have been set by the http.FileServer.
 	// Note: Add or Set might panic if a header has been already claimed. This
 	// is intended behavior.
@@ -98,18 +112,24 @@ func (fsrw *fileServerResponseWriter) WriteHeader(statusCode int) {
 		}
 	}
 
-	if statusCode != int(StatusOK) {
+	switch code := StatusCode(statusCode); code {
+	case StatusOK, StatusPartialContent, StatusNotModified:
+		fsrw.result = fsrw.flight.Write(FileServerResponse{
+			Path:        fsrw.flight.req.URL().Path(),
+			contentType: ct,
+			code:        code,
+		})
+	case StatusPreconditionFailed, StatusRequestedRangeNotSatisfiable:
+		// These are answers to the conditional and range headers of the
+		// request about a file that exists.
+		fsrw.errored = true
+		fsrw.result = fsrw.flight.WriteError(code)
+	default:
 		fsrw.errored = true
-		// We are writing 404 for every error to avoid leaking information about
-		// the filesystem.
+		// We are writing 404 for every other error to avoid leaking
+		// information about the filesystem.
 		fsrw.result = fsrw.flight.WriteError(StatusNotFound)
-		return
 	}
-
-	fsrw.result = fsrw.flight.Write(FileServerResponse{
-		Path:        fsrw.flight.req.URL().Path(),
-		contentType: ct,
-	})
 }
 
 // FileServerResponse represents a FileServer response.
@@ -119,9 +139,19 @@ type FileServerResponse struct {
 
 	// private, to not allow modifications
 	contentType string
+	code        StatusCode
 }
 
 // ContentType is the Content-Type of the response.
 func (resp FileServerResponse) ContentType() string {
 	return resp.contentType
 }
+
+// StatusCode is the status code of the response: 200 OK, 206 Partial Content
+// for range requests or 304 Not Modified for conditional requests.
+func (resp FileServerResponse) StatusCode() StatusCode {
+	if resp.code == 0 {
+		return StatusOK
+	}
+	return resp.code
+}
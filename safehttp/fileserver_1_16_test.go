@@ -12,6 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build go1.16
 // +build go1.16
 
 package safehttp_test
@@ -19,11 +20,18 @@ package safehttp_test
 import (
 	"embed"
 	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/staticheaders"
 )
 
 //go:embed testdata
@@ -89,3 +97,361 @@ func TestFileServerEmbed(t *testing.T) {
 		})
 	}
 }
+
+func TestFileServerFS(t *testing.T) {
+	root := fstest.MapFS{
+		"index.html":    {Data: []byte("<h1>index</h1>")},
+		"app.js":        {Data: []byte("alert(1)")},
+		"style.css":     {Data: []byte("p {}")},
+		"logo.PNG":      {Data: []byte("\x89PNG")},
+		"upload.txt.gz": {Data: []byte("<script>alert(1)</script>")},
+		"noext":         {Data: []byte("<html><script>alert(1)</script>")},
+	}
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		wantCT   string
+		wantBody string
+	}{
+		{
+			name:     "index",
+			path:     "/",
+			wantCode: 200,
+			wantCT:   "text/html; charset=utf-8",
+			wantBody: "<h1>index</h1>",
+		},
+		{
+			name:     "javascript",
+			path:     "/app.js",
+			wantCode: 200,
+			wantCT:   "text/javascript; charset=utf-8",
+			wantBody: "alert(1)",
+		},
+		{
+			name:     "css",
+			path:     "/style.css",
+			wantCode: 200,
+			wantCT:   "text/css; charset=utf-8",
+			wantBody: "p {}",
+		},
+		{
+			name:     "uppercase extension",
+			path:     "/logo.PNG",
+			wantCode: 200,
+			wantCT:   "image/png",
+			wantBody: "\x89PNG",
+		},
+		{
+			name:     "unknown extension is not sniffed",
+			path:     "/upload.txt.gz",
+			wantCode: 200,
+			wantCT:   "application/octet-stream",
+			wantBody: "<script>alert(1)</script>",
+		},
+		{
+			name:     "no extension is not sniffed",
+			path:     "/noext",
+			wantCode: 200,
+			wantCT:   "application/octet-stream",
+			wantBody: "<html><script>alert(1)</script>",
+		},
+		{
+			name:     "missing file",
+			path:     "/missing.js",
+			wantCode: 404,
+			wantCT:   "text/plain; charset=utf-8",
+			wantBody: "Not Found\n",
+		},
+		{
+			name:     "backslash traversal",
+			path:     `/..\secret`,
+			wantCode: 404,
+			wantCT:   "text/plain; charset=utf-8",
+			wantBody: "Not Found\n",
+		},
+	}
+
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(setHeaderInterceptor{name: "Interceptor", value: "ran"})
+	m := mb.Mux()
+	m.Handle("/", safehttp.MethodGet, safehttp.FileServerFS(root))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(safehttp.MethodGet, "https://test.science/", nil)
+			req.URL.Path = tt.path
+			m.ServeHTTP(rr, req)
+
+			if got := rr.Code; got != tt.wantCode {
+				t.Errorf("status code got: %v want: %v", got, tt.wantCode)
+			}
+			if got := rr.Header().Get("Content-Type"); tt.wantCT != got {
+				t.Errorf("Content-Type: got %q want %q", got, tt.wantCT)
+			}
+			if got, want := rr.Header().Get("X-Content-Type-Options"), "nosniff"; got != want {
+				t.Errorf("X-Content-Type-Options: got %q want %q", got, want)
+			}
+			if got, want := rr.Header().Get("Interceptor"), "ran"; got != want {
+				t.Errorf("Interceptor header: got %q want %q", got, want)
+			}
+			if diff := cmp.Diff(tt.wantBody, rr.Body.String()); diff != "" {
+				t.Errorf("Response body diff (-want,+got): \n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFileServerFSDotDot(t *testing.T) {
+	root := fstest.MapFS{"index.html": {Data: []byte("<h1>index</h1>")}}
+	m := safehttp.NewServeMuxConfig(nil).Mux()
+	m.Handle("/", safehttp.MethodGet, safehttp.FileServerFS(root))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(safehttp.MethodGet, "https://test.science/", nil)
+	req.URL.Path = "/static/../../index.html"
+	m.ServeHTTP(rr, req)
+
+	if rr.Code == 200 {
+		t.Errorf("status code got: %v want not 200", rr.Code)
+	}
+}
+
+func TestFileServerFSWithOptions(t *testing.T) {
+	root := fstest.MapFS{
+		"assets/app.3f2a1b9c.js": {Data: []byte("alert(1)")},
+		"assets/app.js":          {Data: []byte("alert(2)")},
+		"docs/index.html":        {Data: []byte("<h1>docs</h1>")},
+	}
+	tests := []struct {
+		name             string
+		opts             safehttp.FileServerOptions
+		path             string
+		wantCode         int
+		wantCacheControl string
+	}{
+		{
+			name:     "listing disabled by default",
+			path:     "/assets/",
+			wantCode: 404,
+		},
+		{
+			name:     "listing allowed",
+			opts:     safehttp.FileServerOptions{AllowDirectoryListing: true},
+			path:     "/assets/",
+			wantCode: 200,
+		},
+		{
+			name:     "directory with index",
+			path:     "/docs/",
+			wantCode: 200,
+		},
+		{
+			name:             "fingerprinted asset",
+			opts:             safehttp.FileServerOptions{Immutable: safehttp.Fingerprinted},
+			path:             "/assets/app.3f2a1b9c.js",
+			wantCode:         200,
+			wantCacheControl: "public, max-age=31536000, immutable",
+		},
+		{
+			name:     "not fingerprinted asset",
+			opts:     safehttp.FileServerOptions{Immutable: safehttp.Fingerprinted},
+			path:     "/assets/app.js",
+			wantCode: 200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.Intercept(staticheaders.Interceptor{})
+			m := mb.Mux()
+			m.Handle("/", safehttp.MethodGet, safehttp.FileServerFSWithOptions(root, tt.opts))
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(safehttp.MethodGet, "https://test.science/", nil)
+			req.URL.Path = tt.path
+			m.ServeHTTP(rr, req)
+
+			if got := rr.Code; got != tt.wantCode {
+				t.Errorf("rr.Code: got %v, want %v", got, tt.wantCode)
+			}
+			if got := rr.Header().Get("Cache-Control"); got != tt.wantCacheControl {
+				t.Errorf("Cache-Control: got %q, want %q", got, tt.wantCacheControl)
+			}
+			wantHeaders := map[string]string{"X-Content-Type-Options": "nosniff", "X-XSS-Protection": "0"}
+			for k, want := range wantHeaders {
+				if got := rr.Header().Get(k); got != want {
+					t.Errorf("%s: got %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFingerprinted(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "/app.3f2a1b9c.js", want: true},
+		{path: "/static/logo-3F2A1B9C0D.png", want: true},
+		{path: "/app.min.3f2a1b9c.js", want: true},
+		{path: "/app.js", want: false},
+		{path: "/3f2a1b9c.js", want: false},
+		{path: "/app.3f2a1b.js", want: false},
+		{path: "/app.deadbeefzz.js", want: false},
+		{path: "/", want: false},
+		{path: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := safehttp.Fingerprinted(tt.path); got != tt.want {
+			t.Errorf("Fingerprinted(%q): got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFileServerFSRangeAndConditional(t *testing.T) {
+	modTime := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+	root := fstest.MapFS{
+		"data.txt": {Data: []byte("0123456789"), ModTime: modTime},
+		"page":     {Data: []byte("<html><body>0123456789</body></html>"), ModTime: modTime},
+	}
+	m := safehttp.NewServeMuxConfig(nil).Mux()
+	m.Handle("/", safehttp.MethodGet, safehttp.FileServerFS(root))
+
+	serveFile := func(name string, header map[string]string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(safehttp.MethodGet, "https://test.science/"+name, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		m.ServeHTTP(rr, req)
+		return rr
+	}
+	serve := func(header map[string]string) *httptest.ResponseRecorder {
+		return serveFile("data.txt", header)
+	}
+
+	rr := serve(nil)
+	if got, want := rr.Code, 200; got != want {
+		t.Fatalf("rr.Code: got %v, want %v", got, want)
+	}
+	if got, want := rr.Header().Get("Last-Modified"), modTime.Format(http.TimeFormat); got != want {
+		t.Errorf("Last-Modified: got %q, want %q", got, want)
+	}
+	etag := rr.Header().Get("Etag")
+	if etag == "" {
+		t.Fatal("Etag: got empty, want non-empty")
+	}
+
+	tests := []struct {
+		name     string
+		header   map[string]string
+		wantCode int
+		wantCT   string
+		wantBody string
+	}{
+		{
+			name:     "range",
+			header:   map[string]string{"Range": "bytes=2-5"},
+			wantCode: 206,
+			wantCT:   "text/plain; charset=utf-8",
+			wantBody: "2345",
+		},
+		{
+			name:     "unsatisfiable range",
+			header:   map[string]string{"Range": "bytes=20-30"},
+			wantCode: 416,
+			wantCT:   "text/plain; charset=utf-8",
+			wantBody: "Requested Range Not Satisfiable\n",
+		},
+		{
+			name:     "matching If-None-Match",
+			header:   map[string]string{"If-None-Match": etag},
+			wantCode: 304,
+		},
+		{
+			name:     "other If-None-Match",
+			header:   map[string]string{"If-None-Match": `W/"other"`},
+			wantCode: 200,
+			wantCT:   "text/plain; charset=utf-8",
+			wantBody: "0123456789",
+		},
+		{
+			name:     "not modified since",
+			header:   map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)},
+			wantCode: 304,
+		},
+		{
+			name:     "modified since",
+			header:   map[string]string{"If-Modified-Since": modTime.Add(-time.Hour).Format(http.TimeFormat)},
+			wantCode: 200,
+			wantCT:   "text/plain; charset=utf-8",
+			wantBody: "0123456789",
+		},
+		{
+			name:     "failed precondition",
+			header:   map[string]string{"If-Match": `"other"`},
+			wantCode: 412,
+			wantCT:   "text/plain; charset=utf-8",
+			wantBody: "Precondition Failed\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serve(tt.header)
+			if got := rr.Code; got != tt.wantCode {
+				t.Errorf("rr.Code: got %v, want %v", got, tt.wantCode)
+			}
+			if tt.wantCT != "" {
+				if got := rr.Header().Get("Content-Type"); got != tt.wantCT {
+					t.Errorf("Content-Type: got %q, want %q", got, tt.wantCT)
+				}
+			}
+			if diff := cmp.Diff(tt.wantBody, rr.Body.String()); diff != "" {
+				t.Errorf("Response body diff (-want,+got): \n%s", diff)
+			}
+		})
+	}
+
+	t.Run("multiple ranges", func(t *testing.T) {
+		rr := serve(map[string]string{"Range": "bytes=0-1,4-5"})
+		if got, want := rr.Code, 206; got != want {
+			t.Errorf("rr.Code: got %v, want %v", got, want)
+		}
+		if got, want := rr.Header().Get("Content-Type"), "multipart/byteranges; boundary="; !strings.HasPrefix(got, want) {
+			t.Errorf("Content-Type: got %q, want prefix %q", got, want)
+		}
+	})
+
+	t.Run("multiple ranges part types", func(t *testing.T) {
+		// The contents of page, which has no extension, would be sniffed as HTML.
+		rr := serveFile("page", map[string]string{"Range": "bytes=0-5,6-11"})
+		if got, want := rr.Code, 206; got != want {
+			t.Fatalf("rr.Code: got %v, want %v", got, want)
+		}
+		_, params, err := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+		if err != nil {
+			t.Fatalf("mime.ParseMediaType: %v", err)
+		}
+		mr := multipart.NewReader(rr.Body, params["boundary"])
+		var parts int
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("mr.NextPart: %v", err)
+			}
+			parts++
+			if got, want := p.Header.Get("Content-Type"), "application/octet-stream"; got != want {
+				t.Errorf("part %d Content-Type: got %q, want %q", parts, got, want)
+			}
+		}
+		if got, want := parts, 2; got != want {
+			t.Errorf("parts: got %d, want %d", got, want)
+		}
+	})
+}
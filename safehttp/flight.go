@@ -17,6 +17,7 @@ package safehttp
 import (
 	"context"
 	"net/http"
+	"time"
 )
 
 // A single request "flight".
@@ -38,21 +39,74 @@ type handlerConfig struct {
 	Handler      Handler
 	Dispatcher   Dispatcher
 	Interceptors []configuredInterceptor
+	// PanicReporter is called with the value of a handler panic. If nil,
+	// handler panics are not recovered.
+	PanicReporter func(*IncomingRequest, interface{})
+	// JSONErrors makes error responses JSON-encoded for requests that accept
+	// application/json.
+	JSONErrors bool
+	// Timeout, if positive, is the deadline for processing the request.
+	Timeout time.Duration
+	// MaxBodySize, if positive, is the maximum size of the request body.
+	MaxBodySize int64
+	// ProxyPolicy is used to resolve the address of the client.
+	ProxyPolicy ProxyPolicy
+	// ErrorHandler, if set, renders error responses.
+	ErrorHandler ErrorHandler
+	// Headers are the default response headers.
+	Headers http.Header
+	// Compression, if set, enables the compression of responses.
+	Compression *CompressionPolicy
 }
 
-func processRequest(cfg handlerConfig, rw http.ResponseWriter, req *http.Request) {
+func processRequest(cfg handlerConfig, rw http.ResponseWriter, req *http.Request, rt route) {
+	start := time.Now()
+	var rec *recordingWriter
+	if observed(cfg.Interceptors) {
+		rw, rec = newRecordingWriter(rw)
+	}
+	if cfg.MaxBodySize > 0 && req.Body != nil && req.Body != http.NoBody {
+		req.Body = http.MaxBytesReader(rw, req.Body, cfg.MaxBodySize)
+	}
+	frw := rw
+	var tw *timeoutWriter
+	if cfg.Timeout > 0 {
+		frw, tw = newTimeoutWriter(rw)
+	}
 	f := &flight{
 		cfg:    cfg,
-		rw:     rw,
-		header: NewHeader(rw.Header()),
+		rw:     frw,
+		header: NewHeader(frw.Header()),
 		req:    NewIncomingRequest(req),
 	}
+	f.req.route = rt
+	f.req.proxyPolicy = cfg.ProxyPolicy
+	if tw != nil {
+		ctx, cancel := context.WithTimeout(f.req.Context(), cfg.Timeout)
+		defer cancel()
+		f.req = f.req.WithContext(ctx)
+		f.processTimeout(rw, tw)
+	} else {
+		f.process()
+	}
+	if rec != nil {
+		info := rec.info()
+		info.Duration = time.Since(start)
+		for _, it := range f.cfg.Interceptors {
+			it.observe(f.req, info)
+		}
+	}
+}
 
+// process runs the interceptors and the handler, and writes a 204 No Content
+// response if nothing else was written.
+func (f *flight) process() {
 	// The net/http package handles all panics. In the early days of the
 	// framework we were handling them ourselves and running interceptors after
 	// a panic happened, but this adds lots of complexity to the codebase and
 	// still isn't perfect (e.g. what if Commit panics?). Instead, we just make
-	// sure to clear all the headers and cookies.
+	// sure to clear all the headers and cookies. Recovering from handler
+	// panics can be enabled with ServeMuxConfig.RecoverPanics.
 	defer func() {
 		if r := recover(); r != nil {
 			// Clear all headers.
@@ -63,49 +117,216 @@ func processRequest(cfg handlerConfig, rw http.ResponseWriter, req *http.Request
 		}
 	}()
 
+	if f.cfg.MaxBodySize > 0 && f.req.req.ContentLength > f.cfg.MaxBodySize {
+		f.WriteError(StatusRequestEntityTooLarge)
+		return
+	}
 	for _, it := range f.cfg.Interceptors {
-		it.Before(f, f.req)
+		it := it
+		f.run(func() { it.Before(f, f.req) })
 		if f.written {
 			return
 		}
 	}
-	f.cfg.Handler.ServeHTTP(f, f.req)
+	f.run(func() { f.cfg.Handler.ServeHTTP(f, f.req) })
 	if !f.written {
-		cfg.Dispatcher.Write(rw, NoContentResponse{})
+		if f.timedOut() {
+			f.WriteError(StatusServiceUnavailable)
+			return
+		}
+		f.setDefaultHeaders()
+		f.cfg.Dispatcher.Write(f.rw, NoContentResponse{})
 	}
 }
 
+// processTimeout runs process in a separate goroutine, writing a 503 Service
+// Unavailable error response to rw if the deadline of the request expires
+// before anything has been written to tw. The response is written by the
+// Dispatcher, without running the Commit phases of the interceptors, which
+// may still be running along with the handler. Panics in process are
+// propagated, unless the response has timed out.
+func (f *flight) processTimeout(rw http.ResponseWriter, tw *timeoutWriter) {
+	done := make(chan struct{})
+	panicked := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panicked <- r
+				return
+			}
+			close(done)
+		}()
+		f.process()
+	}()
+
+	wait := func() {
+		select {
+		case <-done:
+		case r := <-panicked:
+			panic(r)
+		}
+	}
+	ctx := f.req.Context()
+	select {
+	case <-done:
+	case r := <-panicked:
+		panic(r)
+	case <-ctx.Done():
+		if ctx.Err() != context.DeadlineExceeded || !tw.timeout() {
+			// The client went away or the response is being written, so the
+			// handler has to finish.
+			wait()
+			return
+		}
+		f.cfg.Dispatcher.Error(rw, StatusServiceUnavailable)
+	}
+}
+
+// observed reports whether any of the interceptors is an Observer.
+func observed(its []configuredInterceptor) bool {
+	for _, it := range its {
+		if _, ok := it.interceptor.(Observer); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// timedOut reports whether the deadline set with WithTimeout or
+// ServeMuxConfig.HandlerTimeout has expired.
+func (f *flight) timedOut() bool {
+	return f.cfg.Timeout > 0 && f.req.Context().Err() == context.DeadlineExceeded
+}
+
+// run calls fn, which runs an interceptor Before phase or the handler. If
+// panic recovery is enabled with ServeMuxConfig.RecoverPanics, panics in fn
+// are recovered: the panic is reported, the headers are restored to their
+// state before fn was called, including claims, and a 500 error response is
+// written, running the Commit phases of all the interceptors. If fn panics
+// after the response has been written, there is nothing to recover and the
+// panic is propagated.
+func (f *flight) run(fn func()) {
+	if f.cfg.PanicReporter == nil {
+		fn()
+		return
+	}
+	h := f.rw.Header().Clone()
+	claimed := make(map[string]bool, len(f.header.claimed))
+	for k, v := range f.header.claimed {
+		claimed[k] = v
+	}
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		f.cfg.PanicReporter(f.req, r)
+		if f.written {
+			panic(r)
+		}
+		for k := range f.rw.Header() {
+			delete(f.rw.Header(), k)
+		}
+		for k, v := range h {
+			f.rw.Header()[k] = v
+		}
+		f.header.claimed = claimed
+		f.WriteError(StatusInternalServerError)
+	}()
+	fn()
+}
+
 // Write dispatches the response to the Dispatcher. This will be written to the
 // underlying http.ResponseWriter if the Dispatcher decides it's safe to do so.
 func (f *flight) Write(resp Response) Result {
 	if f.written {
 		panic("ResponseWriter was already written to")
 	}
+	if f.timedOut() {
+		return f.WriteError(StatusServiceUnavailable)
+	}
 	f.written = true
 	f.commitPhase(resp)
 
-	if err := f.cfg.Dispatcher.Write(f.rw, resp); err != nil {
+	if err := f.dispatch(f.rw, resp); err != nil {
 		panic(err)
 	}
 	return Result{}
 }
 
+// dispatch writes the response to rw with the Dispatcher, compressing it if
+// compression is enabled.
+func (f *flight) dispatch(rw http.ResponseWriter, resp Response) error {
+	cw := f.compressor(rw, resp)
+	if cw == nil {
+		return f.cfg.Dispatcher.Write(rw, resp)
+	}
+	err := f.cfg.Dispatcher.Write(cw.writer(), resp)
+	if cerr := cw.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
 // WriteError writes an error response (400-599) according to the provided
 // status code.
 //
+// If an ErrorHandler is installed with ServeMuxConfig.HandleErrors, the
+// response it returns for the error is written instead. Otherwise, if JSON
+// errors are enabled with ServeMuxConfig.NegotiateJSONErrors and the request
+// accepts JSON, a JSON error is written instead of calling the Dispatcher.
+//
+// If the request deadline has expired, a 503 Service Unavailable error is
+// written instead of the provided one.
+//
 // If the ResponseWriter has already been written to, then this method will panic.
 func (f *flight) WriteError(resp ErrorResponse) Result {
 	if f.written {
 		panic("ResponseWriter was already written to")
 	}
+	if f.timedOut() {
+		resp = StatusServiceUnavailable
+	}
 	f.written = true
-	f.commitPhase(resp)
+	if f.cfg.ErrorHandler != nil {
+		if page := f.cfg.ErrorHandler.HandleError(f.req, resp); page != nil {
+			f.commitPhase(page)
+			if f.writeErrorPage(resp, page) {
+				return Result{}
+			}
+		} else {
+			f.commitPhase(resp)
+		}
+	} else {
+		f.commitPhase(resp)
+	}
+	if f.cfg.JSONErrors && acceptsJSON(f.req) {
+		writeJSONError(f.rw, resp)
+		return Result{}
+	}
 	if err := f.cfg.Dispatcher.Error(f.rw, resp); err != nil {
 		panic(err)
 	}
 	return Result{}
 }
 
+// writeErrorPage writes the response returned by the ErrorHandler for the
+// error resp, with its status code. It returns false if the Dispatcher
+// refused to write the response before writing anything, e.g. because it's
+// unsafe.
+func (f *flight) writeErrorPage(resp ErrorResponse, page Response) bool {
+	sw := &statusWriter{ResponseWriter: f.rw, code: int(resp.Code())}
+	if err := f.dispatch(sw, page); err != nil {
+		if sw.written {
+			panic(err)
+		}
+		return false
+	}
+	// Responses without a body, e.g. NoContentResponse, may not write.
+	sw.WriteHeader(0)
+	return true
+}
+
 // Header returns the collection of headers that will be set on the response.
 // Headers must be set before writing a response.
 func (f *flight) Header() Header {
@@ -113,8 +334,8 @@ func (f *flight) Header() Header {
 }
 
 // AddCookie adds a Set-Cookie header to the provided ResponseWriter's headers.
-// The provided cookie must have a valid Name, otherwise an error will be
-// returned.
+// The provided cookie must have a valid Name and attributes consistent with
+// its prefix, otherwise an error will be returned.
 func (f *flight) AddCookie(c *Cookie) error {
 	return f.header.addCookie(c)
 }
@@ -122,11 +343,24 @@ func (f *flight) AddCookie(c *Cookie) error {
 // commitPhase calls the Commit phases of all the interceptors. This stage will
 // run before a response is written to the ResponseWriter. If a response is
 // written to the ResponseWriter in a Commit phase then the Commit phases of the
-// remaining interceptors won'f execute.
+// remaining interceptors won'f execute. The default headers are set after the
+// Commit phases.
 func (f *flight) commitPhase(resp Response) {
 	for i := len(f.cfg.Interceptors) - 1; i >= 0; i-- {
 		f.cfg.Interceptors[i].Commit(f, f.req, resp)
 	}
+	f.setDefaultHeaders()
+}
+
+// setDefaultHeaders sets the default headers that haven't been set nor claimed.
+func (f *flight) setDefaultHeaders() {
+	h := f.rw.Header()
+	for name, v := range f.cfg.Headers {
+		if f.header.IsClaimed(name) || len(h[name]) > 0 {
+			continue
+		}
+		h[name] = append([]string(nil), v...)
+	}
 }
 
 // Result is the result of writing an HTTP response.
@@ -17,8 +17,11 @@ package safehttp_test
 import (
 	"fmt"
 	"net/http/httptest"
+	"runtime/debug"
+	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-safeweb/safehttp"
 	"github.com/google/safehtml"
 )
@@ -125,6 +128,148 @@ func TestFlightHandlerPanic(t *testing.T) {
 	mux.ServeHTTP(rw, req)
 }
 
+func TestFlightHandlerPanicRecovered(t *testing.T) {
+	var reported interface{}
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(setHeaderInterceptor{name: "Before-Foo", value: "bar"})
+	mb.Intercept(setHeaderConfigInterceptor{})
+	mb.RecoverPanics(func(r *safehttp.IncomingRequest, v interface{}) {
+		reported = v
+	})
+	mux := mb.Mux()
+
+	mux.Handle("/search", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		w.Header().Set("foo", "bar")
+		panic("handler")
+	}))
+
+	req := httptest.NewRequest(safehttp.MethodGet, "http://foo.com/search", nil)
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, req)
+
+	if want := safehttp.StatusInternalServerError; rw.Code != int(want) {
+		t.Errorf("rw.Code: got %v, want %v", rw.Code, want)
+	}
+	if reported != "handler" {
+		t.Errorf("reported panic: got %v, want %q", reported, "handler")
+	}
+	wantHeaders := map[string][]string{
+		"Before-Foo":             {"bar"},
+		"Commit-Pizza":           {"Hawaii"},
+		"Pizza":                  {"Hawaii"},
+		"Content-Type":           {"text/plain; charset=utf-8"},
+		"X-Content-Type-Options": {"nosniff"},
+	}
+	if diff := cmp.Diff(wantHeaders, map[string][]string(rw.Header())); diff != "" {
+		t.Errorf("rw.Header() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFlightHandlerPanicAfterWriteRecovered(t *testing.T) {
+	var reported interface{}
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.RecoverPanics(func(r *safehttp.IncomingRequest, v interface{}) {
+		reported = v
+	})
+	mux := mb.Mux()
+
+	mux.Handle("/search", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		w.Write(safehtml.HTMLEscaped("ok"))
+		panic("handler")
+	}))
+
+	req := httptest.NewRequest(safehttp.MethodGet, "http://foo.com/search", nil)
+	rw := httptest.NewRecorder()
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatalf("expected panic")
+		}
+		if reported != "handler" {
+			t.Errorf("reported panic: got %v, want %q", reported, "handler")
+		}
+	}()
+	mux.ServeHTTP(rw, req)
+}
+
+func panickingHandler(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+	panic("handler")
+}
+
+func TestFlightHandlerPanicRecoveredStack(t *testing.T) {
+	var stack []byte
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.RecoverPanics(func(r *safehttp.IncomingRequest, v interface{}) {
+		stack = debug.Stack()
+	})
+	mux := mb.Mux()
+	mux.Handle("/search", safehttp.MethodGet, safehttp.HandlerFunc(panickingHandler))
+
+	req := httptest.NewRequest(safehttp.MethodGet, "http://foo.com/search", nil)
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, req)
+
+	if want := safehttp.StatusInternalServerError; rw.Code != int(want) {
+		t.Errorf("rw.Code: got %v, want %v", rw.Code, want)
+	}
+	if !strings.Contains(string(stack), "panickingHandler") {
+		t.Errorf("debug.Stack() in the report function: got %s, want it to contain panickingHandler", stack)
+	}
+}
+
+func TestFlightInterceptorPanicRecovered(t *testing.T) {
+	var reported interface{}
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(setHeaderInterceptor{name: "Before-Foo", value: "bar"})
+	mb.Intercept(claimingPanickingInterceptor{})
+	mb.RecoverPanics(func(r *safehttp.IncomingRequest, v interface{}) {
+		reported = v
+	})
+	mux := mb.Mux()
+
+	mux.Handle("/search", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		t.Error("handler called after a Before panic")
+		return w.Write(safehtml.HTMLEscaped("ok"))
+	}))
+
+	req := httptest.NewRequest(safehttp.MethodGet, "http://foo.com/search", nil)
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, req)
+
+	if want := safehttp.StatusInternalServerError; rw.Code != int(want) {
+		t.Errorf("rw.Code: got %v, want %v", rw.Code, want)
+	}
+	if reported != "before" {
+		t.Errorf("reported panic: got %v, want %q", reported, "before")
+	}
+	// The header claimed by the panicking interceptor is released, so the
+	// Commit phase can set it.
+	wantHeaders := map[string][]string{
+		"Before-Foo":             {"bar"},
+		"Claimed":                {"commit"},
+		"Content-Type":           {"text/plain; charset=utf-8"},
+		"X-Content-Type-Options": {"nosniff"},
+	}
+	if diff := cmp.Diff(wantHeaders, map[string][]string(rw.Header())); diff != "" {
+		t.Errorf("rw.Header() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+type claimingPanickingInterceptor struct{}
+
+func (claimingPanickingInterceptor) Before(w safehttp.ResponseWriter, _ *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	w.Header().Claim("Claimed")([]string{"before"})
+	panic("before")
+}
+
+func (claimingPanickingInterceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+	w.Header().Set("Claimed", "commit")
+}
+
+func (claimingPanickingInterceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
+
 func TestFlightDoubleWritePanics(t *testing.T) {
 	writeFuncs := map[string]func(safehttp.ResponseWriter, *safehttp.IncomingRequest) safehttp.Result{
 		"Write": func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
@@ -0,0 +1,273 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError describes a form parameter that failed validation.
+type FieldError struct {
+	// Param is the name of the form parameter.
+	Param string
+	// Message describes why the value of the parameter is invalid. It doesn't
+	// contain the value itself.
+	Message string
+}
+
+// ValidationError is returned by Form.Decode when one or more form parameters
+// are missing or invalid. It can be passed to templates to show the errors to
+// users, e.g. with {{.Errors.Message "email"}}.
+type ValidationError struct {
+	// Errors are the validation errors, in the order of the struct fields.
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Param+": "+fe.Message)
+	}
+	return "invalid form: " + strings.Join(msgs, "; ")
+}
+
+// Message returns the error message of the given form parameter, or an empty
+// string if it's valid.
+func (e *ValidationError) Message(param string) string {
+	for _, fe := range e.Errors {
+		if fe.Param == param {
+			return fe.Message
+		}
+	}
+	return ""
+}
+
+// formFieldRules are the rules parsed from the form struct tag of a field.
+type formFieldRules struct {
+	param    string
+	required bool
+	minLen   int
+	maxLen   int
+	min, max *float64
+}
+
+// parseFormTag parses tags such as "email,required,maxlen=255".
+func parseFormTag(tag string) (formFieldRules, error) {
+	parts := strings.Split(tag, ",")
+	r := formFieldRules{param: parts[0], minLen: -1, maxLen: -1}
+	if r.param == "" {
+		return r, fmt.Errorf("missing parameter name in form tag %q", tag)
+	}
+	for _, p := range parts[1:] {
+		k, v := p, ""
+		if i := strings.Index(p, "="); i >= 0 {
+			k, v = p[:i], p[i+1:]
+		}
+		var err error
+		switch k {
+		case "required":
+			r.required = true
+		case "minlen":
+			r.minLen, err = strconv.Atoi(v)
+		case "maxlen":
+			r.maxLen, err = strconv.Atoi(v)
+		case "min", "max":
+			var f float64
+			f, err = strconv.ParseFloat(v, 64)
+			if k == "min" {
+				r.min = &f
+			} else {
+				r.max = &f
+			}
+		default:
+			err = errors.New("unknown option")
+		}
+		if err != nil {
+			return r, fmt.Errorf("invalid option %q in form tag %q: %v", p, tag, err)
+		}
+	}
+	return r, nil
+}
+
+// Decode stores the form parameters in the struct pointed to by dst.
+//
+// Only the exported fields with a form struct tag are set. The tag contains
+// the name of the form parameter, followed by optional comma-separated
+// validation rules:
+//   - required: the parameter must be present and non-empty.
+//   - minlen=N, maxlen=N: the length of strings, in characters, or the number
+//     of values of slices must be between the given bounds.
+//   - min=N, max=N: numbers must be between the given bounds.
+//
+// Fields can be strings, booleans, integers, floats or slices of them. Slices
+// receive all the values of the parameter, other fields the first one, which
+// must be valid for the type of the field.
+//
+// If any parameter is missing or invalid, a *ValidationError listing all the
+// failures is returned and the corresponding fields are left unchanged. Other
+// errors are returned if dst is not a pointer to a struct or has fields that
+// can't be decoded.
+func (f *Form) Decode(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("form decoding destination must be a non-nil pointer to a struct, got %T", dst)
+	}
+	v = v.Elem()
+	t := v.Type()
+	verr := &ValidationError{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("form")
+		if !ok || tag == "-" || sf.PkgPath != "" {
+			continue
+		}
+		rules, err := parseFormTag(tag)
+		if err != nil {
+			return fmt.Errorf("field %s: %v", sf.Name, err)
+		}
+		msg, err := decodeFormField(v.Field(i), f.values[rules.param], rules)
+		if err != nil {
+			return fmt.Errorf("field %s: %v", sf.Name, err)
+		}
+		if msg != "" {
+			verr.Errors = append(verr.Errors, FieldError{Param: rules.param, Message: msg})
+		}
+	}
+	if len(verr.Errors) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// decodeFormField sets fv to the decoded values. It returns a validation
+// message if the values don't satisfy the rules, or an error if fv can't be
+// decoded into.
+func decodeFormField(fv reflect.Value, values []string, rules formFieldRules) (string, error) {
+	t := fv.Type()
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if !supportedFormKind(t.Kind()) {
+		return "", fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	if fv.Kind() != reflect.Slice {
+		val := ""
+		if len(values) > 0 {
+			val = values[0]
+		}
+		if val == "" {
+			if rules.required {
+				return "is required", nil
+			}
+			return "", nil
+		}
+		nv := reflect.New(fv.Type()).Elem()
+		if msg, err := decodeFormValue(nv, val, rules); msg != "" || err != nil {
+			return msg, err
+		}
+		fv.Set(nv)
+		return "", nil
+	}
+
+	if len(values) == 0 && rules.required {
+		return "is required", nil
+	}
+	if rules.minLen >= 0 && len(values) < rules.minLen {
+		return fmt.Sprintf("must have at least %d values", rules.minLen), nil
+	}
+	if rules.maxLen >= 0 && len(values) > rules.maxLen {
+		return fmt.Sprintf("must have at most %d values", rules.maxLen), nil
+	}
+	// The length rules apply to the slice, not to its elements.
+	elemRules := rules
+	elemRules.minLen, elemRules.maxLen = -1, -1
+	sv := reflect.MakeSlice(fv.Type(), len(values), len(values))
+	for i, val := range values {
+		if msg, err := decodeFormValue(sv.Index(i), val, elemRules); msg != "" || err != nil {
+			return msg, err
+		}
+	}
+	fv.Set(sv)
+	return "", nil
+}
+
+// decodeFormValue sets v to the decoded val, validating it against the rules.
+func decodeFormValue(v reflect.Value, val string, rules formFieldRules) (string, error) {
+	var num float64
+	switch v.Kind() {
+	case reflect.String:
+		n := utf8.RuneCountInString(val)
+		if rules.minLen >= 0 && n < rules.minLen {
+			return fmt.Sprintf("must be at least %d characters long", rules.minLen), nil
+		}
+		if rules.maxLen >= 0 && n > rules.maxLen {
+			return fmt.Sprintf("must be at most %d characters long", rules.maxLen), nil
+		}
+		v.SetString(val)
+		return "", nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return "must be a boolean", nil
+		}
+		v.SetBool(b)
+		return "", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(val, 10, v.Type().Bits())
+		if err != nil {
+			return "must be an integer", nil
+		}
+		v.SetInt(i)
+		num = float64(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(val, 10, v.Type().Bits())
+		if err != nil {
+			return "must be a non-negative integer", nil
+		}
+		v.SetUint(u)
+		num = float64(u)
+	case reflect.Float32, reflect.Float64:
+		fl, err := strconv.ParseFloat(val, v.Type().Bits())
+		if err != nil || math.IsNaN(fl) || math.IsInf(fl, 0) {
+			return "must be a number", nil
+		}
+		v.SetFloat(fl)
+		num = fl
+	}
+	if rules.min != nil && num < *rules.min {
+		return fmt.Sprintf("must be at least %v", *rules.min), nil
+	}
+	if rules.max != nil && num > *rules.max {
+		return fmt.Sprintf("must be at most %v", *rules.max), nil
+	}
+	return "", nil
+}
+
+func supportedFormKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"errors"
+	"mime/multipart"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type signup struct {
+	Email    string   `form:"email,required,maxlen=20"`
+	Name     string   `form:"name,minlen=2"`
+	Age      int      `form:"age,min=18,max=130"`
+	Score    float64  `form:"score"`
+	Terms    bool     `form:"terms,required"`
+	Tags     []string `form:"tag,maxlen=2"`
+	IDs      []uint16 `form:"id"`
+	Ignored  string
+	Skipped  string `form:"-"`
+	internal string `form:"internal"`
+}
+
+func TestFormDecode(t *testing.T) {
+	values := map[string][]string{
+		"email":    {"pizza@example.com"},
+		"name":     {"Żó"},
+		"age":      {"30"},
+		"score":    {"4.5"},
+		"terms":    {"true"},
+		"tag":      {"a", "b"},
+		"id":       {"1", "65535"},
+		"Ignored":  {"x"},
+		"-":        {"x"},
+		"internal": {"x"},
+	}
+	f := Form{values: values}
+	var got signup
+	if err := f.Decode(&got); err != nil {
+		t.Fatalf("f.Decode: got error %v", err)
+	}
+	want := signup{
+		Email: "pizza@example.com",
+		Name:  "Żó",
+		Age:   30,
+		Score: 4.5,
+		Terms: true,
+		Tags:  []string{"a", "b"},
+		IDs:   []uint16{1, 65535},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(signup{})); diff != "" {
+		t.Errorf("f.Decode: mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFormDecodeMultipart(t *testing.T) {
+	f := newMulipartForm(&multipart.Form{Value: map[string][]string{"email": {"a@b.c"}, "terms": {"1"}}})
+	var got signup
+	if err := f.Decode(&got); err != nil {
+		t.Fatalf("f.Decode: got error %v", err)
+	}
+	if want := "a@b.c"; got.Email != want {
+		t.Errorf("got.Email: got %q, want %q", got.Email, want)
+	}
+}
+
+func TestFormDecodeValidationErrors(t *testing.T) {
+	values := map[string][]string{
+		"email": {"a-very-long-address@example.com"},
+		"name":  {"A"},
+		"age":   {"12"},
+		"score": {"NaN"},
+		"tag":   {"a", "b", "c"},
+		"id":    {"65536"},
+	}
+	f := Form{values: values}
+	got := signup{Email: "unchanged"}
+	err := f.Decode(&got)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("f.Decode: got error %v, want *ValidationError", err)
+	}
+	want := []FieldError{
+		{Param: "email", Message: "must be at most 20 characters long"},
+		{Param: "name", Message: "must be at least 2 characters long"},
+		{Param: "age", Message: "must be at least 18"},
+		{Param: "score", Message: "must be a number"},
+		{Param: "terms", Message: "is required"},
+		{Param: "tag", Message: "must have at most 2 values"},
+		{Param: "id", Message: "must be a non-negative integer"},
+	}
+	if diff := cmp.Diff(want, verr.Errors); diff != "" {
+		t.Errorf("verr.Errors: mismatch (-want +got):\n%s", diff)
+	}
+	if got, want := verr.Message("terms"), "is required"; got != want {
+		t.Errorf(`verr.Message("terms"): got %q, want %q`, got, want)
+	}
+	if got := verr.Message("unknown"); got != "" {
+		t.Errorf(`verr.Message("unknown"): got %q, want ""`, got)
+	}
+	if got.Email != "unchanged" {
+		t.Errorf("got.Email: got %q, want unchanged", got.Email)
+	}
+}
+
+func TestFormDecodeInvalidDestination(t *testing.T) {
+	f := Form{values: map[string][]string{"a": {"1"}}}
+	tests := []struct {
+		name string
+		dst  interface{}
+	}{
+		{name: "Not a pointer", dst: signup{}},
+		{name: "Nil pointer", dst: (*signup)(nil)},
+		{name: "Not a struct", dst: new(string)},
+		{name: "Unsupported type", dst: &struct {
+			A map[string]string `form:"a"`
+		}{}},
+		{name: "Unknown option", dst: &struct {
+			A string `form:"a,email"`
+		}{}},
+		{name: "Invalid bound", dst: &struct {
+			A string `form:"a,maxlen=x"`
+		}{}},
+		{name: "Missing name", dst: &struct {
+			A string `form:",required"`
+		}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := f.Decode(tt.dst)
+			if err == nil {
+				t.Fatal("f.Decode: got nil, want error")
+			}
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				t.Errorf("f.Decode: got *ValidationError %v, want other error", err)
+			}
+		})
+	}
+}
@@ -128,11 +128,15 @@ func (h Header) Values(name string) []string {
 }
 
 // addCookie adds the cookie provided as a Set-Cookie header in the header
-// collection. If the cookie is nil or cookie.Name() is invalid, no header is
-// added and an error is returned. This is the only method that can modify the
+// collection. If the cookie is nil, cookie.Name() is invalid or its attributes
+// are inconsistent, e.g. with the prefix of its name, no header is added and
+// an error is returned. This is the only method that can modify the
 // Set-Cookie header. If other methods try to modify the header they will return
 // errors.
 func (h Header) addCookie(c *Cookie) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
 	v := c.String()
 	if v == "" {
 		return errors.New("invalid cookie name")
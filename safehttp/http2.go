@@ -0,0 +1,67 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// HTTP2Config configures the HTTP/2 support of a Server.
+type HTTP2Config struct {
+	// MaxConcurrentStreams is the number of concurrent streams each client
+	// can open on a connection. If zero, 100 is used.
+	MaxConcurrentStreams uint32
+
+	// IdleTimeout is the time after which idle connections are closed. If
+	// zero, the IdleTimeout of the Server is used.
+	IdleTimeout time.Duration
+
+	// MaxReadFrameSize is the largest frame the server accepts, between 16KiB
+	// and 16MiB. If zero, 16KiB is used.
+	MaxReadFrameSize uint32
+
+	// H2C enables HTTP/2 over cleartext TCP connections, either with prior
+	// knowledge or by upgrading HTTP/1.1 connections. It's meant for servers
+	// behind a proxy that terminates TLS and forwards requests over HTTP/2,
+	// so it can only be used with Serve and ListenAndServe.
+	H2C bool
+}
+
+// configure enables HTTP/2 on srv with the configuration.
+func (c *HTTP2Config) configure(srv *http.Server) error {
+	h2s := &http2.Server{
+		MaxConcurrentStreams: c.MaxConcurrentStreams,
+		IdleTimeout:          c.IdleTimeout,
+		MaxReadFrameSize:     c.MaxReadFrameSize,
+	}
+	if h2s.MaxConcurrentStreams == 0 {
+		h2s.MaxConcurrentStreams = 100
+	}
+	if s := h2s.MaxReadFrameSize; s != 0 && (s < 1<<14 || s > 1<<24) {
+		return fmt.Errorf("HTTP/2 MaxReadFrameSize %d is not between 16KiB and 16MiB", s)
+	}
+	if err := http2.ConfigureServer(srv, h2s); err != nil {
+		return err
+	}
+	if c.H2C {
+		srv.Handler = h2c.NewHandler(srv.Handler, h2s)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/google/safehtml"
+	"golang.org/x/net/http2"
+)
+
+func TestServerH2C(t *testing.T) {
+	mux := NewServeMuxConfig(nil).Mux()
+	mux.Handle("/", "GET", HandlerFunc(func(w ResponseWriter, r *IncomingRequest) Result {
+		return w.Write(safehtml.HTMLEscaped(r.req.Proto))
+	}))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	s := Server{Mux: mux, HTTP2: &HTTP2Config{H2C: true}}
+	go s.Serve(l)
+	defer s.Close()
+
+	client := http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	resp, err := client.Get("http://" + l.Addr().String())
+	if err != nil {
+		t.Fatalf("client.Get: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll: %v", err)
+	}
+	if got, want := string(body), "HTTP/2.0"; got != want {
+		t.Errorf("request protocol: got %q, want %q", got, want)
+	}
+}
+
+func TestServerH2CWithTLS(t *testing.T) {
+	s := Server{Mux: NewServeMuxConfig(nil).Mux(), HTTP2: &HTTP2Config{H2C: true}}
+	if err := s.ListenAndServeTLS("cert.pem", "key.pem"); err == nil {
+		t.Error("s.ListenAndServeTLS: got nil error, want error")
+	}
+}
+
+func TestServerHTTP2InvalidFrameSize(t *testing.T) {
+	for _, size := range []uint32{1 << 10, 1 << 25} {
+		s := Server{Mux: NewServeMuxConfig(nil).Mux(), HTTP2: &HTTP2Config{MaxReadFrameSize: size}}
+		if err := s.buildStd(); err == nil {
+			t.Errorf("s.buildStd() with MaxReadFrameSize %d: got nil error, want error", size)
+		}
+	}
+}
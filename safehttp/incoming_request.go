@@ -17,12 +17,16 @@ package safehttp
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // IncomingRequest represents an HTTP request received by the server.
@@ -40,6 +44,12 @@ type IncomingRequest struct {
 	// IncomingRequest.WithContext. Otherwise, we'd need to copy locks.
 	postParseOnce      *sync.Once
 	multipartParseOnce *sync.Once
+	// bodyRead is the number of body bytes read so far.
+	bodyRead *int64
+	// route is the registered pattern the request has been routed to.
+	route route
+	// proxyPolicy is used to resolve the address of the client.
+	proxyPolicy ProxyPolicy
 }
 
 // NewIncomingRequest creates an IncomingRequest
@@ -50,27 +60,65 @@ func NewIncomingRequest(req *http.Request) *IncomingRequest {
 	}
 	req = req.WithContext(context.WithValue(req.Context(),
 		flightValuesCtxKey{}, flightValues{m: make(map[interface{}]interface{})}))
+	bodyRead := new(int64)
+	if req.Body != nil {
+		req.Body = &countingBody{ReadCloser: req.Body, n: bodyRead}
+	}
 	return &IncomingRequest{
 		req:                req,
 		Header:             NewHeader(req.Header),
 		TLS:                req.TLS,
 		postParseOnce:      &sync.Once{},
 		multipartParseOnce: &sync.Once{},
+		bodyRead:           bodyRead,
 	}
 }
 
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.n, int64(n))
+	return n, err
+}
+
 // Body returns the request body reader. It is always non-nil but will return
 // EOF immediately when no body is present.
 func (r *IncomingRequest) Body() io.ReadCloser {
 	return r.req.Body
 }
 
+// BodyBytesRead returns the number of bytes of the request body that have been
+// read so far, either directly through Body or by parsing forms with PostForm
+// or MultipartForm.
+func (r *IncomingRequest) BodyBytesRead() int64 {
+	return atomic.LoadInt64(r.bodyRead)
+}
+
 // Host returns the host the request is targeted to. This value comes from the
 // Host header.
 func (r *IncomingRequest) Host() string {
 	return r.req.Host
 }
 
+// ClientIP returns the IP address of the client that sent the request,
+// resolved according to the ProxyPolicy configured with
+// ServeMuxConfig.TrustProxies. If no policy was configured, or the request
+// wasn't created by a ServeMux, the address of the direct peer is returned and
+// forwarding headers are ignored.
+//
+// Plugins that need the address of the client, e.g. for rate limiting or
+// logging, should use this method rather than parsing forwarding headers
+// themselves, so that they all agree on the address and don't trust
+// spoofable headers.
+func (r *IncomingRequest) ClientIP() (net.IP, error) {
+	return r.proxyPolicy.ClientIP(r)
+}
+
 // Method returns the HTTP method of the IncomingRequest.
 func (r *IncomingRequest) Method() string {
 	return r.req.Method
@@ -175,6 +223,176 @@ func (r *IncomingRequest) WithContext(ctx context.Context) *IncomingRequest {
 	return r2
 }
 
+// MaxCloneBodySize is the maximum number of bytes of the request body that are
+// buffered by IncomingRequest.Clone.
+const MaxCloneBodySize = 10 << 20 // 10 MiB
+
+// errCloneBodyTooLarge is returned when reading past MaxCloneBodySize bytes from
+// the body of a cloned request.
+var errCloneBodyTooLarge = errors.New("request body too large to be cloned")
+
+// Clone returns a copy of the request with its context changed to ctx. The
+// provided ctx must be non-nil.
+//
+// Unlike WithContext, the body of the returned request can be read
+// independently of the body of the original request. This allows, e.g.,
+// interceptors to inspect the body without affecting the handler.
+//
+// In order to do this, the first MaxCloneBodySize bytes of the body are kept
+// in memory as they are read, either from the original request or from the
+// clone, so Clone should be used sparingly. Bytes that are never read are not
+// buffered. The original request can always be read in full, while reading the
+// body of the clone past MaxCloneBodySize bytes returns an error.
+func (r *IncomingRequest) Clone(ctx context.Context) *IncomingRequest {
+	r2 := new(IncomingRequest)
+	*r2 = *r
+	r2.req = r.req.Clone(ctx)
+	r2.bodyRead = new(int64)
+	r2.Header = NewHeader(r2.req.Header)
+	r2.postParseOnce = &sync.Once{}
+	r2.multipartParseOnce = &sync.Once{}
+
+	src := r.req.Body
+	if src == nil || src == http.NoBody {
+		r2.req.Body = &countingBody{ReadCloser: http.NoBody, n: r2.bodyRead}
+		return r2
+	}
+	// The bytes buffered here are only accounted for in BodyBytesRead once
+	// they are actually read from the body of the original request.
+	cb, counted := src.(*countingBody)
+	if counted {
+		src = cb.ReadCloser
+	}
+	sb := &sharedBody{src: src}
+	restored := &sharedBodyReader{body: sb}
+	if counted {
+		cb.ReadCloser = restored
+	} else {
+		r.req.Body = restored
+	}
+	r2.req.Body = &countingBody{ReadCloser: &sharedBodyReader{body: sb, clone: true}, n: r2.bodyRead}
+	return r2
+}
+
+// sharedBody is the body of a request shared with its clones. The first bytes
+// read from src are buffered, so that they can be read both by the request and
+// by the clones.
+type sharedBody struct {
+	mu  sync.Mutex
+	src io.ReadCloser
+	// buf holds the first bytes read from src, up to MaxCloneBodySize+1 so
+	// that clones can tell whether the body is too large.
+	buf []byte
+	// err is the error returned by src, if any.
+	err error
+}
+
+// read reads into p the bytes of the body starting at off. Clones can't read
+// past MaxCloneBodySize bytes.
+func (s *sharedBody) read(p []byte, off int, clone bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		end := len(s.buf)
+		if clone && end > MaxCloneBodySize {
+			end = MaxCloneBodySize
+		}
+		if off < end {
+			return copy(p, s.buf[off:end]), nil
+		}
+		if clone && off >= MaxCloneBodySize && len(s.buf) > MaxCloneBodySize {
+			return 0, errCloneBodyTooLarge
+		}
+		if s.err != nil {
+			return 0, s.err
+		}
+		if len(s.buf) > MaxCloneBodySize {
+			// Clones can't read these bytes, so there is no need to buffer
+			// them.
+			return s.src.Read(p)
+		}
+		s.fill(len(p))
+	}
+}
+
+// fill reads up to n bytes from src into buf, without growing buf past
+// MaxCloneBodySize+1 bytes.
+func (s *sharedBody) fill(n int) {
+	if max := MaxCloneBodySize + 1 - len(s.buf); n > max {
+		n = max
+	}
+	tmp := make([]byte, n)
+	m, err := s.src.Read(tmp)
+	s.buf = append(s.buf, tmp[:m]...)
+	if err != nil {
+		s.err = err
+	}
+}
+
+// sharedBodyReader reads a sharedBody from the start. Only the reader of the
+// original request closes the body.
+type sharedBodyReader struct {
+	body  *sharedBody
+	off   int
+	clone bool
+}
+
+func (r *sharedBodyReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := r.body.read(p, r.off, r.clone)
+	r.off += n
+	return n, err
+}
+
+func (r *sharedBodyReader) Close() error {
+	if r.clone {
+		return nil
+	}
+	return r.body.src.Close()
+}
+
+// Pattern returns the pattern of the handler the request has been routed to,
+// as registered with ServeMux.Handle. It returns an empty string if the request
+// hasn't been routed by a ServeMux.
+//
+// This allows interceptors to match on the pattern rather than on the
+// concrete path, which contains the values of path parameters.
+func (r *IncomingRequest) Pattern() string {
+	return r.route.pattern
+}
+
+// PathParam returns the value of the named path parameter of the pattern the
+// request has been routed to, e.g. "42" for the parameter "id" of the pattern
+// "/users/{id}" and the path "/users/42". The value is unescaped. It returns an
+// empty string if the pattern has no such parameter.
+func (r *IncomingRequest) PathParam(name string) string {
+	return r.route.params[name]
+}
+
+// PathParamInt64 returns the value of the named path parameter parsed as an
+// int64. It returns an error if the pattern has no such parameter or if the
+// value can't be parsed.
+func (r *IncomingRequest) PathParamInt64(name string) (int64, error) {
+	v, ok := r.route.params[name]
+	if !ok {
+		return 0, fmt.Errorf("no path parameter %q", name)
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// PathParamUint64 returns the value of the named path parameter parsed as an
+// uint64. It returns an error if the pattern has no such parameter or if the
+// value can't be parsed.
+func (r *IncomingRequest) PathParamUint64(name string) (uint64, error) {
+	v, ok := r.route.params[name]
+	if !ok {
+		return 0, fmt.Errorf("no path parameter %q", name)
+	}
+	return strconv.ParseUint(v, 10, 64)
+}
+
 // URL specifies the URL that is parsed from the Request-Line. For most requests,
 // only URL.Path() will return a non-empty result. (See RFC 7230, Section 5.3)
 func (r *IncomingRequest) URL() *URL {
@@ -16,6 +16,8 @@ package safehttp_test
 
 import (
 	"context"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -466,3 +468,228 @@ func TestIncomingRequestFileUploadMissingContent(t *testing.T) {
 		t.Errorf("file.Read(content): got %s, want %s", got, want)
 	}
 }
+
+func TestIncomingRequestClone(t *testing.T) {
+	req := safehttptest.NewRequest(safehttp.MethodPost, "/", strings.NewReader("a=b"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	type ctxKey struct{}
+	clone := req.Clone(context.WithValue(req.Context(), ctxKey{}, "bar"))
+
+	if got, want := clone.Context().Value(ctxKey{}), "bar"; got != want {
+		t.Errorf(`clone.Context().Value(ctxKey{}): got %v, want %q`, got, want)
+	}
+	if req.Context().Value(ctxKey{}) != nil {
+		t.Error(`req.Context().Value(ctxKey{}): got a value, want nil`)
+	}
+
+	b, err := ioutil.ReadAll(clone.Body())
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(clone.Body()): %v", err)
+	}
+	if got, want := string(b), "a=b"; got != want {
+		t.Errorf("clone body: got %q, want %q", got, want)
+	}
+
+	f, err := req.PostForm()
+	if err != nil {
+		t.Fatalf("req.PostForm(): %v", err)
+	}
+	if got, want := f.String("a", ""), "b"; got != want {
+		t.Errorf(`f.String("a", ""): got %q, want %q`, got, want)
+	}
+
+	clone.Header.Set("Foo", "bar")
+	if got := req.Header.Get("Foo"); got != "" {
+		t.Errorf(`req.Header.Get("Foo"): got %q, want ""`, got)
+	}
+}
+
+func TestIncomingRequestCloneBodyTooLarge(t *testing.T) {
+	body := strings.Repeat("a", safehttp.MaxCloneBodySize+1)
+	req := safehttptest.NewRequest(safehttp.MethodPost, "/", strings.NewReader(body))
+	clone := req.Clone(req.Context())
+
+	b, err := ioutil.ReadAll(clone.Body())
+	if err == nil {
+		t.Error("ioutil.ReadAll(clone.Body()): got nil err, want error")
+	}
+	if got, want := len(b), safehttp.MaxCloneBodySize; got != want {
+		t.Errorf("len(clone body): got %d, want %d", got, want)
+	}
+
+	b, err = ioutil.ReadAll(req.Body())
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(req.Body()): %v", err)
+	}
+	if string(b) != body {
+		t.Errorf("len(req body): got %d, want %d", len(b), len(body))
+	}
+}
+
+func TestIncomingRequestCloneNoBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body io.ReadCloser
+	}{
+		{name: "Nil", body: nil},
+		{name: "NoBody", body: http.NoBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(safehttp.MethodGet, "/", nil)
+			r.Body = tt.body
+			req := safehttp.NewIncomingRequest(r)
+			clone := req.Clone(req.Context())
+
+			b, err := ioutil.ReadAll(clone.Body())
+			if err != nil {
+				t.Fatalf("ioutil.ReadAll(clone.Body()): %v", err)
+			}
+			if len(b) != 0 {
+				t.Errorf("clone body: got %q, want empty", b)
+			}
+		})
+	}
+}
+
+// countingReader counts the bytes read from the wrapped reader.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestIncomingRequestCloneLazy(t *testing.T) {
+	body := strings.Repeat("a", safehttp.MaxCloneBodySize)
+	src := &countingReader{Reader: strings.NewReader(body)}
+	req := safehttptest.NewRequest(safehttp.MethodPost, "/", src)
+	clone := req.Clone(req.Context())
+	if src.n != 0 {
+		t.Errorf("bytes read by Clone: got %d, want 0", src.n)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(clone.Body(), 10))
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(clone.Body()): %v", err)
+	}
+	if got, want := string(b), body[:10]; got != want {
+		t.Errorf("clone body: got %q, want %q", got, want)
+	}
+	if src.n != 10 {
+		t.Errorf("bytes read from the body: got %d, want 10", src.n)
+	}
+
+	b, err = ioutil.ReadAll(req.Body())
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(req.Body()): %v", err)
+	}
+	if string(b) != body {
+		t.Errorf("len(req body): got %d, want %d", len(b), len(body))
+	}
+}
+
+func TestIncomingRequestCloneReadOriginalFirst(t *testing.T) {
+	req := safehttptest.NewRequest(safehttp.MethodPost, "/", strings.NewReader("foo bar"))
+	clone := req.Clone(req.Context())
+
+	b, err := ioutil.ReadAll(req.Body())
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(req.Body()): %v", err)
+	}
+	if got, want := string(b), "foo bar"; got != want {
+		t.Errorf("req body: got %q, want %q", got, want)
+	}
+	b, err = ioutil.ReadAll(clone.Body())
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(clone.Body()): %v", err)
+	}
+	if got, want := string(b), "foo bar"; got != want {
+		t.Errorf("clone body: got %q, want %q", got, want)
+	}
+}
+
+func TestIncomingRequestBodyBytesRead(t *testing.T) {
+	multipartBody := "--123\r\n" +
+		"Content-Disposition: form-data; name=\"a\"\r\n" +
+		"\r\n" +
+		"b\r\n" +
+		"--123--\r\n"
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		parse       func(r *safehttp.IncomingRequest) error
+	}{
+		{
+			name:        "PostForm",
+			body:        "a=b&c=d",
+			contentType: "application/x-www-form-urlencoded",
+			parse: func(r *safehttp.IncomingRequest) error {
+				_, err := r.PostForm()
+				return err
+			},
+		},
+		{
+			name:        "MultipartForm",
+			body:        multipartBody,
+			contentType: `multipart/form-data; boundary="123"`,
+			parse: func(r *safehttp.IncomingRequest) error {
+				_, err := r.MultipartForm(1000)
+				return err
+			},
+		},
+		{
+			name: "Body",
+			body: "foo bar",
+			parse: func(r *safehttp.IncomingRequest) error {
+				_, err := ioutil.ReadAll(r.Body())
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := safehttptest.NewRequest(safehttp.MethodPost, "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			if got := r.BodyBytesRead(); got != 0 {
+				t.Errorf("r.BodyBytesRead() before parsing got: %d want: 0", got)
+			}
+			if err := tt.parse(r); err != nil {
+				t.Fatalf("parsing the request: %v", err)
+			}
+			if got, want := r.BodyBytesRead(), int64(len(tt.body)); got != want {
+				t.Errorf("r.BodyBytesRead() got: %d want: %d", got, want)
+			}
+		})
+	}
+}
+
+func TestIncomingRequestBodyBytesReadClone(t *testing.T) {
+	req := safehttptest.NewRequest(safehttp.MethodPost, "/", strings.NewReader("foo bar"))
+	clone := req.Clone(req.Context())
+	if got := req.BodyBytesRead(); got != 0 {
+		t.Errorf("req.BodyBytesRead() after cloning got: %d want: 0", got)
+	}
+
+	if _, err := ioutil.ReadAll(clone.Body()); err != nil {
+		t.Fatalf("ioutil.ReadAll(clone.Body()): %v", err)
+	}
+	if got, want := clone.BodyBytesRead(), int64(7); got != want {
+		t.Errorf("clone.BodyBytesRead() got: %d want: %d", got, want)
+	}
+	if got := req.BodyBytesRead(); got != 0 {
+		t.Errorf("req.BodyBytesRead() after reading the clone got: %d want: 0", got)
+	}
+
+	if _, err := ioutil.ReadAll(req.Body()); err != nil {
+		t.Fatalf("ioutil.ReadAll(req.Body()): %v", err)
+	}
+	if got, want := req.BodyBytesRead(), int64(7); got != want {
+		t.Errorf("req.BodyBytesRead() got: %d want: %d", got, want)
+	}
+}
@@ -14,6 +14,12 @@
 
 package safehttp
 
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
 // Interceptor alter the processing of incoming requests.
 //
 // See the documentation for ServeMux.ServeHTTP to understand how interceptors
@@ -25,8 +31,9 @@ package safehttp
 type Interceptor interface {
 	// Before runs before the IncomingRequest is sent to the handler. If a
 	// response is written to the ResponseWriter, then the remaining
-	// interceptors and the handler won't execute. If Before panics, it will be
-	// recovered and the ServeMux will respond with 500 Internal Server Error.
+	// interceptors and the handler won't execute. If Before panics and panic
+	// recovery is enabled with ServeMuxConfig.RecoverPanics, the ServeMux will
+	// respond with 500 Internal Server Error.
 	Before(w ResponseWriter, r *IncomingRequest, cfg InterceptorConfig) Result
 
 	// Commit runs before the response is written by the Dispatcher. If an error
@@ -38,9 +45,153 @@ type Interceptor interface {
 	Match(InterceptorConfig) bool
 }
 
+// Observer can be implemented by Interceptors that need to know the outcome of
+// requests once their response has been written, e.g. for logging or metrics.
+//
+// Observe is called for every request routed to a handler, including requests
+// rejected by the Before phase of other interceptors, after the response has
+// been written. It's not called if request processing ends with an
+// unrecovered panic.
+type Observer interface {
+	Observe(r *IncomingRequest, info ResponseInfo, cfg InterceptorConfig)
+}
+
+// Shutdowner can be implemented by Interceptors that need to flush their state,
+// e.g. buffered metrics or sessions, before the program exits.
+//
+// Shutdown is called by Server.Shutdown once the requests in flight have been
+// served, for every interceptor installed on the ServeMux of the Server with
+// ServeMuxConfig.Intercept.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// ResponseInfo describes a response that has been written.
+type ResponseInfo struct {
+	// Code is the status code of the response, or StatusSwitchingProtocols if
+	// the connection was hijacked, e.g. for a WebSocket.
+	Code StatusCode
+	// BytesWritten is the number of bytes of the response body written to the
+	// underlying http.ResponseWriter.
+	BytesWritten int64
+	// Duration is the time it took to process the request and write the
+	// response.
+	Duration time.Duration
+}
+
 // InterceptorConfig is a configuration for an interceptor.
 type InterceptorConfig interface{}
 
+// WithInterceptors is an InterceptorConfig that installs the given interceptors
+// only for the handler it's passed to, in addition to the ones installed on the
+// ServeMux. They run after the ServeMux interceptors, in the given order. This
+// allows, for example, protecting different groups of handlers with different
+// XSRF schemes.
+//
+// Other InterceptorConfigs passed to the handler are matched against these
+// interceptors too.
+type WithInterceptors []Interceptor
+
+// WithTimeout is an InterceptorConfig that sets a deadline for processing
+// requests to the handler it's passed to, overriding the one set with
+// ServeMuxConfig.HandlerTimeout. A zero or negative value disables the deadline.
+//
+// The context of the request is canceled when the deadline expires. If no
+// response has been written by then, a 503 Service Unavailable error response
+// is written right away by the Dispatcher, without running the Commit phases
+// of the interceptors, and the response the handler writes later, if any, is
+// discarded, as with http.TimeoutHandler. Responses that have started being
+// written, e.g. streams, are not interrupted.
+//
+// The interceptors and the handler run in a separate goroutine, which is not
+// interrupted: they should stop processing the request when its context is
+// done. Server-level timeouts, such as http.Server.WriteTimeout, should still
+// be used to bound the time spent on the connection.
+type WithTimeout time.Duration
+
+// handlerTimeout returns the timeout set with WithTimeout in cfgs, if any.
+func handlerTimeout(cfgs []InterceptorConfig) (time.Duration, bool) {
+	for _, c := range cfgs {
+		if t, ok := c.(WithTimeout); ok {
+			return time.Duration(t), true
+		}
+	}
+	return 0, false
+}
+
+// DefaultMaxRequestBodySize is the default maximum size in bytes of request
+// bodies, which can be changed with ServeMuxConfig.MaxRequestBodySize.
+const DefaultMaxRequestBodySize = 32 << 20 // 32 MiB
+
+// WithMaxBodySize is an InterceptorConfig that sets the maximum size in bytes
+// of the request bodies accepted by the handler it's passed to, overriding the
+// one set with ServeMuxConfig.MaxRequestBodySize. A zero or negative value
+// disables the limit.
+//
+// Requests whose Content-Length exceeds the limit are rejected with a 413
+// Request Entity Too Large error before interceptors and the handler run.
+// Otherwise, reading more than the limit from the body fails.
+type WithMaxBodySize int64
+
+// handlerMaxBodySize returns the limit set with WithMaxBodySize in cfgs, if
+// any.
+func handlerMaxBodySize(cfgs []InterceptorConfig) (int64, bool) {
+	for _, c := range cfgs {
+		if n, ok := c.(WithMaxBodySize); ok {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+// WithHeaders is an InterceptorConfig that sets default response headers for
+// the handler it's passed to, e.g. a Cache-Control header for all the handlers
+// of a RouteGroup. They are merged with the ones set with
+// ServeMuxConfig.DefaultHeaders, taking precedence over them; a header with no
+// values removes the corresponding default. When several WithHeaders are
+// passed, e.g. to a RouteGroup and to RouteGroup.Handle, the last one takes
+// precedence.
+//
+// See ServeMuxConfig.DefaultHeaders for how default headers are applied.
+type WithHeaders map[string][]string
+
+// routeHeaders returns the default headers resulting from applying the
+// WithHeaders in cfgs, if any, to the given defaults.
+func routeHeaders(defaults http.Header, cfgs []InterceptorConfig) http.Header {
+	h := defaults.Clone()
+	for _, c := range cfgs {
+		wh, ok := c.(WithHeaders)
+		if !ok {
+			continue
+		}
+		if h == nil {
+			h = http.Header{}
+		}
+		for name, v := range defaultHeaders(wh) {
+			if len(v) == 0 {
+				delete(h, name)
+				continue
+			}
+			h[name] = v
+		}
+	}
+	return h
+}
+
+// defaultHeaders returns a copy of h with canonical header names. It panics if
+// h contains headers that can't have defaults.
+func defaultHeaders(h map[string][]string) http.Header {
+	dh := make(http.Header, len(h))
+	for name, v := range h {
+		name = http.CanonicalHeaderKey(name)
+		if name == "Set-Cookie" {
+			panic("cannot set a default Set-Cookie header, use ResponseWriter.AddCookie instead")
+		}
+		dh[name] = append([]string(nil), v...)
+	}
+	return dh
+}
+
 // configuredInterceptor holds an interceptor together with its configuration.
 type configuredInterceptor struct {
 	interceptor Interceptor
@@ -49,8 +200,9 @@ type configuredInterceptor struct {
 
 // Before runs before the IncomingRequest is sent to the handler. If a
 // response is written to the ResponseWriter, then the remaining
-// interceptors and the handler won't execute. If Before panics, it will be
-// recovered and the ServeMux will respond with 500 Internal Server Error.
+// interceptors and the handler won't execute. If Before panics and panic
+// recovery is enabled with ServeMuxConfig.RecoverPanics, the ServeMux will
+// respond with 500 Internal Server Error.
 func (ci *configuredInterceptor) Before(w ResponseWriter, r *IncomingRequest) Result {
 	return ci.interceptor.Before(w, r, ci.config)
 }
@@ -61,3 +213,10 @@ func (ci *configuredInterceptor) Before(w ResponseWriter, r *IncomingRequest) Re
 func (ci *configuredInterceptor) Commit(w ResponseHeadersWriter, r *IncomingRequest, resp Response) {
 	ci.interceptor.Commit(w, r, resp, ci.config)
 }
+
+// observe calls the Observe method of the interceptor, if it's an Observer.
+func (ci *configuredInterceptor) observe(r *IncomingRequest, info ResponseInfo) {
+	if o, ok := ci.interceptor.(Observer); ok {
+		o.Observe(r, info, ci.config)
+	}
+}
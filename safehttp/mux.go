@@ -15,9 +15,15 @@
 package safehttp
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // The HTTP request methods defined by RFC.
@@ -65,31 +71,66 @@ const (
 // header, stripping the port number and redirecting any request containing . or
 // .. elements or repeated slashes to an equivalent, cleaner URL.
 //
+// Patterns can contain path parameters spanning whole path segments, like
+// "/users/{id}/posts/{postID}". A parameter matches any non-empty segment and
+// its value can be retrieved with IncomingRequest.PathParam. Patterns with
+// parameters take precedence over rooted subtrees, but not over fixed paths
+// matching the request exactly: if both "/users/{id}" and "/users/me" are
+// registered, the latter is called for "/users/me". Among patterns with
+// parameters, a fixed segment takes precedence over a parameter in the same
+// position.
+//
 // Multiple handlers can be registered for a single pattern, as long as they
 // handle different HTTP methods.
 type ServeMux struct {
-	mux      *http.ServeMux
-	handlers map[string]*registeredHandler
+	// inflight is the number of requests being served. It's the first field
+	// to be 64-bit aligned for atomic operations.
+	inflight int64
+
+	mux         *http.ServeMux
+	handlers    map[string]*registeredHandler
+	paramRoutes []*paramRoute
 
 	dispatcher       Dispatcher
 	interceptors     []Interceptor
+	preFilters       []func(*IncomingRequest) StatusCode
+	panicReporter    func(*IncomingRequest, interface{})
+	jsonErrors       bool
+	timeout          time.Duration
+	maxBodySize      int64
+	proxyPolicy      ProxyPolicy
+	errorHandler     ErrorHandler
+	headers          http.Header
+	compression      *CompressionPolicy
 	methodNotAllowed handlerConfig
+
+	// draining is set to 1 when the Server starts shutting down.
+	draining int32
 }
 
 // ServeHTTP dispatches the request to the handler whose method matches the
 // incoming request and whose pattern most closely matches the request URL.
 //
 //  For each incoming request:
+//  - [Pre-filter Phase] pre-filters are called in the order they've been
+//    installed, before the request is routed; the first one that returns a
+//    status code other than StatusOK causes an error response to be written
+//    with that code, and neither interceptors nor the handler are run,
 //  - [Before Phase] Interceptor.Before methods are called for every installed
 //    interceptor, until an interceptor writes to a ResponseWriter (including
 //    errors) or panics,
-//  - the handler is called after a [Before Phase] if no writes or panics occured,
+//  - the handler is called after a [Before Phase] if no writes or panics occured;
+//    if RecoverPanics was used and the handler or an Interceptor.Before method
+//    panics before writing, a 500 error response is written instead,
 //  - the handler triggers the [Commit Phase] by writing to the ResponseWriter,
 //  - [Commit Phase] Interceptor.Commit methods run for every interceptor whose
 //    Before method was called,
 //  - [Dispatcher Phase] after the [Commit Phase], the Dispatcher's appropriate
 //    write method is called; the Dispatcher is responsible for determining whether
 //    the response is indeed safe and writing it,
+//  - [Observe Phase] after the response has been written, Observer.Observe
+//    methods are called for every installed interceptor that implements
+//    Observer,
 //  - if the handler attempts to write more than once, it is treated as an
 //    unrecoverable error; the request processing ends abrubptly with a panic and
 //    nothing else happens (note: this will change as soon as [After Phase] is
@@ -97,9 +138,76 @@ type ServeMux struct {
 //
 // Interceptors should NOT rely on the order they're run.
 func (m *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&m.inflight, 1)
+	defer atomic.AddInt64(&m.inflight, -1)
+	if len(m.preFilters) > 0 {
+		ir := NewIncomingRequest(r)
+		ir.proxyPolicy = m.proxyPolicy
+		for _, f := range m.preFilters {
+			if code := f(ir); code != StatusOK {
+				m.rejectRequest(code, w, r)
+				return
+			}
+		}
+	}
+	if pr, params := m.matchParamRoute(r); pr != nil {
+		pr.handler.serve(w, r, params)
+		return
+	}
 	m.mux.ServeHTTP(w, r)
 }
 
+// Draining reports whether the Server serving m is shutting down. Readiness
+// checks can use it to report failures while in-flight requests are drained,
+// so that load balancers stop routing new requests to the server.
+func (m *ServeMux) Draining() bool {
+	return atomic.LoadInt32(&m.draining) == 1
+}
+
+// wait blocks until there are no requests being served by m or until ctx is
+// done.
+func (m *ServeMux) wait(ctx context.Context) error {
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+	for atomic.LoadInt64(&m.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+// shutdownInterceptors calls the Shutdown method of every installed
+// interceptor that is a Shutdowner, returning the first error.
+func (m *ServeMux) shutdownInterceptors(ctx context.Context) error {
+	var err error
+	for _, it := range m.interceptors {
+		if sd, ok := it.(Shutdowner); ok {
+			if serr := sd.Shutdown(ctx); err == nil {
+				err = serr
+			}
+		}
+	}
+	return err
+}
+
+// rejectRequest writes an error response with the given code without running
+// any interceptors.
+func (m *ServeMux) rejectRequest(code StatusCode, w http.ResponseWriter, r *http.Request) {
+	processRequest(handlerConfig{
+		Dispatcher: m.dispatcher,
+		Handler: HandlerFunc(func(w ResponseWriter, _ *IncomingRequest) Result {
+			return w.WriteError(code)
+		}),
+		JSONErrors:   m.jsonErrors,
+		ProxyPolicy:  m.proxyPolicy,
+		ErrorHandler: m.errorHandler,
+		Headers:      m.headers,
+	}, w, r, route{})
+}
+
 // Handle registers a handler for the given pattern and method. If a handler is
 // registered twice for the same pattern and method, Build will panic.
 //
@@ -107,27 +215,201 @@ func (m *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // interceptors on a registered handler. Passing an InterceptorConfig whose
 // corresponding Interceptor was not installed will produce no effect. If
 // multiple configurations are passed for the same Interceptor, Mux will panic.
+// Additional interceptors can be installed for the handler by passing
+// WithInterceptors.
+//
+// Handle panics if the pattern contains malformed path parameters or if it
+// matches exactly the same paths as another pattern with parameters.
 func (m *ServeMux) Handle(pattern string, method string, h Handler, cfgs ...InterceptorConfig) {
 	if m.handlers[pattern] == nil {
-		m.handlers[pattern] = &registeredHandler{
+		m.addHandler(&registeredHandler{
 			pattern:          pattern,
 			methodNotAllowed: m.methodNotAllowed,
 			methods:          make(map[string]handlerConfig),
-		}
-		m.mux.Handle(pattern, m.handlers[pattern])
+		})
+	}
+	timeout := m.timeout
+	if t, ok := handlerTimeout(cfgs); ok {
+		timeout = t
+	}
+	maxBodySize := m.maxBodySize
+	if n, ok := handlerMaxBodySize(cfgs); ok {
+		maxBodySize = n
 	}
 	m.handlers[pattern].handleMethod(method,
 		handlerConfig{
-			Dispatcher:   m.dispatcher,
-			Handler:      h,
-			Interceptors: configureInterceptors(m.interceptors, cfgs),
+			Dispatcher:    m.dispatcher,
+			Handler:       h,
+			Interceptors:  configureInterceptors(m.interceptors, cfgs),
+			PanicReporter: m.panicReporter,
+			JSONErrors:    m.jsonErrors,
+			Timeout:       timeout,
+			MaxBodySize:   maxBodySize,
+			ProxyPolicy:   m.proxyPolicy,
+			ErrorHandler:  m.errorHandler,
+			Headers:       routeHeaders(m.headers, cfgs),
+			Compression:   m.compression,
 		})
 }
 
+func (m *ServeMux) addHandler(rh *registeredHandler) {
+	if isParamPattern(rh.pattern) {
+		pr := parseParamPattern(rh.pattern)
+		pr.handler = rh
+		m.addParamRoute(pr)
+	} else {
+		m.mux.Handle(rh.pattern, rh)
+	}
+	m.handlers[rh.pattern] = rh
+}
+
+// Mount registers all the handlers of sub under the given path prefix, e.g.
+// "/admin". The handlers keep the interceptors and configurations they have
+// been registered with on sub, including its method not allowed handler; the
+// interceptors installed on m are not applied to them. Pre-filters of sub are
+// not run, only the ones of m are.
+//
+// Handlers see the full request path, including the prefix, and
+// IncomingRequest.Pattern returns the prefixed pattern. Only handlers
+// registered on sub before calling Mount are mounted. Mount panics if prefix
+// is not empty and doesn't start with "/", if sub contains host-specific
+// patterns or if a prefixed pattern is already registered on m.
+func (m *ServeMux) Mount(prefix string, sub *ServeMux) {
+	checkPrefix(prefix)
+	prefix = strings.TrimSuffix(prefix, "/")
+	var patterns []string
+	for p := range sub.handlers {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	for _, p := range patterns {
+		if !strings.HasPrefix(p, "/") {
+			panic(fmt.Sprintf("cannot mount host-specific pattern %q", p))
+		}
+		mp := prefix + p
+		if m.handlers[mp] != nil {
+			panic(fmt.Sprintf("double registration of pattern %q", mp))
+		}
+		rh := sub.handlers[p]
+		methods := make(map[string]handlerConfig, len(rh.methods))
+		for method, cfg := range rh.methods {
+			methods[method] = cfg
+		}
+		m.addHandler(&registeredHandler{
+			pattern:          mp,
+			methodNotAllowed: rh.methodNotAllowed,
+			methods:          methods,
+		})
+	}
+}
+
+// RouteGroup registers handlers on a ServeMux under a common path prefix,
+// sharing the same interceptor configurations.
+type RouteGroup struct {
+	mux    *ServeMux
+	prefix string
+	cfgs   []InterceptorConfig
+}
+
+// Group returns a RouteGroup for registering handlers under the given path
+// prefix, e.g. "/admin". The given InterceptorConfigs, including
+// WithInterceptors, are applied to all the handlers registered through the
+// group, in addition to the ones passed to RouteGroup.Handle.
+//
+// As with Handle, if a configuration for the same Interceptor is passed both
+// to the group and to RouteGroup.Handle, the registration will panic. Group
+// also panics if prefix is not empty and doesn't start with "/".
+func (m *ServeMux) Group(prefix string, cfgs ...InterceptorConfig) *RouteGroup {
+	checkPrefix(prefix)
+	return &RouteGroup{mux: m, prefix: strings.TrimSuffix(prefix, "/"), cfgs: cfgs}
+}
+
+// Group returns a nested RouteGroup, whose prefix and configurations are
+// appended to the ones of g.
+func (g *RouteGroup) Group(prefix string, cfgs ...InterceptorConfig) *RouteGroup {
+	checkPrefix(prefix)
+	return &RouteGroup{
+		mux:    g.mux,
+		prefix: g.prefix + strings.TrimSuffix(prefix, "/"),
+		cfgs:   append(append([]InterceptorConfig(nil), g.cfgs...), cfgs...),
+	}
+}
+
+// checkPrefix panics if prefix is not a path prefix, i.e. if it's not empty and
+// doesn't start with "/". Such prefixes would turn the patterns they are
+// prepended to into host-specific patterns, e.g. "admin/users".
+func checkPrefix(prefix string) {
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		panic(fmt.Sprintf("prefix %q must be empty or start with \"/\"", prefix))
+	}
+}
+
+// Handle registers a handler for the group prefix followed by the given
+// pattern, e.g. "/admin/users" for the group "/admin" and the pattern "/users".
+// See ServeMux.Handle for details.
+func (g *RouteGroup) Handle(pattern string, method string, h Handler, cfgs ...InterceptorConfig) {
+	all := append(append([]InterceptorConfig(nil), g.cfgs...), cfgs...)
+	g.mux.Handle(g.prefix+pattern, method, h, all...)
+}
+
+// RouteInfo describes a handler registered on a ServeMux for a given pattern
+// and method.
+type RouteInfo struct {
+	Pattern string
+	Method  string
+	// Interceptors are the interceptors that run for the handler, in the
+	// order they've been installed.
+	Interceptors []InterceptorInfo
+}
+
+// InterceptorInfo describes an interceptor applied to a registered handler.
+type InterceptorInfo struct {
+	Interceptor Interceptor
+	// Config is the configuration passed to the interceptor, or nil if none
+	// was provided when registering the handler.
+	Config InterceptorConfig
+}
+
+// RegisteredRoutes returns information about all the handlers registered on
+// the ServeMux, sorted by pattern and method. This allows, for example,
+// checking at startup that all the handlers of state changing requests are
+// protected against XSRF.
+//
+// The returned slice is a copy and modifying it doesn't affect the ServeMux.
+func (m *ServeMux) RegisteredRoutes() []RouteInfo {
+	var routes []RouteInfo
+	for pattern, rh := range m.handlers {
+		for method, cfg := range rh.methods {
+			its := make([]InterceptorInfo, 0, len(cfg.Interceptors))
+			for _, ci := range cfg.Interceptors {
+				its = append(its, InterceptorInfo{Interceptor: ci.interceptor, Config: ci.config})
+			}
+			routes = append(routes, RouteInfo{Pattern: pattern, Method: method, Interceptors: its})
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Pattern != routes[j].Pattern {
+			return routes[i].Pattern < routes[j].Pattern
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
 // ServeMuxConfig is a builder for ServeMux.
 type ServeMuxConfig struct {
 	dispatcher   Dispatcher
 	interceptors []Interceptor
+	preFilters   []func(*IncomingRequest) StatusCode
+
+	panicReporter func(*IncomingRequest, interface{})
+	jsonErrors    bool
+	timeout       time.Duration
+	maxBodySize   int64
+	proxyPolicy   ProxyPolicy
+	errorHandler  ErrorHandler
+	headers       http.Header
+	compression   *CompressionPolicy
 
 	methodNotAllowed     Handler
 	methodNotAllowedCfgs []InterceptorConfig
@@ -141,6 +423,7 @@ func NewServeMuxConfig(disp Dispatcher) *ServeMuxConfig {
 	}
 	return &ServeMuxConfig{
 		dispatcher:       disp,
+		maxBodySize:      DefaultMaxRequestBodySize,
 		methodNotAllowed: HandlerFunc(defaultMethotNotAllowed),
 	}
 }
@@ -167,6 +450,124 @@ func (s *ServeMuxConfig) Intercept(is ...Interceptor) {
 	s.interceptors = append(s.interceptors, is...)
 }
 
+// PreFilter installs the given pre-filters.
+//
+// Pre-filters run before the request is routed and before any interceptor, so
+// they are a cheap way of dropping obviously malicious requests (e.g. with an
+// unexpected Host header or an oversized URL). A pre-filter returns StatusOK
+// to let the request through; any other status code is written as an error
+// response using the Dispatcher, and no interceptor methods are called for
+// the request.
+//
+// Pre-filters are run in the order they've been installed and calling
+// PreFilter multiple times is valid.
+func (s *ServeMuxConfig) PreFilter(fs ...func(*IncomingRequest) StatusCode) {
+	s.preFilters = append(s.preFilters, fs...)
+}
+
+// RecoverPanics makes the ServeMux recover from panics in handlers and in the
+// Before phases of interceptors.
+//
+// By default, panics are propagated to net/http after clearing all the
+// response headers, so no Commit phases run. When RecoverPanics is used, a
+// panic is reported to the given function, the headers set and claimed by the
+// panicking handler or interceptor are discarded and a 500 Internal Server
+// Error is written by the Dispatcher, running the Commit phases of all
+// interceptors. The report function is called while panicking, so it can
+// capture the stack trace with runtime/debug.Stack. Panics that happen after
+// the response has been written, e.g. in Commit phases, are still propagated.
+func (s *ServeMuxConfig) RecoverPanics(report func(r *IncomingRequest, v interface{})) {
+	s.panicReporter = report
+}
+
+// NegotiateJSONErrors makes the ServeMux write error responses as JSON, e.g.
+// {"error":"forbidden","code":403}, for requests whose Accept header prefers
+// application/json over text/html and text/plain. This applies to all error
+// responses, including the ones written by interceptors and pre-filters, and
+// bypasses Dispatcher.Error. The JSON body only contains the status code and
+// its name.
+func (s *ServeMuxConfig) NegotiateJSONErrors() {
+	s.jsonErrors = true
+}
+
+// HandleErrors installs an ErrorHandler rendering all the error responses
+// written with ResponseWriter.WriteError, including the ones written by
+// interceptors and pre-filters. It takes precedence over NegotiateJSONErrors
+// for the errors it renders.
+func (s *ServeMuxConfig) HandleErrors(h ErrorHandler) {
+	s.errorHandler = h
+}
+
+// DefaultHeaders sets headers, e.g. Cache-Control or Vary, to be added to the
+// responses of all the handlers registered on the ServeMux, including error
+// responses. Calling DefaultHeaders multiple times adds to the previous
+// defaults. Defaults can be overridden for some handlers with WithHeaders.
+//
+// Default headers are set after the Commit phases of the interceptors and
+// never replace a header that has already been set by the handler or by an
+// interceptor. Headers claimed by interceptors are owned by them and are left
+// untouched, so, for example, a plugin claiming Cache-Control always wins over
+// a default Cache-Control. DefaultHeaders panics if a Set-Cookie default is
+// given.
+func (s *ServeMuxConfig) DefaultHeaders(h map[string][]string) {
+	if s.headers == nil {
+		s.headers = http.Header{}
+	}
+	for name, v := range defaultHeaders(h) {
+		s.headers[name] = v
+	}
+}
+
+// Compress enables the compression of the responses written with
+// ResponseWriter.Write, for requests whose Accept-Encoding header accepts one
+// of the Encoders of the policy.
+//
+// Compression happens while the Dispatcher writes the response, after the
+// Commit phases of the interceptors. Responses that are already compressed,
+// e.g. images or responses with a Content-Encoding header, responses to range
+// requests and responses without a body are not compressed. Compressible
+// responses get a "Vary: Accept-Encoding" header, whether they are
+// compressed or not, and their strong ETags are made weak when compressed.
+//
+// Compress panics if the policy is invalid, e.g. if it has a nil Encoder.
+func (s *ServeMuxConfig) Compress(p CompressionPolicy) {
+	if err := p.validate(); err != nil {
+		panic(fmt.Sprintf("cannot compress responses: %v", err))
+	}
+	p.Encoders = append([]Encoder(nil), p.Encoders...)
+	s.compression = &p
+}
+
+// HandlerTimeout sets a deadline for processing requests to all the handlers
+// registered on the ServeMux, unless overridden with WithTimeout. See
+// WithTimeout for details.
+func (s *ServeMuxConfig) HandlerTimeout(d time.Duration) {
+	s.timeout = d
+}
+
+// MaxRequestBodySize sets the maximum size in bytes of the request bodies
+// accepted by all the handlers registered on the ServeMux, unless overridden
+// with WithMaxBodySize. See WithMaxBodySize for details. The default is
+// DefaultMaxRequestBodySize.
+func (s *ServeMuxConfig) MaxRequestBodySize(n int64) {
+	s.maxBodySize = n
+}
+
+// TrustProxies sets the policy used to resolve the IP address of clients with
+// IncomingRequest.ClientIP, for servers deployed behind reverse proxies or load
+// balancers. By default, no proxies are trusted and the address of the direct
+// peer is used.
+//
+// TrustProxies panics if the policy is invalid, e.g. if it has an unsupported
+// Header.
+func (s *ServeMuxConfig) TrustProxies(p ProxyPolicy) {
+	if err := p.validate(); err != nil {
+		panic(fmt.Sprintf("cannot trust proxies: %v", err))
+	}
+	p.TrustedProxies = append([]*net.IPNet(nil), p.TrustedProxies...)
+	s.proxyPolicy = p
+}
+
 // Mux returns the ServeMux with a copy of the current configuration.
 func (s *ServeMuxConfig) Mux() *ServeMux {
 	freezeLocalDev = true
@@ -179,9 +580,16 @@ func (s *ServeMuxConfig) Mux() *ServeMux {
 	}
 
 	methodNotAllowed := handlerConfig{
-		Dispatcher:   s.dispatcher,
-		Handler:      s.methodNotAllowed,
-		Interceptors: configureInterceptors(s.interceptors, s.methodNotAllowedCfgs),
+		Dispatcher:    s.dispatcher,
+		Handler:       s.methodNotAllowed,
+		Interceptors:  configureInterceptors(s.interceptors, s.methodNotAllowedCfgs),
+		PanicReporter: s.panicReporter,
+		JSONErrors:    s.jsonErrors,
+		MaxBodySize:   s.maxBodySize,
+		ProxyPolicy:   s.proxyPolicy,
+		ErrorHandler:  s.errorHandler,
+		Headers:       routeHeaders(s.headers, s.methodNotAllowedCfgs),
+		Compression:   s.compression,
 	}
 
 	m := &ServeMux{
@@ -189,6 +597,15 @@ func (s *ServeMuxConfig) Mux() *ServeMux {
 		handlers:         make(map[string]*registeredHandler),
 		dispatcher:       s.dispatcher,
 		interceptors:     s.interceptors,
+		preFilters:       append([]func(*IncomingRequest) StatusCode(nil), s.preFilters...),
+		panicReporter:    s.panicReporter,
+		jsonErrors:       s.jsonErrors,
+		timeout:          s.timeout,
+		maxBodySize:      s.maxBodySize,
+		proxyPolicy:      s.proxyPolicy,
+		errorHandler:     s.errorHandler,
+		headers:          s.headers.Clone(),
+		compression:      s.compression,
 		methodNotAllowed: methodNotAllowed,
 	}
 	return m
@@ -201,6 +618,15 @@ func (s *ServeMuxConfig) Clone() *ServeMuxConfig {
 	return &ServeMuxConfig{
 		dispatcher:           s.dispatcher,
 		interceptors:         append([]Interceptor(nil), s.interceptors...),
+		preFilters:           append([]func(*IncomingRequest) StatusCode(nil), s.preFilters...),
+		panicReporter:        s.panicReporter,
+		jsonErrors:           s.jsonErrors,
+		timeout:              s.timeout,
+		maxBodySize:          s.maxBodySize,
+		proxyPolicy:          s.proxyPolicy,
+		errorHandler:         s.errorHandler,
+		headers:              s.headers.Clone(),
+		compression:          s.compression,
 		methodNotAllowed:     s.methodNotAllowed,
 		methodNotAllowedCfgs: append([]InterceptorConfig(nil), s.methodNotAllowedCfgs...),
 	}
@@ -213,11 +639,15 @@ type registeredHandler struct {
 }
 
 func (rh *registeredHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rh.serve(w, r, nil)
+}
+
+func (rh *registeredHandler) serve(w http.ResponseWriter, r *http.Request, params map[string]string) {
 	cfg, ok := rh.methods[r.Method]
 	if !ok {
 		cfg = rh.methodNotAllowed
 	}
-	processRequest(cfg, w, r)
+	processRequest(cfg, w, r, route{pattern: rh.pattern, params: params})
 }
 
 func (rh *registeredHandler) handleMethod(method string, cfg handlerConfig) {
@@ -228,6 +658,20 @@ func (rh *registeredHandler) handleMethod(method string, cfg handlerConfig) {
 }
 
 func configureInterceptors(interceptors []Interceptor, cfgs []InterceptorConfig) []configuredInterceptor {
+	var extra []Interceptor
+	var rest []InterceptorConfig
+	for _, c := range cfgs {
+		if w, ok := c.(WithInterceptors); ok {
+			extra = append(extra, w...)
+			continue
+		}
+		rest = append(rest, c)
+	}
+	if len(extra) > 0 {
+		interceptors = append(append([]Interceptor(nil), interceptors...), extra...)
+		cfgs = rest
+	}
+
 	var its []configuredInterceptor
 	for _, it := range interceptors {
 		var matches []InterceptorConfig
@@ -15,10 +15,14 @@
 package safehttp_test
 
 import (
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-safeweb/safehttp"
@@ -761,3 +765,1029 @@ func TestMuxMethodNotAllowedCustom(t *testing.T) {
 		t.Errorf("response body: got %q want %q", got, wantBody)
 	}
 }
+
+type recordingInterceptor struct {
+	before *bool
+}
+
+func (it recordingInterceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	*it.before = true
+	return safehttp.NotWritten()
+}
+
+func (recordingInterceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+}
+
+func (recordingInterceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
+
+func TestMuxPreFilter(t *testing.T) {
+	hostFilter := func(r *safehttp.IncomingRequest) safehttp.StatusCode {
+		if r.Host() != "foo.com" {
+			return safehttp.StatusNotFound
+		}
+		return safehttp.StatusOK
+	}
+	urlFilter := func(r *safehttp.IncomingRequest) safehttp.StatusCode {
+		if len(r.URL().String()) > 20 {
+			return safehttp.StatusRequestURITooLong
+		}
+		return safehttp.StatusOK
+	}
+
+	tests := []struct {
+		name       string
+		req        *http.Request
+		wantStatus safehttp.StatusCode
+		wantBefore bool
+		wantBody   string
+	}{
+		{
+			name:       "Passes all filters",
+			req:        httptest.NewRequest(safehttp.MethodGet, "http://foo.com/bar", nil),
+			wantStatus: safehttp.StatusOK,
+			wantBefore: true,
+			wantBody:   "bar",
+		},
+		{
+			name:       "Rejected by first filter",
+			req:        httptest.NewRequest(safehttp.MethodGet, "http://evil.com/bar", nil),
+			wantStatus: safehttp.StatusNotFound,
+			wantBody:   "Not Found\n",
+		},
+		{
+			name:       "Rejected by second filter",
+			req:        httptest.NewRequest(safehttp.MethodGet, "http://foo.com/bar?q=aaaaaaaaaaaaaaaa", nil),
+			wantStatus: safehttp.StatusRequestURITooLong,
+			wantBody:   "Request URI Too Long\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before bool
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.Intercept(recordingInterceptor{before: &before})
+			mb.PreFilter(hostFilter)
+			mb.PreFilter(urlFilter)
+			mux := mb.Mux()
+			mux.Handle("/bar", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return w.Write(safehtml.HTMLEscaped("bar"))
+			}))
+
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, tt.req)
+
+			if got, want := rw.Code, int(tt.wantStatus); got != want {
+				t.Errorf("rw.Code: got %v want %v", got, want)
+			}
+			if before != tt.wantBefore {
+				t.Errorf("Interceptor.Before called: got %v want %v", before, tt.wantBefore)
+			}
+			if got := rw.Body.String(); got != tt.wantBody {
+				t.Errorf("response body: got %q want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMuxRegisteredRoutes(t *testing.T) {
+	h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.NotWritten()
+	})
+	mb := safehttp.NewServeMuxConfig(nil)
+	unprotected := mb.Clone().Mux()
+	mb.Intercept(setHeaderInterceptor{name: "Foo", value: "bar"})
+	mb.Intercept(setHeaderConfigInterceptor{})
+	protected := mb.Mux()
+
+	protected.Handle("/b", safehttp.MethodGet, h)
+	protected.Handle("/a", safehttp.MethodPost, h, setHeaderConfig{name: "Pizza", value: "Margherita"})
+	protected.Handle("/a", safehttp.MethodGet, h)
+	unprotected.Handle("/c", safehttp.MethodPost, h)
+
+	its := func(cfg safehttp.InterceptorConfig) []safehttp.InterceptorInfo {
+		return []safehttp.InterceptorInfo{
+			{Interceptor: setHeaderInterceptor{name: "Foo", value: "bar"}},
+			{Interceptor: setHeaderConfigInterceptor{}, Config: cfg},
+		}
+	}
+	want := []safehttp.RouteInfo{
+		{Pattern: "/a", Method: safehttp.MethodGet, Interceptors: its(nil)},
+		{Pattern: "/a", Method: safehttp.MethodPost, Interceptors: its(setHeaderConfig{name: "Pizza", value: "Margherita"})},
+		{Pattern: "/b", Method: safehttp.MethodGet, Interceptors: its(nil)},
+	}
+	opts := cmp.AllowUnexported(setHeaderInterceptor{}, setHeaderConfig{})
+	if diff := cmp.Diff(want, protected.RegisteredRoutes(), opts); diff != "" {
+		t.Errorf("protected.RegisteredRoutes() mismatch (-want +got):\n%s", diff)
+	}
+
+	want = []safehttp.RouteInfo{
+		{Pattern: "/c", Method: safehttp.MethodPost, Interceptors: []safehttp.InterceptorInfo{}},
+	}
+	if diff := cmp.Diff(want, unprotected.RegisteredRoutes()); diff != "" {
+		t.Errorf("unprotected.RegisteredRoutes() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMuxWithInterceptors(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(setHeaderInterceptor{name: "Foo", value: "bar"})
+	mux := mb.Mux()
+
+	h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehtml.HTMLEscaped("<h1>Hello World!</h1>"))
+	})
+	mux.Handle("/a", safehttp.MethodGet, h,
+		safehttp.WithInterceptors{setHeaderConfigInterceptor{}},
+		setHeaderConfig{name: "Pizza", value: "Margherita"})
+	mux.Handle("/b", safehttp.MethodGet, h)
+
+	tests := []struct {
+		path        string
+		wantHeaders map[string][]string
+	}{
+		{
+			path: "/a",
+			wantHeaders: map[string][]string{
+				"Content-Type": {"text/html; charset=utf-8"},
+				"Foo":          {"bar"},
+				"Pizza":        {"Margherita"},
+				"Commit-Pizza": {"Margherita"},
+			},
+		},
+		{
+			path: "/b",
+			wantHeaders: map[string][]string{
+				"Content-Type": {"text/html; charset=utf-8"},
+				"Foo":          {"bar"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com"+tt.path, nil))
+
+			if want := safehttp.StatusOK; rw.Code != int(want) {
+				t.Errorf("rw.Code: got %v want %v", rw.Code, want)
+			}
+			if diff := cmp.Diff(tt.wantHeaders, map[string][]string(rw.Header())); diff != "" {
+				t.Errorf("rw.Header() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	routes := mux.RegisteredRoutes()
+	if got, want := len(routes[0].Interceptors), 2; got != want {
+		t.Errorf("len(RegisteredRoutes()[0].Interceptors): got %v, want %v", got, want)
+	}
+}
+
+func TestMuxNegotiateJSONErrors(t *testing.T) {
+	tests := []struct {
+		name            string
+		accept          string
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "No Accept header",
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden\n",
+		},
+		{
+			name:            "Browser",
+			accept:          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden\n",
+		},
+		{
+			name:            "HTML preferred",
+			accept:          "application/json;q=0.5, text/html",
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Forbidden\n",
+		},
+		{
+			name:            "JSON",
+			accept:          "application/json",
+			wantContentType: "application/json; charset=utf-8",
+			wantBody:        `{"error":"forbidden","code":403}` + "\n",
+		},
+		{
+			name:            "Angular",
+			accept:          "application/json, text/plain, */*",
+			wantContentType: "application/json; charset=utf-8",
+			wantBody:        `{"error":"forbidden","code":403}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.NegotiateJSONErrors()
+			mux := mb.Mux()
+			mux.Handle("/bar", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return w.WriteError(safehttp.StatusForbidden)
+			}))
+
+			req := httptest.NewRequest(safehttp.MethodGet, "http://foo.com/bar", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, req)
+
+			if got, want := rw.Code, int(safehttp.StatusForbidden); got != want {
+				t.Errorf("rw.Code: got %v want %v", got, want)
+			}
+			if got := rw.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type: got %q want %q", got, tt.wantContentType)
+			}
+			if got := rw.Body.String(); got != tt.wantBody {
+				t.Errorf("response body: got %q want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMuxJSONErrorsDisabledByDefault(t *testing.T) {
+	mux := safehttp.NewServeMuxConfig(nil).Mux()
+	mux.Handle("/bar", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.WriteError(safehttp.StatusForbidden)
+	}))
+
+	req := httptest.NewRequest(safehttp.MethodGet, "http://foo.com/bar", nil)
+	req.Header.Set("Accept", "application/json")
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, req)
+
+	if got, want := rw.Header().Get("Content-Type"), "text/plain; charset=utf-8"; got != want {
+		t.Errorf("Content-Type: got %q want %q", got, want)
+	}
+}
+
+func TestMuxPathParams(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mux := mb.Mux()
+
+	write := func(format string, params ...string) safehttp.Handler {
+		return safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+			var args []interface{}
+			for _, p := range params {
+				args = append(args, r.PathParam(p))
+			}
+			args = append(args, r.Pattern())
+			return w.Write(safehtml.HTMLEscaped(fmt.Sprintf(format, args...)))
+		})
+	}
+	mux.Handle("/", safehttp.MethodGet, write("root %s"))
+	mux.Handle("/users/me", safehttp.MethodGet, write("me %s"))
+	mux.Handle("/users/{id}", safehttp.MethodGet, write("user %s %s", "id"))
+	mux.Handle("/users/{id}/posts/{postID}", safehttp.MethodGet, write("post %s %s %s", "id", "postID"))
+	mux.Handle("/users/{id}/posts/latest", safehttp.MethodGet, write("latest %s %s", "id"))
+	mux.Handle("/hosts/{id}", safehttp.MethodGet, write("any host %s %s", "id"))
+	mux.Handle("bar.com/hosts/{id}", safehttp.MethodGet, write("bar host %s %s", "id"))
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus safehttp.StatusCode
+		wantBody   string
+	}{
+		{
+			name:       "Parameter",
+			target:     "http://foo.com/users/42",
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "user 42 /users/{id}",
+		},
+		{
+			name:       "Fixed path takes precedence",
+			target:     "http://foo.com/users/me",
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "me /users/me",
+		},
+		{
+			name:       "Escaped parameter",
+			target:     "http://foo.com/users/a%2Fb",
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "user a/b /users/{id}",
+		},
+		{
+			name:       "Multiple parameters",
+			target:     "http://foo.com/users/42/posts/7",
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "post 42 7 /users/{id}/posts/{postID}",
+		},
+		{
+			name:       "Fixed segment takes precedence",
+			target:     "http://foo.com/users/42/posts/latest",
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "latest 42 /users/{id}/posts/latest",
+		},
+		{
+			name:       "Empty parameter",
+			target:     "http://foo.com/users/",
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "root /",
+		},
+		{
+			name:       "Too many segments",
+			target:     "http://foo.com/users/42/comments/7",
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "root /",
+		},
+		{
+			name:       "Host-specific pattern",
+			target:     "http://bar.com:8080/hosts/1",
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "bar host 1 bar.com/hosts/{id}",
+		},
+		{
+			name:       "Other host",
+			target:     "http://foo.com/hosts/1",
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "any host 1 /hosts/{id}",
+		},
+		{
+			name:       "Unclean path",
+			target:     "http://foo.com/users/./42",
+			wantStatus: safehttp.StatusMovedPermanently,
+		},
+		{
+			name:       "Method not allowed",
+			method:     safehttp.MethodPost,
+			target:     "http://foo.com/users/42",
+			wantStatus: safehttp.StatusMethodNotAllowed,
+			wantBody:   "Method Not Allowed\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = safehttp.MethodGet
+			}
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, httptest.NewRequest(method, tt.target, nil))
+
+			if rw.Code != int(tt.wantStatus) {
+				t.Errorf("rw.Code: got %v want %v", rw.Code, tt.wantStatus)
+			}
+			if tt.wantBody == "" {
+				return
+			}
+			if got := rw.Body.String(); got != tt.wantBody {
+				t.Errorf("rw.Body.String(): got %q want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMuxPathParamsTyped(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mux := mb.Mux()
+
+	var gotInt int64
+	var gotUint uint64
+	var intErr, uintErr, missingErr error
+	mux.Handle("/n/{n}", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		gotInt, intErr = r.PathParamInt64("n")
+		gotUint, uintErr = r.PathParamUint64("n")
+		_, missingErr = r.PathParamInt64("m")
+		return w.Write(safehtml.HTMLEscaped("ok"))
+	}))
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(safehttp.MethodGet, "http://foo.com/n/18446744073709551615", nil))
+	if intErr == nil {
+		t.Errorf(`r.PathParamInt64("n"): got %v, want error`, gotInt)
+	}
+	if uintErr != nil || gotUint != 18446744073709551615 {
+		t.Errorf(`r.PathParamUint64("n"): got %v, %v, want 18446744073709551615, nil`, gotUint, uintErr)
+	}
+	if missingErr == nil {
+		t.Error(`r.PathParamInt64("m"): got nil error, want error`)
+	}
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(safehttp.MethodGet, "http://foo.com/n/-3", nil))
+	if intErr != nil || gotInt != -3 {
+		t.Errorf(`r.PathParamInt64("n"): got %v, %v, want -3, nil`, gotInt, intErr)
+	}
+	if uintErr == nil {
+		t.Errorf(`r.PathParamUint64("n"): got %v, want error`, gotUint)
+	}
+}
+
+func TestMuxInvalidPathParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+	}{
+		{name: "Empty name", patterns: []string{"/a/{}"}},
+		{name: "Partial segment", patterns: []string{"/a/x{id}"}},
+		{name: "Invalid name", patterns: []string{"/a/{i-d}"}},
+		{name: "Duplicate name", patterns: []string{"/a/{id}/{id}"}},
+		{name: "Parameter in host", patterns: []string{"{host}.com/a"}},
+		{name: "Conflicting patterns", patterns: []string{"/a/{x}/b", "/a/{y}/b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := safehttp.NewServeMuxConfig(nil).Mux()
+			h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return w.Write(safehtml.HTMLEscaped("ok"))
+			})
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("mux.Handle(%q): expected panic", tt.patterns)
+				}
+			}()
+			for _, p := range tt.patterns {
+				mux.Handle(p, safehttp.MethodGet, h)
+			}
+		})
+	}
+}
+
+func TestMuxGroup(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(setHeaderInterceptor{name: "Foo", value: "bar"})
+	mux := mb.Mux()
+
+	h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehtml.HTMLEscaped(r.Pattern()))
+	})
+	admin := mux.Group("/admin/", safehttp.WithInterceptors{setHeaderConfigInterceptor{}})
+	admin.Handle("/users", safehttp.MethodGet, h, setHeaderConfig{name: "Pizza", value: "Margherita"})
+	admin.Group("/reports").Handle("/{id}", safehttp.MethodGet, h, setHeaderConfig{name: "Pizza", value: "Diavola"})
+	mux.Handle("/users", safehttp.MethodGet, h)
+
+	tests := []struct {
+		path        string
+		wantBody    string
+		wantHeaders map[string][]string
+	}{
+		{
+			path:     "/admin/users",
+			wantBody: "/admin/users",
+			wantHeaders: map[string][]string{
+				"Content-Type": {"text/html; charset=utf-8"},
+				"Foo":          {"bar"},
+				"Pizza":        {"Margherita"},
+				"Commit-Pizza": {"Margherita"},
+			},
+		},
+		{
+			path:     "/admin/reports/1",
+			wantBody: "/admin/reports/{id}",
+			wantHeaders: map[string][]string{
+				"Content-Type": {"text/html; charset=utf-8"},
+				"Foo":          {"bar"},
+				"Pizza":        {"Diavola"},
+				"Commit-Pizza": {"Diavola"},
+			},
+		},
+		{
+			path:     "/users",
+			wantBody: "/users",
+			wantHeaders: map[string][]string{
+				"Content-Type": {"text/html; charset=utf-8"},
+				"Foo":          {"bar"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com"+tt.path, nil))
+
+			if want := safehttp.StatusOK; rw.Code != int(want) {
+				t.Errorf("rw.Code: got %v want %v", rw.Code, want)
+			}
+			if got := rw.Body.String(); got != tt.wantBody {
+				t.Errorf("rw.Body.String(): got %q want %q", got, tt.wantBody)
+			}
+			if diff := cmp.Diff(tt.wantHeaders, map[string][]string(rw.Header())); diff != "" {
+				t.Errorf("rw.Header() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMuxGroupInvalidPrefix(t *testing.T) {
+	mux := safehttp.NewServeMuxConfig(nil).Mux()
+	tests := []struct {
+		name  string
+		group func()
+	}{
+		{
+			name:  "Group",
+			group: func() { mux.Group("admin") },
+		},
+		{
+			name:  "Nested group",
+			group: func() { mux.Group("/admin").Group("users") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Error("expected panic")
+				}
+			}()
+			tt.group()
+		})
+	}
+}
+
+func TestMuxMount(t *testing.T) {
+	h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehtml.HTMLEscaped(r.Pattern() + " " + r.URL().Path()))
+	})
+
+	subCfg := safehttp.NewServeMuxConfig(nil)
+	subCfg.Intercept(setHeaderInterceptor{name: "Module", value: "admin"})
+	sub := subCfg.Mux()
+	sub.Handle("/", safehttp.MethodGet, h)
+	sub.Handle("/users/{id}", safehttp.MethodGet, h)
+
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(setHeaderInterceptor{name: "Module", value: "main"})
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodGet, h)
+	mux.Mount("/admin/", sub)
+
+	tests := []struct {
+		path        string
+		method      string
+		wantStatus  safehttp.StatusCode
+		wantBody    string
+		wantHeaders map[string][]string
+	}{
+		{
+			path:       "/admin/users/42",
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "/admin/users/{id} /admin/users/42",
+			wantHeaders: map[string][]string{
+				"Content-Type": {"text/html; charset=utf-8"},
+				"Module":       {"admin"},
+			},
+		},
+		{
+			path:       "/admin/settings",
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "/admin/ /admin/settings",
+			wantHeaders: map[string][]string{
+				"Content-Type": {"text/html; charset=utf-8"},
+				"Module":       {"admin"},
+			},
+		},
+		{
+			path:       "/admin/users/42",
+			method:     safehttp.MethodPost,
+			wantStatus: safehttp.StatusMethodNotAllowed,
+			wantBody:   "Method Not Allowed\n",
+			wantHeaders: map[string][]string{
+				"Content-Type":           {"text/plain; charset=utf-8"},
+				"Module":                 {"admin"},
+				"X-Content-Type-Options": {"nosniff"},
+			},
+		},
+		{
+			path:       "/other",
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "/ /other",
+			wantHeaders: map[string][]string{
+				"Content-Type": {"text/html; charset=utf-8"},
+				"Module":       {"main"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+tt.path, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = safehttp.MethodGet
+			}
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, httptest.NewRequest(method, "http://foo.com"+tt.path, nil))
+
+			if rw.Code != int(tt.wantStatus) {
+				t.Errorf("rw.Code: got %v want %v", rw.Code, tt.wantStatus)
+			}
+			if got := rw.Body.String(); got != tt.wantBody {
+				t.Errorf("rw.Body.String(): got %q want %q", got, tt.wantBody)
+			}
+			if diff := cmp.Diff(tt.wantHeaders, map[string][]string(rw.Header())); diff != "" {
+				t.Errorf("rw.Header() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	var patterns []string
+	for _, r := range mux.RegisteredRoutes() {
+		patterns = append(patterns, r.Pattern)
+	}
+	if diff := cmp.Diff([]string{"/", "/admin/", "/admin/users/{id}"}, patterns); diff != "" {
+		t.Errorf("mux.RegisteredRoutes() patterns mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMuxMountConflict(t *testing.T) {
+	h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehtml.HTMLEscaped("ok"))
+	})
+	sub := safehttp.NewServeMuxConfig(nil).Mux()
+	sub.Handle("/users", safehttp.MethodGet, h)
+	mux := safehttp.NewServeMuxConfig(nil).Mux()
+	mux.Handle("/admin/users", safehttp.MethodPost, h)
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error(`mux.Mount("/admin", sub): expected panic`)
+		}
+	}()
+	mux.Mount("/admin", sub)
+}
+
+func TestMuxMountInvalidPrefix(t *testing.T) {
+	sub := safehttp.NewServeMuxConfig(nil).Mux()
+	sub.Handle("/x", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehtml.HTMLEscaped("ok"))
+	}))
+	mux := safehttp.NewServeMuxConfig(nil).Mux()
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error(`mux.Mount("api", sub): expected panic`)
+		}
+	}()
+	mux.Mount("api", sub)
+}
+
+func TestMuxHandlerTimeout(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.HandlerTimeout(10 * time.Millisecond)
+	mux := mb.Mux()
+
+	slowWrite := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		<-r.Context().Done()
+		return w.Write(safehtml.HTMLEscaped("too late"))
+	})
+	slowNoWrite := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		<-r.Context().Done()
+		return safehttp.NotWritten()
+	})
+	fast := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		if _, ok := r.Context().Deadline(); !ok {
+			return w.Write(safehtml.HTMLEscaped("no deadline"))
+		}
+		return w.Write(safehtml.HTMLEscaped("in time"))
+	})
+	mux.Handle("/slow-write", safehttp.MethodGet, slowWrite)
+	mux.Handle("/slow-no-write", safehttp.MethodGet, slowNoWrite)
+	mux.Handle("/fast", safehttp.MethodGet, fast)
+	mux.Handle("/disabled", safehttp.MethodGet, fast, safehttp.WithTimeout(0))
+	mux.Handle("/override", safehttp.MethodGet, slowWrite, safehttp.WithTimeout(time.Millisecond))
+
+	tests := []struct {
+		path       string
+		wantStatus safehttp.StatusCode
+		wantBody   string
+	}{
+		{path: "/slow-write", wantStatus: safehttp.StatusServiceUnavailable, wantBody: "Service Unavailable\n"},
+		{path: "/slow-no-write", wantStatus: safehttp.StatusServiceUnavailable, wantBody: "Service Unavailable\n"},
+		{path: "/fast", wantStatus: safehttp.StatusOK, wantBody: "in time"},
+		{path: "/disabled", wantStatus: safehttp.StatusOK, wantBody: "no deadline"},
+		{path: "/override", wantStatus: safehttp.StatusServiceUnavailable, wantBody: "Service Unavailable\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com"+tt.path, nil))
+
+			if rw.Code != int(tt.wantStatus) {
+				t.Errorf("rw.Code: got %v want %v", rw.Code, tt.wantStatus)
+			}
+			if got := rw.Body.String(); got != tt.wantBody {
+				t.Errorf("rw.Body.String(): got %q want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMuxHandlerTimeoutBlockingHandler(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.HandlerTimeout(10 * time.Millisecond)
+	mux := mb.Mux()
+	release, returned := make(chan struct{}), make(chan error, 1)
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		// The handler ignores its context.
+		<-release
+		w.Header().Set("X-Late", "1")
+		res := w.Write(safehtml.HTMLEscaped("too late"))
+		returned <- nil
+		return res
+	}))
+
+	rw := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com/", nil))
+		close(served)
+	}()
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("mux.ServeHTTP didn't return at the deadline")
+	}
+	close(release)
+	<-returned
+
+	if want := int(safehttp.StatusServiceUnavailable); rw.Code != want {
+		t.Errorf("rw.Code: got %v want %v", rw.Code, want)
+	}
+	if got, want := rw.Body.String(), "Service Unavailable\n"; got != want {
+		t.Errorf("rw.Body.String(): got %q want %q", got, want)
+	}
+	if got := rw.Header().Get("X-Late"); got != "" {
+		t.Errorf(`rw.Header().Get("X-Late"): got %q want ""`, got)
+	}
+}
+
+func TestMuxMaxRequestBodySize(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.MaxRequestBodySize(10)
+	mux := mb.Mux()
+
+	readBody := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		b, err := ioutil.ReadAll(r.Body())
+		if err != nil {
+			return w.WriteError(safehttp.StatusRequestEntityTooLarge)
+		}
+		return w.Write(safehtml.HTMLEscaped(string(b)))
+	})
+	mux.Handle("/", safehttp.MethodPost, readBody)
+	mux.Handle("/large", safehttp.MethodPost, readBody, safehttp.WithMaxBodySize(20))
+	mux.Handle("/unlimited", safehttp.MethodPost, readBody, safehttp.WithMaxBodySize(0))
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		chunked    bool
+		wantStatus safehttp.StatusCode
+		wantBody   string
+	}{
+		{name: "Small", path: "/", body: "0123456789", wantStatus: safehttp.StatusOK, wantBody: "0123456789"},
+		{name: "Content-Length too large", path: "/", body: "0123456789a", wantStatus: safehttp.StatusRequestEntityTooLarge, wantBody: "Request Entity Too Large\n"},
+		{name: "Chunked too large", path: "/", body: "0123456789a", chunked: true, wantStatus: safehttp.StatusRequestEntityTooLarge, wantBody: "Request Entity Too Large\n"},
+		{name: "Route limit", path: "/large", body: "0123456789a", wantStatus: safehttp.StatusOK, wantBody: "0123456789a"},
+		{name: "Disabled", path: "/unlimited", body: strings.Repeat("a", 100), wantStatus: safehttp.StatusOK, wantBody: strings.Repeat("a", 100)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(safehttp.MethodPost, "http://foo.com"+tt.path, strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, req)
+
+			if rw.Code != int(tt.wantStatus) {
+				t.Errorf("rw.Code: got %v want %v", rw.Code, tt.wantStatus)
+			}
+			if got := rw.Body.String(); got != tt.wantBody {
+				t.Errorf("rw.Body.String(): got %q want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMuxMaxRequestBodySizeBeforeInterceptors(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.MaxRequestBodySize(1)
+	mb.Intercept(setHeaderInterceptor{name: "Foo", value: "bar"})
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodPost, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		t.Error("handler called for a request with a body too large")
+		return safehttp.NotWritten()
+	}))
+
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodPost, "http://foo.com/", strings.NewReader("too large")))
+	if want := safehttp.StatusRequestEntityTooLarge; rw.Code != int(want) {
+		t.Errorf("rw.Code: got %v want %v", rw.Code, want)
+	}
+	if got := rw.Header().Get("Foo"); got != "" {
+		t.Errorf(`rw.Header().Get("Foo"): got %q, want none`, got)
+	}
+}
+
+func TestMuxStreamCommitsBeforeFirstByte(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(setHeaderInterceptor{name: "Foo", value: "bar"})
+	mux := mb.Mux()
+
+	rw := httptest.NewRecorder()
+	mux.Handle("/download", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.WriteStream(w, "text/csv", func(sw safehttp.StreamWriter) error {
+			if got := rw.Header().Get("Foo"); got != "bar" {
+				t.Errorf(`rw.Header().Get("Foo") before the first byte: got %q, want "bar"`, got)
+			}
+			sw.Write([]byte("a,b\n"))
+			if err := sw.Flush(); err != nil {
+				return err
+			}
+			_, err := sw.Write([]byte("1,2\n"))
+			return err
+		})
+	}))
+	mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com/download", nil))
+
+	if want := safehttp.StatusOK; rw.Code != int(want) {
+		t.Errorf("rw.Code: got %v want %v", rw.Code, want)
+	}
+	if !rw.Flushed {
+		t.Error("rw.Flushed: got false, want true")
+	}
+	if got, want := rw.Body.String(), "a,b\n1,2\n"; got != want {
+		t.Errorf("rw.Body.String(): got %q want %q", got, want)
+	}
+}
+
+type observingInterceptor struct {
+	infos *[]safehttp.ResponseInfo
+}
+
+func (observingInterceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	return safehttp.NotWritten()
+}
+
+func (observingInterceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+}
+
+func (observingInterceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
+
+func (it observingInterceptor) Observe(r *safehttp.IncomingRequest, info safehttp.ResponseInfo, cfg safehttp.InterceptorConfig) {
+	info.Duration = 0
+	*it.infos = append(*it.infos, info)
+}
+
+func TestMuxObserver(t *testing.T) {
+	var infos []safehttp.ResponseInfo
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(observingInterceptor{infos: &infos})
+	mux := mb.Mux()
+	mux.Handle("/ok", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehtml.HTMLEscaped("hello"))
+	}))
+	mux.Handle("/stream", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.WriteStream(w, "text/csv", func(sw safehttp.StreamWriter) error {
+			sw.Write([]byte("a,b\n"))
+			return sw.Flush()
+		})
+	}))
+	mux.Handle("/empty", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.NotWritten()
+	}))
+	mux.Handle("/rejected", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.NotWritten()
+	}), safehttp.WithInterceptors{internalErrorInterceptor{}})
+
+	for _, path := range []string{"/ok", "/stream", "/empty", "/rejected"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(safehttp.MethodGet, "http://foo.com"+path, nil))
+	}
+
+	want := []safehttp.ResponseInfo{
+		{Code: safehttp.StatusOK, BytesWritten: 5},
+		{Code: safehttp.StatusOK, BytesWritten: 4},
+		{Code: safehttp.StatusNoContent},
+		{Code: safehttp.StatusInternalServerError, BytesWritten: int64(len("Internal Server Error\n"))},
+	}
+	if diff := cmp.Diff(want, infos); diff != "" {
+		t.Errorf("observed responses mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMuxObserverPreservesFlusher(t *testing.T) {
+	var infos []safehttp.ResponseInfo
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(observingInterceptor{infos: &infos})
+	mux := mb.Mux()
+	var flushErr error
+	mux.Handle("/stream", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.WriteStream(w, "text/csv", func(sw safehttp.StreamWriter) error {
+			flushErr = sw.Flush()
+			return nil
+		})
+	}))
+
+	// A http.ResponseWriter without Flush.
+	rw := struct{ http.ResponseWriter }{httptest.NewRecorder()}
+	mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com/stream", nil))
+	if flushErr == nil {
+		t.Error("sw.Flush() got nil, want error")
+	}
+}
+
+func TestMuxDefaultHeaders(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.DefaultHeaders(map[string][]string{
+		"cache-control": {"no-store"},
+		"Vary":          {"Accept-Encoding"},
+		"X-Custom":      {"global"},
+	})
+	mb.Intercept(&claimHeaderInterceptor{headerToClaim: "X-Claimed"})
+	mb.DefaultHeaders(map[string][]string{"X-Claimed": {"default"}})
+	mux := mb.Mux()
+
+	ok := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.WriteString(w, "ok")
+	})
+	mux.Handle("/global", safehttp.MethodGet, ok)
+	mux.Handle("/handler", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		w.Header().Set("Cache-Control", "private")
+		claimInterceptorSetHeader(w, r, "plugin")
+		return safehttp.WriteString(w, "ok")
+	}))
+	mux.Handle("/error", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.WriteError(safehttp.StatusNotFound)
+	}))
+	g := mux.Group("/static", safehttp.WithHeaders{"Cache-Control": {"public, max-age=3600"}, "X-Custom": nil})
+	g.Handle("/a", safehttp.MethodGet, ok)
+	g.Handle("/b", safehttp.MethodGet, ok, safehttp.WithHeaders{"Cache-Control": {"public, max-age=60"}})
+
+	tests := []struct {
+		path string
+		want map[string][]string
+	}{
+		{
+			path: "/global",
+			want: map[string][]string{
+				"Cache-Control": {"no-store"},
+				"Vary":          {"Accept-Encoding"},
+				"X-Custom":      {"global"},
+			},
+		},
+		{
+			path: "/handler",
+			want: map[string][]string{
+				"Cache-Control": {"private"},
+				"Vary":          {"Accept-Encoding"},
+				"X-Custom":      {"global"},
+				"X-Claimed":     {"plugin"},
+			},
+		},
+		{
+			path: "/error",
+			want: map[string][]string{
+				"Cache-Control": {"no-store"},
+				"Vary":          {"Accept-Encoding"},
+				"X-Custom":      {"global"},
+			},
+		},
+		{
+			path: "/static/a",
+			want: map[string][]string{
+				"Cache-Control": {"public, max-age=3600"},
+				"Vary":          {"Accept-Encoding"},
+			},
+		},
+		{
+			path: "/static/b",
+			want: map[string][]string{
+				"Cache-Control": {"public, max-age=60"},
+				"Vary":          {"Accept-Encoding"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com"+tt.path, nil))
+
+			got := map[string][]string{}
+			for _, name := range []string{"Cache-Control", "Vary", "X-Custom", "X-Claimed"} {
+				if v := rw.Header()[name]; v != nil {
+					got[name] = v
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("rw.Header() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMuxDefaultHeadersSetCookie(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error(`DefaultHeaders({"Set-Cookie": ...}) got no panic, want panic`)
+		}
+	}()
+	safehttp.NewServeMuxConfig(nil).DefaultHeaders(map[string][]string{"set-cookie": {"a=b"}})
+}
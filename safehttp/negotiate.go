@@ -0,0 +1,183 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// Negotiate returns the media type, among the offered ones, that best matches
+// the Accept header of the request, or "" if none is acceptable. Offers are
+// media types without parameters, e.g. "text/html", in order of preference,
+// which is used to break ties between offers with the same quality value. If
+// the request has no Accept header, the first offer is returned.
+//
+// Handlers serving several representations should write a 406 Not Acceptable
+// error when "" is returned, or use WriteNegotiated.
+func Negotiate(r *IncomingRequest, offers ...string) string {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		if len(offers) == 0 {
+			return ""
+		}
+		return offers[0]
+	}
+	var ranges []acceptRange
+	for _, v := range accept {
+		for _, mr := range strings.Split(v, ",") {
+			mt, params, err := mime.ParseMediaType(mr)
+			if err != nil {
+				continue
+			}
+			ranges = append(ranges, acceptRange{value: mt, q: quality(params)})
+		}
+	}
+	return best(offers, func(offer string) float64 {
+		q, specificity := 0.0, -1
+		for _, mr := range ranges {
+			if s := mr.match(strings.ToLower(offer)); s > specificity {
+				q, specificity = mr.q, s
+			}
+		}
+		return q
+	})
+}
+
+// NegotiateLanguage returns the language tag, among the offered ones, that
+// best matches the Accept-Language header of the request. Offers are language
+// tags, e.g. "en-US", in order of preference. A language range matches the
+// offers it is equal to or a prefix of, e.g. "en" matches "en-GB", as
+// specified by RFC 4647. If no offer is acceptable, the first one is returned.
+func NegotiateLanguage(r *IncomingRequest, offers ...string) string {
+	var ranges []acceptRange
+	for _, v := range r.Header.Values("Accept-Language") {
+		for _, lr := range strings.Split(v, ",") {
+			parts := strings.Split(lr, ";")
+			params := map[string]string{}
+			for _, p := range parts[1:] {
+				if kv := strings.SplitN(strings.TrimSpace(p), "=", 2); len(kv) == 2 {
+					params[strings.ToLower(kv[0])] = kv[1]
+				}
+			}
+			ranges = append(ranges, acceptRange{value: strings.ToLower(strings.TrimSpace(parts[0])), q: quality(params)})
+		}
+	}
+	lang := best(offers, func(offer string) float64 {
+		offer = strings.ToLower(offer)
+		q, specificity := 0.0, -1
+		for _, lr := range ranges {
+			s := -1
+			switch {
+			case lr.value == "*":
+				s = 0
+			case lr.value == offer || strings.HasPrefix(offer, lr.value+"-"):
+				s = len(lr.value)
+			}
+			if s > specificity {
+				q, specificity = lr.q, s
+			}
+		}
+		return q
+	})
+	if lang == "" && len(offers) > 0 {
+		return offers[0]
+	}
+	return lang
+}
+
+// Offer is a representation of a resource, for WriteNegotiated.
+type Offer struct {
+	// MediaType is the media type of the representation, e.g.
+	// "application/json".
+	MediaType string
+	// Response is the response written if the representation is selected.
+	Response Response
+}
+
+// WriteNegotiated writes the response of the offer whose media type best
+// matches the Accept header of the request, as determined by Negotiate. If
+// none is acceptable, a 406 Not Acceptable error is written, which is
+// rendered by the ErrorHandler installed with ServeMuxConfig.HandleErrors, if
+// any. Unless the Vary header has been claimed, "Accept" is added to it so that
+// caches store the representations separately.
+func WriteNegotiated(w ResponseWriter, r *IncomingRequest, offers ...Offer) Result {
+	if !w.Header().IsClaimed("Vary") && !hasToken(w.Header().Values("Vary"), "Accept") {
+		w.Header().Add("Vary", "Accept")
+	}
+	types := make([]string, len(offers))
+	for i, o := range offers {
+		types[i] = o.MediaType
+	}
+	mt := Negotiate(r, types...)
+	for _, o := range offers {
+		if o.MediaType == mt {
+			return w.Write(o.Response)
+		}
+	}
+	return w.WriteError(StatusNotAcceptable)
+}
+
+// acceptRange is a media range of an Accept header, or a language range of an
+// Accept-Language header, with its quality value.
+type acceptRange struct {
+	value string
+	q     float64
+}
+
+// match returns the specificity with which the media range matches the media
+// type: 2 for an exact match, 1 for type/* and 0 for */*. It returns -1 if the
+// media range doesn't match.
+func (mr acceptRange) match(mt string) int {
+	switch {
+	case mr.value == mt:
+		return 2
+	case mr.value == "*/*":
+		return 0
+	case strings.HasSuffix(mr.value, "/*") && strings.HasPrefix(mt, mr.value[:len(mr.value)-1]):
+		return 1
+	}
+	return -1
+}
+
+// quality returns the quality value in the parameters of a range, which
+// defaults to 1. Invalid values are treated as 0.
+func quality(params map[string]string) float64 {
+	qs, ok := params["q"]
+	if !ok {
+		return 1
+	}
+	q, err := strconv.ParseFloat(qs, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0
+	}
+	return q
+}
+
+// best returns the first of the offers with the highest positive quality
+// value, or "" if there is none.
+func best(offers []string, q func(string) float64) string {
+	var (
+		bestOffer string
+		bestQ     float64
+	)
+	for _, o := range offers {
+		if oq := q(o); oq > bestQ {
+			bestOffer, bestQ = o, oq
+		}
+	}
+	return bestOffer
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/safehttptest"
+	"github.com/google/safehtml"
+)
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"text/html", "application/json"}
+	tests := []struct {
+		name   string
+		accept []string
+		want   string
+	}{
+		{name: "No Accept header", want: "text/html"},
+		{name: "Exact", accept: []string{"application/json"}, want: "application/json"},
+		{name: "Case insensitive", accept: []string{"Application/JSON"}, want: "application/json"},
+		{name: "Quality", accept: []string{"text/html;q=0.5, application/json"}, want: "application/json"},
+		{name: "Server preference on ties", accept: []string{"application/json, text/html"}, want: "text/html"},
+		{name: "Wildcard", accept: []string{"*/*"}, want: "text/html"},
+		{name: "Subtype wildcard", accept: []string{"text/*;q=0.5, application/json;q=0.4"}, want: "text/html"},
+		{name: "Most specific range wins", accept: []string{"*/*, text/html;q=0"}, want: "application/json"},
+		{name: "Multiple headers", accept: []string{"text/html;q=0.1", "application/json;q=0.2"}, want: "application/json"},
+		{name: "Not acceptable", accept: []string{"image/png"}, want: ""},
+		{name: "Refused", accept: []string{"text/html;q=0, application/json;q=0"}, want: ""},
+		{name: "Invalid quality", accept: []string{"text/html;q=abc, application/json;q=0.1"}, want: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := safehttptest.NewRequest(safehttp.MethodGet, "/", nil)
+			for _, v := range tt.accept {
+				r.Header.Add("Accept", v)
+			}
+			if got := safehttp.Negotiate(r, offers...); got != tt.want {
+				t.Errorf("Negotiate(%v): got %q, want %q", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	offers := []string{"en-US", "en-GB", "fr"}
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		want           string
+	}{
+		{name: "No Accept-Language header", want: "en-US"},
+		{name: "Exact", acceptLanguage: "en-GB", want: "en-GB"},
+		{name: "Prefix", acceptLanguage: "fr-CA;q=0.9, fr;q=0.8, en;q=0.5", want: "fr"},
+		{name: "Language range", acceptLanguage: "en", want: "en-US"},
+		{name: "Most specific range wins", acceptLanguage: "en;q=0.5, en-GB", want: "en-GB"},
+		{name: "Wildcard", acceptLanguage: "de, *;q=0.1", want: "en-US"},
+		{name: "Fallback", acceptLanguage: "de", want: "en-US"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := safehttptest.NewRequest(safehttp.MethodGet, "/", nil)
+			if tt.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			if got := safehttp.NegotiateLanguage(r, offers...); got != tt.want {
+				t.Errorf("NegotiateLanguage(%q): got %q, want %q", tt.acceptLanguage, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteNegotiated(t *testing.T) {
+	tests := []struct {
+		name            string
+		accept          string
+		wantCode        safehttp.StatusCode
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "HTML",
+			accept:          "text/html",
+			wantCode:        safehttp.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "&lt;hello&gt;",
+		},
+		{
+			name:            "JSON",
+			accept:          "application/json",
+			wantCode:        safehttp.StatusOK,
+			wantContentType: "application/json; charset=utf-8",
+			wantBody:        ")]}',\n\"\\u003chello\\u003e\"\n",
+		},
+		{
+			name:            "Not acceptable",
+			accept:          "image/png",
+			wantCode:        safehttp.StatusNotAcceptable,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Not Acceptable\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := safehttp.NewServeMuxConfig(nil).Mux()
+			mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return safehttp.WriteNegotiated(w, r,
+					safehttp.Offer{MediaType: "text/html", Response: safehtml.HTMLEscaped("<hello>")},
+					safehttp.Offer{MediaType: "application/json", Response: safehttp.JSONResponse{Data: "<hello>"}},
+				)
+			}))
+
+			req := httptest.NewRequest(safehttp.MethodGet, "http://foo.com/", nil)
+			req.Header.Set("Accept", tt.accept)
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, req)
+
+			if got, want := rw.Code, int(tt.wantCode); got != want {
+				t.Errorf("rw.Code: got %v, want %v", got, want)
+			}
+			if got := rw.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type: got %q, want %q", got, tt.wantContentType)
+			}
+			if got, want := rw.Header().Get("Vary"), "Accept"; got != want {
+				t.Errorf("Vary: got %q, want %q", got, want)
+			}
+			if got := rw.Body.String(); got != tt.wantBody {
+				t.Errorf("response body: got %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
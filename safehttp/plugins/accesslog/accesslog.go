@@ -0,0 +1,298 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package accesslog provides a safehttp.Interceptor writing a structured access
+// log entry for every request, once its response has been written.
+//
+// Entries contain the method, route pattern, path, status, latency, number of
+// bytes written, client IP, user agent and request ID of the request. The
+// values of sensitive query parameters and headers are redacted.
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/requestid"
+)
+
+var now = time.Now
+
+// Redacted replaces the values of redacted query parameters and headers.
+const Redacted = "REDACTED"
+
+// DefaultRedactedParams are the query parameters redacted by default, which
+// usually carry credentials or tokens.
+var DefaultRedactedParams = []string{
+	"access_token",
+	"api_key",
+	"client_secret",
+	"code",
+	"id_token",
+	"key",
+	"password",
+	"refresh_token",
+	"secret",
+	"sig",
+	"signature",
+	"state",
+	"token",
+}
+
+// DefaultRedactedHeaders are the headers redacted by default, which usually
+// carry credentials or tokens.
+var DefaultRedactedHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+	"X-Api-Key",
+	"X-Xsrf-Token",
+}
+
+// Field is a key/value pair of an Entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Entry is an access log entry.
+type Entry struct {
+	Method string
+	// Route is the pattern the request was routed to, e.g. "/users/{id}".
+	Route string
+	Path  string
+	// Query is the raw query of the request URL, with redacted values.
+	Query        string
+	Status       safehttp.StatusCode
+	Latency      time.Duration
+	BytesWritten int64
+	// ClientIP is the address of the client, as determined by
+	// IncomingRequest.ClientIP, or empty if it couldn't be determined.
+	ClientIP  string
+	UserAgent string
+	RequestID string
+	// Headers are the logged request headers, keyed by their canonical name.
+	Headers []Field
+}
+
+// Fields returns the fields of the entry, in a stable order, for adapting it to
+// structured logging libraries. Empty query, request ID and header fields are
+// omitted. Headers are keyed as "header.<Canonical-Name>".
+func (e Entry) Fields() []Field {
+	fields := []Field{
+		{"method", e.Method},
+		{"route", e.Route},
+		{"path", e.Path},
+	}
+	if e.Query != "" {
+		fields = append(fields, Field{"query", e.Query})
+	}
+	fields = append(fields,
+		Field{"status", int(e.Status)},
+		Field{"latency", e.Latency},
+		Field{"bytes", e.BytesWritten},
+		Field{"client_ip", e.ClientIP},
+		Field{"user_agent", e.UserAgent},
+	)
+	if e.RequestID != "" {
+		fields = append(fields, Field{"request_id", e.RequestID})
+	}
+	for _, h := range e.Headers {
+		fields = append(fields, Field{"header." + h.Key, h.Value})
+	}
+	return fields
+}
+
+// Logger writes access log entries.
+//
+// Adapters for structured logging libraries take a few lines, e.g. for
+// go.uber.org/zap:
+//
+//	type zapLogger struct{ l *zap.Logger }
+//
+//	func (z zapLogger) Log(ctx context.Context, e accesslog.Entry) {
+//		var fields []zap.Field
+//		for _, f := range e.Fields() {
+//			fields = append(fields, zap.Any(f.Key, f.Value))
+//		}
+//		z.l.Info("request", fields...)
+//	}
+type Logger interface {
+	// Log writes the entry. The context is the one of the request.
+	Log(ctx context.Context, e Entry)
+}
+
+// LoggerFunc is a function implementing Logger.
+type LoggerFunc func(ctx context.Context, e Entry)
+
+// Log calls f(ctx, e).
+func (f LoggerFunc) Log(ctx context.Context, e Entry) {
+	f(ctx, e)
+}
+
+type jsonLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLogger returns a Logger writing entries to w as JSON objects, one per
+// line, with the fields returned by Entry.Fields and a "time" field. Latencies
+// are written as strings, e.g. "1.5ms". It's safe for concurrent use.
+func NewJSONLogger(w io.Writer) Logger {
+	return &jsonLogger{w: w}
+}
+
+func (l *jsonLogger) Log(_ context.Context, e Entry) {
+	var buf bytes.Buffer
+	writeJSONField(&buf, "time", now().UTC().Format(time.RFC3339Nano))
+	for _, f := range e.Fields() {
+		buf.WriteByte(',')
+		v := f.Value
+		if d, ok := v.(time.Duration); ok {
+			v = d.String()
+		}
+		writeJSONField(&buf, f.Key, v)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, "{"+buf.String()+"}\n")
+}
+
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}) {
+	k, _ := json.Marshal(key)
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(err.Error())
+	}
+	buf.Write(k)
+	buf.WriteByte(':')
+	buf.Write(v)
+}
+
+// Interceptor writes an access log entry for every request routed to a
+// handler, including requests rejected by other interceptors.
+type Interceptor struct {
+	// Logger writes the entries. It must be set.
+	Logger Logger
+	// Headers are the request headers logged, in addition to User-Agent, e.g.
+	// "Referer".
+	Headers []string
+	// RedactedHeaders are the headers whose values are logged as Redacted. If
+	// nil, DefaultRedactedHeaders is used.
+	RedactedHeaders []string
+	// RedactedParams are the query parameters whose values are logged as
+	// Redacted, matched ignoring case. If nil, DefaultRedactedParams is used.
+	RedactedParams []string
+	// RequestID returns the ID of the request, for correlating entries with
+	// other logs. If nil, requestid.FromRequest is used, which returns the ID
+	// assigned by the requestid.Interceptor, if installed.
+	RequestID func(r *safehttp.IncomingRequest) string
+}
+
+var (
+	_ safehttp.Interceptor = Interceptor{}
+	_ safehttp.Observer    = Interceptor{}
+)
+
+// Before is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+	return safehttp.NotWritten()
+}
+
+// Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
+}
+
+// Match returns false since there are no supported configurations.
+func (Interceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
+
+// Observe logs the entry of the request.
+func (it Interceptor) Observe(r *safehttp.IncomingRequest, info safehttp.ResponseInfo, _ safehttp.InterceptorConfig) {
+	e := Entry{
+		Method:       r.Method(),
+		Route:        r.Pattern(),
+		Path:         r.URL().Path(),
+		Query:        it.redactQuery(r.URL().RawQuery()),
+		Status:       info.Code,
+		Latency:      info.Duration,
+		BytesWritten: info.BytesWritten,
+		UserAgent:    r.Header.Get("User-Agent"),
+	}
+	if ip, err := r.ClientIP(); err == nil {
+		e.ClientIP = ip.String()
+	}
+	if it.RequestID != nil {
+		e.RequestID = it.RequestID(r)
+	} else {
+		e.RequestID = requestid.FromRequest(r)
+	}
+	redacted := it.RedactedHeaders
+	if redacted == nil {
+		redacted = DefaultRedactedHeaders
+	}
+	for _, name := range it.Headers {
+		v := r.Header.Values(name)
+		if len(v) == 0 {
+			continue
+		}
+		value := strings.Join(v, ", ")
+		if contains(redacted, name) {
+			value = Redacted
+		}
+		e.Headers = append(e.Headers, Field{Key: http.CanonicalHeaderKey(name), Value: value})
+	}
+	it.Logger.Log(r.Context(), e)
+}
+
+// redactQuery replaces the values of the redacted parameters of the raw query.
+func (it Interceptor) redactQuery(query string) string {
+	if query == "" {
+		return ""
+	}
+	redacted := it.RedactedParams
+	if redacted == nil {
+		redacted = DefaultRedactedParams
+	}
+	pairs := strings.Split(query, "&")
+	for i, p := range pairs {
+		kv := strings.SplitN(p, "=", 2)
+		key, err := url.QueryUnescape(kv[0])
+		if err != nil {
+			key = kv[0]
+		}
+		if len(kv) == 2 && contains(redacted, key) {
+			pairs[i] = kv[0] + "=" + Redacted
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/requestid"
+)
+
+func newMux(t *testing.T, it Interceptor) *safehttp.ServeMux {
+	t.Helper()
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.TrustProxies(safehttp.ProxyPolicy{TrustedProxies: []*net.IPNet{proxies}})
+	mb.Intercept(it)
+	m := mb.Mux()
+	m.Handle("/users/{id}", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.WriteJSON(w, r.PathParam("id"))
+	}))
+	m.Handle("/forbidden", safehttp.MethodPost, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.WriteError(safehttp.StatusForbidden)
+	}))
+	return m
+}
+
+func TestInterceptor(t *testing.T) {
+	var got []Entry
+	it := Interceptor{
+		Logger: LoggerFunc(func(ctx context.Context, e Entry) {
+			got = append(got, e)
+		}),
+		Headers:   []string{"referer", "Authorization", "X-Missing"},
+		RequestID: func(r *safehttp.IncomingRequest) string { return "req-1" },
+	}
+	m := newMux(t, it)
+
+	req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com/users/42?page=2&Access_Token=secret&code&q=a%20b", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("Referer", "https://foo.com/")
+	req.Header.Set("Authorization", "Bearer secret")
+	m.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(safehttp.MethodPost, "https://foo.com/forbidden", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	m.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := []Entry{
+		{
+			Method:       safehttp.MethodGet,
+			Route:        "/users/{id}",
+			Path:         "/users/42",
+			Query:        "page=2&Access_Token=REDACTED&code&q=a%20b",
+			Status:       safehttp.StatusOK,
+			BytesWritten: int64(len(")]}',\n\"42\"\n")),
+			ClientIP:     "203.0.113.7",
+			UserAgent:    "test-agent",
+			RequestID:    "req-1",
+			Headers: []Field{
+				{Key: "Referer", Value: "https://foo.com/"},
+				{Key: "Authorization", Value: Redacted},
+			},
+		},
+		{
+			Method:       safehttp.MethodPost,
+			Route:        "/forbidden",
+			Path:         "/forbidden",
+			Status:       safehttp.StatusForbidden,
+			BytesWritten: int64(len("Forbidden\n")),
+			ClientIP:     "192.0.2.1",
+			RequestID:    "req-1",
+		},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Entry{}, "Latency")); diff != "" {
+		t.Errorf("entries mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestInterceptorCustomRedaction(t *testing.T) {
+	var got Entry
+	it := Interceptor{
+		Logger:          LoggerFunc(func(ctx context.Context, e Entry) { got = e }),
+		Headers:         []string{"Cookie", "X-Session"},
+		RedactedHeaders: []string{"X-Session"},
+		RedactedParams:  []string{"ssn"},
+	}
+	m := newMux(t, it)
+
+	req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com/users/42?ssn=123&token=abc", nil)
+	req.Header.Set("Cookie", "a=b")
+	req.Header.Set("X-Session", "secret")
+	m.ServeHTTP(httptest.NewRecorder(), req)
+
+	if want := "ssn=REDACTED&token=abc"; got.Query != want {
+		t.Errorf("got.Query: got %q, want %q", got.Query, want)
+	}
+	wantHeaders := []Field{
+		{Key: "Cookie", Value: "a=b"},
+		{Key: "X-Session", Value: Redacted},
+	}
+	if diff := cmp.Diff(wantHeaders, got.Headers); diff != "" {
+		t.Errorf("got.Headers mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	var buf bytes.Buffer
+	l := NewJSONLogger(&buf)
+	l.Log(context.Background(), Entry{
+		Method:       safehttp.MethodGet,
+		Route:        "/",
+		Path:         "/",
+		Status:       safehttp.StatusOK,
+		Latency:      1500 * time.Microsecond,
+		BytesWritten: 5,
+		ClientIP:     "192.0.2.1",
+		UserAgent:    `agent "quoted"`,
+		Headers:      []Field{{Key: "Referer", Value: "https://foo.com/"}},
+	})
+
+	want := `{"time":"2020-01-02T03:04:05Z","method":"GET","route":"/","path":"/","status":200,"latency":"1.5ms","bytes":5,"client_ip":"192.0.2.1","user_agent":"agent \"quoted\"","header.Referer":"https://foo.com/"}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("buf.String(): got %q, want %q", got, want)
+	}
+}
+
+func TestInterceptorDefaultRequestID(t *testing.T) {
+	var got Entry
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(
+		requestid.Interceptor{Generate: func() string { return "req-2" }},
+		Interceptor{Logger: LoggerFunc(func(ctx context.Context, e Entry) { got = e })},
+	)
+	m := mb.Mux()
+	m.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehttp.NoContentResponse{})
+	}))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil))
+
+	if want := "req-2"; got.RequestID != want {
+		t.Errorf("got.RequestID: got %q, want %q", got.RequestID, want)
+	}
+}
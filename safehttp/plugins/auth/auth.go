@@ -0,0 +1,181 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package auth provides a safehttp.Interceptor that authenticates requests
+// and stores the resulting Identity in their context, so that handlers and
+// other plugins can consume it in a standard way.
+//
+// Authentication is required for all handlers by default. Handlers that must
+// be reachable anonymously, e.g. the login page, need to opt out with
+// AllowAnonymous. Alternatively, the Interceptor can be made Optional and
+// handlers opt in with Require.
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// ErrInvalidCredentials should be returned by Authenticators when the request
+// carries credentials that are not valid, e.g. an expired token.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Identity is the authenticated principal of a request.
+type Identity struct {
+	// Subject is the stable identifier of the principal, e.g. a user ID.
+	Subject string
+	// Method is how the principal was authenticated, e.g. "session" or
+	// "bearer".
+	Method string
+	// Attributes are additional properties of the principal, e.g. an email
+	// address, set by the Authenticator.
+	Attributes map[string]string
+}
+
+// Authenticator authenticates requests.
+type Authenticator interface {
+	// Authenticate returns the identity of the principal making the request,
+	// or nil if the request doesn't carry any credentials this Authenticator
+	// supports. ErrInvalidCredentials should be returned if it carries invalid
+	// ones, any other error is treated as a server error.
+	Authenticate(r *safehttp.IncomingRequest) (*Identity, error)
+}
+
+// AuthenticatorFunc is a function implementing Authenticator.
+type AuthenticatorFunc func(r *safehttp.IncomingRequest) (*Identity, error)
+
+// Authenticate calls f(r).
+func (f AuthenticatorFunc) Authenticate(r *safehttp.IncomingRequest) (*Identity, error) {
+	return f(r)
+}
+
+type identityKey struct{}
+
+// FromContext returns the identity stored in the context of a request by the
+// Interceptor, or nil if the request is anonymous.
+func FromContext(ctx context.Context) *Identity {
+	fv := safehttp.FlightValues(ctx)
+	if fv == nil {
+		return nil
+	}
+	id, _ := fv.Get(identityKey{}).(*Identity)
+	return id
+}
+
+// FromRequest returns the identity of the request, or nil if it is anonymous.
+func FromRequest(r *safehttp.IncomingRequest) *Identity {
+	return FromContext(r.Context())
+}
+
+// Interceptor authenticates requests with the Authenticators, in order, and
+// stores the first identity returned in the context of the request.
+type Interceptor struct {
+	// Authenticators are tried in order until one returns an identity.
+	Authenticators []Authenticator
+	// Optional makes authentication optional for handlers without the
+	// Require configuration. By default, it is required unless the handler
+	// has the AllowAnonymous configuration.
+	Optional bool
+	// Unauthenticated, if set, writes the response to requests that must be
+	// authenticated but are not, e.g. a redirect to a login page. By default,
+	// a 401 Unauthorized error is written.
+	Unauthenticated func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result
+	// Challenge, if set, is the value of the WWW-Authenticate header of the
+	// 401 Unauthorized errors written by the Interceptor, e.g. "Bearer".
+	Challenge string
+}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+// Require is a configuration that requires requests to the handler to be
+// authenticated, for Interceptors that are Optional.
+type Require struct{}
+
+type allowAnonymous struct{}
+
+// AllowAnonymous returns a configuration that allows unauthenticated requests
+// to the handler. Requests with invalid credentials are still rejected.
+func AllowAnonymous(reason string) safehttp.InterceptorConfig {
+	return allowAnonymous{}
+}
+
+// Before authenticates the request. Requests with invalid credentials are
+// rejected with 401 Unauthorized, and requests for which an Authenticator
+// fails with 500 Internal Server Error. Unauthenticated requests are rejected
+// if authentication is required for the handler.
+func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	var id *Identity
+	for _, a := range it.Authenticators {
+		var err error
+		id, err = a.Authenticate(r)
+		if errors.Is(err, ErrInvalidCredentials) {
+			return it.unauthorized(w, true)
+		}
+		if err != nil {
+			return w.WriteError(safehttp.StatusInternalServerError)
+		}
+		if id != nil {
+			break
+		}
+	}
+	if id != nil {
+		safehttp.FlightValues(r.Context()).Put(identityKey{}, id)
+		return safehttp.NotWritten()
+	}
+
+	required := !it.Optional
+	switch cfg.(type) {
+	case Require:
+		required = true
+	case allowAnonymous:
+		required = false
+	}
+	if !required {
+		return safehttp.NotWritten()
+	}
+	if it.Unauthenticated != nil {
+		return it.Unauthenticated(w, r)
+	}
+	return it.unauthorized(w, false)
+}
+
+// unauthorized writes a 401 Unauthorized error with the challenge, if any.
+// Bearer challenges for invalid credentials carry the error code specified
+// by RFC 6750.
+func (it Interceptor) unauthorized(w safehttp.ResponseWriter, invalid bool) safehttp.Result {
+	if it.Challenge != "" {
+		v := it.Challenge
+		if invalid && strings.HasPrefix(strings.ToLower(v), "bearer") {
+			v += ` error="invalid_token"`
+		}
+		w.Header().Set("WWW-Authenticate", v)
+	}
+	return w.WriteError(safehttp.StatusUnauthorized)
+}
+
+// Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+}
+
+// Match recognizes the Require and AllowAnonymous configurations.
+func (Interceptor) Match(cfg safehttp.InterceptorConfig) bool {
+	switch cfg.(type) {
+	case Require, allowAnonymous:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/auth"
+)
+
+// headerAuthenticator authenticates requests with the X-User header, as long
+// as it is not "invalid" or "error".
+var headerAuthenticator = auth.AuthenticatorFunc(func(r *safehttp.IncomingRequest) (*auth.Identity, error) {
+	switch u := r.Header.Get("X-User"); u {
+	case "":
+		return nil, nil
+	case "invalid":
+		return nil, auth.ErrInvalidCredentials
+	case "error":
+		return nil, errors.New("backend unavailable")
+	default:
+		return &auth.Identity{Subject: u, Method: "header"}, nil
+	}
+})
+
+func TestInterceptor(t *testing.T) {
+	tests := []struct {
+		name        string
+		optional    bool
+		cfg         safehttp.InterceptorConfig
+		user        string
+		wantStatus  safehttp.StatusCode
+		wantSubject string
+	}{
+		{name: "Authenticated", user: "alice", wantStatus: safehttp.StatusNoContent, wantSubject: "alice"},
+		{name: "Anonymous", wantStatus: safehttp.StatusUnauthorized},
+		{name: "Anonymous allowed", cfg: auth.AllowAnonymous("login page"), wantStatus: safehttp.StatusNoContent},
+		{name: "Authenticated anonymous allowed", cfg: auth.AllowAnonymous("login page"), user: "alice", wantStatus: safehttp.StatusNoContent, wantSubject: "alice"},
+		{name: "Invalid credentials", user: "invalid", wantStatus: safehttp.StatusUnauthorized},
+		{name: "Invalid credentials anonymous allowed", cfg: auth.AllowAnonymous("login page"), user: "invalid", wantStatus: safehttp.StatusUnauthorized},
+		{name: "Authenticator error", user: "error", wantStatus: safehttp.StatusInternalServerError},
+		{name: "Optional anonymous", optional: true, wantStatus: safehttp.StatusNoContent},
+		{name: "Optional required", optional: true, cfg: auth.Require{}, wantStatus: safehttp.StatusUnauthorized},
+		{name: "Optional required authenticated", optional: true, cfg: auth.Require{}, user: "alice", wantStatus: safehttp.StatusNoContent, wantSubject: "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.Intercept(auth.Interceptor{
+				Authenticators: []auth.Authenticator{headerAuthenticator},
+				Optional:       tt.optional,
+			})
+			var cfgs []safehttp.InterceptorConfig
+			if tt.cfg != nil {
+				cfgs = append(cfgs, tt.cfg)
+			}
+			var gotSubject string
+			mux := mb.Mux()
+			mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				if id := auth.FromRequest(r); id != nil {
+					gotSubject = id.Subject
+				}
+				return w.Write(safehttp.NoContentResponse{})
+			}), cfgs...)
+
+			req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+			if tt.user != "" {
+				req.Header.Set("X-User", tt.user)
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if got, want := rr.Code, int(tt.wantStatus); got != want {
+				t.Errorf("rr.Code: got %v, want %v", got, want)
+			}
+			if gotSubject != tt.wantSubject {
+				t.Errorf("auth.FromRequest(r).Subject: got %q, want %q", gotSubject, tt.wantSubject)
+			}
+		})
+	}
+}
+
+func TestInterceptorAuthenticatorsOrder(t *testing.T) {
+	var calls []string
+	authenticator := func(name string, id *auth.Identity) auth.Authenticator {
+		return auth.AuthenticatorFunc(func(*safehttp.IncomingRequest) (*auth.Identity, error) {
+			calls = append(calls, name)
+			return id, nil
+		})
+	}
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(auth.Interceptor{Authenticators: []auth.Authenticator{
+		authenticator("session", nil),
+		authenticator("bearer", &auth.Identity{Subject: "alice", Method: "bearer"}),
+		authenticator("other", &auth.Identity{Subject: "bob"}),
+	}})
+	var got *auth.Identity
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		got = auth.FromContext(r.Context())
+		return w.Write(safehttp.NoContentResponse{})
+	}))
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil))
+
+	if diff := cmp.Diff(&auth.Identity{Subject: "alice", Method: "bearer"}, got); diff != "" {
+		t.Errorf("auth.FromContext(): mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"session", "bearer"}, calls); diff != "" {
+		t.Errorf("authenticators called: mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestInterceptorUnauthenticated(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(auth.Interceptor{
+		Authenticators: []auth.Authenticator{headerAuthenticator},
+		Unauthenticated: func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+			return safehttp.Redirect(w, r, "/login", safehttp.StatusSeeOther)
+		},
+	})
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		t.Error("handler called, want the request to be rejected")
+		return w.Write(safehttp.NoContentResponse{})
+	}))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil))
+
+	if got, want := rr.Code, int(safehttp.StatusSeeOther); got != want {
+		t.Errorf("rr.Code: got %v, want %v", got, want)
+	}
+	if got, want := rr.Header().Get("Location"), "/login"; got != want {
+		t.Errorf(`rr.Header().Get("Location"): got %q, want %q`, got, want)
+	}
+}
+
+func TestInterceptorChallenge(t *testing.T) {
+	tests := []struct {
+		name, user, want string
+	}{
+		{name: "Anonymous", want: "Bearer"},
+		{name: "Invalid credentials", user: "invalid", want: `Bearer error="invalid_token"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.Intercept(auth.Interceptor{
+				Authenticators: []auth.Authenticator{headerAuthenticator},
+				Challenge:      "Bearer",
+			})
+			mux := mb.Mux()
+			mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return w.Write(safehttp.NoContentResponse{})
+			}))
+			req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+			if tt.user != "" {
+				req.Header.Set("X-User", tt.user)
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if got, want := rr.Code, int(safehttp.StatusUnauthorized); got != want {
+				t.Errorf("rr.Code: got %v, want %v", got, want)
+			}
+			if got := rr.Header().Get("WWW-Authenticate"); got != tt.want {
+				t.Errorf(`rr.Header().Get("WWW-Authenticate"): got %q, want %q`, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jwt verifies JSON Web Tokens, as specified by RFC 7519, signed by
+// an identity provider publishing its keys as a JWKS document, and provides
+// an auth.Authenticator for bearer tokens, so that API services can
+// authenticate the requests of clients of the provider.
+//
+// To prevent algorithm confusion attacks, only the algorithms allowed by the
+// Verifier are accepted, and only with keys of the matching type. Unsigned
+// tokens and symmetric algorithms are never accepted.
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/auth"
+)
+
+// Signature algorithms, as specified by RFC 7518 and RFC 8037.
+const (
+	RS256 = "RS256"
+	RS384 = "RS384"
+	RS512 = "RS512"
+	PS256 = "PS256"
+	PS384 = "PS384"
+	PS512 = "PS512"
+	ES256 = "ES256"
+	ES384 = "ES384"
+	EdDSA = "EdDSA"
+)
+
+// clockSkew is the tolerated difference between the clocks of the identity
+// provider and the verifier.
+const clockSkew = time.Minute
+
+var now = time.Now
+
+// ErrInvalidToken is returned when a token is malformed, not signed by the
+// identity provider or has invalid claims.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the registered claims of a verified token.
+type Claims struct {
+	// Issuer is the "iss" claim, the identity provider.
+	Issuer string
+	// Subject is the "sub" claim, the principal the token was issued to.
+	Subject string
+	// Audience is the "aud" claim, the services the token is intended for.
+	Audience []string
+	// Expiry, NotBefore and IssuedAt are the "exp", "nbf" and "iat" claims.
+	// NotBefore and IssuedAt are zero if the token doesn't have them.
+	Expiry    time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	// ID is the "jti" claim, the unique identifier of the token.
+	ID string
+
+	payload []byte
+}
+
+// Decode decodes the JSON payload of the token into v, e.g. to access custom
+// claims.
+func (c *Claims) Decode(v interface{}) error {
+	return json.Unmarshal(c.payload, v)
+}
+
+// registeredClaims is the JSON representation of the registered claims.
+type registeredClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	Expiry    *float64 `json:"exp"`
+	NotBefore *float64 `json:"nbf"`
+	IssuedAt  *float64 `json:"iat"`
+	ID        string   `json:"jti"`
+}
+
+// audience is the "aud" claim, which is either a string or an array of
+// strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = l
+	return nil
+}
+
+// Verifier verifies tokens.
+type Verifier struct {
+	// Keys are the keys of the identity provider. It must be set.
+	Keys *KeySet
+	// Issuer must match the "iss" claim of tokens. It must be set.
+	Issuer string
+	// Audience, the identifier of the service, must be one of the values of
+	// the "aud" claim of tokens. It must be set.
+	Audience string
+	// Algorithms are the allowed signature algorithms. It must be set.
+	Algorithms []string
+}
+
+// Verify checks the signature of the token and that it was issued by the
+// Issuer, for the Audience, and is valid at the current time. Tokens without
+// an expiry are rejected. ErrInvalidToken is returned if the token is not
+// valid, any other error means the keys could not be fetched or the Verifier
+// is misconfigured, e.g. without an Issuer.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	if err := v.check(); err != nil {
+		return nil, err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+	var header struct {
+		Alg  string   `json:"alg"`
+		Kid  string   `json:"kid"`
+		Crit []string `json:"crit"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header: %v", ErrInvalidToken, err)
+	}
+	if !v.allowed(header.Alg) {
+		return nil, fmt.Errorf("%w: algorithm %q not allowed", ErrInvalidToken, header.Alg)
+	}
+	if len(header.Crit) > 0 {
+		return nil, fmt.Errorf("%w: unsupported critical extensions %q", ErrInvalidToken, header.Crit)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature: %v", ErrInvalidToken, err)
+	}
+	k, err := v.Keys.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if k.alg != "" && k.alg != header.Alg {
+		return nil, fmt.Errorf("%w: key %q is restricted to %q", ErrInvalidToken, header.Kid, k.alg)
+	}
+	if err := verifySignature(header.Alg, k.pub, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed payload: %v", ErrInvalidToken, err)
+	}
+	var rc registeredClaims
+	if err := json.Unmarshal(payload, &rc); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims: %v", ErrInvalidToken, err)
+	}
+	c := &Claims{
+		Issuer:   rc.Issuer,
+		Subject:  rc.Subject,
+		Audience: rc.Audience,
+		ID:       rc.ID,
+		payload:  payload,
+	}
+	if rc.Expiry != nil {
+		c.Expiry = unixTime(*rc.Expiry)
+	}
+	if rc.NotBefore != nil {
+		c.NotBefore = unixTime(*rc.NotBefore)
+	}
+	if rc.IssuedAt != nil {
+		c.IssuedAt = unixTime(*rc.IssuedAt)
+	}
+	if err := v.validate(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// check returns an error if a field of v that must be set is empty, which
+// would disable the validation of tokens.
+func (v *Verifier) check() error {
+	switch {
+	case v.Keys == nil:
+		return errors.New("jwt: Verifier.Keys must be set")
+	case v.Issuer == "":
+		return errors.New("jwt: Verifier.Issuer must be set")
+	case v.Audience == "":
+		return errors.New("jwt: Verifier.Audience must be set")
+	case len(v.Algorithms) == 0:
+		return errors.New("jwt: Verifier.Algorithms must be set")
+	}
+	return nil
+}
+
+func (v *Verifier) allowed(alg string) bool {
+	for _, a := range v.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Verifier) validate(c *Claims) error {
+	t := now()
+	switch {
+	case c.Issuer != v.Issuer:
+		return fmt.Errorf("%w: issued by %q, want %q", ErrInvalidToken, c.Issuer, v.Issuer)
+	case !contains(c.Audience, v.Audience):
+		return fmt.Errorf("%w: issued for %q, want %q", ErrInvalidToken, c.Audience, v.Audience)
+	case c.Expiry.IsZero():
+		return fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	case !t.Before(c.Expiry.Add(clockSkew)):
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	case !c.NotBefore.IsZero() && t.Add(clockSkew).Before(c.NotBefore):
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	case !c.IssuedAt.IsZero() && t.Add(clockSkew).Before(c.IssuedAt):
+		return fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	}
+	return nil
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func unixTime(secs float64) time.Time {
+	return time.Unix(int64(secs), 0)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verifySignature checks the signature of the signing input with the key,
+// which must be of the type required by the algorithm.
+func verifySignature(alg string, pub crypto.PublicKey, input, sig []byte) error {
+	mismatch := fmt.Errorf("%w: key type doesn't match algorithm %q", ErrInvalidToken, alg)
+	invalid := fmt.Errorf("%w: invalid signature", ErrInvalidToken)
+	switch alg {
+	case RS256, RS384, RS512, PS256, PS384, PS512:
+		k, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return mismatch
+		}
+		h, digest := hash(alg[2:], input)
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(k, h, digest, sig)
+		} else {
+			err = rsa.VerifyPSS(k, h, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return invalid
+		}
+	case ES256, ES384:
+		curve := map[string]elliptic.Curve{ES256: elliptic.P256(), ES384: elliptic.P384()}[alg]
+		k, ok := pub.(*ecdsa.PublicKey)
+		if !ok || k.Curve != curve {
+			return mismatch
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return invalid
+		}
+		_, digest := hash(alg[2:], input)
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return invalid
+		}
+	case EdDSA:
+		k, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return mismatch
+		}
+		if !ed25519.Verify(k, input, sig) {
+			return invalid
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, alg)
+	}
+	return nil
+}
+
+// hash returns the hash function with the given size and the digest of input.
+func hash(size string, input []byte) (crypto.Hash, []byte) {
+	switch size {
+	case "384":
+		d := sha512.Sum384(input)
+		return crypto.SHA384, d[:]
+	case "512":
+		d := sha512.Sum512(input)
+		return crypto.SHA512, d[:]
+	default:
+		d := sha256.Sum256(input)
+		return crypto.SHA256, d[:]
+	}
+}
+
+type claimsKey struct{}
+
+// ClaimsFromRequest returns the claims of the bearer token of the request, as
+// stored by the Authenticator, or nil if there is none.
+func ClaimsFromRequest(r *safehttp.IncomingRequest) *Claims {
+	fv := safehttp.FlightValues(r.Context())
+	if fv == nil {
+		return nil
+	}
+	c, _ := fv.Get(claimsKey{}).(*Claims)
+	return c
+}
+
+// Authenticator is an auth.Authenticator authenticating requests with a
+// bearer token in the Authorization header, as specified by RFC 6750. The
+// identity has the "bearer" method and the subject of the token, and the
+// claims are available with ClaimsFromRequest.
+type Authenticator struct {
+	Verifier *Verifier
+}
+
+var _ auth.Authenticator = Authenticator{}
+
+// Authenticate verifies the bearer token of the request, if any. Invalid
+// tokens are rejected with auth.ErrInvalidCredentials.
+func (a Authenticator) Authenticate(r *safehttp.IncomingRequest) (*auth.Identity, error) {
+	h := r.Header.Get("Authorization")
+	if h == "" {
+		return nil, nil
+	}
+	const scheme = "bearer "
+	if len(h) < len(scheme) || !strings.EqualFold(h[:len(scheme)], scheme) {
+		return nil, nil
+	}
+	c, err := a.Verifier.Verify(r.Context(), strings.TrimSpace(h[len(scheme):]))
+	if errors.Is(err, ErrInvalidToken) {
+		return nil, auth.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	safehttp.FlightValues(r.Context()).Put(claimsKey{}, c)
+	return &auth.Identity{Subject: c.Subject, Method: "bearer"}, nil
+}
+
+// NewInterceptor creates an auth.Interceptor requiring requests to be
+// authenticated with a bearer token verified by v. Handlers can opt out with
+// auth.AllowAnonymous. It panics if v is misconfigured, e.g. without an Issuer.
+func NewInterceptor(v *Verifier) auth.Interceptor {
+	if err := v.check(); err != nil {
+		panic(err)
+	}
+	return auth.Interceptor{
+		Authenticators: []auth.Authenticator{Authenticator{Verifier: v}},
+		Challenge:      "Bearer",
+	}
+}
@@ -0,0 +1,469 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+const (
+	testIssuer   = "https://accounts.example.com"
+	testAudience = "api"
+)
+
+var (
+	keysOnce sync.Once
+	rsaKey   *rsa.PrivateKey
+	p256Key  *ecdsa.PrivateKey
+	p384Key  *ecdsa.PrivateKey
+	edKey    ed25519.PrivateKey
+)
+
+func generateKeys(t *testing.T) {
+	t.Helper()
+	keysOnce.Do(func() {
+		var err error
+		if rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatalf("rsa.GenerateKey() got err: %v", err)
+		}
+		if p256Key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatalf("ecdsa.GenerateKey() got err: %v", err)
+		}
+		if p384Key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader); err != nil {
+			t.Fatalf("ecdsa.GenerateKey() got err: %v", err)
+		}
+		if _, edKey, err = ed25519.GenerateKey(rand.Reader); err != nil {
+			t.Fatalf("ed25519.GenerateKey() got err: %v", err)
+		}
+	})
+}
+
+// jwksServer serves the test keys and counts the requests.
+type jwksServer struct {
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	fetches int
+	fail    bool
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	generateKeys(t)
+	js := &jwksServer{}
+	b64 := base64.RawURLEncoding.EncodeToString
+	ecPoint := func(k *ecdsa.PrivateKey) (string, string) {
+		size := (k.Curve.Params().BitSize + 7) / 8
+		x, y := make([]byte, size), make([]byte, size)
+		k.X.FillBytes(x)
+		k.Y.FillBytes(y)
+		return b64(x), b64(y)
+	}
+	x256, y256 := ecPoint(p256Key)
+	x384, y384 := ecPoint(p384Key)
+	keys := []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "RSA", "kid": "rsa-rs256", "alg": "RS256", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "RSA", "kid": "rsa-enc", "use": "enc", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "p256", "crv": "P-256", "x": x256, "y": y256},
+		{"kty": "EC", "kid": "p384", "crv": "P-384", "x": x384, "y": y384},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(edKey.Public().(ed25519.PublicKey))},
+	}
+	js.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		js.mu.Lock()
+		defer js.mu.Unlock()
+		js.fetches++
+		if js.fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	t.Cleanup(js.srv.Close)
+	return js
+}
+
+func (js *jwksServer) verifier(algs ...string) *Verifier {
+	return &Verifier{
+		Keys:       NewKeySet(js.srv.URL, js.srv.Client()),
+		Issuer:     testIssuer,
+		Audience:   testAudience,
+		Algorithms: algs,
+	}
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   testIssuer,
+		"sub":   "alice",
+		"aud":   testAudience,
+		"exp":   now().Add(time.Hour).Unix(),
+		"iat":   now().Unix(),
+		"scope": "read",
+	}
+}
+
+// sign returns a token with the given header and claims, signed with the
+// private key matching the algorithm.
+func sign(t *testing.T, header map[string]interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatalf("json.Marshal() got err: %v", err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("json.Marshal() got err: %v", err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	alg, _ := header["alg"].(string)
+	var sig []byte
+	switch alg {
+	case RS256, RS512, PS256:
+		hf, digest := hash(alg[2:], []byte(input))
+		if alg[0] == 'R' {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, rsaKey, hf, digest)
+		} else {
+			sig, err = rsa.SignPSS(rand.Reader, rsaKey, hf, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case ES256, ES384:
+		k := map[string]*ecdsa.PrivateKey{ES256: p256Key, ES384: p384Key}[alg]
+		_, digest := hash(alg[2:], []byte(input))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest)
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		if err == nil {
+			r.FillBytes(sig[:size])
+			s.FillBytes(sig[size:])
+		}
+	case EdDSA:
+		sig = ed25519.Sign(edKey, []byte(input))
+	case "HS256":
+		// Algorithm confusion: the RSA public key used as an HMAC secret.
+		m := hmac.New(sha256.New, rsaKey.N.Bytes())
+		m.Write([]byte(input))
+		sig = m.Sum(nil)
+	}
+	if err != nil {
+		t.Fatalf("signing %s token got err: %v", alg, err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerify(t *testing.T) {
+	js := newJWKSServer(t)
+	tests := []struct {
+		alg, kid string
+	}{
+		{alg: RS256, kid: "rsa"},
+		{alg: RS256, kid: "rsa-rs256"},
+		{alg: RS512, kid: "rsa"},
+		{alg: PS256, kid: "rsa"},
+		{alg: ES256, kid: "p256"},
+		{alg: ES384, kid: "p384"},
+		{alg: EdDSA, kid: "ed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alg+" "+tt.kid, func(t *testing.T) {
+			v := js.verifier(tt.alg)
+			tok := sign(t, map[string]interface{}{"alg": tt.alg, "kid": tt.kid}, validClaims())
+			c, err := v.Verify(context.Background(), tok)
+			if err != nil {
+				t.Fatalf("v.Verify() got err: %v", err)
+			}
+			if c.Subject != "alice" || c.Issuer != testIssuer || len(c.Audience) != 1 || c.Audience[0] != testAudience {
+				t.Errorf("v.Verify(): got %+v", c)
+			}
+			var custom struct {
+				Scope string `json:"scope"`
+			}
+			if err := c.Decode(&custom); err != nil || custom.Scope != "read" {
+				t.Errorf("c.Decode(): got %+v, %v, want scope %q", custom, err, "read")
+			}
+		})
+	}
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	js := newJWKSServer(t)
+	header := func(alg, kid string) map[string]interface{} {
+		return map[string]interface{}{"alg": alg, "kid": kid}
+	}
+	modified := func(f func(c map[string]interface{})) map[string]interface{} {
+		c := validClaims()
+		f(c)
+		return c
+	}
+	valid := sign(t, header(RS256, "rsa"), validClaims())
+	tampered := []byte(valid)
+	tampered[len(valid)-5] ^= 1
+
+	tests := []struct {
+		name  string
+		algs  []string
+		token string
+	}{
+		{name: "Algorithm not allowed", algs: []string{ES256}, token: valid},
+		{name: "Algorithm none", algs: []string{RS256, "none"}, token: sign(t, header("none", "rsa"), validClaims())},
+		{name: "HMAC with public key", algs: []string{RS256, "HS256"}, token: sign(t, header("HS256", "rsa"), validClaims())},
+		{name: "Key type mismatch", algs: []string{RS256, ES256}, token: sign(t, header(ES256, "rsa"), validClaims())},
+		{name: "Key restricted to other algorithm", algs: []string{PS256}, token: sign(t, header(PS256, "rsa-rs256"), validClaims())},
+		{name: "Encryption key", algs: []string{RS256}, token: sign(t, header(RS256, "rsa-enc"), validClaims())},
+		{name: "Unknown key", algs: []string{RS256}, token: sign(t, header(RS256, "other"), validClaims())},
+		{name: "Curve mismatch", algs: []string{ES384}, token: sign(t, header(ES384, "p256"), validClaims())},
+		{name: "Critical extension", algs: []string{RS256}, token: sign(t, map[string]interface{}{"alg": RS256, "kid": "rsa", "crit": []string{"exp"}}, validClaims())},
+		{name: "Tampered", algs: []string{RS256}, token: string(tampered)},
+		{name: "Malformed", algs: []string{RS256}, token: "abc.def"},
+		{name: "Other issuer", algs: []string{RS256}, token: sign(t, header(RS256, "rsa"), modified(func(c map[string]interface{}) { c["iss"] = "https://evil.com" }))},
+		{name: "Other audience", algs: []string{RS256}, token: sign(t, header(RS256, "rsa"), modified(func(c map[string]interface{}) { c["aud"] = []string{"other", "another"} }))},
+		{name: "No expiry", algs: []string{RS256}, token: sign(t, header(RS256, "rsa"), modified(func(c map[string]interface{}) { delete(c, "exp") }))},
+		{name: "Expired", algs: []string{RS256}, token: sign(t, header(RS256, "rsa"), modified(func(c map[string]interface{}) { c["exp"] = now().Add(-time.Hour).Unix() }))},
+		{name: "Not valid yet", algs: []string{RS256}, token: sign(t, header(RS256, "rsa"), modified(func(c map[string]interface{}) { c["nbf"] = now().Add(time.Hour).Unix() }))},
+		{name: "Issued in the future", algs: []string{RS256}, token: sign(t, header(RS256, "rsa"), modified(func(c map[string]interface{}) { c["iat"] = now().Add(time.Hour).Unix() }))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := js.verifier(tt.algs...)
+			if _, err := v.Verify(context.Background(), tt.token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("v.Verify(): got err %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestVerifierMisconfigured(t *testing.T) {
+	js := newJWKSServer(t)
+	tok := sign(t, map[string]interface{}{"alg": RS256, "kid": "rsa"}, validClaims())
+	tests := []struct {
+		name   string
+		modify func(v *Verifier)
+	}{
+		{name: "No keys", modify: func(v *Verifier) { v.Keys = nil }},
+		{name: "No issuer", modify: func(v *Verifier) { v.Issuer = "" }},
+		{name: "No audience", modify: func(v *Verifier) { v.Audience = "" }},
+		{name: "No algorithms", modify: func(v *Verifier) { v.Algorithms = nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := js.verifier(RS256)
+			tt.modify(v)
+			if _, err := v.Verify(context.Background(), tok); err == nil || errors.Is(err, ErrInvalidToken) {
+				t.Errorf("v.Verify() got err %v, want a configuration error", err)
+			}
+			defer func() {
+				if recover() == nil {
+					t.Error("NewInterceptor(v): expected panic")
+				}
+			}()
+			NewInterceptor(v)
+		})
+	}
+}
+
+func TestKeySetCaching(t *testing.T) {
+	defer func() { now = time.Now }()
+	start := time.Now()
+	now = func() time.Time { return start }
+	js := newJWKSServer(t)
+	v := js.verifier(RS256)
+
+	steps := []struct {
+		name        string
+		elapsed     time.Duration
+		kid         string
+		fail        bool
+		wantErr     bool
+		wantFetches int
+	}{
+		{name: "First", kid: "rsa", wantFetches: 1},
+		{name: "Cached", kid: "rsa", wantFetches: 1},
+		{name: "Unknown key rate limited", kid: "other", wantErr: true, wantFetches: 1},
+		{name: "Unknown key refreshed", elapsed: 2 * time.Minute, kid: "other", wantErr: true, wantFetches: 2},
+		{name: "Expired keys refreshed", elapsed: 2 * time.Hour, kid: "rsa", wantFetches: 3},
+		{name: "Stale keys used when refresh fails", elapsed: 4 * time.Hour, kid: "rsa", fail: true, wantFetches: 4},
+		{name: "Failed refresh throttled", elapsed: 4*time.Hour + 30*time.Second, kid: "rsa", fail: true, wantFetches: 4},
+		{name: "Unknown key while throttled", elapsed: 4*time.Hour + 30*time.Second, kid: "other", fail: true, wantErr: true, wantFetches: 4},
+		{name: "Failed refresh retried", elapsed: 4*time.Hour + time.Minute, kid: "rsa", fail: true, wantFetches: 5},
+		{name: "Failed refresh backoff", elapsed: 4*time.Hour + 2*time.Minute, kid: "rsa", wantFetches: 5},
+		{name: "Failed refresh backoff expired", elapsed: 4*time.Hour + 3*time.Minute, kid: "rsa", wantFetches: 6},
+	}
+	for _, s := range steps {
+		now = func() time.Time { return start.Add(s.elapsed) }
+		js.mu.Lock()
+		js.fail = s.fail
+		js.mu.Unlock()
+		tok := sign(t, map[string]interface{}{"alg": RS256, "kid": s.kid}, validClaims())
+		_, err := v.Verify(context.Background(), tok)
+		if gotErr := err != nil; gotErr != s.wantErr {
+			t.Errorf("%s: v.Verify() got err %v, want err: %v", s.name, err, s.wantErr)
+		}
+		waitRefresh(v.Keys)
+		js.mu.Lock()
+		if js.fetches != s.wantFetches {
+			t.Errorf("%s: fetches got %d, want %d", s.name, js.fetches, s.wantFetches)
+		}
+		js.mu.Unlock()
+	}
+}
+
+func TestKeySetUnavailable(t *testing.T) {
+	defer func() { now = time.Now }()
+	start := time.Now()
+	now = func() time.Time { return start }
+	js := newJWKSServer(t)
+	js.fail = true
+	v := js.verifier(RS256)
+	tok := sign(t, map[string]interface{}{"alg": RS256, "kid": "rsa"}, validClaims())
+
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(context.Background(), tok); err == nil || errors.Is(err, ErrInvalidToken) {
+			t.Errorf("v.Verify() got err %v, want a fetch error", err)
+		}
+	}
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.fetches != 1 {
+		t.Errorf("fetches got %d, want 1", js.fetches)
+	}
+}
+
+// waitRefresh waits for the ongoing refresh of the keys, if any.
+func waitRefresh(ks *KeySet) {
+	ks.mu.Lock()
+	done := ks.refreshing
+	ks.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func TestKeySetSlowRefresh(t *testing.T) {
+	defer func() { now = time.Now }()
+	start := time.Now()
+	now = func() time.Time { return start }
+	js := newJWKSServer(t)
+	v := js.verifier(RS256)
+	tok := sign(t, map[string]interface{}{"alg": RS256, "kid": "rsa"}, validClaims())
+	if _, err := v.Verify(context.Background(), tok); err != nil {
+		t.Fatalf("v.Verify() got err: %v", err)
+	}
+
+	// Block the JWKS server, so that the refresh of the expired keys hangs.
+	js.mu.Lock()
+	now = func() time.Time { return start.Add(2 * time.Hour) }
+	tok = sign(t, map[string]interface{}{"alg": RS256, "kid": "rsa"}, validClaims())
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := v.Verify(context.Background(), tok)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("v.Verify() during refresh got err: %v", err)
+		}
+	}
+
+	// Unknown keys wait for the refresh, until the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	other := sign(t, map[string]interface{}{"alg": RS256, "kid": "other"}, validClaims())
+	if _, err := v.Verify(ctx, other); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("v.Verify() with unknown key got err %v, want context.DeadlineExceeded", err)
+	}
+	js.mu.Unlock()
+
+	waitRefresh(v.Keys)
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.fetches != 2 {
+		t.Errorf("fetches got %d, want 2", js.fetches)
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	js := newJWKSServer(t)
+	valid := sign(t, map[string]interface{}{"alg": RS256, "kid": "rsa"}, validClaims())
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantChallenge string
+		wantBody      string
+	}{
+		{name: "Valid", authorization: "Bearer " + valid, wantStatus: http.StatusOK, wantBody: "alice bearer read"},
+		{name: "Lowercase scheme", authorization: "bearer " + valid, wantStatus: http.StatusOK, wantBody: "alice bearer read"},
+		{name: "Missing", wantStatus: http.StatusUnauthorized, wantChallenge: "Bearer"},
+		{name: "Other scheme", authorization: "Basic YWxpY2U6cGFzcw==", wantStatus: http.StatusUnauthorized, wantChallenge: "Bearer"},
+		{name: "Invalid", authorization: "Bearer abc", wantStatus: http.StatusUnauthorized, wantChallenge: `Bearer error="invalid_token"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.Intercept(NewInterceptor(js.verifier(RS256)))
+			mux := mb.Mux()
+			mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				c := ClaimsFromRequest(r)
+				var custom struct {
+					Scope string `json:"scope"`
+				}
+				if err := c.Decode(&custom); err != nil {
+					t.Errorf("c.Decode() got err: %v", err)
+				}
+				return safehttp.WriteString(w, c.Subject+" bearer "+custom.Scope)
+			}))
+			req := httptest.NewRequest(safehttp.MethodGet, "https://api.example.com/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("rr.Code: got %v, want %v", rr.Code, tt.wantStatus)
+			}
+			if got := rr.Header().Get("WWW-Authenticate"); got != tt.wantChallenge {
+				t.Errorf(`rr.Header().Get("WWW-Authenticate"): got %q, want %q`, got, tt.wantChallenge)
+			}
+			if tt.wantBody != "" && rr.Body.String() != tt.wantBody {
+				t.Errorf("rr.Body: got %q, want %q", rr.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
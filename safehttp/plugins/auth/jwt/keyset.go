@@ -0,0 +1,286 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultKeySetMaxAge is how long the keys of a KeySet are used before
+	// being refreshed, unless overridden with KeySet.MaxAge.
+	DefaultKeySetMaxAge = time.Hour
+	// minKeyRefresh is the minimum interval between fetches of the keys, so
+	// that tokens with unknown key IDs can't be used to flood the identity
+	// provider with requests.
+	minKeyRefresh = time.Minute
+	// maxKeyRefreshBackoff is the maximum interval between fetches of the
+	// keys while they fail. The interval starts at minKeyRefresh and doubles
+	// with every failure, so that an identity provider that is down isn't
+	// flooded either.
+	maxKeyRefreshBackoff = 16 * minKeyRefresh
+	// maxKeySetSize is the maximum size of the JWKS document.
+	maxKeySetSize = 1 << 20
+	// keyFetchTimeout bounds the time spent fetching the keys.
+	keyFetchTimeout = 10 * time.Second
+)
+
+// KeySet holds the keys tokens are signed with, fetched from the JWKS URL of
+// an identity provider, as specified by RFC 7517. The keys are cached and
+// refreshed when they are older than MaxAge or when a token is signed with an
+// unknown key, as providers rotate them. Expired keys are used until the
+// refreshed ones have been fetched, so a slow identity provider doesn't delay
+// verifications. It is safe for concurrent use.
+type KeySet struct {
+	// MaxAge is how long keys are used before being refreshed. If zero,
+	// DefaultKeySetMaxAge is used.
+	MaxAge time.Duration
+
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]key
+	fetched time.Time
+	// refreshing is closed once the ongoing fetch of the keys completes, or
+	// nil if the keys are not being fetched.
+	refreshing chan struct{}
+	// fetchErr is the error of the last fetch of the keys, if it failed.
+	fetchErr error
+	// failures is the number of consecutive failed fetches.
+	failures int
+	// nextFetch is the earliest time the keys can be fetched again.
+	nextFetch time.Time
+}
+
+// key is a public key together with the algorithm it is restricted to, if
+// any.
+type key struct {
+	pub crypto.PublicKey
+	alg string
+}
+
+// NewKeySet creates a KeySet fetching the keys from the given URL. If client
+// is nil, a client with a 10 seconds timeout is used. Fetches are bounded by
+// this timeout regardless of the client.
+func NewKeySet(url string, client *http.Client) *KeySet {
+	if client == nil {
+		client = &http.Client{Timeout: keyFetchTimeout}
+	}
+	return &KeySet{url: url, client: client}
+}
+
+func (ks *KeySet) maxAge() time.Duration {
+	if ks.MaxAge == 0 {
+		return DefaultKeySetMaxAge
+	}
+	return ks.MaxAge
+}
+
+// key returns the key with the given ID. Expired keys are refreshed in the
+// background and used meanwhile, as well as if refreshing them fails. Unknown
+// keys wait for the refresh, until ctx is done. The keys are fetched at most
+// once every minKeyRefresh, or less often while fetching them fails.
+func (ks *KeySet) key(ctx context.Context, kid string) (key, error) {
+	ks.mu.Lock()
+	k, ok := ks.keys[kid]
+	if ok && now().Sub(ks.fetched) < ks.maxAge() {
+		ks.mu.Unlock()
+		return k, nil
+	}
+	var done <-chan struct{} = ks.refreshing
+	if done == nil && !now().Before(ks.nextFetch) {
+		done = ks.refresh()
+	}
+	fetched, fetchErr := ks.keys != nil, ks.fetchErr
+	ks.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+	if done == nil {
+		// Fetching the keys is throttled.
+		if !fetched && fetchErr != nil {
+			return key{}, fetchErr
+		}
+		return key{}, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return key{}, ctx.Err()
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if k, ok := ks.keys[kid]; ok {
+		return k, nil
+	}
+	if ks.fetchErr != nil {
+		return key{}, ks.fetchErr
+	}
+	return key{}, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+}
+
+// refresh starts fetching the keys and returns a channel closed once they have
+// been fetched. Concurrent callers share the same fetch, which isn't canceled
+// with their requests. ks.mu must be held and the keys must not be already
+// being fetched.
+func (ks *KeySet) refresh() <-chan struct{} {
+	done := make(chan struct{})
+	ks.refreshing = done
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), keyFetchTimeout)
+		defer cancel()
+		keys, err := ks.fetch(ctx)
+		ks.mu.Lock()
+		t := now()
+		if err == nil {
+			ks.keys, ks.fetched = keys, t
+			ks.failures = 0
+			ks.nextFetch = t.Add(minKeyRefresh)
+		} else {
+			backoff := maxKeyRefreshBackoff
+			if ks.failures < 4 {
+				backoff = minKeyRefresh << ks.failures
+			}
+			ks.failures++
+			ks.nextFetch = t.Add(backoff)
+		}
+		ks.fetchErr = err
+		ks.refreshing = nil
+		ks.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+func (ks *KeySet) fetch(ctx context.Context) (map[string]key, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching keys: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching keys: status %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxKeySetSize))
+	if err != nil {
+		return nil, fmt.Errorf("fetching keys: %v", err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("parsing keys: %v", err)
+	}
+	keys := map[string]key{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			// Keys of unsupported types are ignored.
+			continue
+		}
+		keys[k.Kid] = key{pub: pub, alg: k.Alg}
+	}
+	return keys, nil
+}
+
+// jwk is a JSON Web Key, as specified by RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA keys.
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP keys.
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		c, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: c, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !c.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("invalid EC point")
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/google/go-safeweb/safehttp/plugins/auth/jwt"
+)
+
+// maxResponseSize is the maximum size of the responses of the provider.
+const maxResponseSize = 1 << 20
+
+// idTokenAlgorithms are the algorithms ID tokens can be signed with.
+var idTokenAlgorithms = []string{jwt.RS256, jwt.ES256}
+
+// Claims are the claims of a validated ID token.
+type Claims struct {
+	// Subject is the identifier of the user at the provider.
+	Subject string `json:"sub"`
+	// Email is the email address of the user, if requested with the "email"
+	// scope.
+	Email string `json:"email"`
+	// EmailVerified reports whether the provider verified the email address.
+	EmailVerified bool `json:"email_verified"`
+	// Name is the full name of the user, if requested with the "profile"
+	// scope.
+	Name string `json:"name"`
+
+	AuthorizedParty string `json:"azp"`
+	Nonce           string `json:"nonce"`
+}
+
+// verifier validates ID tokens issued by a provider for a client.
+type verifier struct {
+	jwt      *jwt.Verifier
+	clientID string
+}
+
+func newVerifier(p Provider, clientID string, client *http.Client) *verifier {
+	return &verifier{
+		jwt: &jwt.Verifier{
+			Keys:       jwt.NewKeySet(p.JWKSURL, client),
+			Issuer:     p.Issuer,
+			Audience:   clientID,
+			Algorithms: idTokenAlgorithms,
+		},
+		clientID: clientID,
+	}
+}
+
+// verify checks the signature and the claims of the ID token, which must have
+// been issued for the given nonce.
+func (v *verifier) verify(ctx context.Context, token, nonce string) (*Claims, error) {
+	jc, err := v.jwt.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	var c Claims
+	if err := jc.Decode(&c); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %v", err)
+	}
+	switch {
+	case (len(jc.Audience) > 1 || c.AuthorizedParty != "") && c.AuthorizedParty != v.clientID:
+		return nil, fmt.Errorf("ID token authorized party is %q, want %q", c.AuthorizedParty, v.clientID)
+	case c.Subject == "":
+		return nil, errors.New("ID token has no subject")
+	case subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1:
+		return nil, errors.New("ID token nonce mismatch")
+	}
+	return &c, nil
+}
+
+// getJSON fetches the JSON document at the URL and decodes it into v.
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return doJSON(client, req, v)
+}
+
+func doJSON(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", req.URL, resp.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
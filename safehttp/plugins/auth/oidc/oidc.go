@@ -0,0 +1,321 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package oidc provides an OpenID Connect relying party, so that users can
+// log in with an identity provider using the authorization code flow with
+// PKCE.
+//
+// The relying party keeps its state in the session of the user, so the
+// sessions.Interceptor must be installed. Once logged in, users are
+// authenticated by the Authenticator of the RelyingParty, to be passed to the
+// auth.Interceptor. The Login and Callback handlers must be registered with
+// auth.AllowAnonymous, and the Logout handler should only be registered for
+// POST requests, so that it is protected against XSRF.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/auth"
+	"github.com/google/go-safeweb/safehttp/plugins/sessions"
+)
+
+// Session keys of the state of the relying party.
+const (
+	stateKey    = "oidc.state"
+	nonceKey    = "oidc.nonce"
+	verifierKey = "oidc.verifier"
+	nextKey     = "oidc.next"
+	subjectKey  = "oidc.sub"
+	emailKey    = "oidc.email"
+	nameKey     = "oidc.name"
+)
+
+// entropy is the number of random bytes of the state, nonce and PKCE code
+// verifier.
+const entropy = 32
+
+// Provider holds the endpoints of an OpenID provider.
+type Provider struct {
+	// Issuer is the identifier of the provider, which must match the "iss"
+	// claim of ID tokens.
+	Issuer string `json:"issuer"`
+	// AuthURL is the URL of the authorization endpoint.
+	AuthURL string `json:"authorization_endpoint"`
+	// TokenURL is the URL of the token endpoint.
+	TokenURL string `json:"token_endpoint"`
+	// JWKSURL is the URL of the keys used to sign ID tokens.
+	JWKSURL string `json:"jwks_uri"`
+}
+
+// Discover fetches the configuration of the provider with the given issuer,
+// as specified by OpenID Connect Discovery 1.0. If client is nil,
+// http.DefaultClient is used.
+func Discover(ctx context.Context, client *http.Client, issuer string) (Provider, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var p Provider
+	u := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, client, u, &p); err != nil {
+		return Provider{}, fmt.Errorf("discovering provider: %v", err)
+	}
+	if p.Issuer != issuer {
+		return Provider{}, fmt.Errorf("discovered issuer %q, want %q", p.Issuer, issuer)
+	}
+	return p, nil
+}
+
+// Config is the configuration of a RelyingParty.
+type Config struct {
+	// Provider is the OpenID provider users log in with.
+	Provider Provider
+	// ClientID and ClientSecret are the credentials of the client registered
+	// with the provider.
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute URL of the Callback handler, registered with
+	// the provider.
+	RedirectURL string
+	// Scopes are requested in addition to "openid", e.g. "email".
+	Scopes []string
+	// HTTPClient is used to make requests to the provider. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+// RelyingParty logs users in with an OpenID provider.
+type RelyingParty struct {
+	cfg      Config
+	verifier *verifier
+}
+
+// NewRelyingParty creates a RelyingParty. The endpoints of the provider must
+// use HTTPS.
+func NewRelyingParty(cfg Config) (*RelyingParty, error) {
+	if cfg.ClientID == "" {
+		return nil, errors.New("missing client ID")
+	}
+	if cfg.Provider.Issuer == "" {
+		return nil, errors.New("missing provider issuer")
+	}
+	for _, u := range []string{cfg.Provider.AuthURL, cfg.Provider.TokenURL, cfg.Provider.JWKSURL} {
+		if pu, err := url.Parse(u); err != nil || pu.Scheme != "https" || pu.Host == "" {
+			return nil, fmt.Errorf("invalid provider endpoint %q, want an https URL", u)
+		}
+	}
+	if ru, err := url.Parse(cfg.RedirectURL); err != nil || !ru.IsAbs() {
+		return nil, fmt.Errorf("invalid redirect URL %q, want an absolute URL", cfg.RedirectURL)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	return &RelyingParty{cfg: cfg, verifier: newVerifier(cfg.Provider, cfg.ClientID, cfg.HTTPClient)}, nil
+}
+
+// Login returns a handler redirecting users to the provider to log in. The
+// "next" query parameter can be set to a path on the same origin, which users
+// are redirected to once logged in.
+func (rp *RelyingParty) Login() safehttp.Handler {
+	return safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		s := sessions.FromRequest(r)
+		if s == nil {
+			return w.WriteError(safehttp.StatusInternalServerError)
+		}
+		state, err := random()
+		if err != nil {
+			return w.WriteError(safehttp.StatusInternalServerError)
+		}
+		nonce, err := random()
+		if err != nil {
+			return w.WriteError(safehttp.StatusInternalServerError)
+		}
+		verifier, err := random()
+		if err != nil {
+			return w.WriteError(safehttp.StatusInternalServerError)
+		}
+		next := "/"
+		if q, err := r.URL().Query(); err == nil {
+			if n := q.String("next", ""); n != "" && (safehttp.RedirectPolicy{}).Check(n) == nil {
+				next = n
+			}
+		}
+		s.Set(stateKey, state)
+		s.Set(nonceKey, nonce)
+		s.Set(verifierKey, verifier)
+		s.Set(nextKey, next)
+
+		u, err := url.Parse(rp.cfg.Provider.AuthURL)
+		if err != nil {
+			return w.WriteError(safehttp.StatusInternalServerError)
+		}
+		challenge := sha256.Sum256([]byte(verifier))
+		q := u.Query()
+		q.Set("response_type", "code")
+		q.Set("client_id", rp.cfg.ClientID)
+		q.Set("redirect_uri", rp.cfg.RedirectURL)
+		q.Set("scope", strings.Join(append([]string{"openid"}, rp.cfg.Scopes...), " "))
+		q.Set("state", state)
+		q.Set("nonce", nonce)
+		q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+		q.Set("code_challenge_method", "S256")
+		u.RawQuery = q.Encode()
+		return safehttp.Redirect(w, r, u.String(), safehttp.StatusFound)
+	})
+}
+
+// Callback returns the handler the provider redirects users to once they
+// logged in. It validates the response of the provider, exchanges the
+// authorization code for an ID token and validates it. Then, it renews the
+// ID of the session, to prevent session fixation, stores the identity of the
+// user in it and redirects them to the path passed to Login.
+//
+// Requests that don't match a login started by the same session are rejected
+// with 400 Bad Request, and failed logins with 401 Unauthorized.
+func (rp *RelyingParty) Callback() safehttp.Handler {
+	return safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		s := sessions.FromRequest(r)
+		if s == nil {
+			return w.WriteError(safehttp.StatusInternalServerError)
+		}
+		wantState, _ := s.Get(stateKey)
+		nonce, _ := s.Get(nonceKey)
+		verifier, _ := s.Get(verifierKey)
+		next, _ := s.Get(nextKey)
+		// The state is single use, whatever the outcome.
+		for _, k := range []string{stateKey, nonceKey, verifierKey, nextKey} {
+			s.Delete(k)
+		}
+
+		q, err := r.URL().Query()
+		if err != nil {
+			return w.WriteError(safehttp.StatusBadRequest)
+		}
+		state := q.String("state", "")
+		if wantState == "" || subtle.ConstantTimeCompare([]byte(state), []byte(wantState)) != 1 {
+			return w.WriteError(safehttp.StatusBadRequest)
+		}
+		if q.String("error", "") != "" {
+			return w.WriteError(safehttp.StatusUnauthorized)
+		}
+		code := q.String("code", "")
+		if code == "" {
+			return w.WriteError(safehttp.StatusBadRequest)
+		}
+
+		idToken, err := rp.exchange(r.Context(), code, verifier)
+		if err != nil {
+			return w.WriteError(safehttp.StatusUnauthorized)
+		}
+		c, err := rp.verifier.verify(r.Context(), idToken, nonce)
+		if err != nil {
+			return w.WriteError(safehttp.StatusUnauthorized)
+		}
+		if err := s.RenewID(); err != nil {
+			return w.WriteError(safehttp.StatusInternalServerError)
+		}
+		s.Set(subjectKey, c.Subject)
+		if c.Email != "" && c.EmailVerified {
+			s.Set(emailKey, c.Email)
+		}
+		if c.Name != "" {
+			s.Set(nameKey, c.Name)
+		}
+		if next == "" {
+			next = "/"
+		}
+		return safehttp.LocalRedirect(w, r, next, safehttp.StatusSeeOther)
+	})
+}
+
+// Logout returns a handler destroying the session of the user and redirecting
+// them to the given path on the same origin.
+func (rp *RelyingParty) Logout(redirect string) safehttp.Handler {
+	return safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		if s := sessions.FromRequest(r); s != nil {
+			s.Destroy()
+		}
+		return safehttp.LocalRedirect(w, r, redirect, safehttp.StatusSeeOther)
+	})
+}
+
+// Authenticator returns an auth.Authenticator authenticating the users logged
+// in with the relying party. Their identity has the "oidc" method, the subject
+// of the ID token and, if available, the "email" and "name" attributes.
+func (rp *RelyingParty) Authenticator() auth.Authenticator {
+	return auth.AuthenticatorFunc(func(r *safehttp.IncomingRequest) (*auth.Identity, error) {
+		s := sessions.FromRequest(r)
+		if s == nil {
+			return nil, nil
+		}
+		sub, ok := s.Get(subjectKey)
+		if !ok {
+			return nil, nil
+		}
+		id := &auth.Identity{Subject: sub, Method: "oidc", Attributes: map[string]string{}}
+		if v, ok := s.Get(emailKey); ok {
+			id.Attributes["email"] = v
+		}
+		if v, ok := s.Get(nameKey); ok {
+			id.Attributes["name"] = v
+		}
+		return id, nil
+	})
+}
+
+// exchange exchanges the authorization code for an ID token at the token
+// endpoint, authenticating with the client secret.
+func (rp *RelyingParty) exchange(ctx context.Context, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {rp.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rp.cfg.Provider.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(rp.cfg.ClientID), url.QueryEscape(rp.cfg.ClientSecret))
+	var resp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := doJSON(rp.cfg.HTTPClient, req, &resp); err != nil {
+		return "", fmt.Errorf("exchanging code: %v", err)
+	}
+	if resp.IDToken == "" {
+		return "", errors.New("no ID token in token response")
+	}
+	return resp.IDToken, nil
+}
+
+func random() (string, error) {
+	buf := make([]byte, entropy)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("crypto/rand.Read: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
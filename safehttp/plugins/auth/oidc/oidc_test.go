@@ -0,0 +1,410 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/auth"
+	"github.com/google/go-safeweb/safehttp/plugins/sessions"
+)
+
+const testClientID = "client"
+
+var (
+	keysOnce sync.Once
+	rsaKey   *rsa.PrivateKey
+	ecKey    *ecdsa.PrivateKey
+)
+
+func testKeys(t *testing.T) (*rsa.PrivateKey, *ecdsa.PrivateKey) {
+	keysOnce.Do(func() {
+		var err error
+		if rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatalf("rsa.GenerateKey() got err: %v", err)
+		}
+		if ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatalf("ecdsa.GenerateKey() got err: %v", err)
+		}
+	})
+	return rsaKey, ecKey
+}
+
+// fakeProvider is an OpenID provider issuing ID tokens for the authorization
+// codes registered with authorize.
+type fakeProvider struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu    sync.Mutex
+	codes map[string]authRequest
+	// modify, if set, alters the claims of the issued ID tokens.
+	modify func(claims map[string]interface{})
+	// alg is the algorithm used to sign ID tokens.
+	alg string
+}
+
+type authRequest struct {
+	nonce, challenge string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	p := &fakeProvider{t: t, codes: map[string]authRequest{}, alg: "RS256"}
+	m := http.NewServeMux()
+	m.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(p.provider())
+	})
+	m.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		rk, ek := testKeys(t)
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rk.N.Bytes()), "e": b64(big.NewInt(int64(rk.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ek.X.Bytes()), "y": b64(ek.Y.Bytes())},
+			{"kty": "oct", "kid": "hmac", "k": b64([]byte("secret"))},
+		}})
+	})
+	m.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != testClientID || secret != "secret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		p.mu.Lock()
+		ar, ok := p.codes[r.PostFormValue("code")]
+		delete(p.codes, r.PostFormValue("code"))
+		p.mu.Unlock()
+		challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(challenge[:]) != ar.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		claims := map[string]interface{}{
+			"iss":            p.srv.URL,
+			"sub":            "alice",
+			"aud":            testClientID,
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          ar.nonce,
+			"email":          "alice@example.com",
+			"email_verified": true,
+		}
+		if p.modify != nil {
+			p.modify(claims)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(claims)})
+	})
+	p.srv = httptest.NewTLSServer(m)
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+func (p *fakeProvider) provider() Provider {
+	return Provider{
+		Issuer:   p.srv.URL,
+		AuthURL:  p.srv.URL + "/auth",
+		TokenURL: p.srv.URL + "/token",
+		JWKSURL:  p.srv.URL + "/jwks",
+	}
+}
+
+// authorize simulates the user logging in at the authorization URL, returning
+// the authorization code and the state.
+func (p *fakeProvider) authorize(location string) (code, state string) {
+	u, err := url.Parse(location)
+	if err != nil {
+		p.t.Fatalf("url.Parse(%q) got err: %v", location, err)
+	}
+	q := u.Query()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	code = "code" + q.Get("state")
+	p.codes[code] = authRequest{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	return code, q.Get("state")
+}
+
+func (p *fakeProvider) sign(claims map[string]interface{}) string {
+	rk, ek := testKeys(p.t)
+	kid := map[string]string{"RS256": "rsa", "ES256": "ec", "HS256": "hmac", "none": "rsa"}[p.alg]
+	header, _ := json.Marshal(map[string]string{"alg": p.alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch p.alg {
+	case "RS256":
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, rk, crypto.SHA256, digest[:]); err != nil {
+			p.t.Fatalf("rsa.SignPKCS1v15() got err: %v", err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, ek, digest[:])
+		if err != nil {
+			p.t.Fatalf("ecdsa.Sign() got err: %v", err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	default:
+		sig = []byte("forged")
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// browser sends requests to a ServeMux with the relying party handlers,
+// keeping cookies.
+type browser struct {
+	t       *testing.T
+	mux     *safehttp.ServeMux
+	cookies map[string]*http.Cookie
+}
+
+func newBrowser(t *testing.T, rp *RelyingParty) *browser {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(sessions.Interceptor{Store: &sessions.MemoryStore{}})
+	mb.Intercept(auth.Interceptor{Authenticators: []auth.Authenticator{rp.Authenticator()}})
+	m := mb.Mux()
+	anon := auth.AllowAnonymous("login flow")
+	m.Handle("/login", safehttp.MethodGet, rp.Login(), anon)
+	m.Handle("/callback", safehttp.MethodGet, rp.Callback(), anon)
+	m.Handle("/logout", safehttp.MethodPost, rp.Logout("/"))
+	m.Handle("/me", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		id := auth.FromRequest(r)
+		return safehttp.WriteString(w, id.Subject+" "+id.Attributes["email"])
+	}))
+	return &browser{t: t, mux: m, cookies: map[string]*http.Cookie{}}
+}
+
+func (b *browser) do(method, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "https://app.example.com"+target, nil)
+	for _, c := range b.cookies {
+		req.AddCookie(c)
+	}
+	rr := httptest.NewRecorder()
+	b.mux.ServeHTTP(rr, req)
+	for _, c := range rr.Result().Cookies() {
+		if c.MaxAge < 0 {
+			delete(b.cookies, c.Name)
+		} else {
+			b.cookies[c.Name] = c
+		}
+	}
+	return rr
+}
+
+func newTestRelyingParty(t *testing.T, p *fakeProvider) *RelyingParty {
+	rp, err := NewRelyingParty(Config{
+		Provider:     p.provider(),
+		ClientID:     testClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "https://app.example.com/callback",
+		Scopes:       []string{"email"},
+		HTTPClient:   p.srv.Client(),
+	})
+	if err != nil {
+		t.Fatalf("NewRelyingParty() got err: %v", err)
+	}
+	return rp
+}
+
+func TestLoginFlow(t *testing.T) {
+	p := newFakeProvider(t)
+	b := newBrowser(t, newTestRelyingParty(t, p))
+
+	if got, want := b.do(safehttp.MethodGet, "/me").Code, http.StatusUnauthorized; got != want {
+		t.Errorf("GET /me before login: got %v, want %v", got, want)
+	}
+
+	rr := b.do(safehttp.MethodGet, "/login?next=/me")
+	if got, want := rr.Code, http.StatusFound; got != want {
+		t.Fatalf("GET /login: got %v, want %v", got, want)
+	}
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("url.Parse(Location) got err: %v", err)
+	}
+	q := loc.Query()
+	for k, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          "https://app.example.com/callback",
+		"scope":                 "openid email",
+		"code_challenge_method": "S256",
+	} {
+		if got := q.Get(k); got != want {
+			t.Errorf("authorization URL %s: got %q, want %q", k, got, want)
+		}
+	}
+	preLogin := b.cookies[sessions.DefaultCookieName].Value
+
+	code, state := p.authorize(loc.String())
+	rr = b.do(safehttp.MethodGet, "/callback?state="+state+"&code="+code)
+	if got, want := rr.Code, http.StatusSeeOther; got != want {
+		t.Fatalf("GET /callback: got %v, want %v", got, want)
+	}
+	if got, want := rr.Header().Get("Location"), "/me"; got != want {
+		t.Errorf("GET /callback Location: got %q, want %q", got, want)
+	}
+	if b.cookies[sessions.DefaultCookieName].Value == preLogin {
+		t.Error("session ID not renewed on login")
+	}
+
+	rr = b.do(safehttp.MethodGet, "/me")
+	if got, want := rr.Body.String(), "alice alice@example.com"; got != want {
+		t.Errorf("GET /me after login: got %q, want %q", got, want)
+	}
+
+	// Replaying the callback must fail, as the state is single use.
+	if got, want := b.do(safehttp.MethodGet, "/callback?state="+state+"&code="+code).Code, http.StatusBadRequest; got != want {
+		t.Errorf("replayed GET /callback: got %v, want %v", got, want)
+	}
+
+	b.do(safehttp.MethodPost, "/logout")
+	if got, want := b.do(safehttp.MethodGet, "/me").Code, http.StatusUnauthorized; got != want {
+		t.Errorf("GET /me after logout: got %v, want %v", got, want)
+	}
+}
+
+func TestLoginNextOpenRedirect(t *testing.T) {
+	p := newFakeProvider(t)
+	b := newBrowser(t, newTestRelyingParty(t, p))
+	code, state := p.authorize(b.do(safehttp.MethodGet, "/login?next=https://evil.com/").Header().Get("Location"))
+	rr := b.do(safehttp.MethodGet, "/callback?state="+state+"&code="+code)
+
+	if got, want := rr.Header().Get("Location"), "/"; got != want {
+		t.Errorf("GET /callback Location: got %q, want %q", got, want)
+	}
+}
+
+func TestCallbackInvalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		alg        string
+		modify     func(claims map[string]interface{})
+		wrongState bool
+		error      bool
+		wantStatus int
+	}{
+		{name: "Wrong state", wrongState: true, wantStatus: http.StatusBadRequest},
+		{name: "Provider error", error: true, wantStatus: http.StatusUnauthorized},
+		{name: "Other issuer", modify: func(c map[string]interface{}) { c["iss"] = "https://evil.com" }, wantStatus: http.StatusUnauthorized},
+		{name: "Other audience", modify: func(c map[string]interface{}) { c["aud"] = "other" }, wantStatus: http.StatusUnauthorized},
+		{name: "Multiple audiences without azp", modify: func(c map[string]interface{}) { c["aud"] = []string{testClientID, "other"} }, wantStatus: http.StatusUnauthorized},
+		{name: "Expired", modify: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantStatus: http.StatusUnauthorized},
+		{name: "Issued in the future", modify: func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() }, wantStatus: http.StatusUnauthorized},
+		{name: "Wrong nonce", modify: func(c map[string]interface{}) { c["nonce"] = "other" }, wantStatus: http.StatusUnauthorized},
+		{name: "No subject", modify: func(c map[string]interface{}) { delete(c, "sub") }, wantStatus: http.StatusUnauthorized},
+		{name: "Algorithm none", alg: "none", wantStatus: http.StatusUnauthorized},
+		{name: "Algorithm HS256", alg: "HS256", wantStatus: http.StatusUnauthorized},
+		{name: "ES256", alg: "ES256", wantStatus: http.StatusSeeOther},
+		{name: "Multiple audiences with azp", modify: func(c map[string]interface{}) {
+			c["aud"] = []string{testClientID, "other"}
+			c["azp"] = testClientID
+		}, wantStatus: http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newFakeProvider(t)
+			p.modify = tt.modify
+			if tt.alg != "" {
+				p.alg = tt.alg
+			}
+			b := newBrowser(t, newTestRelyingParty(t, p))
+			code, state := p.authorize(b.do(safehttp.MethodGet, "/login").Header().Get("Location"))
+			target := "/callback?state=" + state + "&code=" + code
+			if tt.wrongState {
+				target = "/callback?state=other&code=" + code
+			}
+			if tt.error {
+				target = "/callback?state=" + state + "&error=access_denied"
+			}
+			rr := b.do(safehttp.MethodGet, target)
+
+			if got := rr.Code; got != tt.wantStatus {
+				t.Errorf("rr.Code: got %v, want %v", got, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCallbackWithoutLogin(t *testing.T) {
+	p := newFakeProvider(t)
+	b := newBrowser(t, newTestRelyingParty(t, p))
+	if got, want := b.do(safehttp.MethodGet, "/callback?state=&code=code").Code, http.StatusBadRequest; got != want {
+		t.Errorf("rr.Code: got %v, want %v", got, want)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	p := newFakeProvider(t)
+	got, err := Discover(context.Background(), p.srv.Client(), p.srv.URL)
+	if err != nil {
+		t.Fatalf("Discover() got err: %v", err)
+	}
+	if want := p.provider(); got != want {
+		t.Errorf("Discover(): got %+v, want %+v", got, want)
+	}
+	if _, err := Discover(context.Background(), p.srv.Client(), p.srv.URL+"/"); err == nil {
+		t.Error("Discover() with other issuer: got nil err, want error")
+	}
+}
+
+func TestNewRelyingPartyInvalid(t *testing.T) {
+	valid := Config{
+		Provider: Provider{
+			Issuer:   "https://accounts.example.com",
+			AuthURL:  "https://accounts.example.com/auth",
+			TokenURL: "https://accounts.example.com/token",
+			JWKSURL:  "https://accounts.example.com/jwks",
+		},
+		ClientID:    testClientID,
+		RedirectURL: "https://app.example.com/callback",
+	}
+	if _, err := NewRelyingParty(valid); err != nil {
+		t.Fatalf("NewRelyingParty(valid) got err: %v", err)
+	}
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+	}{
+		{name: "No client ID", modify: func(c *Config) { c.ClientID = "" }},
+		{name: "No issuer", modify: func(c *Config) { c.Provider.Issuer = "" }},
+		{name: "Insecure token URL", modify: func(c *Config) { c.Provider.TokenURL = "http://accounts.example.com/token" }},
+		{name: "Relative redirect URL", modify: func(c *Config) { c.RedirectURL = "/callback" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			if _, err := NewRelyingParty(cfg); err == nil {
+				t.Error("NewRelyingParty() got nil err, want error")
+			}
+		})
+	}
+}
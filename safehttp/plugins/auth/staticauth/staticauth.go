@@ -0,0 +1,185 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package staticauth provides auth.Authenticators for credentials configured
+// statically, HTTP Basic passwords and API keys, for internal tools that
+// don't need a full identity provider. Credentials are compared in constant
+// time and must only be sent over HTTPS.
+//
+// Whether handlers require authentication is configured with the
+// auth.Interceptor, see NewBasicInterceptor and NewAPIKeyInterceptor.
+package staticauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/auth"
+)
+
+// DefaultAPIKeyHeader is the header API keys are read from, unless overridden
+// with APIKey.Header.
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// Hooks are called on authentication attempts, e.g. to record metrics or to
+// lock out clients guessing credentials.
+type Hooks struct {
+	// Locked, if set, reports whether attempts for the principal, the user
+	// name or "" for API keys, are locked out. Locked out attempts are
+	// rejected without checking the credentials.
+	Locked func(r *safehttp.IncomingRequest, principal string) bool
+	// OnSuccess, if set, is called after successful attempts.
+	OnSuccess func(r *safehttp.IncomingRequest, principal string)
+	// OnFailure, if set, is called after failed attempts, including locked
+	// out ones.
+	OnFailure func(r *safehttp.IncomingRequest, principal string)
+}
+
+func (h Hooks) locked(r *safehttp.IncomingRequest, principal string) bool {
+	return h.Locked != nil && h.Locked(r, principal)
+}
+
+func (h Hooks) success(r *safehttp.IncomingRequest, principal string) {
+	if h.OnSuccess != nil {
+		h.OnSuccess(r, principal)
+	}
+}
+
+func (h Hooks) failure(r *safehttp.IncomingRequest, principal string) {
+	if h.OnFailure != nil {
+		h.OnFailure(r, principal)
+	}
+}
+
+// equal compares a and b in constant time, regardless of their lengths.
+func equal(a, b string) int {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:])
+}
+
+// Basic authenticates requests with HTTP Basic credentials, as specified by
+// RFC 7617. Identities have the "basic" method and the user name as subject.
+type Basic struct {
+	// Users maps user names to their passwords.
+	Users map[string]string
+	// Hooks are called on authentication attempts.
+	Hooks Hooks
+}
+
+var _ auth.Authenticator = Basic{}
+
+// Authenticate checks the Basic credentials of the request, if any. All
+// users are compared, so that the time taken doesn't reveal which exist.
+func (b Basic) Authenticate(r *safehttp.IncomingRequest) (*auth.Identity, error) {
+	user, pass, ok := basicCredentials(r.Header.Get("Authorization"))
+	if !ok {
+		return nil, nil
+	}
+	if b.Hooks.locked(r, user) {
+		b.Hooks.failure(r, user)
+		return nil, auth.ErrInvalidCredentials
+	}
+	match := 0
+	for u, p := range b.Users {
+		match |= equal(u, user) & equal(p, pass)
+	}
+	if match != 1 {
+		b.Hooks.failure(r, user)
+		return nil, auth.ErrInvalidCredentials
+	}
+	b.Hooks.success(r, user)
+	return &auth.Identity{Subject: user, Method: "basic"}, nil
+}
+
+// basicCredentials parses the value of a Basic Authorization header.
+func basicCredentials(h string) (user, pass string, ok bool) {
+	const scheme = "basic "
+	if len(h) < len(scheme) || !strings.EqualFold(h[:len(scheme)], scheme) {
+		return "", "", false
+	}
+	dec, err := base64.StdEncoding.DecodeString(strings.TrimSpace(h[len(scheme):]))
+	if err != nil {
+		return "", "", false
+	}
+	i := strings.IndexByte(string(dec), ':')
+	if i < 0 {
+		return "", "", false
+	}
+	return string(dec[:i]), string(dec[i+1:]), true
+}
+
+// NewBasicInterceptor creates an auth.Interceptor requiring requests to be
+// authenticated with Basic credentials for the users. Browsers prompt for
+// credentials for the given realm. Handlers can opt out with
+// auth.AllowAnonymous.
+func NewBasicInterceptor(realm string, b Basic) auth.Interceptor {
+	return auth.Interceptor{
+		Authenticators: []auth.Authenticator{b},
+		Challenge:      `Basic realm="` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(realm) + `", charset="UTF-8"`,
+	}
+}
+
+// APIKey authenticates requests with an API key sent in a header. Identities
+// have the "apikey" method and the name of the key as subject.
+type APIKey struct {
+	// Keys maps API keys to their names, e.g. the service using them.
+	Keys map[string]string
+	// Header is the header the API key is read from. If empty,
+	// DefaultAPIKeyHeader is used.
+	Header string
+	// Hooks are called on authentication attempts, with an empty principal.
+	Hooks Hooks
+}
+
+var _ auth.Authenticator = APIKey{}
+
+// Authenticate checks the API key of the request, if any. All keys are
+// compared, so that the time taken doesn't reveal them.
+func (k APIKey) Authenticate(r *safehttp.IncomingRequest) (*auth.Identity, error) {
+	h := k.Header
+	if h == "" {
+		h = DefaultAPIKeyHeader
+	}
+	key := r.Header.Get(h)
+	if key == "" {
+		return nil, nil
+	}
+	if k.Hooks.locked(r, "") {
+		k.Hooks.failure(r, "")
+		return nil, auth.ErrInvalidCredentials
+	}
+	var name string
+	found := false
+	for candidate, n := range k.Keys {
+		if equal(candidate, key) == 1 {
+			name, found = n, true
+		}
+	}
+	if !found {
+		k.Hooks.failure(r, "")
+		return nil, auth.ErrInvalidCredentials
+	}
+	k.Hooks.success(r, "")
+	return &auth.Identity{Subject: name, Method: "apikey"}, nil
+}
+
+// NewAPIKeyInterceptor creates an auth.Interceptor requiring requests to be
+// authenticated with one of the API keys. Handlers can opt out with
+// auth.AllowAnonymous.
+func NewAPIKeyInterceptor(k APIKey) auth.Interceptor {
+	return auth.Interceptor{Authenticators: []auth.Authenticator{k}}
+}
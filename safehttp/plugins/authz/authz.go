@@ -0,0 +1,119 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package authz provides a safehttp.Interceptor that authorizes requests
+// against the permissions required by their handler, using the identity
+// stored in their context by the auth.Interceptor.
+//
+// Handlers declare the permissions they require with the Require
+// configuration, which are evaluated by a PolicyEngine. RolePolicy is a
+// simple role-based engine, other authorization systems can be integrated by
+// implementing PolicyEngine.
+package authz
+
+import (
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/auth"
+)
+
+// PolicyEngine decides whether identities hold permissions.
+type PolicyEngine interface {
+	// Authorize reports whether the identity holds all the permissions for
+	// the request. The request can be used to make decisions depending on the
+	// accessed resource, e.g. based on its path parameters.
+	Authorize(r *safehttp.IncomingRequest, id *auth.Identity, permissions []string) (bool, error)
+}
+
+// RolePolicy is a PolicyEngine granting permissions to the roles of the
+// identities. A role implicitly grants the permission with its own name, so
+// that Require("admin") is satisfied by identities with the "admin" role.
+type RolePolicy struct {
+	// Roles returns the roles of the identity, e.g. from its attributes. It
+	// must be set.
+	Roles func(id *auth.Identity) []string
+	// Grants maps roles to the permissions they grant.
+	Grants map[string][]string
+}
+
+var _ PolicyEngine = RolePolicy{}
+
+// Authorize reports whether the roles of the identity grant all the
+// permissions.
+func (p RolePolicy) Authorize(_ *safehttp.IncomingRequest, id *auth.Identity, permissions []string) (bool, error) {
+	held := map[string]bool{}
+	for _, role := range p.Roles(id) {
+		held[role] = true
+		for _, perm := range p.Grants[role] {
+			held[perm] = true
+		}
+	}
+	for _, perm := range permissions {
+		if !held[perm] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+type requirement struct {
+	permissions []string
+}
+
+// Require returns a configuration requiring the identity of requests to the
+// handler to hold all the given permissions, or roles for RolePolicy.
+func Require(permissions ...string) safehttp.InterceptorConfig {
+	return requirement{permissions: permissions}
+}
+
+// Interceptor authorizes the requests to handlers with the Require
+// configuration. It must be installed after the auth.Interceptor. Requests
+// to other handlers are not checked.
+type Interceptor struct {
+	// Engine evaluates the permissions. It must be set.
+	Engine PolicyEngine
+}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+// Before rejects anonymous requests with 401 Unauthorized and requests whose
+// identity doesn't hold the required permissions with 403 Forbidden. If the
+// Engine fails, requests are rejected with 500 Internal Server Error.
+func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	req, ok := cfg.(requirement)
+	if !ok {
+		return safehttp.NotWritten()
+	}
+	id := auth.FromRequest(r)
+	if id == nil {
+		return w.WriteError(safehttp.StatusUnauthorized)
+	}
+	allowed, err := it.Engine.Authorize(r, id, req.permissions)
+	if err != nil {
+		return w.WriteError(safehttp.StatusInternalServerError)
+	}
+	if !allowed {
+		return w.WriteError(safehttp.StatusForbidden)
+	}
+	return safehttp.NotWritten()
+}
+
+// Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+}
+
+// Match recognizes the Require configuration.
+func (Interceptor) Match(cfg safehttp.InterceptorConfig) bool {
+	_, ok := cfg.(requirement)
+	return ok
+}
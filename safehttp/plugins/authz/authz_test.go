@@ -0,0 +1,133 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authz_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/auth"
+	"github.com/google/go-safeweb/safehttp/plugins/authz"
+)
+
+// users authenticates requests with the X-User header, whose value is the
+// comma-separated roles of the user.
+var users = auth.AuthenticatorFunc(func(r *safehttp.IncomingRequest) (*auth.Identity, error) {
+	u := r.Header.Get("X-User")
+	if u == "" {
+		return nil, nil
+	}
+	return &auth.Identity{Subject: "user", Attributes: map[string]string{"roles": u}}, nil
+})
+
+var rolePolicy = authz.RolePolicy{
+	Roles: func(id *auth.Identity) []string {
+		return strings.Split(id.Attributes["roles"], ",")
+	},
+	Grants: map[string][]string{
+		"editor": {"posts.read", "posts.write"},
+		"viewer": {"posts.read"},
+	},
+}
+
+type failingEngine struct{}
+
+func (failingEngine) Authorize(*safehttp.IncomingRequest, *auth.Identity, []string) (bool, error) {
+	return false, errors.New("policy backend unavailable")
+}
+
+func TestInterceptor(t *testing.T) {
+	tests := []struct {
+		name       string
+		engine     authz.PolicyEngine
+		cfg        safehttp.InterceptorConfig
+		user       string
+		wantStatus safehttp.StatusCode
+	}{
+		{name: "Role", cfg: authz.Require("admin"), user: "admin", wantStatus: safehttp.StatusNoContent},
+		{name: "Missing role", cfg: authz.Require("admin"), user: "editor", wantStatus: safehttp.StatusForbidden},
+		{name: "Granted permission", cfg: authz.Require("posts.write"), user: "editor", wantStatus: safehttp.StatusNoContent},
+		{name: "Missing permission", cfg: authz.Require("posts.write"), user: "viewer", wantStatus: safehttp.StatusForbidden},
+		{name: "All permissions required", cfg: authz.Require("posts.read", "admin"), user: "editor", wantStatus: safehttp.StatusForbidden},
+		{name: "Permissions from several roles", cfg: authz.Require("posts.write", "admin"), user: "editor,admin", wantStatus: safehttp.StatusNoContent},
+		{name: "Anonymous", cfg: authz.Require("posts.read"), wantStatus: safehttp.StatusUnauthorized},
+		{name: "No requirement", wantStatus: safehttp.StatusNoContent},
+		{name: "Engine error", engine: failingEngine{}, cfg: authz.Require("admin"), user: "admin", wantStatus: safehttp.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tt.engine
+			if engine == nil {
+				engine = rolePolicy
+			}
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.Intercept(auth.Interceptor{Authenticators: []auth.Authenticator{users}, Optional: true})
+			mb.Intercept(authz.Interceptor{Engine: engine})
+			var cfgs []safehttp.InterceptorConfig
+			if tt.cfg != nil {
+				cfgs = append(cfgs, tt.cfg)
+			}
+			mux := mb.Mux()
+			mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return w.Write(safehttp.NoContentResponse{})
+			}), cfgs...)
+
+			req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+			if tt.user != "" {
+				req.Header.Set("X-User", tt.user)
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if got, want := rr.Code, int(tt.wantStatus); got != want {
+				t.Errorf("rr.Code: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// ownerPolicy only allows users to access their own resources.
+type ownerPolicy struct{}
+
+func (ownerPolicy) Authorize(r *safehttp.IncomingRequest, id *auth.Identity, permissions []string) (bool, error) {
+	return r.PathParam("user") == id.Subject, nil
+}
+
+func TestInterceptorRequestAttributes(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(auth.Interceptor{Authenticators: []auth.Authenticator{users}})
+	mb.Intercept(authz.Interceptor{Engine: ownerPolicy{}})
+	mux := mb.Mux()
+	mux.Handle("/users/{user}", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehttp.NoContentResponse{})
+	}), authz.Require("profile.read"))
+
+	for path, want := range map[string]safehttp.StatusCode{
+		"/users/user":  safehttp.StatusNoContent,
+		"/users/other": safehttp.StatusForbidden,
+	} {
+		req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com"+path, nil)
+		req.Header.Set("X-User", "viewer")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if got := rr.Code; got != int(want) {
+			t.Errorf("GET %s: rr.Code got %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cachecontrol provides a safehttp.Interceptor that prevents private
+// data from being cached by browsers and intermediaries, e.g. CDNs or
+// corporate proxies.
+//
+// All the responses get a "Cache-Control: no-store" header by default.
+// Handlers serving content that is the same for everyone, such as static
+// assets, can opt in to caching with the Public configuration. Even then,
+// responses are not cacheable if the request is authenticated, i.e. it has an
+// Authorization header or an identity set by the auth plugin, or if the
+// response sets cookies.
+//
+// More info:
+//   - MDN: https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Cache-Control
+//   - RFC 7234: https://tools.ietf.org/html/rfc7234
+//
+// # Usage
+//
+// Install an instance of Interceptor using safehttp.ServeMuxConfig.Intercept.
+// It should be installed before the plugins that set cookies in their Commit
+// phases, e.g. sessions, so that its Commit phase runs after theirs.
+package cachecontrol
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/auth"
+	"github.com/google/safehtml"
+)
+
+// NoStore is the Cache-Control value of responses that must not be cached.
+const NoStore = "no-store"
+
+// Interceptor claims the Cache-Control header and sets it to NoStore, unless
+// the handler is configured with Public. The zero value is valid and ready to
+// use.
+type Interceptor struct{}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+// Public is a configuration that allows the responses of the handler it's
+// passed to to be cached by browsers and shared caches.
+type Public struct {
+	// MaxAge is how long responses can be cached. It will be rounded to
+	// seconds before use and must not be negative.
+	MaxAge time.Duration
+	// Immutable tells browsers that the response won't change while it's
+	// fresh, so that they don't revalidate it, e.g. for versioned assets.
+	Immutable bool
+	// ETag enables setting an ETag header computed by hashing the body of
+	// the response. This is only supported for safehtml.HTML,
+	// safehttp.StringResponse and safehttp.JSONResponse responses.
+	ETag bool
+}
+
+// Value returns the Cache-Control value for the configuration.
+func (p Public) Value() string {
+	v := "public, max-age=" + strconv.FormatInt(int64(p.MaxAge.Seconds()), 10)
+	if p.Immutable {
+		v += ", immutable"
+	}
+	return v
+}
+
+type settersKey struct{}
+
+type setters struct {
+	cacheControl func([]string)
+	etag         func([]string)
+}
+
+// Before claims the Cache-Control header, as well as the ETag header if the
+// handler is configured with Public and ETag. It panics if the Public
+// configuration has a negative MaxAge.
+func (Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	s := setters{cacheControl: w.Header().Claim("Cache-Control")}
+	if p, ok := cfg.(Public); ok {
+		if p.MaxAge < 0 {
+			panic("cannot cache responses: negative MaxAge")
+		}
+		if p.ETag {
+			s.etag = w.Header().Claim("ETag")
+		}
+	}
+	safehttp.FlightValues(r.Context()).Put(settersKey{}, s)
+	return safehttp.NotWritten()
+}
+
+// Commit sets the Cache-Control header, and the ETag header if enabled.
+// Responses are only cacheable if the handler is configured with Public, the
+// response is not an error, the request is not authenticated and the response
+// doesn't set cookies.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+	s, ok := safehttp.FlightValues(r.Context()).Get(settersKey{}).(setters)
+	if !ok {
+		// The request has been rejected before Before ran.
+		return
+	}
+	p, ok := cfg.(Public)
+	if !ok || !cacheable(w, r, resp) {
+		s.cacheControl([]string{NoStore})
+		return
+	}
+	s.cacheControl([]string{p.Value()})
+	if s.etag == nil {
+		return
+	}
+	if tag, ok := etag(resp); ok {
+		s.etag([]string{tag})
+	}
+}
+
+// Match returns true if cfg is Public.
+func (Interceptor) Match(cfg safehttp.InterceptorConfig) bool {
+	_, ok := cfg.(Public)
+	return ok
+}
+
+// cacheable reports whether the response doesn't contain private data.
+func cacheable(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response) bool {
+	if _, ok := resp.(safehttp.ErrorResponse); ok {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" || auth.FromRequest(r) != nil {
+		return false
+	}
+	return w.Header().Get("Set-Cookie") == ""
+}
+
+// etag returns a strong ETag for the body of the response, if it's supported.
+func etag(resp safehttp.Response) (string, bool) {
+	var body []byte
+	switch x := resp.(type) {
+	case safehtml.HTML:
+		body = []byte(x.String())
+	case safehttp.StringResponse:
+		body = []byte(x.Data)
+	case safehttp.JSONResponse:
+		b, err := json.Marshal(x.Data)
+		if err != nil {
+			return "", false
+		}
+		body = b
+	default:
+		return "", false
+	}
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, true
+}
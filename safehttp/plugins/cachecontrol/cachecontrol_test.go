@@ -0,0 +1,143 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cachecontrol_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/auth"
+	"github.com/google/go-safeweb/safehttp/plugins/cachecontrol"
+	"github.com/google/safehtml"
+)
+
+func TestInterceptor(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(cachecontrol.Interceptor{})
+	mb.Intercept(auth.Interceptor{
+		Optional: true,
+		Authenticators: []auth.Authenticator{auth.AuthenticatorFunc(func(r *safehttp.IncomingRequest) (*auth.Identity, error) {
+			if r.Header.Get("X-User") == "" {
+				return nil, nil
+			}
+			return &auth.Identity{Subject: r.Header.Get("X-User")}, nil
+		})},
+	})
+	mux := mb.Mux()
+
+	hello := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehtml.HTMLEscaped("hello"))
+	})
+	public := cachecontrol.Public{MaxAge: time.Hour, ETag: true}
+	mux.Handle("/private", safehttp.MethodGet, hello)
+	mux.Handle("/public", safehttp.MethodGet, hello, public)
+	mux.Handle("/immutable", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.WriteString(w, "hello")
+	}), cachecontrol.Public{MaxAge: 365 * 24 * time.Hour, Immutable: true})
+	mux.Handle("/error", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.WriteError(safehttp.StatusNotFound)
+	}), public)
+	mux.Handle("/cookie", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		if err := w.AddCookie(safehttp.NewCookie("pref", "dark")); err != nil {
+			t.Fatalf("w.AddCookie: %v", err)
+		}
+		return w.Write(safehtml.HTMLEscaped("hello"))
+	}), public)
+
+	// The SHA-256 digest of "hello", truncated to 16 bytes.
+	helloETag := `"2cf24dba5fb0a30e26e83b2ac5b9e29e"`
+	tests := []struct {
+		name             string
+		path             string
+		header           map[string]string
+		wantCacheControl string
+		wantETag         string
+	}{
+		{
+			name:             "Private by default",
+			path:             "/private",
+			wantCacheControl: "no-store",
+		},
+		{
+			name:             "Public",
+			path:             "/public",
+			wantCacheControl: "public, max-age=3600",
+			wantETag:         helloETag,
+		},
+		{
+			name:             "Immutable",
+			path:             "/immutable",
+			wantCacheControl: "public, max-age=31536000, immutable",
+		},
+		{
+			name:             "Authorization header",
+			path:             "/public",
+			header:           map[string]string{"Authorization": "Bearer token"},
+			wantCacheControl: "no-store",
+		},
+		{
+			name:             "Authenticated",
+			path:             "/public",
+			header:           map[string]string{"X-User": "alice"},
+			wantCacheControl: "no-store",
+		},
+		{
+			name:             "Error",
+			path:             "/error",
+			wantCacheControl: "no-store",
+		},
+		{
+			name:             "Sets cookies",
+			path:             "/cookie",
+			wantCacheControl: "no-store",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com"+tt.path, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("Cache-Control"); got != tt.wantCacheControl {
+				t.Errorf(`rr.Header().Get("Cache-Control"): got %q, want %q`, got, tt.wantCacheControl)
+			}
+			if got := rr.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf(`rr.Header().Get("ETag"): got %q, want %q`, got, tt.wantETag)
+			}
+		})
+	}
+}
+
+func TestInterceptorClaimsCacheControl(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(cachecontrol.Interceptor{})
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error(`w.Header().Set("Cache-Control", ...) got no panic, want panic`)
+			}
+		}()
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		return safehttp.NotWritten()
+	}))
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil))
+}
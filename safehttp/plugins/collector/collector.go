@@ -16,11 +16,18 @@
 // The created safehttp.Handler will be able to parse generic violation reports
 // as specified by https://w3c.github.io/reporting/ and CSP violation reports as
 // specified by https://www.w3.org/TR/CSP3/#deprecated-serialize-violation.
+//
+// Reports of all types, including COOP, deprecation and network error (NEL)
+// reports, can be deduplicated with Deduplicate and dispatched to several
+// sinks with Fanout before being handled.
 package collector
 
 import (
 	"encoding/json"
+	"io"
 	"io/ioutil"
+	"sync"
+	"time"
 
 	"github.com/google/go-safeweb/safehttp"
 )
@@ -88,16 +95,59 @@ type CSPReport struct {
 // a violation report is received. Make sure to register the handler to receive POST
 // requests. If the handler recieves anything other than POST requests it will
 // respond with a 405 Method Not Allowed.
+//
+// Requests are limited to DefaultMaxBodySize bytes, use NewHandler to change
+// the limits.
 func Handler(handler func(Report), cspHandler func(CSPReport)) safehttp.Handler {
+	return NewHandler(Limits{}, handler, cspHandler)
+}
+
+// DefaultMaxBodySize is the maximum size of report requests, unless configured
+// otherwise.
+const DefaultMaxBodySize = 1 << 20
+
+// Limits bounds the resources used by a report handler. As reports are sent
+// by browsers without authentication, anybody can send arbitrary amounts of
+// them.
+type Limits struct {
+	// MaxBodySize is the maximum size in bytes of report requests. Larger
+	// requests are rejected with 413 Request Entity Too Large. If zero,
+	// DefaultMaxBodySize is used.
+	MaxBodySize int64
+	// RequestsPerSecond, if positive, limits the rate at which report requests
+	// are processed, across all clients. Requests over the limit are rejected
+	// with 429 Too Many Requests before being parsed.
+	RequestsPerSecond float64
+	// Burst is the number of requests that can be processed at once when
+	// RequestsPerSecond is set. If zero, one request is allowed.
+	Burst int
+}
+
+// NewHandler is like Handler, but applies the given limits.
+func NewHandler(l Limits, handler func(Report), cspHandler func(CSPReport)) safehttp.Handler {
+	maxBodySize := l.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	var limiter *rateLimiter
+	if l.RequestsPerSecond > 0 {
+		limiter = newRateLimiter(l.RequestsPerSecond, l.Burst)
+	}
 	return safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
 		if r.Method() != safehttp.MethodPost {
 			return w.WriteError(safehttp.StatusMethodNotAllowed)
 		}
+		if limiter != nil && !limiter.allow() {
+			return w.WriteError(safehttp.StatusTooManyRequests)
+		}
 
-		b, err := ioutil.ReadAll(r.Body())
+		b, err := ioutil.ReadAll(io.LimitReader(r.Body(), maxBodySize+1))
 		if err != nil {
 			return w.WriteError(safehttp.StatusBadRequest)
 		}
+		if int64(len(b)) > maxBodySize {
+			return w.WriteError(safehttp.StatusRequestEntityTooLarge)
+		}
 
 		ct := r.Header.Get("Content-Type")
 		if ct == "application/csp-report" {
@@ -110,6 +160,40 @@ func Handler(handler func(Report), cspHandler func(CSPReport)) safehttp.Handler
 	})
 }
 
+// rateLimiter is a token bucket allowing rate events per second, with bursts of
+// up to burst events.
+type rateLimiter struct {
+	rate, burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// allow reports whether an event can happen now, consuming a token if so.
+func (l *rateLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
 func handleDeprecatedCSPReports(h func(CSPReport), w safehttp.ResponseWriter, b []byte) safehttp.Result {
 	// In CSP2 it is clearly stated that a report has a single key 'csp-report'
 	// which holds the report object. Like this:
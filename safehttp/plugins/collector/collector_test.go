@@ -456,3 +456,67 @@ func TestInvalidRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestHandlerLimits(t *testing.T) {
+	report := `{"csp-report": {"blocked-uri": "https://evil.com/", "disposition": "report", "document-uri": "https://example.com/"}}`
+	tests := []struct {
+		name      string
+		limits    collector.Limits
+		report    string
+		requests  int
+		wantCodes []safehttp.StatusCode
+	}{
+		{
+			name:      "Body within limit",
+			limits:    collector.Limits{MaxBodySize: int64(len(report))},
+			report:    report,
+			requests:  1,
+			wantCodes: []safehttp.StatusCode{safehttp.StatusNoContent},
+		},
+		{
+			name:      "Body too large",
+			limits:    collector.Limits{MaxBodySize: 16},
+			report:    report,
+			requests:  1,
+			wantCodes: []safehttp.StatusCode{safehttp.StatusRequestEntityTooLarge},
+		},
+		{
+			name:      "Default body limit",
+			report:    `{"csp-report": {"script-sample": "` + strings.Repeat("a", collector.DefaultMaxBodySize) + `"}}`,
+			requests:  1,
+			wantCodes: []safehttp.StatusCode{safehttp.StatusRequestEntityTooLarge},
+		},
+		{
+			name:     "Rate limited",
+			limits:   collector.Limits{RequestsPerSecond: 0.001, Burst: 2},
+			report:   report,
+			requests: 3,
+			wantCodes: []safehttp.StatusCode{
+				safehttp.StatusNoContent,
+				safehttp.StatusNoContent,
+				safehttp.StatusTooManyRequests,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := collector.NewHandler(tt.limits, func(r collector.Report) {
+				t.Fatalf("expected generic reports handler not to be called")
+			}, func(r collector.CSPReport) {})
+
+			var gotCodes []safehttp.StatusCode
+			for i := 0; i < tt.requests; i++ {
+				req := safehttptest.NewRequest(safehttp.MethodPost, "/collector", strings.NewReader(tt.report))
+				req.Header.Set("Content-Type", "application/csp-report")
+				fakeRW, rr := safehttptest.NewFakeResponseWriter()
+				h.ServeHTTP(fakeRW, req)
+				gotCodes = append(gotCodes, safehttp.StatusCode(rr.Code))
+			}
+
+			if diff := cmp.Diff(tt.wantCodes, gotCodes); diff != "" {
+				t.Errorf("status codes mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+var now = time.Now
+
+// maxDedupEntries bounds the number of reports remembered for deduplication.
+// When the bound is reached, all the remembered reports are forgotten.
+const maxDedupEntries = 10000
+
+// deduper remembers keys for a given time window.
+type deduper struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newDeduper(window time.Duration) *deduper {
+	return &deduper{window: window, seen: map[string]time.Time{}}
+}
+
+// duplicate reports whether key has been seen within the time window and
+// remembers it otherwise.
+func (d *deduper) duplicate(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t := now()
+	if exp, ok := d.seen[key]; ok && t.Before(exp) {
+		return true
+	}
+	if len(d.seen) >= maxDedupEntries {
+		for k, exp := range d.seen {
+			if !t.Before(exp) {
+				delete(d.seen, k)
+			}
+		}
+		if len(d.seen) >= maxDedupEntries {
+			d.seen = map[string]time.Time{}
+		}
+	}
+	d.seen[key] = t.Add(d.window)
+	return false
+}
+
+// Deduplicate returns a report handler which calls h only for the first of
+// identical reports received within the given time window. Reports are
+// identical if all their fields but Age are equal.
+//
+// Browsers send a report for every violation, so a single misconfigured page
+// can generate large amounts of identical reports.
+func Deduplicate(window time.Duration, h func(Report)) func(Report) {
+	d := newDeduper(window)
+	return func(r Report) {
+		k := r
+		k.Age = 0
+		key, err := json.Marshal(k)
+		if err == nil && d.duplicate(string(key)) {
+			return
+		}
+		h(r)
+	}
+}
+
+// DeduplicateCSP is like Deduplicate, but for CSP violation reports sent
+// using the deprecated report-uri directive.
+func DeduplicateCSP(window time.Duration, h func(CSPReport)) func(CSPReport) {
+	d := newDeduper(window)
+	return func(r CSPReport) {
+		key, err := json.Marshal(r)
+		if err == nil && d.duplicate(string(key)) {
+			return
+		}
+		h(r)
+	}
+}
+
+// Fanout returns a report handler which dispatches every report to all the
+// given sinks, in order.
+func Fanout(sinks ...func(Report)) func(Report) {
+	return func(r Report) {
+		for _, s := range sinks {
+			s(r)
+		}
+	}
+}
+
+// FanoutCSP is like Fanout, but for CSP violation reports sent using the
+// deprecated report-uri directive.
+func FanoutCSP(sinks ...func(CSPReport)) func(CSPReport) {
+	return func(r CSPReport) {
+		for _, s := range sinks {
+			s(r)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDeduplicate(t *testing.T) {
+	start := time.Now()
+	defer func() { now = time.Now }()
+
+	var got []Report
+	h := Deduplicate(time.Minute, func(r Report) { got = append(got, r) })
+
+	steps := []struct {
+		elapsed time.Duration
+		report  Report
+	}{
+		{report: Report{Type: "deprecation", URL: "https://foo.com/a", Age: 1, Body: map[string]interface{}{"id": "x"}}},
+		// Only the age differs: duplicate.
+		{elapsed: time.Second, report: Report{Type: "deprecation", URL: "https://foo.com/a", Age: 2, Body: map[string]interface{}{"id": "x"}}},
+		// Different body.
+		{elapsed: time.Second, report: Report{Type: "deprecation", URL: "https://foo.com/a", Body: map[string]interface{}{"id": "y"}}},
+		// Different URL.
+		{elapsed: time.Second, report: Report{Type: "deprecation", URL: "https://foo.com/b", Body: map[string]interface{}{"id": "x"}}},
+		// Window elapsed.
+		{elapsed: 2 * time.Minute, report: Report{Type: "deprecation", URL: "https://foo.com/a", Age: 3, Body: map[string]interface{}{"id": "x"}}},
+	}
+	for _, s := range steps {
+		now = func() time.Time { return start.Add(s.elapsed) }
+		h(s.report)
+	}
+
+	want := []Report{steps[0].report, steps[2].report, steps[3].report, steps[4].report}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("reports mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDeduplicateCSP(t *testing.T) {
+	var got []CSPReport
+	h := DeduplicateCSP(time.Minute, func(r CSPReport) { got = append(got, r) })
+
+	a := CSPReport{BlockedURL: "inline", DocumentURL: "https://foo.com", EffectiveDirective: "script-src"}
+	b := CSPReport{BlockedURL: "eval", DocumentURL: "https://foo.com", EffectiveDirective: "script-src"}
+	h(a)
+	h(a)
+	h(b)
+
+	if diff := cmp.Diff([]CSPReport{a, b}, got); diff != "" {
+		t.Errorf("reports mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDeduplicateBounded(t *testing.T) {
+	d := newDeduper(time.Hour)
+	for i := 0; i < maxDedupEntries+1; i++ {
+		d.duplicate(strconv.Itoa(i))
+	}
+	if got := len(d.seen); got > maxDedupEntries {
+		t.Errorf("len(d.seen): got %d, want at most %d", got, maxDedupEntries)
+	}
+}
+
+func TestFanout(t *testing.T) {
+	var first, second []Report
+	h := Fanout(
+		func(r Report) { first = append(first, r) },
+		func(r Report) { second = append(second, r) },
+	)
+	r := Report{Type: "coop", URL: "https://foo.com"}
+	h(r)
+
+	if diff := cmp.Diff([]Report{r}, first); diff != "" {
+		t.Errorf("first sink mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]Report{r}, second); diff != "" {
+		t.Errorf("second sink mismatch (-want +got):\n%s", diff)
+	}
+
+	var csp []CSPReport
+	hCSP := FanoutCSP(func(r CSPReport) { csp = append(csp, r) })
+	hCSP(CSPReport{BlockedURL: "inline"})
+	if len(csp) != 1 {
+		t.Errorf("len(csp): got %d, want 1", len(csp))
+	}
+}
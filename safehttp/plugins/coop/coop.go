@@ -13,6 +13,10 @@
 // limitations under the License.
 
 // Package coop provides Cross-Origin-Opener-Policy protection. Specification: https://html.spec.whatwg.org/#cross-origin-opener-policies
+//
+// It also provides an IsolationInterceptor which, together with COOP, sets the
+// Cross-Origin-Embedder-Policy and Cross-Origin-Resource-Policy headers needed
+// to enable cross-origin isolation.
 package coop
 
 import (
@@ -0,0 +1,244 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coop
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// EmbedderMode represents a Cross-Origin-Embedder-Policy mode.
+//
+// Specification: https://html.spec.whatwg.org/#coep
+type EmbedderMode string
+
+const (
+	// RequireCORP only allows loading cross-origin resources that explicitly
+	// grant permission via CORS or Cross-Origin-Resource-Policy.
+	RequireCORP EmbedderMode = "require-corp"
+	// Credentialless allows loading cross-origin no-cors resources, but sends
+	// the requests without credentials.
+	Credentialless EmbedderMode = "credentialless"
+	// EmbedderUnsafeNone disables COEP: this is the default value in browsers.
+	EmbedderUnsafeNone EmbedderMode = "unsafe-none"
+)
+
+// EmbedderPolicy represents a Cross-Origin-Embedder-Policy value.
+type EmbedderPolicy struct {
+	// Mode is the mode for the policy.
+	Mode EmbedderMode
+	// ReportingGroup is an optional reporting group that needs to be defined with the Reporting API.
+	ReportingGroup string
+	// ReportOnly makes the policy report-only if set.
+	ReportOnly bool
+}
+
+// String serializes the policy. The returned value can be used as a header value.
+func (p EmbedderPolicy) String() string {
+	if p.ReportingGroup == "" {
+		return string(p.Mode)
+	}
+	return string(p.Mode) + `; report-to "` + p.ReportingGroup + `"`
+}
+
+// ResourcePolicy represents a Cross-Origin-Resource-Policy value.
+//
+// Specification: https://fetch.spec.whatwg.org/#cross-origin-resource-policy-header
+type ResourcePolicy string
+
+const (
+	// ResourceSameOrigin only allows same-origin no-cors requests to load the resource.
+	ResourceSameOrigin ResourcePolicy = "same-origin"
+	// ResourceSameSite only allows same-site no-cors requests to load the resource.
+	ResourceSameSite ResourcePolicy = "same-site"
+	// ResourceCrossOrigin allows any origin to load the resource.
+	ResourceCrossOrigin ResourcePolicy = "cross-origin"
+)
+
+// IsolationPolicy configures the headers needed to put documents in a
+// cross-origin isolated state.
+//
+// See https://web.dev/coop-coep/ for more details.
+type IsolationPolicy struct {
+	// Opener contains the Cross-Origin-Opener-Policy policies. At most one of
+	// them can be enforcing.
+	Opener []Policy
+	// Embedder contains the Cross-Origin-Embedder-Policy policies. At most one
+	// of them can be enforcing.
+	Embedder []EmbedderPolicy
+	// Resource is the Cross-Origin-Resource-Policy. If empty, the header is
+	// not set.
+	Resource ResourcePolicy
+}
+
+// header is a response header name with its values.
+type header struct {
+	name   string
+	values []string
+}
+
+// IsolationInterceptor sets the Cross-Origin-Opener-Policy,
+// Cross-Origin-Embedder-Policy and Cross-Origin-Resource-Policy headers, as
+// well as their report-only variants.
+//
+// Headers already set by the handler are never overridden.
+type IsolationInterceptor struct {
+	headers []header
+}
+
+var _ safehttp.Interceptor = IsolationInterceptor{}
+
+var (
+	validOpenerModes = map[Mode]bool{
+		SameOrigin:            true,
+		SameOriginAllowPopups: true,
+		UnsafeNone:            true,
+	}
+	validEmbedderModes = map[EmbedderMode]bool{
+		RequireCORP:        true,
+		Credentialless:     true,
+		EmbedderUnsafeNone: true,
+	}
+	validResourcePolicies = map[ResourcePolicy]bool{
+		ResourceSameOrigin:  true,
+		ResourceSameSite:    true,
+		ResourceCrossOrigin: true,
+	}
+)
+
+// NewIsolationInterceptor constructs an IsolationInterceptor that applies the
+// given policy. It returns an error if the policy contains unknown modes or
+// mutually exclusive enforcing policies for the same header.
+func NewIsolationInterceptor(p IsolationPolicy) (IsolationInterceptor, error) {
+	var coop serializedPolicies
+	for _, op := range p.Opener {
+		if !validOpenerModes[op.Mode] {
+			return IsolationInterceptor{}, fmt.Errorf("invalid Cross-Origin-Opener-Policy mode %q", op.Mode)
+		}
+		if op.ReportOnly {
+			coop.rep = append(coop.rep, op.String())
+			continue
+		}
+		if len(coop.enf) > 0 {
+			return IsolationInterceptor{}, errors.New("multiple enforcing Cross-Origin-Opener-Policy policies")
+		}
+		coop.enf = append(coop.enf, op.String())
+	}
+
+	var coep serializedPolicies
+	for _, ep := range p.Embedder {
+		if !validEmbedderModes[ep.Mode] {
+			return IsolationInterceptor{}, fmt.Errorf("invalid Cross-Origin-Embedder-Policy mode %q", ep.Mode)
+		}
+		if ep.ReportOnly {
+			coep.rep = append(coep.rep, ep.String())
+			continue
+		}
+		if len(coep.enf) > 0 {
+			return IsolationInterceptor{}, errors.New("multiple enforcing Cross-Origin-Embedder-Policy policies")
+		}
+		coep.enf = append(coep.enf, ep.String())
+	}
+
+	var corp []string
+	if p.Resource != "" {
+		if !validResourcePolicies[p.Resource] {
+			return IsolationInterceptor{}, fmt.Errorf("invalid Cross-Origin-Resource-Policy %q", p.Resource)
+		}
+		corp = []string{string(p.Resource)}
+	}
+
+	var it IsolationInterceptor
+	for _, h := range []header{
+		{name: "Cross-Origin-Opener-Policy", values: coop.enf},
+		{name: "Cross-Origin-Opener-Policy-Report-Only", values: coop.rep},
+		{name: "Cross-Origin-Embedder-Policy", values: coep.enf},
+		{name: "Cross-Origin-Embedder-Policy-Report-Only", values: coep.rep},
+		{name: "Cross-Origin-Resource-Policy", values: corp},
+	} {
+		if len(h.values) > 0 {
+			it.headers = append(it.headers, h)
+		}
+	}
+	return it, nil
+}
+
+// DefaultIsolationPolicy returns the policy used by DefaultIsolation: COOP
+// same-origin, COEP require-corp and CORP same-origin, with the given
+// (potentially empty) report group used for COOP and COEP. Its fields can be
+// relaxed before passing it to NewIsolationInterceptor.
+func DefaultIsolationPolicy(reportGroup string) IsolationPolicy {
+	return IsolationPolicy{
+		Opener:   []Policy{{Mode: SameOrigin, ReportingGroup: reportGroup}},
+		Embedder: []EmbedderPolicy{{Mode: RequireCORP, ReportingGroup: reportGroup}},
+		Resource: ResourceSameOrigin,
+	}
+}
+
+// DefaultIsolation returns an IsolationInterceptor that enforces cross-origin
+// isolation: COOP same-origin, COEP require-corp and CORP same-origin. The
+// given (potentially empty) report group is used for COOP and COEP.
+func DefaultIsolation(reportGroup string) IsolationInterceptor {
+	it, err := NewIsolationInterceptor(DefaultIsolationPolicy(reportGroup))
+	if err != nil {
+		// This path should not be possible.
+		panic(err)
+	}
+	return it
+}
+
+// DefaultIsolationReportOnly returns an IsolationInterceptor that sets the
+// COOP and COEP policies of DefaultIsolation in report-only mode, reporting
+// violations to the given report group. The report group should be defined
+// with the reportingapi plugin. Cross-Origin-Resource-Policy has no
+// report-only variant, so it is not set.
+func DefaultIsolationReportOnly(reportGroup string) IsolationInterceptor {
+	p := DefaultIsolationPolicy(reportGroup)
+	p.Opener[0].ReportOnly = true
+	p.Embedder[0].ReportOnly = true
+	p.Resource = ""
+	it, err := NewIsolationInterceptor(p)
+	if err != nil {
+		// This path should not be possible.
+		panic(err)
+	}
+	return it
+}
+
+// Before is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (IsolationInterceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+	return safehttp.NotWritten()
+}
+
+// Commit sets the configured headers, unless they have already been set by
+// the handler.
+func (it IsolationInterceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
+	h := w.Header()
+	for _, hdr := range it.headers {
+		if len(h.Values(hdr.name)) > 0 || h.IsClaimed(hdr.name) {
+			continue
+		}
+		for _, v := range hdr.values {
+			h.Add(hdr.name, v)
+		}
+	}
+}
+
+// Match returns false since there are no supported configurations.
+func (IsolationInterceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
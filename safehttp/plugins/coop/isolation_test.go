@@ -0,0 +1,165 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coop
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/safehttptest"
+)
+
+func TestIsolationCommit(t *testing.T) {
+	tests := []struct {
+		name        string
+		interceptor IsolationInterceptor
+		want        map[string][]string
+	}{
+		{
+			name:        "Default",
+			interceptor: DefaultIsolation("coi"),
+			want: map[string][]string{
+				"Cross-Origin-Opener-Policy":   {`same-origin; report-to "coi"`},
+				"Cross-Origin-Embedder-Policy": {`require-corp; report-to "coi"`},
+				"Cross-Origin-Resource-Policy": {"same-origin"},
+			},
+		},
+		{
+			name:        "Default report only",
+			interceptor: DefaultIsolationReportOnly("coi"),
+			want: map[string][]string{
+				"Cross-Origin-Opener-Policy-Report-Only":   {`same-origin; report-to "coi"`},
+				"Cross-Origin-Embedder-Policy-Report-Only": {`require-corp; report-to "coi"`},
+			},
+		},
+		{
+			name: "Relaxed default",
+			interceptor: func() IsolationInterceptor {
+				p := DefaultIsolationPolicy("")
+				p.Embedder[0].Mode = Credentialless
+				p.Resource = ResourceSameSite
+				it, err := NewIsolationInterceptor(p)
+				if err != nil {
+					t.Fatalf("NewIsolationInterceptor: %v", err)
+				}
+				return it
+			}(),
+			want: map[string][]string{
+				"Cross-Origin-Opener-Policy":   {"same-origin"},
+				"Cross-Origin-Embedder-Policy": {"credentialless"},
+				"Cross-Origin-Resource-Policy": {"same-site"},
+			},
+		},
+		{
+			name: "Report only",
+			interceptor: func() IsolationInterceptor {
+				it, err := NewIsolationInterceptor(IsolationPolicy{
+					Opener: []Policy{
+						{Mode: SameOriginAllowPopups},
+						{Mode: SameOrigin, ReportingGroup: "coop", ReportOnly: true},
+					},
+					Embedder: []EmbedderPolicy{
+						{Mode: RequireCORP, ReportingGroup: "coep", ReportOnly: true},
+					},
+				})
+				if err != nil {
+					t.Fatalf("NewIsolationInterceptor: %v", err)
+				}
+				return it
+			}(),
+			want: map[string][]string{
+				"Cross-Origin-Opener-Policy":               {"same-origin-allow-popups"},
+				"Cross-Origin-Opener-Policy-Report-Only":   {`same-origin; report-to "coop"`},
+				"Cross-Origin-Embedder-Policy-Report-Only": {`require-corp; report-to "coep"`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			req := safehttptest.NewRequest(safehttp.MethodGet, "/", nil)
+			tt.interceptor.Before(fakeRW, req, nil)
+			tt.interceptor.Commit(fakeRW, req, nil, nil)
+
+			if diff := cmp.Diff(tt.want, map[string][]string(rr.Header())); diff != "" {
+				t.Errorf("rr.Header() mismatch (-want +got):\n%s", diff)
+			}
+			if rr.Code != int(safehttp.StatusOK) {
+				t.Errorf("Status: got %v want: %v", rr.Code, safehttp.StatusOK)
+			}
+		})
+	}
+}
+
+func TestIsolationNoClobber(t *testing.T) {
+	fakeRW, rr := safehttptest.NewFakeResponseWriter()
+	req := safehttptest.NewRequest(safehttp.MethodGet, "/", nil)
+	it := DefaultIsolation("")
+	it.Before(fakeRW, req, nil)
+	// Simulate the handler relaxing CORP for this response.
+	fakeRW.Header().Set("Cross-Origin-Resource-Policy", "cross-origin")
+	it.Commit(fakeRW, req, nil, nil)
+
+	want := map[string][]string{
+		"Cross-Origin-Opener-Policy":   {"same-origin"},
+		"Cross-Origin-Embedder-Policy": {"require-corp"},
+		"Cross-Origin-Resource-Policy": {"cross-origin"},
+	}
+	if diff := cmp.Diff(want, map[string][]string(rr.Header())); diff != "" {
+		t.Errorf("rr.Header() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNewIsolationInterceptorInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy IsolationPolicy
+	}{
+		{
+			name: "Multiple enforcing COOP",
+			policy: IsolationPolicy{Opener: []Policy{
+				{Mode: SameOrigin},
+				{Mode: UnsafeNone},
+			}},
+		},
+		{
+			name: "Multiple enforcing COEP",
+			policy: IsolationPolicy{Embedder: []EmbedderPolicy{
+				{Mode: RequireCORP},
+				{Mode: Credentialless},
+			}},
+		},
+		{
+			name:   "Invalid COOP mode",
+			policy: IsolationPolicy{Opener: []Policy{{Mode: "same-site"}}},
+		},
+		{
+			name:   "Invalid COEP mode",
+			policy: IsolationPolicy{Embedder: []EmbedderPolicy{{}}},
+		},
+		{
+			name:   "Invalid CORP",
+			policy: IsolationPolicy{Resource: "none"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewIsolationInterceptor(tt.policy); err == nil {
+				t.Errorf("NewIsolationInterceptor(%+v): got nil err, want error", tt.policy)
+			}
+		})
+	}
+}
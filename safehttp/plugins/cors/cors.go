@@ -46,10 +46,19 @@ var disallowedContentTypes = map[string]bool{
 // The HEAD request method is disallowed.
 //
 // All of this is to prevent XSRF.
+//
+// Allowed origins are reflected in the Access-Control-Allow-Origin header, the
+// "*" wildcard value is never used.
 type Interceptor struct {
 	// AllowedOrigins determines which origins should be allowed in the
 	// Access-Control-Allow-Origin header.
+	//
+	// An entry of the form "https://*.example.com" allows any subdomain of
+	// example.com served over https, but not example.com itself.
 	AllowedOrigins map[string]bool
+	// AllowOriginFunc, if set, is called for origins that don't match
+	// AllowedOrigins and reports whether they should be allowed.
+	AllowOriginFunc func(origin string) bool
 	// ExposedHeaders determines which headers should be set in the
 	// Access-Control-Expose-Headers header. This controls which headers are
 	//  accessible by JavaScript in the response.
@@ -111,9 +120,15 @@ func (it *Interceptor) SetAllowedHeaders(headers ...string) {
 //  - Access-Control-Expose-Headers
 //  - Access-Control-Max-Age
 //  - Vary
-func (it *Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+//
+// If the handler has been registered with a configuration created by Override,
+// its Interceptor is used instead.
+func (it *Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	if o, ok := cfg.(override); ok {
+		return o.it.Before(w, r, nil)
+	}
 	origin := r.Header.Get("Origin")
-	if origin != "" && !it.AllowedOrigins[origin] {
+	if origin != "" && !it.allowedOrigin(origin) {
 		return w.WriteError(safehttp.StatusForbidden)
 	}
 	h := w.Header()
@@ -158,9 +173,52 @@ func (it *Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingReq
 func (it *Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
 }
 
-// Match returns false since there are no supported configurations.
-func (*Interceptor) Match(safehttp.InterceptorConfig) bool {
-	return false
+// Match recognizes configurations created by Override.
+func (*Interceptor) Match(cfg safehttp.InterceptorConfig) bool {
+	_, ok := cfg.(override)
+	return ok
+}
+
+type override struct {
+	it *Interceptor
+}
+
+// Override returns a configuration which makes the given Interceptor handle
+// CORS requests for a specific handler, instead of the one installed on the
+// ServeMux.
+func Override(reason string, it *Interceptor) safehttp.InterceptorConfig {
+	return override{it: it}
+}
+
+func (it *Interceptor) allowedOrigin(origin string) bool {
+	if strings.Contains(origin, "*") {
+		// Wildcard patterns are not valid origins.
+		return false
+	}
+	if it.AllowedOrigins[origin] {
+		return true
+	}
+	for o := range it.AllowedOrigins {
+		if matchWildcard(o, origin) {
+			return true
+		}
+	}
+	return it.AllowOriginFunc != nil && it.AllowOriginFunc(origin)
+}
+
+// matchWildcard reports whether origin is a subdomain of the origin pattern,
+// which must be of the form "scheme://*.domain[:port]".
+func matchWildcard(pattern, origin string) bool {
+	i := strings.Index(pattern, "://*.")
+	if i < 0 {
+		return false
+	}
+	prefix, suffix := pattern[:i+len("://")], pattern[i+len("://*"):]
+	if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	sub := origin[len(prefix) : len(origin)-len(suffix)]
+	return !strings.ContainsAny(sub, "/:@")
 }
 
 func appendToVary(w safehttp.ResponseWriter, val string) {
@@ -537,3 +537,89 @@ func TestPreflightEmptyOrigin(t *testing.T) {
 		t.Errorf("rr.Header() mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func TestAllowedOriginMatching(t *testing.T) {
+	it := cors.Default("https://foo.com", "https://*.bar.com", "http://*.baz.com:8080")
+	it.AllowOriginFunc = func(origin string) bool {
+		return origin == "https://callback.com"
+	}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "https://foo.com", want: true},
+		{origin: "https://a.bar.com", want: true},
+		{origin: "https://a.b.bar.com", want: true},
+		{origin: "http://a.baz.com:8080", want: true},
+		{origin: "https://callback.com", want: true},
+		{origin: "http://foo.com"},
+		{origin: "https://bar.com"},
+		{origin: "https://.bar.com"},
+		{origin: "http://a.bar.com"},
+		{origin: "https://abar.com"},
+		{origin: "https://a.bar.com.evil.com"},
+		{origin: "https://evil.com/.bar.com"},
+		{origin: "https://evil.com:1.bar.com"},
+		{origin: "http://a.baz.com"},
+		{origin: "https://*.bar.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			req := safehttptest.NewRequest(safehttp.MethodPut, "http://bar.com/asdf", nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("X-Cors", "1")
+			req.Header.Set("Content-Type", "application/json")
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+
+			it.Before(fakeRW, req, nil)
+
+			want := map[string][]string{}
+			wantCode := safehttp.StatusForbidden
+			if tt.want {
+				want = map[string][]string{
+					"Access-Control-Allow-Origin": {tt.origin},
+					"Vary":                        {"Origin"},
+				}
+				wantCode = safehttp.StatusOK
+			}
+			if got := safehttp.StatusCode(rr.Code); got != wantCode {
+				t.Errorf("rr.Code: got %v, want %v", got, wantCode)
+			}
+			if diff := cmp.Diff(want, map[string][]string(rr.Header())); diff != "" {
+				t.Errorf("rr.Header() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOverride(t *testing.T) {
+	it := cors.Default("https://foo.com")
+	o := cors.Default("https://pizza.com")
+	o.AllowCredentials = true
+	cfg := cors.Override("public API", o)
+
+	if !it.Match(cfg) {
+		t.Error("it.Match(cors.Override(...)): got false, want true")
+	}
+
+	req := safehttptest.NewRequest(safehttp.MethodPut, "http://bar.com/asdf", nil)
+	req.Header.Set("Origin", "https://pizza.com")
+	req.Header.Set("X-Cors", "1")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Cookie", "a=b")
+	fakeRW, rr := safehttptest.NewFakeResponseWriter()
+
+	it.Before(fakeRW, req, cfg)
+
+	if want := safehttp.StatusOK; rr.Code != int(want) {
+		t.Errorf("rr.Code: got %v, want %v", rr.Code, want)
+	}
+	want := map[string][]string{
+		"Access-Control-Allow-Credentials": {"true"},
+		"Access-Control-Allow-Origin":      {"https://pizza.com"},
+		"Vary":                             {"Origin"},
+	}
+	if diff := cmp.Diff(want, map[string][]string(rr.Header())); diff != "" {
+		t.Errorf("rr.Header() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -19,6 +19,11 @@
 //  - A strict nonce based CSP
 //  - A framing policy which sets frame-ancestors to 'self'
 //  - A Trusted Types policy which makes usage of dangerous web API functions secure by default
+//
+// A new nonce is generated for every request and made available to templates
+// through the CSPNonce function. Templates loaded with htmlinject, e.g. via
+// htmlinject.LoadTrustedTemplate, call it to add the nonce to their <script>,
+// <style> and <link rel=preload> tags automatically.
 package csp
 
 import (
@@ -200,6 +205,19 @@ type TrustedTypesPolicy struct {
 	// ReportURI controls the report-uri directive. If ReportUri is empty, no report-uri
 	// directive will be set.
 	ReportURI string
+	// AllowedPolicies controls the trusted-types directive, which restricts the
+	// names of the Trusted Types policies the application can create, e.g.
+	// "default" or "dompurify". If AllowedPolicies is empty, no trusted-types
+	// directive will be set and any policy can be created.
+	//
+	// See https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Security-Policy/trusted-types
+	// for more info.
+	AllowedPolicies []string
+	// AllowDuplicates controls whether the trusted-types directive should
+	// contain the 'allow-duplicates' value, which allows creating multiple
+	// policies with the same name. It has no effect if AllowedPolicies is
+	// empty.
+	AllowDuplicates bool
 }
 
 // Serialize serializes this policy for use in a Content-Security-Policy header
@@ -209,6 +227,14 @@ func (t TrustedTypesPolicy) Serialize(nonce string) string {
 	var b strings.Builder
 	b.WriteString("require-trusted-types-for 'script'")
 
+	if len(t.AllowedPolicies) > 0 {
+		b.WriteString("; trusted-types ")
+		b.WriteString(strings.Join(t.AllowedPolicies, " "))
+		if t.AllowDuplicates {
+			b.WriteString(" 'allow-duplicates'")
+		}
+	}
+
 	if t.ReportURI != "" {
 		b.WriteString("; report-uri ")
 		b.WriteString(t.ReportURI)
@@ -244,6 +270,34 @@ func Default(reportURI string) Interceptor {
 	}
 }
 
+// DefaultReportOnly creates a new CSP interceptor with the same policies as
+// Default, but in report-only mode: violations are reported to reportURI
+// without being blocked. This allows rolling out a strict CSP gradually, by
+// fixing the reported violations before switching to Default.
+func DefaultReportOnly(reportURI string) Interceptor {
+	return Interceptor{
+		ReportOnly: []Policy{
+			StrictPolicy{ReportURI: reportURI},
+			FramingPolicy{ReportURI: reportURI},
+			TrustedTypesPolicy{ReportURI: reportURI},
+		},
+	}
+}
+
+// TrustedTypes creates a new CSP interceptor which only enforces the given
+// Trusted Types policy, for applications that can't deploy the other default
+// policies yet.
+func TrustedTypes(p TrustedTypesPolicy) Interceptor {
+	return Interceptor{Enforce: []Policy{p}}
+}
+
+// TrustedTypesReportOnly is like TrustedTypes, but sets the policy in
+// report-only mode. Violations are reported to p.ReportURI, if set, without
+// being blocked.
+func TrustedTypesReportOnly(p TrustedTypesPolicy) Interceptor {
+	return Interceptor{ReportOnly: []Policy{p}}
+}
+
 // Before claims and sets the Content-Security-Policy header and the
 // Content-Security-Policy-Report-Only header.
 func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
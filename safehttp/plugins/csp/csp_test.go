@@ -16,13 +16,17 @@ package csp
 
 import (
 	"errors"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/htmlinject"
 	"github.com/google/go-safeweb/safehttp/safehttptest"
+	"github.com/google/safehtml/template/uncheckedconversions"
 )
 
 type endlessAReader struct{}
@@ -120,6 +124,25 @@ func TestSerialize(t *testing.T) {
 			policy:     TrustedTypesPolicy{ReportURI: "httsp://example.com/collector"},
 			wantString: "require-trusted-types-for 'script'; report-uri httsp://example.com/collector",
 		},
+		{
+			name:       "TrustedTypesCSP with allowed policies",
+			policy:     TrustedTypesPolicy{AllowedPolicies: []string{"default", "dompurify"}},
+			wantString: "require-trusted-types-for 'script'; trusted-types default dompurify",
+		},
+		{
+			name: "TrustedTypesCSP with allowed policies, duplicates and report-uri",
+			policy: TrustedTypesPolicy{
+				AllowedPolicies: []string{"default"},
+				AllowDuplicates: true,
+				ReportURI:       "https://example.com/collector",
+			},
+			wantString: "require-trusted-types-for 'script'; trusted-types default 'allow-duplicates'; report-uri https://example.com/collector",
+		},
+		{
+			name:       "TrustedTypesCSP with duplicates and no allowed policies",
+			policy:     TrustedTypesPolicy{AllowDuplicates: true},
+			wantString: "require-trusted-types-for 'script'",
+		},
 	}
 
 	for _, tt := range tests {
@@ -166,6 +189,28 @@ func TestBefore(t *testing.T) {
 			},
 			wantNonce: "KSkpKSkpKSkpKSkpKSkpKSkpKSk=",
 		},
+		{
+			name:        "Default report-only policies",
+			interceptor: DefaultReportOnly("https://example.com/collector"),
+			wantReportOnlyPolicy: []string{
+				"object-src 'none'; script-src 'unsafe-inline' 'nonce-KSkpKSkpKSkpKSkpKSkpKSkpKSk=' 'strict-dynamic' https: http:; base-uri 'none'; report-uri https://example.com/collector",
+				"frame-ancestors 'self'; report-uri https://example.com/collector;",
+				"require-trusted-types-for 'script'; report-uri https://example.com/collector",
+			},
+			wantNonce: "KSkpKSkpKSkpKSkpKSkpKSkpKSk=",
+		},
+		{
+			name:              "TrustedTypes",
+			interceptor:       TrustedTypes(TrustedTypesPolicy{AllowedPolicies: []string{"default"}}),
+			wantEnforcePolicy: []string{"require-trusted-types-for 'script'; trusted-types default"},
+			wantNonce:         "KSkpKSkpKSkpKSkpKSkpKSkpKSk=",
+		},
+		{
+			name:                 "TrustedTypes Report Only",
+			interceptor:          TrustedTypesReportOnly(TrustedTypesPolicy{ReportURI: "https://example.com/collector"}),
+			wantReportOnlyPolicy: []string{"require-trusted-types-for 'script'; report-uri https://example.com/collector"},
+			wantNonce:            "KSkpKSkpKSkpKSkpKSkpKSkpKSk=",
+		},
 		{
 			name: "StrictCSP Report Only",
 			interceptor: Interceptor{
@@ -335,3 +380,32 @@ func TestCommitNotTemplateResponse(t *testing.T) {
 	}
 
 }
+
+func TestNoncesInjectedInTemplates(t *testing.T) {
+	tpl, err := htmlinject.LoadTrustedTemplate(nil, htmlinject.LoadConfig{DisableXSRF: true},
+		uncheckedconversions.TrustedTemplateFromStringKnownToSatisfyTypeContract(`<script>alert(1)</script><style>a{}</style>`))
+	if err != nil {
+		t.Fatalf("htmlinject.LoadTrustedTemplate: got err %v", err)
+	}
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(Default(""))
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.ExecuteTemplate(w, tpl, nil)
+	}))
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil))
+
+	if got, want := rr.Code, int(safehttp.StatusOK); got != want {
+		t.Fatalf("rr.Code: got %v, want %v", got, want)
+	}
+	nonce := generateNonce()
+	if got, want := rr.Header().Get("Content-Security-Policy"), "script-src 'unsafe-inline' 'nonce-"+nonce+"' 'strict-dynamic'"; !strings.Contains(got, want) {
+		t.Errorf("Content-Security-Policy: got %q, want to contain %q", got, want)
+	}
+	want := `<script nonce="` + nonce + `">alert(1)</script><style nonce="` + nonce + `">a{}</style>`
+	if got := rr.Body.String(); got != want {
+		t.Errorf("rr.Body.String(): got %q, want %q", got, want)
+	}
+}
@@ -15,6 +15,13 @@
 // Package fetchmetadata provides a safehttp.Interceptor that applies Fetch
 // Metadata policies to incoming requests in order to protect applications
 // against cross-origin attacks.
+//
+// The Resource Isolation Policy rejects cross-site requests based on the
+// Sec-Fetch-Site, Sec-Fetch-Mode and Sec-Fetch-Dest request headers, only
+// letting simple top-level navigations through. Individual handlers can be
+// exempted by registering them with the configuration returned by Disable, and
+// the policy can be rolled out without breaking clients by using
+// NewReportOnly, which logs would-be rejections instead of enforcing them.
 package fetchmetadata
 
 import (
@@ -65,6 +72,15 @@ type Interceptor struct {
 
 var _ safehttp.Interceptor = &Interceptor{}
 
+// NewReportOnly returns an Interceptor in "report" mode which reports policy
+// violations to the given logger without rejecting any requests. The function
+// will panic if logger is nil.
+func NewReportOnly(logger RequestLogger) *Interceptor {
+	p := &Interceptor{Logger: logger}
+	p.SetReportOnly()
+	return p
+}
+
 func (p *Interceptor) checkResourceIsolationPolicy(r *safehttp.IncomingRequest) bool {
 	h := r.Header
 	if h.Get("Sec-Fetch-Site") != "cross-site" {
@@ -323,6 +323,40 @@ func TestReportModeMissingLogger(t *testing.T) {
 	p.SetReportOnly()
 }
 
+func TestNewReportOnly(t *testing.T) {
+	tests := append(disallowedRIPHeaders, disallowedRIPNavHeaders...)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := safehttptest.NewRequest(test.method, "https://spaghetti.com/carbonara", nil)
+			req.Header.Add("Sec-Fetch-Site", test.site)
+			req.Header.Add("Sec-Fetch-Mode", test.mode)
+			req.Header.Add("Sec-Fetch-Dest", test.dest)
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+
+			logger := &methodLogger{}
+			p := fetchmetadata.NewReportOnly(logger)
+			p.Before(fakeRW, req, nil)
+
+			if want, got := safehttp.StatusOK, safehttp.StatusCode(rr.Code); got != want {
+				t.Errorf("rr.Code got: %v want: %v", got, want)
+			}
+			if logger.report != test.method {
+				t.Errorf("logger.report: got %s, want %s", logger.report, test.method)
+			}
+		})
+	}
+}
+
+func TestNewReportOnlyMissingLogger(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			return
+		}
+		t.Error("fetchmetadata.NewReportOnly(nil) expected panic")
+	}()
+	fetchmetadata.NewReportOnly(nil)
+}
+
 func TestNavIsolationEnforceMode(t *testing.T) {
 	tests := append(allowedRIPNavHeaders, disallowedRIPNavHeaders...)
 	for _, test := range tests {
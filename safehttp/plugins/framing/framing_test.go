@@ -0,0 +1,154 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package framing_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/framing"
+	"github.com/google/go-safeweb/safehttp/safehttptest"
+)
+
+func TestFraming(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         safehttp.InterceptorConfig
+		site, dest  string
+		wantStatus  safehttp.StatusCode
+		wantHeaders map[string][]string
+	}{
+		{
+			name:       "No Fetch Metadata",
+			wantStatus: safehttp.StatusOK,
+			wantHeaders: map[string][]string{
+				"X-Frame-Options":         {"SAMEORIGIN"},
+				"Content-Security-Policy": {"frame-ancestors 'self'"},
+			},
+		},
+		{
+			name:       "Same origin iframe",
+			site:       "same-origin",
+			dest:       "iframe",
+			wantStatus: safehttp.StatusOK,
+			wantHeaders: map[string][]string{
+				"X-Frame-Options":         {"SAMEORIGIN"},
+				"Content-Security-Policy": {"frame-ancestors 'self'"},
+			},
+		},
+		{
+			name:       "Cross site document",
+			site:       "cross-site",
+			dest:       "document",
+			wantStatus: safehttp.StatusOK,
+			wantHeaders: map[string][]string{
+				"X-Frame-Options":         {"SAMEORIGIN"},
+				"Content-Security-Policy": {"frame-ancestors 'self'"},
+			},
+		},
+		{
+			name:       "Cross site iframe",
+			site:       "cross-site",
+			dest:       "iframe",
+			wantStatus: safehttp.StatusForbidden,
+			wantHeaders: map[string][]string{
+				"Content-Security-Policy": {"frame-ancestors 'self'"},
+				"X-Frame-Options":         {"SAMEORIGIN"},
+			},
+		},
+		{
+			name:       "Same site frame",
+			site:       "same-site",
+			dest:       "frame",
+			wantStatus: safehttp.StatusForbidden,
+			wantHeaders: map[string][]string{
+				"Content-Security-Policy": {"frame-ancestors 'self'"},
+				"X-Frame-Options":         {"SAMEORIGIN"},
+			},
+		},
+		{
+			name:       "Allowed embedders",
+			cfg:        framing.Allow("https://embedder.example", "https://*.partner.example"),
+			site:       "cross-site",
+			dest:       "iframe",
+			wantStatus: safehttp.StatusOK,
+			wantHeaders: map[string][]string{
+				"Content-Security-Policy": {"frame-ancestors 'self' https://embedder.example https://*.partner.example"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/bar", nil)
+			if tt.site != "" {
+				req.Header.Add("Sec-Fetch-Site", tt.site)
+			}
+			if tt.dest != "" {
+				req.Header.Add("Sec-Fetch-Dest", tt.dest)
+			}
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+
+			it := framing.Default()
+			it.Before(fakeRW, req, tt.cfg)
+			it.Commit(fakeRW, req, nil, tt.cfg)
+
+			if got := safehttp.StatusCode(rr.Code); got != tt.wantStatus {
+				t.Errorf("rr.Code: got %v, want %v", got, tt.wantStatus)
+			}
+			if diff := cmp.Diff(tt.wantHeaders, map[string][]string(rr.Header())); diff != "" {
+				t.Errorf("rr.Header() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCommitClaimedCSP(t *testing.T) {
+	req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/bar", nil)
+	fakeRW, rr := safehttptest.NewFakeResponseWriter()
+	setCSP := fakeRW.Header().Claim("Content-Security-Policy")
+	setCSP([]string{"frame-ancestors 'none'"})
+
+	it := framing.Default()
+	it.Before(fakeRW, req, nil)
+	it.Commit(fakeRW, req, nil, nil)
+
+	want := map[string][]string{
+		"X-Frame-Options":         {"SAMEORIGIN"},
+		"Content-Security-Policy": {"frame-ancestors 'none'"},
+	}
+	if diff := cmp.Diff(want, map[string][]string(rr.Header())); diff != "" {
+		t.Errorf("rr.Header() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAllowInvalidOrigin(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error(`framing.Allow("https://a.example; script-src *") expected panic`)
+		}
+	}()
+	framing.Allow("https://a.example; script-src *")
+}
+
+func TestMatch(t *testing.T) {
+	it := framing.Default()
+	if !it.Match(framing.Allow("https://embedder.example")) {
+		t.Error("it.Match(framing.Allow(...)): got false, want true")
+	}
+	if it.Match(nil) {
+		t.Error("it.Match(nil): got true, want false")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package headerstrip provides a safehttp.Interceptor which removes a
+// configurable deny-list of headers from responses, e.g. headers leaking
+// information about the server software or hop-by-hop headers that can cause
+// request smuggling issues when the response goes through proxies.
+//
+// The Interceptor should be installed before any other interceptor using
+// safehttp.ServeMuxConfig.Intercept. Commit phases run in the reverse order of
+// installation, so this makes sure it has the last word on the headers.
+package headerstrip
+
+import (
+	"errors"
+	"net/textproto"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// DefaultDenyList contains the headers removed by the Default Interceptor.
+var DefaultDenyList = []string{
+	"Server",
+	"X-Powered-By",
+	"X-AspNet-Version",
+	"X-AspNetMvc-Version",
+	"Content-Length",
+	"Transfer-Encoding",
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+}
+
+// Interceptor removes deny-listed headers from responses.
+//
+// Headers claimed by other interceptors are owned by them and are never
+// removed.
+type Interceptor struct {
+	deny []string
+}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+// New creates an Interceptor removing the headers with the given names. It
+// returns an error if Set-Cookie is deny-listed, as cookies can only be managed
+// through safehttp.ResponseHeadersWriter.AddCookie.
+func New(names ...string) (Interceptor, error) {
+	var deny []string
+	for _, n := range names {
+		n = textproto.CanonicalMIMEHeaderKey(n)
+		if n == "Set-Cookie" {
+			return Interceptor{}, errors.New("the Set-Cookie header can't be deny-listed")
+		}
+		deny = append(deny, n)
+	}
+	return Interceptor{deny: deny}, nil
+}
+
+// Default creates an Interceptor removing the headers in DefaultDenyList.
+func Default() Interceptor {
+	it, err := New(DefaultDenyList...)
+	if err != nil {
+		// This path should not be possible.
+		panic(err)
+	}
+	return it
+}
+
+// Before is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+	return safehttp.NotWritten()
+}
+
+// Commit removes the deny-listed headers that are not claimed.
+func (it Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
+	h := w.Header()
+	for _, n := range it.deny {
+		if h.IsClaimed(n) {
+			continue
+		}
+		h.Del(n)
+	}
+}
+
+// Match returns false since there are no supported configurations.
+func (Interceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package headerstrip_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/headerstrip"
+	"github.com/google/go-safeweb/safehttp/plugins/staticheaders"
+	"github.com/google/go-safeweb/safehttp/plugins/xsrf/xsrfhtml"
+	"github.com/google/safehtml"
+)
+
+func TestCommit(t *testing.T) {
+	it, err := headerstrip.New(append(headerstrip.DefaultDenyList, "X-Content-Type-Options")...)
+	if err != nil {
+		t.Fatalf("headerstrip.New() got err: %v", err)
+	}
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(it)
+	mb.Intercept(staticheaders.Interceptor{})
+	mb.Intercept(&xsrfhtml.Interceptor{SecretAppKey: "testSecretAppKey"})
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		h := w.Header()
+		h.Set("Server", "Apache/2.4.1 (Unix)")
+		h.Set("X-Powered-By", "PHP/5.4.0")
+		h.Set("Content-Length", "1000")
+		h.Set("X-Custom", "foo")
+		return w.Write(safehtml.HTMLEscaped("hello"))
+	}))
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil))
+
+	if got, want := rr.Code, int(safehttp.StatusOK); got != want {
+		t.Errorf("rr.Code: got %v, want %v", got, want)
+	}
+	h := rr.Header()
+	for _, n := range []string{"Server", "X-Powered-By", "Content-Length"} {
+		if got := h.Values(n); len(got) != 0 {
+			t.Errorf("rr.Header().Values(%q): got %v, want empty", n, got)
+		}
+	}
+	for n, want := range map[string][]string{
+		"X-Custom": {"foo"},
+		// Claimed by staticheaders, so it's not removed.
+		"X-Content-Type-Options": {"nosniff"},
+	} {
+		if diff := cmp.Diff(want, h.Values(n)); diff != "" {
+			t.Errorf("rr.Header().Values(%q) mismatch (-want +got):\n%s", n, diff)
+		}
+	}
+	if got := len(rr.Result().Cookies()); got != 1 {
+		t.Errorf("len(rr.Result().Cookies()): got %d, want 1", got)
+	}
+}
+
+func TestNewSetCookie(t *testing.T) {
+	if _, err := headerstrip.New("Server", "set-cookie"); err == nil {
+		t.Error(`headerstrip.New("Server", "set-cookie") got nil err, want error`)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health provides liveness and readiness endpoints for orchestrators
+// and load balancers, such as Kubernetes probes.
+//
+// The endpoints are registered without the interceptors installed on the
+// application ServeMux, so that probes are not rejected by authentication or
+// XSRF protection, and run pluggable checks, e.g. a database ping. Their
+// response is a JSON object with the aggregated status and the status of
+// each check, e.g.
+//
+//	{"status":"fail","checks":{"db":"ok","cache":"fail"}}
+//
+// Errors returned by checks are not included in responses, since the
+// endpoints are not authenticated.
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// DefaultTimeout is the default deadline for running the checks of an
+// endpoint.
+const DefaultTimeout = 5 * time.Second
+
+// Checker checks the health of a component.
+type Checker interface {
+	// Check returns an error if the component is unhealthy. It should return
+	// when ctx is done.
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc is a function implementing Checker.
+type CheckerFunc func(ctx context.Context) error
+
+// Check calls f(ctx).
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Checks are the health checks of a service.
+type Checks struct {
+	// Timeout is the deadline for running the checks of an endpoint. If zero,
+	// DefaultTimeout is used.
+	Timeout time.Duration
+
+	liveness  map[string]Checker
+	readiness map[string]Checker
+}
+
+// New creates Checks without any check, so both endpoints report the service
+// as healthy.
+func New() *Checks {
+	return &Checks{liveness: map[string]Checker{}, readiness: map[string]Checker{}}
+}
+
+// AddLiveness adds a check to /healthz. A failing liveness check should mean
+// that the service must be restarted, so they should not check dependencies.
+func (c *Checks) AddLiveness(name string, chk Checker) {
+	c.liveness[name] = chk
+}
+
+// AddReadiness adds a check to /readyz. A failing readiness check means that
+// the service can't serve requests, e.g. because a dependency is unreachable,
+// and shouldn't receive traffic.
+func (c *Checks) AddReadiness(name string, chk Checker) {
+	c.readiness[name] = chk
+}
+
+// Register registers the /healthz and /readyz endpoints on mux, for GET
+// requests. They don't run the interceptors installed on mux, but its
+// pre-filters still apply. /readyz also fails while mux is draining, when its
+// Server is shutting down.
+//
+// Register must be called after the checks have been added.
+func (c *Checks) Register(mux *safehttp.ServeMux) {
+	cfg := safehttp.NewServeMuxConfig(&safehttp.DefaultDispatcher{JSON: safehttp.JSONOptions{OmitXSSIPrefix: true}})
+	cfg.HandleErrors(safehttp.ErrorHandlerFunc(func(r *safehttp.IncomingRequest, resp safehttp.ErrorResponse) safehttp.Response {
+		if rep, ok := resp.(report); ok {
+			return safehttp.JSONResponse{Data: rep}
+		}
+		return nil
+	}))
+	sub := cfg.Mux()
+	sub.Handle("/healthz", safehttp.MethodGet, c.handler(c.liveness, nil))
+	sub.Handle("/readyz", safehttp.MethodGet, c.handler(c.readiness, mux.Draining))
+	mux.Mount("", sub)
+}
+
+// report is the response of an endpoint. It's also written as an error
+// response if a check fails.
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Code returns 503 Service Unavailable.
+func (report) Code() safehttp.StatusCode {
+	return safehttp.StatusServiceUnavailable
+}
+
+// handler returns a handler running the checks concurrently. If draining is
+// set and reports true, the handler fails without running the checks.
+func (c *Checks) handler(checks map[string]Checker, draining func() bool) safehttp.Handler {
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		w.Header().Set("Cache-Control", "no-store")
+		if draining != nil && draining() {
+			return w.WriteError(report{Status: "fail"})
+		}
+		timeout := c.Timeout
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		errs := make([]error, len(names))
+		var wg sync.WaitGroup
+		for i, name := range names {
+			i, chk := i, checks[name]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = chk.Check(ctx)
+			}()
+		}
+		wg.Wait()
+
+		rep := report{Status: "ok", Checks: make(map[string]string, len(names))}
+		for i, name := range names {
+			rep.Checks[name] = "ok"
+			if errs[i] != nil {
+				rep.Checks[name] = "fail"
+				rep.Status = "fail"
+			}
+		}
+		if rep.Status != "ok" {
+			return w.WriteError(rep)
+		}
+		return w.Write(safehttp.JSONResponse{Data: rep})
+	})
+}
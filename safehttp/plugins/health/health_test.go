@@ -0,0 +1,168 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/health"
+)
+
+var (
+	ok   = health.CheckerFunc(func(context.Context) error { return nil })
+	fail = health.CheckerFunc(func(context.Context) error { return errors.New("connection refused") })
+)
+
+// denyAll rejects all requests, like an authentication interceptor would for
+// unauthenticated probes.
+type denyAll struct{}
+
+func (denyAll) Before(w safehttp.ResponseWriter, _ *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+	return w.WriteError(safehttp.StatusUnauthorized)
+}
+
+func (denyAll) Commit(safehttp.ResponseHeadersWriter, *safehttp.IncomingRequest, safehttp.Response, safehttp.InterceptorConfig) {
+}
+
+func (denyAll) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
+
+func TestChecks(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		liveness   map[string]health.Checker
+		readiness  map[string]health.Checker
+		wantStatus safehttp.StatusCode
+		wantBody   string
+	}{
+		{
+			name:       "No checks",
+			path:       "/healthz",
+			wantStatus: safehttp.StatusOK,
+			wantBody:   `{"status":"ok"}` + "\n",
+		},
+		{
+			name:       "Liveness ok",
+			path:       "/healthz",
+			liveness:   map[string]health.Checker{"loop": ok},
+			readiness:  map[string]health.Checker{"db": fail},
+			wantStatus: safehttp.StatusOK,
+			wantBody:   `{"status":"ok","checks":{"loop":"ok"}}` + "\n",
+		},
+		{
+			name:       "Readiness ok",
+			path:       "/readyz",
+			readiness:  map[string]health.Checker{"db": ok, "cache": ok},
+			wantStatus: safehttp.StatusOK,
+			wantBody:   `{"status":"ok","checks":{"cache":"ok","db":"ok"}}` + "\n",
+		},
+		{
+			name:       "Readiness fail",
+			path:       "/readyz",
+			readiness:  map[string]health.Checker{"db": ok, "cache": fail},
+			wantStatus: safehttp.StatusServiceUnavailable,
+			wantBody:   `{"status":"fail","checks":{"cache":"fail","db":"ok"}}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := health.New()
+			for name, chk := range tt.liveness {
+				c.AddLiveness(name, chk)
+			}
+			for name, chk := range tt.readiness {
+				c.AddReadiness(name, chk)
+			}
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.Intercept(denyAll{})
+			mux := mb.Mux()
+			c.Register(mux)
+
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com"+tt.path, nil))
+
+			if rw.Code != int(tt.wantStatus) {
+				t.Errorf("rw.Code: got %v want %v", rw.Code, tt.wantStatus)
+			}
+			if got := rw.Body.String(); got != tt.wantBody {
+				t.Errorf("rw.Body: got %q want %q", got, tt.wantBody)
+			}
+			if got, want := rw.Header().Get("Cache-Control"), "no-store"; got != want {
+				t.Errorf(`rw.Header().Get("Cache-Control"): got %q want %q`, got, want)
+			}
+		})
+	}
+}
+
+func TestChecksTimeout(t *testing.T) {
+	c := health.New()
+	c.Timeout = 10 * time.Millisecond
+	c.AddReadiness("slow", health.CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	mux := safehttp.NewServeMuxConfig(nil).Mux()
+	c.Register(mux)
+
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com/readyz", nil))
+
+	if want := int(safehttp.StatusServiceUnavailable); rw.Code != want {
+		t.Errorf("rw.Code: got %v want %v", rw.Code, want)
+	}
+}
+
+func TestReadinessDraining(t *testing.T) {
+	c := health.New()
+	c.AddReadiness("db", ok)
+	mux := safehttp.NewServeMuxConfig(nil).Mux()
+	c.Register(mux)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	s := &safehttp.Server{Mux: mux}
+	go s.Serve(l)
+	resp, err := http.Get("http://" + l.Addr().String() + "/readyz")
+	if err != nil {
+		t.Fatalf("http.Get: %v", err)
+	}
+	resp.Body.Close()
+	if want := int(safehttp.StatusOK); resp.StatusCode != want {
+		t.Errorf("resp.StatusCode before Shutdown: got %v want %v", resp.StatusCode, want)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("s.Shutdown() got err: %v", err)
+	}
+
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com/readyz", nil))
+
+	if want := int(safehttp.StatusServiceUnavailable); rw.Code != want {
+		t.Errorf("rw.Code: got %v want %v", rw.Code, want)
+	}
+	if got, want := rw.Body.String(), `{"status":"fail"}`+"\n"; got != want {
+		t.Errorf("rw.Body: got %q want %q", got, want)
+	}
+}
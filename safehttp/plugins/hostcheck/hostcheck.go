@@ -21,21 +21,40 @@
 package hostcheck
 
 import (
+	"net"
+	"strings"
+
 	"github.com/google/go-safeweb/safehttp"
 )
 
 // Interceptor checks whether the Host header of the incoming request is in an
 // allowlist.
 type Interceptor struct {
-	hosts map[string]bool
+	// IgnorePort makes the Interceptor strip the port from the Host header
+	// before matching it against the allowlist.
+	IgnorePort bool
+	// HostFunc, if set, is called for hosts that are not in the allowlist and
+	// reports whether they should be allowed. This can be used for dynamic
+	// virtual host lookups.
+	HostFunc func(host string) bool
+
+	hosts     map[string]bool
+	wildcards []string
 }
 
 var _ safehttp.Interceptor = Interceptor{}
 
-// New creates an Interceptor.
+// New creates an Interceptor. Hosts are matched case-insensitively. A host of
+// the form "*.example.com" allows all subdomains of example.com, but not
+// example.com itself.
 func New(hosts ...string) Interceptor {
 	it := Interceptor{hosts: map[string]bool{}}
 	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if strings.HasPrefix(h, "*.") {
+			it.wildcards = append(it.wildcards, h[1:])
+			continue
+		}
 		it.hosts[h] = true
 	}
 	return it
@@ -44,12 +63,30 @@ func New(hosts ...string) Interceptor {
 // Before checks whether the request's Host header is in the list of allowed
 // hosts. If it's not, it responds with 404 Not Found.
 func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
-	if !it.hosts[r.Host()] {
+	if !it.allowed(r.Host()) {
 		return w.WriteError(safehttp.StatusNotFound)
 	}
 	return safehttp.NotWritten()
 }
 
+func (it Interceptor) allowed(host string) bool {
+	if it.IgnorePort {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	host = strings.ToLower(host)
+	if it.hosts[host] {
+		return true
+	}
+	for _, suffix := range it.wildcards {
+		if len(host) > len(suffix) && strings.HasSuffix(host, suffix) && !strings.ContainsAny(host[:len(host)-len(suffix)], ":[]") {
+			return true
+		}
+	}
+	return it.HostFunc != nil && it.HostFunc(host)
+}
+
 // Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
 func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
 }
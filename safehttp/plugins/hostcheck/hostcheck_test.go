@@ -62,3 +62,117 @@ func TestInterceptor(t *testing.T) {
 		})
 	}
 }
+
+func TestAllowedHosts(t *testing.T) {
+	var test = []struct {
+		name       string
+		it         func() hostcheck.Interceptor
+		host       string
+		wantStatus safehttp.StatusCode
+	}{
+		{
+			name:       "Case insensitive",
+			it:         func() hostcheck.Interceptor { return hostcheck.New("Foo.com") },
+			host:       "fOO.com",
+			wantStatus: safehttp.StatusOK,
+		},
+		{
+			name:       "Port not ignored",
+			it:         func() hostcheck.Interceptor { return hostcheck.New("foo.com") },
+			host:       "foo.com:8080",
+			wantStatus: safehttp.StatusNotFound,
+		},
+		{
+			name:       "Explicit port",
+			it:         func() hostcheck.Interceptor { return hostcheck.New("foo.com:8080") },
+			host:       "foo.com:8080",
+			wantStatus: safehttp.StatusOK,
+		},
+		{
+			name: "Port ignored",
+			it: func() hostcheck.Interceptor {
+				it := hostcheck.New("foo.com")
+				it.IgnorePort = true
+				return it
+			},
+			host:       "foo.com:8080",
+			wantStatus: safehttp.StatusOK,
+		},
+		{
+			name: "Port ignored IPv6",
+			it: func() hostcheck.Interceptor {
+				it := hostcheck.New("::1")
+				it.IgnorePort = true
+				return it
+			},
+			host:       "[::1]:8080",
+			wantStatus: safehttp.StatusOK,
+		},
+		{
+			name:       "Wildcard subdomain",
+			it:         func() hostcheck.Interceptor { return hostcheck.New("*.foo.com") },
+			host:       "a.b.foo.com",
+			wantStatus: safehttp.StatusOK,
+		},
+		{
+			name:       "Wildcard apex",
+			it:         func() hostcheck.Interceptor { return hostcheck.New("*.foo.com") },
+			host:       "foo.com",
+			wantStatus: safehttp.StatusNotFound,
+		},
+		{
+			name:       "Wildcard suffix only",
+			it:         func() hostcheck.Interceptor { return hostcheck.New("*.foo.com") },
+			host:       "evilfoo.com",
+			wantStatus: safehttp.StatusNotFound,
+		},
+		{
+			name:       "Wildcard with port",
+			it:         func() hostcheck.Interceptor { return hostcheck.New("*.foo.com") },
+			host:       "a.foo.com:8080",
+			wantStatus: safehttp.StatusNotFound,
+		},
+		{
+			name: "Callback",
+			it: func() hostcheck.Interceptor {
+				it := hostcheck.New("foo.com")
+				it.HostFunc = func(host string) bool { return host == "tenant.bar.com" }
+				return it
+			},
+			host:       "tenant.bar.com",
+			wantStatus: safehttp.StatusOK,
+		},
+		{
+			name: "Callback rejects",
+			it: func() hostcheck.Interceptor {
+				it := hostcheck.New("foo.com")
+				it.HostFunc = func(host string) bool { return host == "tenant.bar.com" }
+				return it
+			},
+			host:       "other.bar.com",
+			wantStatus: safehttp.StatusNotFound,
+		},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.Intercept(tt.it())
+			mux := mb.Mux()
+
+			h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return w.Write(safehtml.HTMLEscaped("<h1>Hello World!</h1>"))
+			})
+			mux.Handle("/", safehttp.MethodGet, h)
+
+			req := httptest.NewRequest(safehttp.MethodGet, "http://foo.com/", nil)
+			req.Host = tt.host
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, req)
+
+			if rw.Code != int(tt.wantStatus) {
+				t.Errorf("rw.Code: got %v want %v", rw.Code, tt.wantStatus)
+			}
+		})
+	}
+}
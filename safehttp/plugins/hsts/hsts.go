@@ -81,6 +81,15 @@ func Default() Interceptor {
 	return Interceptor{MaxAge: 63072000 * time.Second} // two years in seconds
 }
 
+// DefaultPreload creates a new HSTS interceptor which, in addition to the
+// defaults of Default, enables the preload directive. The resulting header
+// meets the requirements for submission to https://hstspreload.org/.
+func DefaultPreload() Interceptor {
+	it := Default()
+	it.Preload = true
+	return it
+}
+
 // Before should be executed before the request is sent to the handler.
 // The function redirects HTTP requests to HTTPS. When HTTPS traffic
 // is received the Strict-Transport-Security header is applied to the
@@ -103,6 +112,15 @@ func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequ
 		return safehttp.Redirect(w, r, u.String(), safehttp.StatusMovedPermanently)
 	}
 
+	set := w.Header().Claim("Strict-Transport-Security")
+	set([]string{it.StrictTransportSecurity()})
+	return safehttp.NotWritten()
+}
+
+// StrictTransportSecurity returns the value of the Strict-Transport-Security
+// header set by the interceptor. It also allows safehttp.Server to check that
+// the plugin is installed when redirecting plain HTTP requests to HTTPS.
+func (it Interceptor) StrictTransportSecurity() string {
 	var value strings.Builder
 	value.WriteString("max-age=")
 	value.WriteString(strconv.FormatInt(int64(it.MaxAge.Seconds()), 10))
@@ -112,9 +130,7 @@ func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequ
 	if it.Preload {
 		value.WriteString("; preload")
 	}
-	set := w.Header().Claim("Strict-Transport-Security")
-	set([]string{value.String()})
-	return safehttp.NotWritten()
+	return value.String()
 }
 
 // Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
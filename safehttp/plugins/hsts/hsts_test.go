@@ -40,6 +40,13 @@ func TestHSTSReject(t *testing.T) {
 			wantStatus:   safehttp.StatusMovedPermanently,
 			wantRedirect: "https://localhost/",
 		},
+		{
+			name:         "HTTP with path and query",
+			interceptor:  hsts.DefaultPreload(),
+			req:          safehttptest.NewRequest(safehttp.MethodPost, "http://localhost/pizza?topping=ham", nil),
+			wantStatus:   safehttp.StatusMovedPermanently,
+			wantRedirect: "https://localhost/pizza?topping=ham",
+		},
 		{
 			name:        "Negative MaxAge",
 			interceptor: hsts.Interceptor{MaxAge: -1 * time.Second},
@@ -88,6 +95,14 @@ func TestHSTSOK(t *testing.T) {
 				"Strict-Transport-Security": {"max-age=63072000; includeSubDomains"}, // 63072000 seconds is two years
 			},
 		},
+		{
+			name:        "Default preload",
+			interceptor: hsts.DefaultPreload(),
+			req:         safehttptest.NewRequest(safehttp.MethodGet, "https://localhost/", nil),
+			wantHeaders: map[string][]string{
+				"Strict-Transport-Security": {"max-age=63072000; includeSubDomains; preload"},
+			},
+		},
 		{
 			name:        "HTTP behind proxy",
 			interceptor: hsts.Interceptor{BehindProxy: true},
@@ -147,6 +162,10 @@ func TestHSTSOK(t *testing.T) {
 			if diff := cmp.Diff(tt.wantHeaders, map[string][]string(rr.Header())); diff != "" {
 				t.Errorf("rr.Header() mismatch (-want +got):\n%s", diff)
 			}
+
+			if got, want := tt.interceptor.StrictTransportSecurity(), tt.wantHeaders["Strict-Transport-Security"][0]; got != want {
+				t.Errorf("tt.interceptor.StrictTransportSecurity() got: %q want: %q", got, want)
+			}
 		})
 	}
 }
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"path/filepath"
 	"strings"
 
@@ -37,6 +38,10 @@ type Rule struct {
 	// WithAttributes is a filter applied on tags to decide whether to run the Rule:
 	// only tags with the given attributes key:value will be matched.
 	WithAttributes map[string]string
+	// Filter, if set, is an additional filter applied on tags to decide whether
+	// to run the Rule: only tags for which it returns true will be matched. The
+	// attribute names are lowercase.
+	Filter func(attributes map[string]string) bool
 	// AddAttributes is a list of strings to add to the HTML as attributes.
 	// All the given strings will be appended verbatim after the matched tag so they
 	// should be prefixed with a space.
@@ -44,6 +49,8 @@ type Rule struct {
 	// AddNodes is a list of nodes to append immediately after the opening tag that matched.
 	// This means that for elements that have a matching closing tag the added node will be
 	// a child node, for self-closing tags it will be a sibling.
+	// Nodes that are already present immediately after the opening tag are not
+	// added again, so transforming a template twice has no further effect.
 	AddNodes []string
 }
 
@@ -99,10 +106,55 @@ func XSRFTokens(inputTag string) TransformConfig {
 		AddNodes: []string{inputTag}}}
 }
 
+// XSRFTokensSameOriginDefault is like XSRFTokensDefault, but only adds the
+// hidden input to forms that are submitted with POST to the same origin.
+var XSRFTokensSameOriginDefault = XSRFTokensSameOrigin(`<input type="hidden" name="xsrf-token" value="{{` + XSRFTokensDefaultFuncName + `}}">`)
+
+// XSRFTokensSameOrigin constructs a Config to add the given string as a child
+// node to forms with method="post" whose action is missing or a relative URL.
+// This avoids sending the token to other origins, as well as exposing it in the
+// URL of GET forms. Forms with actions set through template actions are never
+// matched, as their origin can't be determined.
+//
+// Note that the button formaction and formmethod attributes are not taken into
+// account.
+func XSRFTokensSameOrigin(inputTag string) TransformConfig {
+	return TransformConfig{Rule{
+		Name:     "XSRFTokens on same-origin POST forms",
+		OnTag:    "form",
+		Filter:   sameOriginPost,
+		AddNodes: []string{inputTag}}}
+}
+
+// sameOriginPost reports whether a form with the given attributes is submitted
+// with POST to the same origin.
+func sameOriginPost(attributes map[string]string) bool {
+	if !strings.EqualFold(strings.TrimSpace(attributes["method"]), "post") {
+		return false
+	}
+	action := strings.TrimSpace(attributes["action"])
+	if action == "" {
+		return true
+	}
+	if strings.Contains(action, "{{") {
+		return false
+	}
+	// Browsers treat backslashes like slashes, e.g. /\example.com is a
+	// protocol-relative URL.
+	if strings.HasPrefix(strings.ReplaceAll(action, `\`, "/"), "//") {
+		return false
+	}
+	u, err := url.Parse(action)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" && u.Host == ""
+}
+
 // Transform rewrites the given template according to the given configs.
 // If the passed io.Rewriter has a `Size() int64` method it will be used to pre-allocate buffers.
 func Transform(src io.Reader, cfg ...TransformConfig) (string, error) {
-	rw := rewriter{
+	rw := &rewriter{
 		rules:     map[string][]Rule{},
 		tokenizer: html.NewTokenizer(src),
 		out:       &strings.Builder{},
@@ -127,6 +179,9 @@ type LoadConfig struct {
 	DisableCSP bool
 	// DisableXSRF disables XSRF token injection
 	DisableXSRF bool
+	// XSRFSameOriginOnly only injects XSRF tokens in forms submitted with POST
+	// to the same origin (see XSRFTokensSameOrigin) instead of in all forms.
+	XSRFSameOriginOnly bool
 }
 
 // LoadTrustedTemplate processes the given TrustedTemplate with the specified default configurations and
@@ -143,7 +198,11 @@ func LoadTrustedTemplate(tpl *template.Template, lcfg LoadConfig, src template.T
 		funcMap[CSPNoncesDefaultFuncName] = noop
 	}
 	if !lcfg.DisableXSRF {
-		cfg = append(cfg, XSRFTokensDefault)
+		if lcfg.XSRFSameOriginOnly {
+			cfg = append(cfg, XSRFTokensSameOriginDefault)
+		} else {
+			cfg = append(cfg, XSRFTokensDefault)
+		}
 		funcMap[XSRFTokensDefaultFuncName] = noop
 	}
 	got, err := Transform(strings.NewReader(src.String()), cfg...)
@@ -212,18 +271,45 @@ type rewriter struct {
 	rules     map[string][]Rule
 	tokenizer *html.Tokenizer
 	out       *strings.Builder
+	// pending are the nodes to be added after the last opening tag, unless they
+	// are already there.
+	pending []string
 }
 
 // emitRaw copies the current raw token to the output.
-func (r rewriter) emitRaw() error {
+func (r *rewriter) emitRaw() error {
 	_, err := r.out.Write(r.tokenizer.Raw())
 	return err
 }
 
+// emitPending writes the pending nodes to the output, except for the ones that
+// are already present at the current position.
+func (r *rewriter) emitPending() error {
+	if len(r.pending) == 0 {
+		return nil
+	}
+	if r.pending[0] == string(r.tokenizer.Raw()) {
+		// The node is already there: it will be copied as part of the input.
+		r.pending = r.pending[1:]
+		return nil
+	}
+	for _, node := range r.pending {
+		if _, err := r.out.WriteString(node); err != nil {
+			return fmt.Errorf("adding nodes: %w", err)
+		}
+	}
+	r.pending = nil
+	return nil
+}
+
 // rewrite runs the rewriter.
-func (r rewriter) rewrite() error {
+func (r *rewriter) rewrite() error {
 	for {
-		switch tkn := r.tokenizer.Next(); tkn {
+		tkn := r.tokenizer.Next()
+		if err := r.emitPending(); err != nil {
+			return err
+		}
+		switch tkn {
 		case html.ErrorToken:
 			if err := r.tokenizer.Err(); !errors.Is(err, io.EOF) {
 				return err
@@ -242,7 +328,7 @@ func (r rewriter) rewrite() error {
 	}
 }
 
-func (r rewriter) processTag() error {
+func (r *rewriter) processTag() error {
 	// Copy raw tokens to better formats
 	var (
 		tagname    string
@@ -271,6 +357,9 @@ func (r rewriter) processTag() error {
 					break
 				}
 			}
+			if match && r.Filter != nil {
+				match = r.Filter(attributes)
+			}
 			if match {
 				triggeredRules = append(triggeredRules, r)
 			}
@@ -296,13 +385,10 @@ func (r rewriter) processTag() error {
 		if _, err := r.out.Write(raw[attrPos:]); err != nil {
 			return fmt.Errorf("copying end of tag: %w", err)
 		}
-		// Write the nodes we have to add
+		// Queue the nodes we have to add, they are written before the next
+		// token unless they are already there.
 		for _, rule := range triggeredRules {
-			for _, node := range rule.AddNodes {
-				if _, err := r.out.WriteString(node); err != nil {
-					return fmt.Errorf("executing rule %q: %w", rule.Name, err)
-				}
-			}
+			r.pending = append(r.pending, rule.AddNodes...)
 		}
 	}
 	return nil
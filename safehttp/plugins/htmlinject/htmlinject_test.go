@@ -262,6 +262,87 @@ func TestTransform(t *testing.T) {
 	}
 }
 
+func TestTransformXSRFSameOrigin(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "single form",
+			in:   `<form method="post"><input name="a"></form>`,
+			want: `<form method="post"><input type="hidden" name="xsrf-token" value="{{XSRFToken}}"><input name="a"></form>`,
+		},
+		{
+			name: "multiple forms",
+			in: `<form method=POST action="/submit"><input name="a"></form>
+<form method="post" action="edit?id=1"></form>`,
+			want: `<form method=POST action="/submit"><input type="hidden" name="xsrf-token" value="{{XSRFToken}}"><input name="a"></form>
+<form method="post" action="edit?id=1"><input type="hidden" name="xsrf-token" value="{{XSRFToken}}"></form>`,
+		},
+		{
+			name: "external origins",
+			in: `<form method="post" action="https://example.com/submit"></form>
+<form method="post" action="//example.com/submit"></form>
+<form method="post" action="/\example.com/submit"></form>
+<form method="post" action="{{.Action}}"></form>`,
+			want: `<form method="post" action="https://example.com/submit"></form>
+<form method="post" action="//example.com/submit"></form>
+<form method="post" action="/\example.com/submit"></form>
+<form method="post" action="{{.Action}}"></form>`,
+		},
+		{
+			name: "GET forms",
+			in: `<form><input name="q"></form>
+<form method="get" action="/search"></form>`,
+			want: `<form><input name="q"></form>
+<form method="get" action="/search"></form>`,
+		},
+		{
+			name: "token already present",
+			in:   `<form method="post"><input type="hidden" name="xsrf-token" value="{{XSRFToken}}"><input name="a"></form>`,
+			want: `<form method="post"><input type="hidden" name="xsrf-token" value="{{XSRFToken}}"><input name="a"></form>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Transform(strings.NewReader(tt.in), XSRFTokensSameOriginDefault)
+			if err != nil {
+				t.Fatalf("Transform: got err %q, didn't want one", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("-want +got %s", diff)
+			}
+			// Transforming again must not change the result.
+			got, err = Transform(strings.NewReader(got), XSRFTokensSameOriginDefault)
+			if err != nil {
+				t.Fatalf("Transform: got err %q, didn't want one", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("second Transform: -want +got %s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadTrustedTemplateXSRFSameOriginOnly(t *testing.T) {
+	src := `<form method="post"></form><form method="post" action="https://example.com"></form>`
+	tpl, err := LoadTrustedTemplate(nil, LoadConfig{XSRFSameOriginOnly: true}, uncheckedconversions.TrustedTemplateFromStringKnownToSatisfyTypeContract(src))
+	if err != nil {
+		t.Fatalf("LoadTrustedTemplate: got err %q", err)
+	}
+	var sb strings.Builder
+	err = tpl.Funcs(map[string]interface{}{
+		XSRFTokensDefaultFuncName: func() string { return "secret" },
+		CSPNoncesDefaultFuncName:  func() string { return "nonce" },
+	}).Execute(&sb, nil)
+	if err != nil {
+		t.Fatalf("Execute: got err %q", err)
+	}
+	want := `<form method="post"><input type="hidden" name="xsrf-token" value="secret"></form><form method="post" action="https://example.com"></form>`
+	if diff := cmp.Diff(want, sb.String()); diff != "" {
+		t.Errorf("-want +got %s", diff)
+	}
+}
+
 func TestLoadTrustedTemplateWithDefaultConfig(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,153 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpmiddleware provides a plugin running net/http middleware, i.e.
+// functions of type func(http.Handler) http.Handler, as a safehttp
+// Interceptor. It's meant to ease the migration of existing applications to
+// safehttp, one middleware at a time, and should not be used in new code.
+//
+// The middleware has access to the raw *http.Request, so its usage should be
+// security reviewed. Only middleware that inspect the request, annotate its
+// context, set response headers or reject it are supported:
+//  - the middleware runs before the handler. The handler it wraps returns
+//    immediately, so the middleware can't observe or transform the response,
+//    e.g. to compress it or to log its status code,
+//  - the response headers set by the middleware must be declared, so that
+//    they are claimed by the Interceptor and other interceptors can't
+//    overwrite them. Undeclared headers make the request fail with 500
+//    Internal Server Error, as does an attempt to set cookies,
+//  - if the middleware doesn't call the handler it wraps, its response body
+//    is discarded. Redirects are written as safehttp.RedirectResponse, so the
+//    Location header must not be controlled by the client; 4xx and 5xx
+//    responses are written as errors and other responses as 500 Internal
+//    Server Error,
+//  - changes to the request, other than its context, are not seen by the
+//    handler. The context the middleware passed to the handler is returned by
+//    Context.
+package httpmiddleware
+
+import (
+	"context"
+	"net/http"
+	"net/textproto"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/restricted"
+)
+
+// Interceptor runs a net/http middleware before the handler.
+type Interceptor struct {
+	middleware func(http.Handler) http.Handler
+	headers    []string
+}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+// New creates an Interceptor running the middleware, which is allowed to set
+// the given response headers.
+//
+// The headers are claimed for every request, so installing another
+// interceptor claiming one of them panics.
+func New(middleware func(http.Handler) http.Handler, headers ...string) Interceptor {
+	it := Interceptor{middleware: middleware}
+	for _, h := range headers {
+		it.headers = append(it.headers, textproto.CanonicalMIMEHeaderKey(h))
+	}
+	return it
+}
+
+type ctxKey struct{}
+
+// Context returns the context the last middleware run by an Interceptor
+// passed to the handler, with the values the middleware added to it, e.g. the
+// identity of the user. If no middleware ran, it returns r.Context().
+func Context(r *safehttp.IncomingRequest) context.Context {
+	if fv := safehttp.FlightValues(r.Context()); fv != nil {
+		if ctx, ok := fv.Get(ctxKey{}).(context.Context); ok {
+			return ctx
+		}
+	}
+	return r.Context()
+}
+
+// Before runs the middleware, applies the response headers it set and, if it
+// didn't call the handler it wraps, writes its response.
+func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+	setters := make(map[string]func([]string), len(it.headers))
+	for _, h := range it.headers {
+		setters[h] = w.Header().Claim(h)
+	}
+
+	rec := &recorder{header: http.Header{}}
+	var next *http.Request
+	it.middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		next = r
+	})).ServeHTTP(rec, restricted.RawRequest(r).WithContext(Context(r)))
+
+	for name, v := range rec.header {
+		if set, ok := setters[name]; ok {
+			set(v)
+		} else if next != nil {
+			// The middleware may rely on the header, e.g. a security header, so
+			// the response can't be written without it.
+			return w.WriteError(safehttp.StatusInternalServerError)
+		}
+	}
+
+	if next == nil {
+		switch {
+		case rec.code >= 300 && rec.code < 400 && rec.header.Get("Location") != "":
+			return safehttp.Redirect(w, r, rec.header.Get("Location"), safehttp.StatusCode(rec.code))
+		case rec.code >= 400:
+			return w.WriteError(safehttp.StatusCode(rec.code))
+		default:
+			return w.WriteError(safehttp.StatusInternalServerError)
+		}
+	}
+	if fv := safehttp.FlightValues(r.Context()); fv != nil {
+		fv.Put(ctxKey{}, next.Context())
+	}
+	return safehttp.NotWritten()
+}
+
+// Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
+}
+
+// Match returns false since there are no supported configurations.
+func (Interceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
+
+// recorder is the http.ResponseWriter passed to the middleware. It records the
+// headers and the status code and discards the body.
+type recorder struct {
+	header http.Header
+	code   int
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	if rec.code == 0 {
+		rec.code = code
+	}
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return len(b), nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpmiddleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/httpmiddleware"
+	"github.com/google/safehtml"
+)
+
+type ctxKey string
+
+func TestInterceptor(t *testing.T) {
+	tests := []struct {
+		name         string
+		middleware   func(http.Handler) http.Handler
+		headers      []string
+		wantStatus   safehttp.StatusCode
+		wantHeaders  map[string]string
+		wantBody     string
+		wantLocation string
+	}{
+		{
+			name: "Pass through",
+			middleware: func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					next.ServeHTTP(w, r)
+				})
+			},
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "hello",
+		},
+		{
+			name: "Declared header",
+			middleware: func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("X-Legacy", "1")
+					next.ServeHTTP(w, r)
+				})
+			},
+			headers:     []string{"x-legacy"},
+			wantStatus:  safehttp.StatusOK,
+			wantHeaders: map[string]string{"X-Legacy": "1"},
+			wantBody:    "hello",
+		},
+		{
+			name: "Undeclared header",
+			middleware: func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("X-Legacy", "1")
+					next.ServeHTTP(w, r)
+				})
+			},
+			wantStatus: safehttp.StatusInternalServerError,
+		},
+		{
+			name: "Cookie",
+			middleware: func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					http.SetCookie(w, &http.Cookie{Name: "session", Value: "a"})
+					next.ServeHTTP(w, r)
+				})
+			},
+			wantStatus: safehttp.StatusInternalServerError,
+		},
+		{
+			name: "Rejected",
+			middleware: func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("WWW-Authenticate", "Basic")
+					http.Error(w, "<script>alert(1)</script>", http.StatusUnauthorized)
+				})
+			},
+			headers:     []string{"WWW-Authenticate"},
+			wantStatus:  safehttp.StatusUnauthorized,
+			wantHeaders: map[string]string{"Www-Authenticate": "Basic"},
+			wantBody:    "Unauthorized\n",
+		},
+		{
+			name: "Redirect",
+			middleware: func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					http.Redirect(w, r, "/login", http.StatusFound)
+				})
+			},
+			wantStatus:   safehttp.StatusFound,
+			wantLocation: "/login",
+		},
+		{
+			name: "Written response",
+			middleware: func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte("<script>alert(1)</script>"))
+				})
+			},
+			wantStatus: safehttp.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.Intercept(httpmiddleware.New(tt.middleware, tt.headers...))
+			mux := mb.Mux()
+			mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return w.Write(safehtml.HTMLEscaped("hello"))
+			}))
+
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com/", nil))
+
+			if rw.Code != int(tt.wantStatus) {
+				t.Errorf("rw.Code: got %v want %v", rw.Code, tt.wantStatus)
+			}
+			for name, want := range tt.wantHeaders {
+				if got := rw.Header().Get(name); got != want {
+					t.Errorf("rw.Header().Get(%q): got %q want %q", name, got, want)
+				}
+			}
+			if got := rw.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf(`rw.Header().Get("Location"): got %q want %q`, got, tt.wantLocation)
+			}
+			if tt.wantBody != "" && rw.Body.String() != tt.wantBody {
+				t.Errorf("rw.Body: got %q want %q", rw.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	withValue := func(key ctxKey, value string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), key, value)))
+			})
+		}
+	}
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(httpmiddleware.New(withValue("user", "alice")))
+	mb.Intercept(httpmiddleware.New(withValue("role", "admin")))
+	mux := mb.Mux()
+	var user, role interface{}
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		ctx := httpmiddleware.Context(r)
+		user, role = ctx.Value(ctxKey("user")), ctx.Value(ctxKey("role"))
+		return w.Write(safehtml.HTMLEscaped("hello"))
+	}))
+
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com/", nil))
+
+	if user != "alice" || role != "admin" {
+		t.Errorf("context values: got user %v, role %v, want alice, admin", user, role)
+	}
+}
+
+func TestClaimConflict(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("claiming a header claimed by another interceptor: got no panic")
+		}
+	}()
+	mw := func(next http.Handler) http.Handler { return next }
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(httpmiddleware.New(mw, "X-Frame-Options"))
+	mb.Intercept(httpmiddleware.New(mw, "X-Frame-Options"))
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehtml.HTMLEscaped("hello"))
+	}))
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(safehttp.MethodGet, "http://foo.com/", nil))
+}
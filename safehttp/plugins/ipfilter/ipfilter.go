@@ -0,0 +1,123 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ipfilter provides a plugin that allows or denies requests based on
+// the IP address of the client.
+//
+// The client address is determined with IncomingRequest.ClientIP, so
+// forwarding headers are only trusted when the request comes through one of
+// the proxies trusted with safehttp.ServeMuxConfig.TrustProxies.
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// Interceptor allows or denies requests based on the IP address of the
+// client.
+type Interceptor struct {
+	// OnDeny, if set, is called for denied requests, e.g. for auditing. The
+	// IP is nil if the client address couldn't be determined.
+	OnDeny func(r *safehttp.IncomingRequest, ip net.IP)
+
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+// New creates an Interceptor. Both lists contain CIDR ranges, e.g.
+// "10.0.0.0/8", or single IP addresses. Addresses in deny are always denied.
+// If allow is not empty, only the addresses in it are allowed, otherwise all
+// the addresses not in deny are.
+func New(allow, deny []string) (Interceptor, error) {
+	a, err := parseNets(allow)
+	if err != nil {
+		return Interceptor{}, err
+	}
+	d, err := parseNets(deny)
+	if err != nil {
+		return Interceptor{}, err
+	}
+	return Interceptor{allow: a, deny: d}, nil
+}
+
+func parseNets(ranges []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, r := range ranges {
+		if !strings.Contains(r, "/") {
+			ip := net.ParseIP(r)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", r)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q: %v", r, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Before responds with 403 Forbidden if the client address is denied or
+// can't be determined.
+func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+	ip, err := r.ClientIP()
+	if err != nil {
+		ip = nil
+	}
+	if ip == nil || !it.allowed(ip) {
+		if it.OnDeny != nil {
+			it.OnDeny(r, ip)
+		}
+		return w.WriteError(safehttp.StatusForbidden)
+	}
+	return safehttp.NotWritten()
+}
+
+func (it Interceptor) allowed(ip net.IP) bool {
+	if contains(it.deny, ip) {
+		return false
+	}
+	return len(it.allow) == 0 || contains(it.allow, ip)
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
+}
+
+// Match returns false since there are no supported configurations.
+func (Interceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
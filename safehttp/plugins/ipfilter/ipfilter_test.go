@@ -0,0 +1,105 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipfilter_test
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/ipfilter"
+	"github.com/google/go-safeweb/safehttp/safehttptest"
+)
+
+func TestInterceptor(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	tests := []struct {
+		name        string
+		allow, deny []string
+		remoteAddr  string
+		xff         string
+		wantStatus  safehttp.StatusCode
+	}{
+		{name: "No lists", remoteAddr: "192.0.2.1:1234", wantStatus: safehttp.StatusNoContent},
+		{name: "Allowed range", allow: []string{"192.0.2.0/24"}, remoteAddr: "192.0.2.1:1234", wantStatus: safehttp.StatusNoContent},
+		{name: "Not allowed", allow: []string{"192.0.2.0/24"}, remoteAddr: "198.51.100.1:1234", wantStatus: safehttp.StatusForbidden},
+		{name: "Allowed address", allow: []string{"198.51.100.1"}, remoteAddr: "198.51.100.1:1234", wantStatus: safehttp.StatusNoContent},
+		{name: "Denied range", deny: []string{"192.0.2.0/24"}, remoteAddr: "192.0.2.1:1234", wantStatus: safehttp.StatusForbidden},
+		{name: "Deny overrides allow", allow: []string{"192.0.2.0/24"}, deny: []string{"192.0.2.1"}, remoteAddr: "192.0.2.1:1234", wantStatus: safehttp.StatusForbidden},
+		{name: "IPv6", allow: []string{"2001:db8::/32"}, remoteAddr: "[2001:db8::1]:1234", wantStatus: safehttp.StatusNoContent},
+		{name: "IPv4-mapped IPv6", deny: []string{"192.0.2.1"}, remoteAddr: "[::ffff:192.0.2.1]:1234", wantStatus: safehttp.StatusForbidden},
+		{name: "Forwarded through trusted proxy", allow: []string{"192.0.2.0/24"}, remoteAddr: "10.0.0.1:1234", xff: "192.0.2.1", wantStatus: safehttp.StatusNoContent},
+		{name: "Spoofed forwarded", allow: []string{"192.0.2.0/24"}, remoteAddr: "198.51.100.1:1234", xff: "192.0.2.1", wantStatus: safehttp.StatusForbidden},
+		{name: "Denied behind trusted proxy", deny: []string{"198.51.100.0/24"}, remoteAddr: "10.0.0.1:1234", xff: "198.51.100.1, 10.0.0.2", wantStatus: safehttp.StatusForbidden},
+		{name: "Invalid forwarded", remoteAddr: "10.0.0.1:1234", xff: "garbage", wantStatus: safehttp.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it, err := ipfilter.New(tt.allow, tt.deny)
+			if err != nil {
+				t.Fatalf("ipfilter.New() got err: %v", err)
+			}
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.TrustProxies(safehttp.ProxyPolicy{TrustedProxies: []*net.IPNet{proxies}})
+			mb.Intercept(it)
+			m := mb.Mux()
+			m.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return w.Write(safehttp.NoContentResponse{})
+			}))
+
+			req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			rr := httptest.NewRecorder()
+			m.ServeHTTP(rr, req)
+
+			if got, want := rr.Code, int(tt.wantStatus); got != want {
+				t.Errorf("rr.Code: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestInterceptorOnDeny(t *testing.T) {
+	it, err := ipfilter.New(nil, []string{"192.0.2.0/24"})
+	if err != nil {
+		t.Fatalf("ipfilter.New() got err: %v", err)
+	}
+	var denied []string
+	it.OnDeny = func(r *safehttp.IncomingRequest, ip net.IP) {
+		denied = append(denied, ip.String())
+	}
+	for _, addr := range []string{"192.0.2.7:1234", "198.51.100.1:1234", "invalid"} {
+		req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+		req.RemoteAddr = addr
+		fakeRW, _ := safehttptest.NewFakeResponseWriter()
+		it.Before(fakeRW, safehttp.NewIncomingRequest(req), nil)
+	}
+
+	if len(denied) != 2 || denied[0] != "192.0.2.7" || denied[1] != "<nil>" {
+		t.Errorf("OnDeny calls: got %q, want [192.0.2.7 <nil>]", denied)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, r := range []string{"192.0.2.0/33", "not an ip", "192.0.2.300"} {
+		if _, err := ipfilter.New([]string{r}, nil); err == nil {
+			t.Errorf("ipfilter.New(%q): got nil err, want error", r)
+		}
+	}
+}
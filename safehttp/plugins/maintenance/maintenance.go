@@ -0,0 +1,134 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package maintenance provides a safehttp.Interceptor that rejects requests
+// with 503 Service Unavailable while maintenance mode is on. Maintenance mode
+// can be toggled at runtime, e.g. from an admin handler or a signal handler,
+// to drain or freeze an application without redeploying it.
+package maintenance
+
+import (
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// Switch is a maintenance mode flag that can be toggled concurrently. The
+// zero value is off.
+type Switch struct {
+	on int32
+}
+
+// Enable turns maintenance mode on.
+func (s *Switch) Enable() {
+	atomic.StoreInt32(&s.on, 1)
+}
+
+// Disable turns maintenance mode off.
+func (s *Switch) Disable() {
+	atomic.StoreInt32(&s.on, 0)
+}
+
+// Enabled reports whether maintenance mode is on.
+func (s *Switch) Enabled() bool {
+	return atomic.LoadInt32(&s.on) == 1
+}
+
+// Error is the error response written while maintenance mode is on. An
+// ErrorHandler installed with safehttp.ServeMuxConfig.HandleErrors can use its
+// Message to render a branded page.
+type Error struct {
+	// Message describes the maintenance to users, e.g. its expected end.
+	Message string
+}
+
+// Code returns 503 Service Unavailable.
+func (Error) Code() safehttp.StatusCode {
+	return safehttp.StatusServiceUnavailable
+}
+
+// Interceptor rejects requests while maintenance mode is on.
+type Interceptor struct {
+	// Enabled reports whether maintenance mode is on for the request. If nil,
+	// only handlers with the WithSwitch configuration can be in maintenance.
+	Enabled func(r *safehttp.IncomingRequest) bool
+	// RetryAfter, if positive, is sent in the Retry-After header of the
+	// responses to tell clients when to retry.
+	RetryAfter time.Duration
+	// Message is the Message of the Error responses.
+	Message string
+}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+// New creates an Interceptor whose maintenance mode is toggled by s.
+func New(s *Switch) Interceptor {
+	return Interceptor{Enabled: func(*safehttp.IncomingRequest) bool { return s.Enabled() }}
+}
+
+type routeSwitch struct {
+	s *Switch
+}
+
+// WithSwitch returns a configuration putting the handler in maintenance when
+// s is on, instead of when the Interceptor is. It can be used to put selected
+// routes, e.g. a RouteGroup, in maintenance.
+func WithSwitch(s *Switch) safehttp.InterceptorConfig {
+	return routeSwitch{s: s}
+}
+
+type exempt struct{}
+
+// Exempt returns a configuration keeping the handler available during
+// maintenance, e.g. for health checks or admin handlers that toggle
+// maintenance mode.
+func Exempt(reason string) safehttp.InterceptorConfig {
+	return exempt{}
+}
+
+// Before rejects the request with an Error if maintenance mode is on.
+func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	var on bool
+	switch c := cfg.(type) {
+	case exempt:
+		return safehttp.NotWritten()
+	case routeSwitch:
+		on = c.s.Enabled()
+	default:
+		on = it.Enabled != nil && it.Enabled(r)
+	}
+	if !on {
+		return safehttp.NotWritten()
+	}
+	if it.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(it.RetryAfter.Seconds()))))
+	}
+	return w.WriteError(Error{Message: it.Message})
+}
+
+// Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+}
+
+// Match recognizes the WithSwitch and Exempt configurations.
+func (Interceptor) Match(cfg safehttp.InterceptorConfig) bool {
+	switch cfg.(type) {
+	case routeSwitch, exempt:
+		return true
+	}
+	return false
+}
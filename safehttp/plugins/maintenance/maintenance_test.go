@@ -0,0 +1,118 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maintenance_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/maintenance"
+	"github.com/google/safehtml"
+)
+
+func TestInterceptor(t *testing.T) {
+	var global, admin maintenance.Switch
+	it := maintenance.New(&global)
+	it.RetryAfter = 90 * time.Second
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(it)
+	mux := mb.Mux()
+
+	h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehtml.HTMLEscaped("hello"))
+	})
+	mux.Handle("/", safehttp.MethodGet, h)
+	mux.Handle("/healthz", safehttp.MethodGet, h, maintenance.Exempt("probes"))
+	mux.Handle("/admin", safehttp.MethodGet, h, maintenance.WithSwitch(&admin))
+
+	tests := []struct {
+		name   string
+		global bool
+		admin  bool
+		want   map[string]safehttp.StatusCode
+	}{
+		{
+			name: "Off",
+			want: map[string]safehttp.StatusCode{"/": safehttp.StatusOK, "/healthz": safehttp.StatusOK, "/admin": safehttp.StatusOK},
+		},
+		{
+			name:   "Global",
+			global: true,
+			want:   map[string]safehttp.StatusCode{"/": safehttp.StatusServiceUnavailable, "/healthz": safehttp.StatusOK, "/admin": safehttp.StatusOK},
+		},
+		{
+			name:  "Selected routes",
+			admin: true,
+			want:  map[string]safehttp.StatusCode{"/": safehttp.StatusOK, "/healthz": safehttp.StatusOK, "/admin": safehttp.StatusServiceUnavailable},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			global.Disable()
+			admin.Disable()
+			if tt.global {
+				global.Enable()
+			}
+			if tt.admin {
+				admin.Enable()
+			}
+			for path, want := range tt.want {
+				rw := httptest.NewRecorder()
+				mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com"+path, nil))
+				if rw.Code != int(want) {
+					t.Errorf("%s: rw.Code: got %v want %v", path, rw.Code, want)
+				}
+				wantRetry := ""
+				if want == safehttp.StatusServiceUnavailable {
+					wantRetry = "90"
+				}
+				if got := rw.Header().Get("Retry-After"); got != wantRetry {
+					t.Errorf("%s: Retry-After: got %q want %q", path, got, wantRetry)
+				}
+			}
+		})
+	}
+}
+
+func TestInterceptorBrandedError(t *testing.T) {
+	var s maintenance.Switch
+	s.Enable()
+	it := maintenance.New(&s)
+	it.Message = "Back at 10:00 UTC"
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(it)
+	mb.HandleErrors(safehttp.ErrorHandlerFunc(func(r *safehttp.IncomingRequest, resp safehttp.ErrorResponse) safehttp.Response {
+		if e, ok := resp.(maintenance.Error); ok {
+			return safehtml.HTMLEscaped("Maintenance: " + e.Message)
+		}
+		return nil
+	}))
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		t.Error("handler called during maintenance")
+		return safehttp.NotWritten()
+	}))
+
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(safehttp.MethodGet, "http://foo.com/", nil))
+	if want := safehttp.StatusServiceUnavailable; rw.Code != int(want) {
+		t.Errorf("rw.Code: got %v want %v", rw.Code, want)
+	}
+	if got, want := rw.Body.String(), "Maintenance: Back at 10:00 UTC"; got != want {
+		t.Errorf("rw.Body.String(): got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics provides a safehttp.Interceptor collecting request metrics,
+// and a handler exposing them in the Prometheus text format.
+//
+// The following metrics are collected, labeled by route pattern, method and,
+// except for the in-flight gauge, status class (e.g. "2xx"):
+//   - <namespace>_requests_total, a counter of requests,
+//   - <namespace>_request_duration_seconds, a histogram of request latencies,
+//   - <namespace>_response_size_bytes, a histogram of response body sizes,
+//   - <namespace>_requests_in_flight, a gauge of requests being processed.
+//
+// Labels use route patterns, e.g. "/users/{id}", rather than paths, so that
+// the number of series is bounded.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// DefaultNamespace is the default prefix of metric names.
+const DefaultNamespace = "safehttp"
+
+// DefaultLatencyBuckets are the default upper bounds of the latency
+// histogram buckets, in seconds.
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets are the default upper bounds of the response size
+// histogram buckets, in bytes.
+var DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1e6, 1e7}
+
+// Config configures Metrics.
+type Config struct {
+	// Namespace is the prefix of metric names. If empty, DefaultNamespace is
+	// used.
+	Namespace string
+	// LatencyBuckets are the upper bounds of the latency histogram buckets, in
+	// seconds. If nil, DefaultLatencyBuckets are used.
+	LatencyBuckets []float64
+	// SizeBuckets are the upper bounds of the response size histogram buckets,
+	// in bytes. If nil, DefaultSizeBuckets are used.
+	SizeBuckets []float64
+}
+
+// labels identify a series.
+type labels struct {
+	route, method, status string
+}
+
+func (l labels) String() string {
+	s := `method="` + escape(l.method) + `",route="` + escape(l.route) + `"`
+	if l.status != "" {
+		s += `,status="` + l.status + `"`
+	}
+	return s
+}
+
+func escape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative; the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(bounds []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(bounds)+1)
+	}
+	h.counts[sort.SearchFloat64s(bounds, v)]++
+	h.sum += v
+	h.count++
+}
+
+// Metrics collects request metrics. It's a safehttp.Interceptor, which should
+// be installed before any interceptor that can reject requests so that they
+// are taken into account in the in-flight gauge.
+//
+// Metrics of requests whose processing ends with an unrecovered panic are not
+// collected, and they are counted as in flight forever. Use
+// safehttp.ServeMuxConfig.RecoverPanics to avoid this.
+type Metrics struct {
+	ns             string
+	latencyBuckets []float64
+	sizeBuckets    []float64
+
+	mu       sync.Mutex
+	requests map[labels]uint64
+	latency  map[labels]*histogram
+	size     map[labels]*histogram
+	inFlight map[labels]int64
+}
+
+var (
+	_ safehttp.Interceptor = &Metrics{}
+	_ safehttp.Observer    = &Metrics{}
+)
+
+// New creates Metrics. It panics if the buckets are not sorted in increasing
+// order.
+func New(cfg Config) *Metrics {
+	m := &Metrics{
+		ns:             cfg.Namespace,
+		latencyBuckets: cfg.LatencyBuckets,
+		sizeBuckets:    cfg.SizeBuckets,
+		requests:       make(map[labels]uint64),
+		latency:        make(map[labels]*histogram),
+		size:           make(map[labels]*histogram),
+		inFlight:       make(map[labels]int64),
+	}
+	if m.ns == "" {
+		m.ns = DefaultNamespace
+	}
+	if m.latencyBuckets == nil {
+		m.latencyBuckets = DefaultLatencyBuckets
+	}
+	if m.sizeBuckets == nil {
+		m.sizeBuckets = DefaultSizeBuckets
+	}
+	for _, b := range [][]float64{m.latencyBuckets, m.sizeBuckets} {
+		if !sort.Float64sAreSorted(b) {
+			panic(fmt.Sprintf("cannot use unsorted buckets %v", b))
+		}
+	}
+	return m
+}
+
+type inFlightKey struct{}
+
+// Before counts the request as in flight.
+func (m *Metrics) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+	l := labels{route: r.Pattern(), method: r.Method()}
+	m.mu.Lock()
+	m.inFlight[l]++
+	m.mu.Unlock()
+	safehttp.FlightValues(r.Context()).Put(inFlightKey{}, true)
+	return safehttp.NotWritten()
+}
+
+// Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (m *Metrics) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
+}
+
+// Match returns false since there are no supported configurations.
+func (m *Metrics) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
+
+// Observe records the metrics of the request.
+func (m *Metrics) Observe(r *safehttp.IncomingRequest, info safehttp.ResponseInfo, _ safehttp.InterceptorConfig) {
+	l := labels{route: r.Pattern(), method: r.Method()}
+	counted := safehttp.FlightValues(r.Context()).Get(inFlightKey{}) != nil
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if counted {
+		m.inFlight[l]--
+	}
+	l.status = strconv.Itoa(int(info.Code)/100) + "xx"
+	m.requests[l]++
+	if m.latency[l] == nil {
+		m.latency[l] = &histogram{}
+		m.size[l] = &histogram{}
+	}
+	m.latency[l].observe(m.latencyBuckets, info.Duration.Seconds())
+	m.size[l].observe(m.sizeBuckets, float64(info.BytesWritten))
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	m.mu.Lock()
+	b.WriteString(m.header("requests_total", "counter", "Total number of requests."))
+	for _, l := range sortedLabels(m.requests) {
+		fmt.Fprintf(&b, "%s_requests_total{%s} %d\n", m.ns, l, m.requests[l])
+	}
+	b.WriteString(m.header("request_duration_seconds", "histogram", "Latency of requests, in seconds."))
+	m.writeHistograms(&b, "request_duration_seconds", m.latencyBuckets, m.latency)
+	b.WriteString(m.header("response_size_bytes", "histogram", "Size of response bodies, in bytes."))
+	m.writeHistograms(&b, "response_size_bytes", m.sizeBuckets, m.size)
+	b.WriteString(m.header("requests_in_flight", "gauge", "Number of requests being processed."))
+	for _, l := range sortedLabels(m.inFlight) {
+		fmt.Fprintf(&b, "%s_requests_in_flight{%s} %d\n", m.ns, l, m.inFlight[l])
+	}
+	m.mu.Unlock()
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (m *Metrics) header(name, typ, help string) string {
+	return fmt.Sprintf("# HELP %s_%s %s\n# TYPE %s_%s %s\n", m.ns, name, help, m.ns, name, typ)
+}
+
+func (m *Metrics) writeHistograms(b *strings.Builder, name string, bounds []float64, hs map[labels]*histogram) {
+	for _, l := range sortedLabels(hs) {
+		h := hs[l]
+		var cum uint64
+		for i, c := range h.counts {
+			cum += c
+			le := math.Inf(1)
+			if i < len(bounds) {
+				le = bounds[i]
+			}
+			fmt.Fprintf(b, "%s_%s_bucket{%s,le=%q} %d\n", m.ns, name, l, formatFloat(le), cum)
+		}
+		fmt.Fprintf(b, "%s_%s_sum{%s} %s\n", m.ns, name, l, formatFloat(h.sum))
+		fmt.Fprintf(b, "%s_%s_count{%s} %d\n", m.ns, name, l, h.count)
+	}
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// sortedLabels returns the keys of a map of series, sorted.
+func sortedLabels(series interface{}) []labels {
+	var ls []labels
+	switch s := series.(type) {
+	case map[labels]uint64:
+		for l := range s {
+			ls = append(ls, l)
+		}
+	case map[labels]int64:
+		for l := range s {
+			ls = append(ls, l)
+		}
+	case map[labels]*histogram:
+		for l := range s {
+			ls = append(ls, l)
+		}
+	}
+	sort.Slice(ls, func(i, j int) bool { return ls[i].String() < ls[j].String() })
+	return ls
+}
+
+// Handler returns a handler serving the metrics in the Prometheus text format,
+// e.g. on "/metrics". The metrics reveal the routes of the application and
+// its traffic, so the handler should be protected, e.g. with the auth plugin.
+func (m *Metrics) Handler() safehttp.Handler {
+	return safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.WriteStream(w, "text/plain; version=0.0.4; charset=utf-8", func(sw safehttp.StreamWriter) error {
+			_, err := m.WriteTo(sw)
+			return err
+		})
+	})
+}
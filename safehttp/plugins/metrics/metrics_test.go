@@ -0,0 +1,120 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/metrics"
+)
+
+func newMux(m *metrics.Metrics) *safehttp.ServeMux {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(m)
+	mux := mb.Mux()
+	mux.Handle("/users/{id}", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		if r.PathParam("id") == "missing" {
+			return w.WriteError(safehttp.StatusNotFound)
+		}
+		return safehttp.WriteString(w, "hello")
+	}))
+	mux.Handle("/metrics", safehttp.MethodGet, m.Handler())
+	return mux
+}
+
+func TestMetrics(t *testing.T) {
+	m := metrics.New(metrics.Config{Namespace: "app", LatencyBuckets: []float64{60}, SizeBuckets: []float64{5, 100}})
+	mux := newMux(m)
+	for _, path := range []string{"/users/1", "/users/2", "/users/missing"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(safehttp.MethodGet, "https://foo.com"+path, nil))
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(safehttp.MethodGet, "https://foo.com/metrics", nil))
+	if got, want := rr.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"; got != want {
+		t.Errorf(`rr.Header().Get("Content-Type"): got %q, want %q`, got, want)
+	}
+	body := rr.Body.String()
+	wantLines := []string{
+		"# TYPE app_requests_total counter",
+		`app_requests_total{method="GET",route="/users/{id}",status="2xx"} 2`,
+		`app_requests_total{method="GET",route="/users/{id}",status="4xx"} 1`,
+		"# TYPE app_request_duration_seconds histogram",
+		`app_request_duration_seconds_bucket{method="GET",route="/users/{id}",status="2xx",le="60"} 2`,
+		`app_request_duration_seconds_bucket{method="GET",route="/users/{id}",status="2xx",le="+Inf"} 2`,
+		`app_request_duration_seconds_count{method="GET",route="/users/{id}",status="2xx"} 2`,
+		`app_response_size_bytes_bucket{method="GET",route="/users/{id}",status="2xx",le="5"} 2`,
+		`app_response_size_bytes_sum{method="GET",route="/users/{id}",status="2xx"} 10`,
+		`app_response_size_bytes_bucket{method="GET",route="/users/{id}",status="4xx",le="5"} 0`,
+		`app_response_size_bytes_bucket{method="GET",route="/users/{id}",status="4xx",le="100"} 1`,
+		"# TYPE app_requests_in_flight gauge",
+		`app_requests_in_flight{method="GET",route="/users/{id}"} 0`,
+		// The request for the metrics is being processed.
+		`app_requests_in_flight{method="GET",route="/metrics"} 1`,
+	}
+	for _, l := range wantLines {
+		if !strings.Contains(body, l+"\n") {
+			t.Errorf("metrics: missing line %q in:\n%s", l, body)
+		}
+	}
+}
+
+func TestMetricsRejectedRequests(t *testing.T) {
+	m := metrics.New(metrics.Config{})
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(rejectingInterceptor{}, m)
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.NotWritten()
+	}))
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil))
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatalf("m.WriteTo() got err: %v", err)
+	}
+	if l := `safehttp_requests_total{method="GET",route="/",status="4xx"} 1`; !strings.Contains(b.String(), l+"\n") {
+		t.Errorf("metrics: missing line %q in:\n%s", l, b.String())
+	}
+	// The Before phase of m didn't run, so the request was never in flight.
+	if l := `safehttp_requests_in_flight{method="GET",route="/"}`; strings.Contains(b.String(), l) {
+		t.Errorf("metrics: unexpected series %q in:\n%s", l, b.String())
+	}
+}
+
+type rejectingInterceptor struct{}
+
+func (rejectingInterceptor) Before(w safehttp.ResponseWriter, _ *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+	return w.WriteError(safehttp.StatusForbidden)
+}
+
+func (rejectingInterceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
+}
+
+func (rejectingInterceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
+
+func TestNewUnsortedBuckets(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("metrics.New() expected panic")
+		}
+	}()
+	metrics.New(metrics.Config{LatencyBuckets: []float64{1, 0.5}})
+}
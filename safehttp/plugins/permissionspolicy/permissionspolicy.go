@@ -0,0 +1,203 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package permissionspolicy provides a safehttp.Interceptor which sets the
+// Permissions-Policy header, controlling which powerful browser features can be
+// used by documents and the frames they embed.
+//
+// More info:
+//   - Specification: https://w3c.github.io/webappsec-permissions-policy/
+//   - MDN: https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Permissions-Policy
+//
+// To deny all the known features, install the Interceptor returned by Default.
+// Otherwise, build a Policy starting from DenyAll, allow the needed features
+// and pass it to NewInterceptor.
+package permissionspolicy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// Feature is a policy-controlled browser feature.
+type Feature string
+
+// Policy-controlled features supported by major browsers.
+const (
+	Accelerometer           Feature = "accelerometer"
+	Autoplay                Feature = "autoplay"
+	Camera                  Feature = "camera"
+	DisplayCapture          Feature = "display-capture"
+	EncryptedMedia          Feature = "encrypted-media"
+	Fullscreen              Feature = "fullscreen"
+	Geolocation             Feature = "geolocation"
+	Gyroscope               Feature = "gyroscope"
+	Magnetometer            Feature = "magnetometer"
+	Microphone              Feature = "microphone"
+	Midi                    Feature = "midi"
+	Payment                 Feature = "payment"
+	PictureInPicture        Feature = "picture-in-picture"
+	PublicKeyCredentialsGet Feature = "publickey-credentials-get"
+	ScreenWakeLock          Feature = "screen-wake-lock"
+	SyncXHR                 Feature = "sync-xhr"
+	USB                     Feature = "usb"
+	XRSpatialTracking       Feature = "xr-spatial-tracking"
+)
+
+var knownFeatures = []Feature{
+	Accelerometer,
+	Autoplay,
+	Camera,
+	DisplayCapture,
+	EncryptedMedia,
+	Fullscreen,
+	Geolocation,
+	Gyroscope,
+	Magnetometer,
+	Microphone,
+	Midi,
+	Payment,
+	PictureInPicture,
+	PublicKeyCredentialsGet,
+	ScreenWakeLock,
+	SyncXHR,
+	USB,
+	XRSpatialTracking,
+}
+
+const (
+	// Self can be used in allowlists to allow a feature for the origin of the
+	// document.
+	Self = "self"
+	// All can be used in allowlists to allow a feature for all origins.
+	All = "*"
+)
+
+// Policy is a Permissions-Policy, mapping features to the origins allowed to
+// use them. Policies are immutable: Allow returns a modified copy.
+type Policy struct {
+	allowlists map[Feature][]string
+}
+
+// DenyAll returns a Policy that disables all the known features in the
+// document and in all the frames it embeds.
+func DenyAll() Policy {
+	p := Policy{allowlists: map[Feature][]string{}}
+	for _, f := range knownFeatures {
+		p.allowlists[f] = nil
+	}
+	return p
+}
+
+// Allow returns a copy of the policy which allows the given feature for the
+// given origins. Origins are either Self, All or serialized origins such as
+// "https://example.com". If no origins are given, the feature is disabled.
+func (p Policy) Allow(f Feature, origins ...string) Policy {
+	np := Policy{allowlists: map[Feature][]string{}}
+	for k, v := range p.allowlists {
+		np.allowlists[k] = v
+	}
+	np.allowlists[f] = append([]string(nil), origins...)
+	return np
+}
+
+// String serializes the policy. The returned value can be used as a header
+// value. Features are sorted by name.
+func (p Policy) String() string {
+	var features []string
+	for f := range p.allowlists {
+		features = append(features, string(f))
+	}
+	sort.Strings(features)
+
+	var directives []string
+	for _, f := range features {
+		var values []string
+		for _, o := range p.allowlists[Feature(f)] {
+			switch o {
+			case Self, All:
+				values = append(values, o)
+			default:
+				values = append(values, `"`+o+`"`)
+			}
+		}
+		if len(values) == 1 && values[0] == All {
+			directives = append(directives, f+"=*")
+			continue
+		}
+		directives = append(directives, f+"=("+strings.Join(values, " ")+")")
+	}
+	return strings.Join(directives, ", ")
+}
+
+func (p Policy) validate() error {
+	for f, origins := range p.allowlists {
+		if f == "" || strings.ContainsAny(string(f), " \t\r\n\",;=()") {
+			return fmt.Errorf("invalid feature %q", f)
+		}
+		for _, o := range origins {
+			if o == "" || strings.ContainsAny(o, " \t\r\n\"\\,;()") {
+				return fmt.Errorf("invalid origin %q for feature %q", o, f)
+			}
+		}
+	}
+	return nil
+}
+
+// Interceptor claims and sets the Permissions-Policy header.
+type Interceptor struct {
+	value string
+}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+// NewInterceptor constructs an Interceptor that applies the given policy. It
+// returns an error if the policy contains malformed features or origins.
+func NewInterceptor(p Policy) (Interceptor, error) {
+	if err := p.validate(); err != nil {
+		return Interceptor{}, err
+	}
+	return Interceptor{value: p.String()}, nil
+}
+
+// Default returns an Interceptor that denies all the known features.
+func Default() Interceptor {
+	it, err := NewInterceptor(DenyAll())
+	if err != nil {
+		// This path should not be possible.
+		panic(err)
+	}
+	return it
+}
+
+// Before claims and sets the Permissions-Policy header.
+func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+	set := w.Header().Claim("Permissions-Policy")
+	if it.value != "" {
+		set([]string{it.value})
+	}
+	return safehttp.NotWritten()
+}
+
+// Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
+}
+
+// Match returns false since there are no supported configurations.
+func (Interceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
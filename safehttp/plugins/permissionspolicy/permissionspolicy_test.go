@@ -0,0 +1,144 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package permissionspolicy_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/permissionspolicy"
+	"github.com/google/go-safeweb/safehttp/safehttptest"
+)
+
+func TestPolicyString(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy permissionspolicy.Policy
+		want   string
+	}{
+		{
+			name:   "Empty",
+			policy: permissionspolicy.Policy{},
+			want:   "",
+		},
+		{
+			name: "Single feature",
+			policy: permissionspolicy.Policy{}.
+				Allow(permissionspolicy.Camera, permissionspolicy.Self, "https://foo.com"),
+			want: `camera=(self "https://foo.com")`,
+		},
+		{
+			name: "All origins",
+			policy: permissionspolicy.Policy{}.
+				Allow(permissionspolicy.Fullscreen, permissionspolicy.All).
+				Allow(permissionspolicy.Geolocation),
+			want: `fullscreen=*, geolocation=()`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.String(); got != tt.want {
+				t.Errorf("tt.policy.String(): got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowDoesNotModifyPolicy(t *testing.T) {
+	p := permissionspolicy.Policy{}.Allow(permissionspolicy.Camera)
+	p.Allow(permissionspolicy.Camera, permissionspolicy.Self)
+	if got, want := p.String(), "camera=()"; got != want {
+		t.Errorf("p.String(): got %q, want %q", got, want)
+	}
+}
+
+func TestBefore(t *testing.T) {
+	tests := []struct {
+		name        string
+		interceptor permissionspolicy.Interceptor
+		want        map[string][]string
+	}{
+		{
+			name:        "Default",
+			interceptor: permissionspolicy.Default(),
+			want: map[string][]string{
+				"Permissions-Policy": {"accelerometer=(), autoplay=(), camera=(), display-capture=(), encrypted-media=(), fullscreen=(), geolocation=(), gyroscope=(), magnetometer=(), microphone=(), midi=(), payment=(), picture-in-picture=(), publickey-credentials-get=(), screen-wake-lock=(), sync-xhr=(), usb=(), xr-spatial-tracking=()"},
+			},
+		},
+		{
+			name: "Relaxed",
+			interceptor: func() permissionspolicy.Interceptor {
+				p := permissionspolicy.DenyAll().
+					Allow(permissionspolicy.Camera, permissionspolicy.Self).
+					Allow(permissionspolicy.Microphone, permissionspolicy.Self, "https://meet.foo.com")
+				it, err := permissionspolicy.NewInterceptor(p)
+				if err != nil {
+					t.Fatalf("permissionspolicy.NewInterceptor(p): %v", err)
+				}
+				return it
+			}(),
+			want: map[string][]string{
+				"Permissions-Policy": {`accelerometer=(), autoplay=(), camera=(self), display-capture=(), encrypted-media=(), fullscreen=(), geolocation=(), gyroscope=(), magnetometer=(), microphone=(self "https://meet.foo.com"), midi=(), payment=(), picture-in-picture=(), publickey-credentials-get=(), screen-wake-lock=(), sync-xhr=(), usb=(), xr-spatial-tracking=()`},
+			},
+		},
+		{
+			name:        "Zero value",
+			interceptor: permissionspolicy.Interceptor{},
+			want:        map[string][]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+			tt.interceptor.Before(fakeRW, req, nil)
+
+			if diff := cmp.Diff(tt.want, map[string][]string(rr.Header())); diff != "" {
+				t.Errorf("rr.Header() mismatch (-want +got):\n%s", diff)
+			}
+			if !fakeRW.Header().IsClaimed("Permissions-Policy") {
+				t.Error(`fakeRW.Header().IsClaimed("Permissions-Policy"): got false, want true`)
+			}
+		})
+	}
+}
+
+func TestNewInterceptorInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy permissionspolicy.Policy
+	}{
+		{
+			name:   "Invalid feature",
+			policy: permissionspolicy.Policy{}.Allow("camera=*, geolocation", permissionspolicy.Self),
+		},
+		{
+			name:   "Empty origin",
+			policy: permissionspolicy.Policy{}.Allow(permissionspolicy.Camera, ""),
+		},
+		{
+			name:   "Quote in origin",
+			policy: permissionspolicy.Policy{}.Allow(permissionspolicy.Camera, `https://foo.com"), usb=*`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := permissionspolicy.NewInterceptor(tt.policy); err == nil {
+				t.Error("permissionspolicy.NewInterceptor(tt.policy): got nil error, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit provides a safehttp.Interceptor limiting the rate of
+// requests of each client, using token buckets.
+//
+// Clients are identified by a KeyFunc, by default their IP address. Each
+// client has a bucket holding up to Limit.Requests tokens, refilled over
+// Limit.Period, and every request takes a token. Requests made when the
+// bucket is empty are rejected with 429 Too Many Requests.
+//
+// Responses carry the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers, and rejected ones the Retry-After header.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/auth"
+)
+
+var now = time.Now
+
+// Limit is the rate requests are allowed at.
+type Limit struct {
+	// Requests is the number of requests allowed per Period. They can all be
+	// made at once, after which requests are allowed as the bucket refills.
+	Requests int
+	// Period is the time it takes for an empty bucket to be refilled.
+	Period time.Duration
+}
+
+// rate returns the number of tokens added to buckets per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+func (l Limit) valid() bool {
+	return l.Requests > 0 && l.Period > 0
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	// Allowed reports whether a token was taken.
+	Allowed bool
+	// Remaining is the number of tokens left in the bucket.
+	Remaining int
+	// RetryAfter is the time until a token is available, if none was.
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full.
+	Reset time.Duration
+}
+
+// take takes a token from a bucket holding the given tokens, refilled since
+// the given time, returning the new number of tokens and the result.
+func take(l Limit, tokens float64, last, t time.Time) (float64, Result) {
+	if d := t.Sub(last); d > 0 {
+		tokens = math.Min(float64(l.Requests), tokens+d.Seconds()*l.rate())
+	}
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	return tokens, result(l, tokens, allowed)
+}
+
+func result(l Limit, tokens float64, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Remaining: int(tokens),
+		Reset:     seconds((float64(l.Requests) - tokens) / l.rate()),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / l.rate())
+	}
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// KeyFunc returns the key identifying the client making the request, which
+// requests sharing a bucket have in common.
+type KeyFunc func(r *safehttp.IncomingRequest) (string, error)
+
+// ByIP identifies clients by their IP address, as determined by
+// IncomingRequest.ClientIP. IPv6 clients are identified by their /64 network,
+// as that's usually what clients are assigned.
+func ByIP(r *safehttp.IncomingRequest) (string, error) {
+	ip, err := r.ClientIP()
+	if err != nil {
+		return "", err
+	}
+	if ip.To4() == nil {
+		ip = ip.Mask(net.CIDRMask(64, 128))
+	}
+	return "ip:" + ip.String(), nil
+}
+
+// ByIdentity identifies clients by the identity stored in the context of the
+// request by the auth.Interceptor, which must be installed before. Anonymous
+// clients are identified with fallback, or ByIP if nil.
+func ByIdentity(fallback KeyFunc) KeyFunc {
+	if fallback == nil {
+		fallback = ByIP
+	}
+	return func(r *safehttp.IncomingRequest) (string, error) {
+		if id := auth.FromRequest(r); id != nil {
+			return "id:" + id.Method + ":" + id.Subject, nil
+		}
+		return fallback(r)
+	}
+}
+
+// Interceptor limits the rate of requests.
+type Interceptor struct {
+	// Store holds the buckets. It must be set.
+	Store Store
+	// Key identifies the clients. If nil, ByIP is used.
+	Key KeyFunc
+	// Limit applies to all the requests of a client, except those to handlers
+	// with the WithLimit or Exempt configurations. If zero, only handlers with
+	// WithLimit are limited.
+	Limit Limit
+}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+type routeLimit struct {
+	limit Limit
+}
+
+// WithLimit returns a configuration limiting the requests to the handler with
+// the given limit instead of the Interceptor one. Each handler with WithLimit
+// has its own buckets.
+func WithLimit(l Limit) safehttp.InterceptorConfig {
+	if !l.valid() {
+		panic(fmt.Sprintf("invalid rate limit: %+v", l))
+	}
+	return routeLimit{limit: l}
+}
+
+type exempt struct{}
+
+// Exempt returns a configuration exempting the requests to the handler from
+// rate limiting.
+func Exempt(reason string) safehttp.InterceptorConfig {
+	return exempt{}
+}
+
+// Before takes a token from the bucket of the client and sets the RateLimit
+// headers. Requests are rejected with 429 Too Many Requests if the bucket is
+// empty, with 400 Bad Request if the client can't be identified and with 500
+// Internal Server Error if the Store fails.
+func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	l, scope := it.Limit, ""
+	switch c := cfg.(type) {
+	case exempt:
+		return safehttp.NotWritten()
+	case routeLimit:
+		l, scope = c.limit, r.Pattern()
+	}
+	if !l.valid() {
+		return safehttp.NotWritten()
+	}
+	keyFn := it.Key
+	if keyFn == nil {
+		keyFn = ByIP
+	}
+	key, err := keyFn(r)
+	if err != nil {
+		return w.WriteError(safehttp.StatusBadRequest)
+	}
+	res, err := it.Store.Take(r.Context(), scope+"|"+key, l)
+	if err != nil {
+		return w.WriteError(safehttp.StatusInternalServerError)
+	}
+
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(l.Requests))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+	if !res.Allowed {
+		h.Set("Retry-After", ceilSeconds(res.RetryAfter))
+		return w.WriteError(safehttp.StatusTooManyRequests)
+	}
+	return safehttp.NotWritten()
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+}
+
+// Match recognizes the WithLimit and Exempt configurations.
+func (Interceptor) Match(cfg safehttp.InterceptorConfig) bool {
+	switch cfg.(type) {
+	case routeLimit, exempt:
+		return true
+	}
+	return false
+}
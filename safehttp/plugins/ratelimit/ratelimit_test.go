@@ -0,0 +1,302 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/auth"
+)
+
+var twoPerMinute = Limit{Requests: 2, Period: time.Minute}
+
+func newMux(it Interceptor, cfgs ...safehttp.InterceptorConfig) *safehttp.ServeMux {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(it)
+	m := mb.Mux()
+	h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehttp.NoContentResponse{})
+	})
+	m.Handle("/", safehttp.MethodGet, h, cfgs...)
+	m.Handle("/other", safehttp.MethodGet, h)
+	return m
+}
+
+func get(m *safehttp.ServeMux, path, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com"+path, nil)
+	if remoteAddr != "" {
+		req.RemoteAddr = remoteAddr
+	}
+	rr := httptest.NewRecorder()
+	m.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestInterceptor(t *testing.T) {
+	defer func() { now = time.Now }()
+	start := time.Now()
+	m := newMux(Interceptor{Store: NewMemoryStore(0), Limit: twoPerMinute})
+
+	steps := []struct {
+		elapsed     time.Duration
+		wantStatus  safehttp.StatusCode
+		wantHeaders map[string]string
+	}{
+		{
+			wantStatus:  safehttp.StatusNoContent,
+			wantHeaders: map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "1", "RateLimit-Reset": "30", "Retry-After": ""},
+		},
+		{
+			wantStatus:  safehttp.StatusNoContent,
+			wantHeaders: map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "0", "RateLimit-Reset": "60", "Retry-After": ""},
+		},
+		{
+			elapsed:     15 * time.Second,
+			wantStatus:  safehttp.StatusTooManyRequests,
+			wantHeaders: map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "0", "RateLimit-Reset": "45", "Retry-After": "15"},
+		},
+		{
+			elapsed:     30 * time.Second,
+			wantStatus:  safehttp.StatusNoContent,
+			wantHeaders: map[string]string{"RateLimit-Remaining": "0", "Retry-After": ""},
+		},
+	}
+	for i, s := range steps {
+		now = func() time.Time { return start.Add(s.elapsed) }
+		rr := get(m, "/", "")
+		if got, want := rr.Code, int(s.wantStatus); got != want {
+			t.Errorf("request %d: rr.Code got %v, want %v", i, got, want)
+		}
+		for k, want := range s.wantHeaders {
+			if got := rr.Header().Get(k); got != want {
+				t.Errorf("request %d: rr.Header().Get(%q) got %q, want %q", i, k, got, want)
+			}
+		}
+	}
+}
+
+func TestInterceptorConfigs(t *testing.T) {
+	tests := []struct {
+		name       string
+		limit      Limit
+		cfg        safehttp.InterceptorConfig
+		requests   []string
+		wantStatus []safehttp.StatusCode
+	}{
+		{
+			name:       "Shared default limit",
+			limit:      twoPerMinute,
+			requests:   []string{"/", "/other", "/"},
+			wantStatus: []safehttp.StatusCode{204, 204, 429},
+		},
+		{
+			name:       "Route limit",
+			limit:      twoPerMinute,
+			cfg:        WithLimit(Limit{Requests: 1, Period: time.Minute}),
+			requests:   []string{"/", "/", "/other", "/other", "/other"},
+			wantStatus: []safehttp.StatusCode{204, 429, 204, 204, 429},
+		},
+		{
+			name:       "Route limit only",
+			cfg:        WithLimit(Limit{Requests: 1, Period: time.Minute}),
+			requests:   []string{"/", "/", "/other", "/other", "/other"},
+			wantStatus: []safehttp.StatusCode{204, 429, 204, 204, 204},
+		},
+		{
+			name:       "Exempt",
+			limit:      twoPerMinute,
+			cfg:        Exempt("health check"),
+			requests:   []string{"/", "/", "/", "/other"},
+			wantStatus: []safehttp.StatusCode{204, 204, 204, 204},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfgs []safehttp.InterceptorConfig
+			if tt.cfg != nil {
+				cfgs = append(cfgs, tt.cfg)
+			}
+			m := newMux(Interceptor{Store: NewMemoryStore(0), Limit: tt.limit}, cfgs...)
+			var got []safehttp.StatusCode
+			for _, p := range tt.requests {
+				got = append(got, safehttp.StatusCode(get(m, p, "").Code))
+			}
+			if diff := cmp.Diff(tt.wantStatus, got); diff != "" {
+				t.Errorf("status codes: mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestByIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	tests := []struct {
+		name, remoteAddr, xff, want string
+	}{
+		{name: "IPv4", remoteAddr: "192.0.2.1:1234", want: "ip:192.0.2.1"},
+		{name: "IPv6 network", remoteAddr: "[2001:db8:1:2:3:4:5:6]:1234", want: "ip:2001:db8:1:2::"},
+		{name: "Untrusted forwarded", remoteAddr: "192.0.2.1:1234", xff: "198.51.100.7", want: "ip:192.0.2.1"},
+		{name: "Trusted forwarded", remoteAddr: "10.0.0.1:1234", xff: "198.51.100.7", want: "ip:198.51.100.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.TrustProxies(safehttp.ProxyPolicy{TrustedProxies: []*net.IPNet{proxies}})
+			m := mb.Mux()
+			var got string
+			m.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				key, err := ByIP(r)
+				if err != nil {
+					t.Fatalf("ByIP() got err: %v", err)
+				}
+				got = key
+				return w.Write(safehttp.NoContentResponse{})
+			}))
+
+			req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			m.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("ByIP(): got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterceptorInvalidClient(t *testing.T) {
+	m := newMux(Interceptor{Store: NewMemoryStore(0), Limit: twoPerMinute})
+	if got, want := get(m, "/", "invalid").Code, int(safehttp.StatusBadRequest); got != want {
+		t.Errorf("rr.Code: got %v, want %v", got, want)
+	}
+}
+
+func TestByIdentity(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(auth.Interceptor{
+		Optional: true,
+		Authenticators: []auth.Authenticator{auth.AuthenticatorFunc(func(r *safehttp.IncomingRequest) (*auth.Identity, error) {
+			if u := r.Header.Get("X-User"); u != "" {
+				return &auth.Identity{Subject: u, Method: "test"}, nil
+			}
+			return nil, nil
+		})},
+	})
+	mb.Intercept(Interceptor{Store: NewMemoryStore(0), Key: ByIdentity(nil), Limit: Limit{Requests: 1, Period: time.Minute}})
+	m := mb.Mux()
+	m.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehttp.NoContentResponse{})
+	}))
+
+	var got []int
+	for _, u := range []string{"alice", "alice", "bob", "", ""} {
+		req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+		if u != "" {
+			req.Header.Set("X-User", u)
+		}
+		rr := httptest.NewRecorder()
+		m.ServeHTTP(rr, req)
+		got = append(got, rr.Code)
+	}
+	if diff := cmp.Diff([]int{204, 429, 204, 204, 429}, got); diff != "" {
+		t.Errorf("status codes: mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	s := NewMemoryStore(2)
+	l := Limit{Requests: 1, Period: time.Hour}
+	ctx := context.Background()
+	for _, k := range []string{"a", "b", "a", "c"} {
+		s.Take(ctx, k, l)
+	}
+	// "b" was the least recently used bucket, so it was evicted and is full.
+	for _, c := range []struct {
+		key  string
+		want bool
+	}{{"c", false}, {"b", true}} {
+		res, err := s.Take(ctx, c.key, l)
+		if err != nil {
+			t.Fatalf("s.Take(%q) got err: %v", c.key, err)
+		}
+		if res.Allowed != c.want {
+			t.Errorf("s.Take(%q).Allowed: got %v, want %v", c.key, res.Allowed, c.want)
+		}
+	}
+}
+
+type fakeRedis struct {
+	keys  []string
+	args  []interface{}
+	reply interface{}
+	err   error
+}
+
+func (f *fakeRedis) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	f.keys, f.args = keys, args
+	return f.reply, f.err
+}
+
+func TestRedisStore(t *testing.T) {
+	defer func() { now = time.Now }()
+	start := time.Unix(1000, 0)
+	now = func() time.Time { return start }
+
+	f := &fakeRedis{reply: []interface{}{int64(1), "0.5"}}
+	s := RedisStore{Client: f, Prefix: "rl:"}
+	res, err := s.Take(context.Background(), "|ip:192.0.2.1", twoPerMinute)
+	if err != nil {
+		t.Fatalf("s.Take() got err: %v", err)
+	}
+	if diff := cmp.Diff([]string{"rl:|ip:192.0.2.1"}, f.keys); diff != "" {
+		t.Errorf("keys: mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]interface{}{2, "3.3333333333333335e-05", int64(1000000)}, f.args); diff != "" {
+		t.Errorf("args: mismatch (-want +got):\n%s", diff)
+	}
+	want := Result{Allowed: true, Remaining: 0, Reset: 45 * time.Second}
+	if diff := cmp.Diff(want, res); diff != "" {
+		t.Errorf("s.Take(): mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRedisStoreError(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply interface{}
+		err   error
+	}{
+		{name: "Error", err: errors.New("connection refused")},
+		{name: "Unexpected reply", reply: "OK"},
+		{name: "Malformed tokens", reply: []interface{}{int64(1), "abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := RedisStore{Client: &fakeRedis{reply: tt.reply, err: tt.err}}
+			if _, err := s.Take(context.Background(), "k", twoPerMinute); err == nil {
+				t.Error("s.Take(): got nil err, want error")
+			}
+		})
+	}
+}
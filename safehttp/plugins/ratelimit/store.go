@@ -0,0 +1,158 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultCapacity is the number of buckets kept by a MemoryStore, unless
+// overridden with NewMemoryStore.
+const DefaultCapacity = 10000
+
+// Store holds the buckets of the clients. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Take takes a token from the bucket with the given key, creating a full
+	// bucket if it doesn't exist.
+	Take(ctx context.Context, key string, l Limit) (Result, error)
+}
+
+// MemoryStore is a Store keeping buckets in memory, evicting the least
+// recently used ones when full. Buckets are not shared across instances.
+type MemoryStore struct {
+	mu       sync.Mutex
+	capacity int
+	lru      *list.List
+	buckets  map[string]*list.Element
+}
+
+var _ Store = &MemoryStore{}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// NewMemoryStore creates a MemoryStore keeping up to capacity buckets, or
+// DefaultCapacity if it's not positive. Evicted buckets are full when used
+// again, so the capacity should exceed the number of clients active within
+// the limit period.
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &MemoryStore{capacity: capacity, lru: list.New(), buckets: map[string]*list.Element{}}
+}
+
+// Take takes a token from the bucket with the given key.
+func (m *MemoryStore) Take(_ context.Context, key string, l Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := now()
+	e, ok := m.buckets[key]
+	if ok {
+		m.lru.MoveToFront(e)
+	} else {
+		if m.lru.Len() >= m.capacity {
+			oldest := m.lru.Back()
+			m.lru.Remove(oldest)
+			delete(m.buckets, oldest.Value.(*bucket).key)
+		}
+		e = m.lru.PushFront(&bucket{key: key, tokens: float64(l.Requests), last: t})
+		m.buckets[key] = e
+	}
+	b := e.Value.(*bucket)
+	var res Result
+	b.tokens, res = take(l, b.tokens, b.last, t)
+	b.last = t
+	return res, nil
+}
+
+// RedisClient evaluates Lua scripts on a Redis server. Clients of Redis
+// libraries can be adapted to it, e.g. by returning Eval(...).Result() for
+// github.com/go-redis/redis.
+type RedisClient interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// redisTake refills and takes a token from the bucket stored in the hash
+// KEYS[1], atomically. The arguments are the capacity, the refill rate in
+// tokens per millisecond and the current time in milliseconds. The bucket
+// expires once it would be full again. It returns whether the token was
+// taken and the remaining tokens, as a string since Redis truncates numbers.
+const redisTake = `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local b = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(b[1]) or capacity
+local last = tonumber(b[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - last) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "last", now)
+redis.call("PEXPIRE", KEYS[1], math.max(1, math.ceil((capacity - tokens) / rate)))
+return {allowed, tostring(tokens)}
+`
+
+// RedisStore is a Store keeping buckets in Redis, so that they are shared
+// across instances. The time of the instances is used to refill the buckets,
+// so their clocks should be synchronized.
+type RedisStore struct {
+	// Client is the Redis client. It must be set.
+	Client RedisClient
+	// Prefix is prepended to the keys of the buckets.
+	Prefix string
+}
+
+var _ Store = RedisStore{}
+
+// Take takes a token from the bucket with the given key.
+func (s RedisStore) Take(ctx context.Context, key string, l Limit) (Result, error) {
+	perMs := l.rate() / 1000
+	reply, err := s.Client.Eval(ctx, redisTake, []string{s.Prefix + key},
+		l.Requests, strconv.FormatFloat(perMs, 'g', -1, 64), now().UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return Result{}, fmt.Errorf("redis: %v", err)
+	}
+	vals, ok := reply.([]interface{})
+	if !ok || len(vals) != 2 {
+		return Result{}, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	allowed, ok := vals[0].(int64)
+	if !ok {
+		return Result{}, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	str, ok := vals[1].(string)
+	if !ok {
+		return Result{}, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	tokens, err := strconv.ParseFloat(str, 64)
+	if err != nil || math.IsNaN(tokens) {
+		return Result{}, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	return result(l, tokens, allowed == 1), nil
+}
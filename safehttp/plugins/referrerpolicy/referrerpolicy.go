@@ -0,0 +1,106 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package referrerpolicy provides a safehttp.Interceptor which sets the
+// Referrer-Policy header, controlling how much referrer information browsers
+// include in requests made from the application's documents.
+//
+// More info:
+//   - Specification: https://w3c.github.io/webappsec-referrer-policy/
+//   - MDN: https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Referrer-Policy
+package referrerpolicy
+
+import (
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// Policy is a Referrer-Policy value.
+type Policy string
+
+// Referrer policies defined by the specification.
+const (
+	NoReferrer                  Policy = "no-referrer"
+	NoReferrerWhenDowngrade     Policy = "no-referrer-when-downgrade"
+	Origin                      Policy = "origin"
+	OriginWhenCrossOrigin       Policy = "origin-when-cross-origin"
+	SameOrigin                  Policy = "same-origin"
+	StrictOrigin                Policy = "strict-origin"
+	StrictOriginWhenCrossOrigin Policy = "strict-origin-when-cross-origin"
+	UnsafeURL                   Policy = "unsafe-url"
+)
+
+var validPolicies = map[Policy]bool{
+	NoReferrer:                  true,
+	NoReferrerWhenDowngrade:     true,
+	Origin:                      true,
+	OriginWhenCrossOrigin:       true,
+	SameOrigin:                  true,
+	StrictOrigin:                true,
+	StrictOriginWhenCrossOrigin: true,
+	UnsafeURL:                   true,
+}
+
+// Interceptor claims and sets the Referrer-Policy header.
+type Interceptor struct {
+	// Policy is the policy set on all responses, unless overridden for a
+	// handler. If empty, NoReferrer is used.
+	Policy Policy
+}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+// Default creates a new Interceptor which sets the no-referrer policy.
+func Default() Interceptor {
+	return Interceptor{Policy: NoReferrer}
+}
+
+// Before claims and sets the Referrer-Policy header. If the handler has been
+// registered with a configuration created by Override, its policy is used
+// instead of the default one. Invalid policies result in a 500 Internal
+// Server Error response.
+func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	p := it.Policy
+	if o, ok := cfg.(override); ok {
+		p = o.policy
+	}
+	if p == "" {
+		p = NoReferrer
+	}
+	if !validPolicies[p] {
+		return w.WriteError(safehttp.StatusInternalServerError)
+	}
+	set := w.Header().Claim("Referrer-Policy")
+	set([]string{string(p)})
+	return safehttp.NotWritten()
+}
+
+// Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
+}
+
+// Match recognizes configurations created by Override.
+func (Interceptor) Match(cfg safehttp.InterceptorConfig) bool {
+	_, ok := cfg.(override)
+	return ok
+}
+
+type override struct {
+	policy Policy
+}
+
+// Override returns a configuration which sets the given policy for a specific
+// handler instead of the default one.
+func Override(reason string, p Policy) safehttp.InterceptorConfig {
+	return override{policy: p}
+}
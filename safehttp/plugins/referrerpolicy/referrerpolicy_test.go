@@ -0,0 +1,90 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package referrerpolicy_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/referrerpolicy"
+	"github.com/google/go-safeweb/safehttp/safehttptest"
+)
+
+func TestBefore(t *testing.T) {
+	tests := []struct {
+		name        string
+		interceptor referrerpolicy.Interceptor
+		cfg         safehttp.InterceptorConfig
+		wantStatus  safehttp.StatusCode
+		wantHeaders map[string][]string
+	}{
+		{
+			name:        "Default",
+			interceptor: referrerpolicy.Default(),
+			wantStatus:  safehttp.StatusOK,
+			wantHeaders: map[string][]string{"Referrer-Policy": {"no-referrer"}},
+		},
+		{
+			name:        "Zero value",
+			interceptor: referrerpolicy.Interceptor{},
+			wantStatus:  safehttp.StatusOK,
+			wantHeaders: map[string][]string{"Referrer-Policy": {"no-referrer"}},
+		},
+		{
+			name:        "Custom policy",
+			interceptor: referrerpolicy.Interceptor{Policy: referrerpolicy.StrictOriginWhenCrossOrigin},
+			wantStatus:  safehttp.StatusOK,
+			wantHeaders: map[string][]string{"Referrer-Policy": {"strict-origin-when-cross-origin"}},
+		},
+		{
+			name:        "Override",
+			interceptor: referrerpolicy.Default(),
+			cfg:         referrerpolicy.Override("analytics redirect", referrerpolicy.Origin),
+			wantStatus:  safehttp.StatusOK,
+			wantHeaders: map[string][]string{"Referrer-Policy": {"origin"}},
+		},
+		{
+			name:        "Invalid policy",
+			interceptor: referrerpolicy.Interceptor{Policy: "no-referrer, unsafe-url"},
+			wantStatus:  safehttp.StatusInternalServerError,
+			wantHeaders: map[string][]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+			tt.interceptor.Before(fakeRW, req, tt.cfg)
+
+			if got := safehttp.StatusCode(rr.Code); got != tt.wantStatus {
+				t.Errorf("rr.Code: got %v, want %v", got, tt.wantStatus)
+			}
+			if diff := cmp.Diff(tt.wantHeaders, map[string][]string(rr.Header())); diff != "" {
+				t.Errorf("rr.Header() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	it := referrerpolicy.Default()
+	if !it.Match(referrerpolicy.Override("reason", referrerpolicy.Origin)) {
+		t.Error("it.Match(referrerpolicy.Override(...)): got false, want true")
+	}
+	if it.Match(nil) {
+		t.Error("it.Match(nil): got true, want false")
+	}
+}
@@ -15,12 +15,16 @@
 // Package reportingapi is an implementation of the Report-To header described
 // in https://www.w3.org/TR/reporting/#header.
 //
-// It allows for setting reporting groups to use in conjuction with COOP and CSP.
+// It allows for setting reporting groups to use in conjuction with COOP and CSP,
+// as well as the Reporting-Endpoints and NEL headers. Reports sent by browsers
+// to the configured endpoints can be parsed with the collector plugin.
 package reportingapi
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/google/go-safeweb/safehttp"
 )
@@ -33,6 +37,12 @@ const DefaultMaxAge = 7 * 24 * 60 * 60
 // ReportToHeaderKey is the HTTP header key for the Reporting API.
 const ReportToHeaderKey = "Report-To"
 
+// ReportingEndpointsHeaderKey is the HTTP header key for Reporting-Endpoints.
+const ReportingEndpointsHeaderKey = "Reporting-Endpoints"
+
+// NELHeaderKey is the HTTP header key for Network Error Logging.
+const NELHeaderKey = "NEL"
+
 // Endpoint is the Go representation of the endpoints values as specified
 // in https://www.w3.org/TR/reporting/#endpoints-member
 type Endpoint struct {
@@ -75,9 +85,43 @@ func NewGroup(name string, url string, otherUrls ...string) Group {
 	}
 }
 
+// NELPolicy is the Go representation of the NEL header value as specified in
+// https://w3c.github.io/network-error-logging/#nel-response-header.
+type NELPolicy struct {
+	// ReportTo is the name of the reporting group network errors are reported
+	// to.
+	ReportTo string `json:"report_to"`
+	// MaxAge defines the policy’s lifetime, as a non-negative integer number of seconds.
+	// A value of 0 will cause the policy to be removed from the user agent’s cache.
+	MaxAge uint `json:"max_age"`
+	// IncludeSubdomains enables this policy for all subdomains of the current origin’s host.
+	IncludeSubdomains bool `json:"include_subdomains,omitempty"`
+	// SuccessFraction is the sampling rate, between 0 and 1, applied to
+	// successful network requests.
+	SuccessFraction float64 `json:"success_fraction,omitempty"`
+	// FailureFraction is the sampling rate, between 0 and 1, applied to failed
+	// network requests. If zero, browsers report all failures.
+	FailureFraction float64 `json:"failure_fraction,omitempty"`
+}
+
+// Config configures the headers set by an Interceptor created with New.
+type Config struct {
+	// Groups are the reporting groups set in the Report-To header.
+	Groups []Group
+	// ReportingEndpoints enables the Reporting-Endpoints header, which
+	// supersedes Report-To in newer browsers. Each group is serialized using
+	// its first endpoint, as the header doesn't support failover.
+	ReportingEndpoints bool
+	// NEL, if set, is the Network Error Logging policy set in the NEL
+	// header. Its ReportTo must name one of the Groups.
+	NEL *NELPolicy
+}
+
 // Interceptor is the interceptor for the Report-To header.
 type Interceptor struct {
-	values []string
+	values    []string
+	endpoints string
+	nel       string
 }
 
 // NewInterceptor instantiates a new Interceptor for the given groups.
@@ -94,11 +138,64 @@ func NewInterceptor(groups ...Group) Interceptor {
 	return i
 }
 
-// Before adds all the configured Report-To header values as separate headers.
+// New instantiates a new Interceptor for the given configuration. It returns
+// an error if the configuration can't be serialized safely or if the NEL
+// policy refers to an unknown group.
+func New(c Config) (Interceptor, error) {
+	i := NewInterceptor(c.Groups...)
+	names := map[string]bool{}
+	var endpoints []string
+	for _, g := range c.Groups {
+		name := g.Name
+		if name == "" {
+			name = "default"
+		}
+		names[name] = true
+		if !c.ReportingEndpoints {
+			continue
+		}
+		if strings.ContainsAny(name, " \t\r\n\",;=()") {
+			return Interceptor{}, fmt.Errorf("invalid group name %q", name)
+		}
+		if len(g.Endpoints) == 0 {
+			return Interceptor{}, fmt.Errorf("group %q has no endpoints", name)
+		}
+		u := g.Endpoints[0].URL
+		if strings.ContainsAny(u, "\"\\\r\n") {
+			return Interceptor{}, fmt.Errorf("invalid endpoint URL %q", u)
+		}
+		endpoints = append(endpoints, name+`="`+u+`"`)
+	}
+	i.endpoints = strings.Join(endpoints, ", ")
+
+	if p := c.NEL; p != nil {
+		if !names[p.ReportTo] {
+			return Interceptor{}, fmt.Errorf("NEL policy reports to unknown group %q", p.ReportTo)
+		}
+		if p.SuccessFraction < 0 || p.SuccessFraction > 1 || p.FailureFraction < 0 || p.FailureFraction > 1 {
+			return Interceptor{}, errors.New("NEL sampling fractions must be between 0 and 1")
+		}
+		buf, err := json.Marshal(p)
+		if err != nil {
+			return Interceptor{}, fmt.Errorf("marshalling NEL policy: %v", err)
+		}
+		i.nel = string(buf)
+	}
+	return i, nil
+}
+
+// Before adds all the configured Report-To header values as separate headers,
+// as well as the Reporting-Endpoints and NEL headers, if configured.
 func (i Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
 	for _, v := range i.values {
 		w.Header().Add(ReportToHeaderKey, v)
 	}
+	if i.endpoints != "" {
+		w.Header().Add(ReportingEndpointsHeaderKey, i.endpoints)
+	}
+	if i.nel != "" {
+		w.Header().Add(NELHeaderKey, i.nel)
+	}
 	return safehttp.NotWritten()
 }
 
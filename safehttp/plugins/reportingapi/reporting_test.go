@@ -113,3 +113,106 @@ func TestBefore(t *testing.T) {
 		})
 	}
 }
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		name string
+		cfg  reportingapi.Config
+		want map[string][]string
+	}{
+		{
+			name: "Reporting-Endpoints",
+			cfg: reportingapi.Config{
+				Groups: []reportingapi.Group{
+					reportingapi.NewGroup("csp", "https://fuffa.buffa/csp", "https://fuffa.buffa/csp-backup"),
+					{Endpoints: []reportingapi.Endpoint{{URL: "https://fuffa.buffa/default"}}},
+				},
+				ReportingEndpoints: true,
+			},
+			want: map[string][]string{
+				"Report-To": {
+					`{"group":"csp","max_age":604800,"endpoints":[{"url":"https://fuffa.buffa/csp"},{"url":"https://fuffa.buffa/csp-backup"}]}`,
+					`{"max_age":0,"endpoints":[{"url":"https://fuffa.buffa/default"}]}`,
+				},
+				"Reporting-Endpoints": {`csp="https://fuffa.buffa/csp", default="https://fuffa.buffa/default"`},
+			},
+		},
+		{
+			name: "NEL",
+			cfg: reportingapi.Config{
+				Groups: []reportingapi.Group{reportingapi.NewGroup("network-errors", "https://fuffa.buffa/nel")},
+				NEL:    &reportingapi.NELPolicy{ReportTo: "network-errors", MaxAge: 3600, SuccessFraction: 0.01},
+			},
+			want: map[string][]string{
+				"Report-To": {`{"group":"network-errors","max_age":604800,"endpoints":[{"url":"https://fuffa.buffa/nel"}]}`},
+				"Nel":       {`{"report_to":"network-errors","max_age":3600,"success_fraction":0.01}`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, err := reportingapi.New(tt.cfg)
+			if err != nil {
+				t.Fatalf("reportingapi.New(tt.cfg): %v", err)
+			}
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			req := safehttptest.NewRequest(safehttp.MethodGet, "/", nil)
+			i.Before(fakeRW, req, nil)
+			if diff := cmp.Diff(tt.want, map[string][]string(rr.Header()), sortStringSlices); diff != "" {
+				t.Errorf("rr.Header() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	var tests = []struct {
+		name string
+		cfg  reportingapi.Config
+	}{
+		{
+			name: "Unknown NEL group",
+			cfg: reportingapi.Config{
+				Groups: []reportingapi.Group{reportingapi.NewGroup("csp", "https://fuffa.buffa/csp")},
+				NEL:    &reportingapi.NELPolicy{ReportTo: "nel"},
+			},
+		},
+		{
+			name: "Invalid NEL fraction",
+			cfg: reportingapi.Config{
+				Groups: []reportingapi.Group{reportingapi.NewGroup("nel", "https://fuffa.buffa/nel")},
+				NEL:    &reportingapi.NELPolicy{ReportTo: "nel", FailureFraction: 2},
+			},
+		},
+		{
+			name: "Invalid group name",
+			cfg: reportingapi.Config{
+				Groups:             []reportingapi.Group{reportingapi.NewGroup(`a="b", c`, "https://fuffa.buffa/csp")},
+				ReportingEndpoints: true,
+			},
+		},
+		{
+			name: "Invalid endpoint URL",
+			cfg: reportingapi.Config{
+				Groups:             []reportingapi.Group{reportingapi.NewGroup("csp", `https://fuffa.buffa/"`)},
+				ReportingEndpoints: true,
+			},
+		},
+		{
+			name: "No endpoints",
+			cfg: reportingapi.Config{
+				Groups:             []reportingapi.Group{{Name: "csp"}},
+				ReportingEndpoints: true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := reportingapi.New(tt.cfg); err == nil {
+				t.Error("reportingapi.New(tt.cfg): got nil error, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package requestid provides a safehttp.Interceptor assigning an ID to every
+// request, for correlating logs, traces and error reports.
+//
+// The ID is taken from the request header set by a trusted proxy, if any, or
+// generated otherwise. It's stored in the context of the request and set in
+// the same header of the response.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// DefaultHeader is the header carrying the request ID by default.
+const DefaultHeader = "X-Request-Id"
+
+// maxLen is the maximum length of request IDs accepted from proxies.
+const maxLen = 128
+
+type idKey struct{}
+
+// FromContext returns the ID stored in the context by the Interceptor, or the
+// empty string if there is none.
+func FromContext(ctx context.Context) string {
+	fv := safehttp.FlightValues(ctx)
+	if fv == nil {
+		return ""
+	}
+	id, _ := fv.Get(idKey{}).(string)
+	return id
+}
+
+// FromRequest returns the ID of the request, or the empty string if there is
+// none.
+func FromRequest(r *safehttp.IncomingRequest) string {
+	return FromContext(r.Context())
+}
+
+// generate returns a random 128-bit ID, hex-encoded.
+func generate() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// valid reports whether an ID received from a proxy is short and only
+// contains characters that can't be used for log or header injection.
+func valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Interceptor assigns an ID to every request. It should be installed before
+// any interceptor that can reject requests, so that their error responses
+// carry the ID too.
+type Interceptor struct {
+	// Header is the request and response header carrying the ID. If empty,
+	// DefaultHeader is used.
+	Header string
+	// Generate, if set, generates the IDs of requests not carrying a valid
+	// one. By default, random 128-bit hex-encoded IDs are generated.
+	Generate func() string
+}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+func (it Interceptor) header() string {
+	if it.Header == "" {
+		return DefaultHeader
+	}
+	return it.Header
+}
+
+// Before stores the ID of the request in its context and sets the response
+// header. The ID sent in the request header is only used if the request comes
+// from a proxy trusted with safehttp.ServeMuxConfig.TrustProxies and it's at
+// most 128 characters long, made of letters, digits and "-_.:/+=".
+func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+	id := r.Header.Get(it.header())
+	if !r.FromTrustedProxy() || !valid(id) {
+		if it.Generate != nil {
+			id = it.Generate()
+		} else {
+			id = generate()
+		}
+	}
+	safehttp.FlightValues(r.Context()).Put(idKey{}, id)
+	w.Header().Claim(it.header())([]string{id})
+	return safehttp.NotWritten()
+}
+
+// Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
+}
+
+// Match returns false since there are no supported configurations.
+func (Interceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
+
+// Dispatcher wraps a safehttp.Dispatcher to write error responses as text
+// including the request ID, e.g. "Forbidden\nRequest ID: 0a1b...", so that
+// users can report it. Responses without an ID are written by the wrapped
+// Dispatcher.
+type Dispatcher struct {
+	safehttp.Dispatcher
+	// Header is the response header carrying the ID, as set by the
+	// Interceptor. If empty, DefaultHeader is used.
+	Header string
+}
+
+// Error writes a text error response including the request ID.
+func (d Dispatcher) Error(rw http.ResponseWriter, resp safehttp.ErrorResponse) error {
+	h := d.Header
+	if h == "" {
+		h = DefaultHeader
+	}
+	id := rw.Header().Get(h)
+	if id == "" {
+		return d.Dispatcher.Error(rw, resp)
+	}
+	code := int(resp.Code())
+	http.Error(rw, http.StatusText(code)+"\nRequest ID: "+id, code)
+	return nil
+}
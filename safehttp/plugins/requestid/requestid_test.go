@@ -0,0 +1,127 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requestid_test
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/requestid"
+)
+
+func newMux(t *testing.T, disp safehttp.Dispatcher, it requestid.Interceptor, got *string) *safehttp.ServeMux {
+	t.Helper()
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb := safehttp.NewServeMuxConfig(disp)
+	mb.TrustProxies(safehttp.ProxyPolicy{TrustedProxies: []*net.IPNet{proxies}})
+	mb.Intercept(it)
+	m := mb.Mux()
+	m.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		*got = requestid.FromRequest(r)
+		return w.Write(safehttp.NoContentResponse{})
+	}))
+	m.Handle("/error", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.WriteError(safehttp.StatusForbidden)
+	}))
+	return m
+}
+
+func TestInterceptor(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		want       string
+	}{
+		{name: "Trusted proxy", remoteAddr: "10.0.0.1:1234", header: "abc-123", want: "abc-123"},
+		{name: "Untrusted peer", remoteAddr: "192.0.2.1:1234", header: "abc-123", want: "generated"},
+		{name: "No header", remoteAddr: "10.0.0.1:1234", want: "generated"},
+		{name: "Invalid characters", remoteAddr: "10.0.0.1:1234", header: "abc\x00123 <script>", want: "generated"},
+		{name: "Too long", remoteAddr: "10.0.0.1:1234", header: string(make([]byte, 129)), want: "generated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			it := requestid.Interceptor{Generate: func() string { return "generated" }}
+			m := newMux(t, nil, it, &got)
+
+			req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				req.Header["X-Request-Id"] = []string{tt.header}
+			}
+			rr := httptest.NewRecorder()
+			m.ServeHTTP(rr, req)
+
+			if got != tt.want {
+				t.Errorf("requestid.FromRequest(): got %q, want %q", got, tt.want)
+			}
+			if got := rr.Header().Get("X-Request-Id"); got != tt.want {
+				t.Errorf(`rr.Header().Get("X-Request-Id"): got %q, want %q`, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterceptorGeneratesRandomIDs(t *testing.T) {
+	var got string
+	m := newMux(t, nil, requestid.Interceptor{Header: "X-Trace"}, &got)
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		rr := httptest.NewRecorder()
+		m.ServeHTTP(rr, httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil))
+		if len(got) != 32 {
+			t.Errorf("requestid.FromRequest(): got %q, want 32 hex characters", got)
+		}
+		if seen[got] {
+			t.Errorf("requestid.FromRequest(): got duplicate ID %q", got)
+		}
+		seen[got] = true
+		if h := rr.Header().Get("X-Trace"); h != got {
+			t.Errorf(`rr.Header().Get("X-Trace"): got %q, want %q`, h, got)
+		}
+	}
+}
+
+func TestFromContextWithoutInterceptor(t *testing.T) {
+	if got := requestid.FromContext(context.Background()); got != "" {
+		t.Errorf("requestid.FromContext(): got %q, want empty", got)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	var got string
+	disp := requestid.Dispatcher{Dispatcher: &safehttp.DefaultDispatcher{}}
+	m := newMux(t, disp, requestid.Interceptor{Generate: func() string { return "id-1" }}, &got)
+
+	rr := httptest.NewRecorder()
+	m.ServeHTTP(rr, httptest.NewRequest(safehttp.MethodGet, "https://foo.com/error", nil))
+
+	if got, want := rr.Code, int(safehttp.StatusForbidden); got != want {
+		t.Errorf("rr.Code: got %v, want %v", got, want)
+	}
+	if got, want := rr.Body.String(), "Forbidden\nRequest ID: id-1\n"; got != want {
+		t.Errorf("rr.Body.String(): got %q, want %q", got, want)
+	}
+	if got, want := rr.Header().Get("Content-Type"), "text/plain; charset=utf-8"; got != want {
+		t.Errorf(`rr.Header().Get("Content-Type"): got %q, want %q`, got, want)
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sessions provides a safehttp.Interceptor that loads and saves a
+// session for every request, and the stores sessions can be kept in.
+//
+// Sessions are referenced by cookies with the __Host- prefix, which browsers
+// only accept if they are Secure, have no Domain and have Path=/, so that they
+// can't be set or overwritten by other subdomains or insecure origins. They
+// are also HttpOnly and SameSite=Lax.
+//
+// Sessions expire after a period of inactivity and after a maximum lifetime,
+// regardless of activity. To prevent session fixation attacks, handlers must
+// call RenewID whenever the privileges of a session change, e.g. on login.
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+const (
+	// DefaultCookieName is the name of the session cookie, unless overridden
+	// with Interceptor.CookieName.
+	DefaultCookieName = safehttp.HostPrefix + "session"
+	// DefaultIdleTimeout is how long sessions are kept without activity,
+	// unless overridden with Interceptor.IdleTimeout.
+	DefaultIdleTimeout = 30 * time.Minute
+	// DefaultAbsoluteTimeout is the maximum lifetime of sessions, unless
+	// overridden with Interceptor.AbsoluteTimeout.
+	DefaultAbsoluteTimeout = 12 * time.Hour
+
+	// localDevCookieName is used in local development mode, where cookies are
+	// not Secure and the __Host- prefix would make browsers reject them.
+	localDevCookieName = "session"
+	// idEntropy is the number of random bytes of session IDs.
+	idEntropy = 32
+)
+
+var now = time.Now
+
+// ErrNotFound is returned by stores when the session doesn't exist or has
+// expired.
+var ErrNotFound = errors.New("session not found")
+
+// Data is the state of a session persisted by a Store.
+type Data struct {
+	// ID is the random identifier of the session.
+	ID string
+	// Values are the values stored in the session.
+	Values map[string]string
+	// Created is when the session was created.
+	Created time.Time
+	// Expires is when the session expires, either because of inactivity or
+	// because it reached its maximum lifetime.
+	Expires time.Time
+}
+
+// Store persists sessions. Implementations must be safe for concurrent use.
+type Store interface {
+	// Load returns the session referenced by the value of a session cookie,
+	// or ErrNotFound if it doesn't exist or has expired.
+	Load(ctx context.Context, cookie string) (*Data, error)
+	// Save persists the session and returns the value of the cookie
+	// referencing it.
+	Save(ctx context.Context, d *Data) (cookie string, err error)
+	// Delete removes the session, if it exists.
+	Delete(ctx context.Context, d *Data) error
+}
+
+// Session is the session of a request. It is not safe for concurrent use.
+type Session struct {
+	data     Data
+	isNew    bool
+	modified bool
+	// renewed is the previous state of the session when its ID was renewed.
+	renewed   *Data
+	destroyed bool
+}
+
+// ID returns the random identifier of the session, e.g. to bind XSRF tokens
+// to it. It changes when RenewID is called.
+func (s *Session) ID() string {
+	return s.data.ID
+}
+
+// IsNew reports whether the session was created for this request, rather than
+// loaded from the store.
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+// Get returns the value stored in the session with the given key, if any.
+func (s *Session) Get(key string) (string, bool) {
+	v, ok := s.data.Values[key]
+	return v, ok
+}
+
+// Set stores a value in the session.
+func (s *Session) Set(key, value string) {
+	if s.data.Values == nil {
+		s.data.Values = map[string]string{}
+	}
+	s.data.Values[key] = value
+	s.modified = true
+}
+
+// Delete removes the value stored in the session with the given key.
+func (s *Session) Delete(key string) {
+	delete(s.data.Values, key)
+	s.modified = true
+}
+
+// RenewID gives the session a new ID, keeping its values, and deletes the
+// session with the old ID from the store. It must be called when the
+// privileges of the session change, e.g. on login, to prevent session
+// fixation attacks.
+func (s *Session) RenewID() error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	if s.renewed == nil && !s.isNew {
+		old := s.data
+		s.renewed = &old
+	}
+	s.data.ID = id
+	s.modified = true
+	return nil
+}
+
+// Destroy deletes the session from the store and the cookie from the client,
+// e.g. on logout.
+func (s *Session) Destroy() {
+	s.destroyed = true
+}
+
+func newID() (string, error) {
+	buf := make([]byte, idEntropy)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("crypto/rand.Read: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+type sessionKey struct{}
+
+// FromRequest returns the session of the request, or nil if the Interceptor
+// is not installed.
+func FromRequest(r *safehttp.IncomingRequest) *Session {
+	fv := safehttp.FlightValues(r.Context())
+	if fv == nil {
+		return nil
+	}
+	s, _ := fv.Get(sessionKey{}).(*Session)
+	return s
+}
+
+// Interceptor loads the session of the request in its Before phase and saves
+// it in its Commit phase. New sessions are only saved, and their cookie set,
+// once a value is stored in them.
+type Interceptor struct {
+	// Store persists the sessions. It must be set.
+	Store Store
+	// CookieName is the name of the session cookie. If empty,
+	// DefaultCookieName is used, or "session" in local development mode.
+	CookieName string
+	// IdleTimeout is how long sessions are kept without activity. If zero,
+	// DefaultIdleTimeout is used.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout is the maximum lifetime of sessions. If zero,
+	// DefaultAbsoluteTimeout is used.
+	AbsoluteTimeout time.Duration
+}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+func (it Interceptor) cookieName() string {
+	switch {
+	case it.CookieName != "":
+		return it.CookieName
+	case safehttp.IsLocalDev():
+		return localDevCookieName
+	default:
+		return DefaultCookieName
+	}
+}
+
+func (it Interceptor) idleTimeout() time.Duration {
+	if it.IdleTimeout == 0 {
+		return DefaultIdleTimeout
+	}
+	return it.IdleTimeout
+}
+
+func (it Interceptor) absoluteTimeout() time.Duration {
+	if it.AbsoluteTimeout == 0 {
+		return DefaultAbsoluteTimeout
+	}
+	return it.AbsoluteTimeout
+}
+
+// Before loads the session referenced by the session cookie or, if there is
+// none or it has expired, creates a new one. Requests fail with 500 Internal
+// Server Error if the store can't be accessed.
+func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+	s, err := it.load(r)
+	if err != nil {
+		return w.WriteError(safehttp.StatusInternalServerError)
+	}
+	safehttp.FlightValues(r.Context()).Put(sessionKey{}, s)
+	return safehttp.NotWritten()
+}
+
+func (it Interceptor) load(r *safehttp.IncomingRequest) (*Session, error) {
+	if c, err := r.Cookie(it.cookieName()); err == nil {
+		d, err := it.Store.Load(r.Context(), c.Value())
+		switch {
+		case err == nil && now().Before(d.Expires):
+			return &Session{data: *d}, nil
+		case err == nil:
+			// The store didn't enforce the expiry.
+			if err := it.Store.Delete(r.Context(), d); err != nil {
+				return nil, err
+			}
+		case !errors.Is(err, ErrNotFound):
+			return nil, err
+		}
+	}
+	// Session IDs sent by the client are never reused, so that attackers
+	// can't fixate them.
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{data: Data{ID: id, Created: now()}, isNew: true}, nil
+}
+
+// Commit saves the session, extending its expiry, and sets the session
+// cookie. Destroyed sessions are deleted and their cookie removed. It panics
+// if the store can't be accessed.
+func (it Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
+	s := FromRequest(r)
+	if s == nil {
+		return
+	}
+	ctx := r.Context()
+	if s.destroyed {
+		if !s.isNew {
+			if err := it.Store.Delete(ctx, &s.data); err != nil {
+				panic(fmt.Sprintf("cannot delete session: %v", err))
+			}
+		}
+		if s.renewed != nil {
+			if err := it.Store.Delete(ctx, s.renewed); err != nil {
+				panic(fmt.Sprintf("cannot delete session: %v", err))
+			}
+		}
+		c := safehttp.NewCookie(it.cookieName(), "")
+		c.Path("/")
+		c.SetMaxAge(-1)
+		if err := w.AddCookie(c); err != nil {
+			panic(fmt.Sprintf("cannot delete session cookie: %v", err))
+		}
+		return
+	}
+	if s.isNew && !s.modified {
+		return
+	}
+	if s.renewed != nil {
+		if err := it.Store.Delete(ctx, s.renewed); err != nil {
+			panic(fmt.Sprintf("cannot delete renewed session: %v", err))
+		}
+	}
+
+	t := now()
+	expires := s.data.Created.Add(it.absoluteTimeout())
+	if idle := t.Add(it.idleTimeout()); idle.Before(expires) {
+		expires = idle
+	}
+	s.data.Expires = expires
+	v, err := it.Store.Save(ctx, &s.data)
+	if err != nil {
+		panic(fmt.Sprintf("cannot save session: %v", err))
+	}
+	c := safehttp.NewCookie(it.cookieName(), v)
+	c.Path("/")
+	c.SetMaxAge(int(expires.Sub(t) / time.Second))
+	if err := w.AddCookie(c); err != nil {
+		panic(fmt.Sprintf("cannot add session cookie: %v", err))
+	}
+}
+
+// Match returns false since there are no supported configurations.
+func (Interceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
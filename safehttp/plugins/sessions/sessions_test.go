@@ -0,0 +1,333 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessions
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// client runs requests through a ServeMux with the Interceptor installed,
+// keeping the session cookie across requests like a browser.
+type client struct {
+	t      *testing.T
+	mux    *safehttp.ServeMux
+	cookie *http.Cookie
+}
+
+func newClient(t *testing.T, it Interceptor, h func(*Session)) *client {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(it)
+	m := mb.Mux()
+	m.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		h(FromRequest(r))
+		return w.Write(safehttp.NoContentResponse{})
+	}))
+	return &client{t: t, mux: m}
+}
+
+func (c *client) do() *httptest.ResponseRecorder {
+	req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+	if c.cookie != nil {
+		req.AddCookie(c.cookie)
+	}
+	rr := httptest.NewRecorder()
+	c.mux.ServeHTTP(rr, req)
+	for _, ck := range rr.Result().Cookies() {
+		if ck.MaxAge < 0 {
+			c.cookie = nil
+		} else {
+			c.cookie = ck
+		}
+	}
+	return rr
+}
+
+func TestInterceptorSession(t *testing.T) {
+	var got []string
+	c := newClient(t, Interceptor{Store: &MemoryStore{}}, func(s *Session) {
+		v, _ := s.Get("user")
+		got = append(got, v)
+		s.Set("user", v+"x")
+	})
+	for i := 0; i < 3; i++ {
+		c.do()
+	}
+	if want := []string{"", "x", "xx"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("session values: got %q, want %q", got, want)
+	}
+}
+
+func TestInterceptorCookie(t *testing.T) {
+	c := newClient(t, Interceptor{Store: &MemoryStore{}}, func(s *Session) { s.Set("k", "v") })
+	rr := c.do()
+
+	if got, want := rr.Code, int(safehttp.StatusNoContent); got != want {
+		t.Errorf("rr.Code: got %v, want %v", got, want)
+	}
+	if got, want := len(rr.Header()["Set-Cookie"]), 1; got != want {
+		t.Fatalf("len(Set-Cookie): got %d, want %d", got, want)
+	}
+	ck := rr.Header().Get("Set-Cookie")
+	for _, want := range []string{"__Host-session=", "Path=/", "Max-Age=1800", "HttpOnly", "Secure", "SameSite=Lax"} {
+		if !strings.Contains(ck, want) {
+			t.Errorf("Set-Cookie: got %q, want it to contain %q", ck, want)
+		}
+	}
+	if strings.Contains(ck, "Domain") {
+		t.Errorf("Set-Cookie: got %q, want no Domain", ck)
+	}
+}
+
+func TestInterceptorUnmodifiedNewSession(t *testing.T) {
+	c := newClient(t, Interceptor{Store: &MemoryStore{}}, func(s *Session) {})
+	rr := c.do()
+
+	if got := rr.Header()["Set-Cookie"]; len(got) != 0 {
+		t.Errorf("Set-Cookie: got %q, want none", got)
+	}
+}
+
+func TestInterceptorUnknownSession(t *testing.T) {
+	var ids []string
+	c := newClient(t, Interceptor{Store: &MemoryStore{}}, func(s *Session) {
+		ids = append(ids, s.ID())
+		if !s.IsNew() {
+			t.Error("s.IsNew(): got false, want true")
+		}
+		s.Set("k", "v")
+	})
+	c.cookie = &http.Cookie{Name: DefaultCookieName, Value: "attacker-chosen"}
+	c.do()
+
+	if ids[0] == "attacker-chosen" {
+		t.Error("s.ID(): got the ID sent by the client, want a new one")
+	}
+	if c.cookie == nil || c.cookie.Value == "attacker-chosen" {
+		t.Errorf("session cookie: got %v, want a new session", c.cookie)
+	}
+}
+
+func TestInterceptorTimeouts(t *testing.T) {
+	defer func() { now = time.Now }()
+	start := time.Now()
+
+	tests := []struct {
+		name     string
+		requests []time.Duration
+		wantNew  bool
+	}{
+		{
+			name:     "Active",
+			requests: []time.Duration{20 * time.Minute, 40 * time.Minute, 55 * time.Minute},
+		},
+		{
+			name:     "Idle",
+			requests: []time.Duration{31 * time.Minute},
+			wantNew:  true,
+		},
+		{
+			name:     "Absolute",
+			requests: []time.Duration{25 * time.Minute, 50 * time.Minute, 75 * time.Minute},
+			wantNew:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = func() time.Time { return start }
+			var isNew bool
+			it := Interceptor{Store: &MemoryStore{}, AbsoluteTimeout: time.Hour}
+			c := newClient(t, it, func(s *Session) {
+				isNew = s.IsNew()
+				s.Set("k", "v")
+			})
+			c.do()
+			for _, d := range tt.requests {
+				now = func() time.Time { return start.Add(d) }
+				c.do()
+			}
+			if isNew != tt.wantNew {
+				t.Errorf("s.IsNew() on last request: got %v, want %v", isNew, tt.wantNew)
+			}
+		})
+	}
+}
+
+func TestInterceptorRenewID(t *testing.T) {
+	store := &MemoryStore{}
+	var ids []string
+	login := false
+	c := newClient(t, Interceptor{Store: store}, func(s *Session) {
+		if login {
+			if err := s.RenewID(); err != nil {
+				t.Fatalf("s.RenewID(): got err %v", err)
+			}
+			s.Set("user", "alice")
+		} else {
+			s.Set("visited", "yes")
+		}
+		ids = append(ids, s.ID())
+	})
+	c.do()
+	old := c.cookie
+	login = true
+	c.do()
+
+	if ids[0] == ids[1] {
+		t.Errorf("s.ID() after RenewID: got %q, want a new ID", ids[1])
+	}
+	if _, err := store.Load(context.Background(), old.Value); !errors.Is(err, ErrNotFound) {
+		t.Errorf("store.Load(old session): got err %v, want ErrNotFound", err)
+	}
+	d, err := store.Load(context.Background(), c.cookie.Value)
+	if err != nil {
+		t.Fatalf("store.Load(new session): got err %v", err)
+	}
+	if got, want := d.Values["visited"], "yes"; got != want {
+		t.Errorf(`d.Values["visited"]: got %q, want %q`, got, want)
+	}
+}
+
+func TestInterceptorDestroy(t *testing.T) {
+	store := &MemoryStore{}
+	destroy := false
+	c := newClient(t, Interceptor{Store: store}, func(s *Session) {
+		if destroy {
+			s.Destroy()
+			return
+		}
+		s.Set("k", "v")
+	})
+	c.do()
+	old := c.cookie
+	destroy = true
+	rr := c.do()
+
+	if c.cookie != nil {
+		t.Errorf("session cookie: got %v, want deleted", c.cookie)
+	}
+	if ck := rr.Header().Get("Set-Cookie"); !strings.Contains(ck, "Max-Age=0") {
+		t.Errorf("Set-Cookie: got %q, want it to contain Max-Age=0", ck)
+	}
+	if _, err := store.Load(context.Background(), old.Value); !errors.Is(err, ErrNotFound) {
+		t.Errorf("store.Load(destroyed session): got err %v, want ErrNotFound", err)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Load(context.Context, string) (*Data, error) {
+	return nil, errors.New("unavailable")
+}
+
+func (failingStore) Save(context.Context, *Data) (string, error) {
+	return "", errors.New("unavailable")
+}
+
+func (failingStore) Delete(context.Context, *Data) error {
+	return errors.New("unavailable")
+}
+
+func TestInterceptorStoreError(t *testing.T) {
+	c := newClient(t, Interceptor{Store: failingStore{}}, func(s *Session) {
+		t.Error("handler called, want the request to fail")
+	})
+	c.cookie = &http.Cookie{Name: DefaultCookieName, Value: "session"}
+	rr := c.do()
+
+	if got, want := rr.Code, int(safehttp.StatusInternalServerError); got != want {
+		t.Errorf("rr.Code: got %v, want %v", got, want)
+	}
+}
+
+func TestCookieStore(t *testing.T) {
+	s, err := NewCookieStore([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("NewCookieStore() got err: %v", err)
+	}
+	ctx := context.Background()
+	d := &Data{ID: "id", Values: map[string]string{"user": "alice"}, Created: time.Now(), Expires: time.Now().Add(time.Hour)}
+	v, err := s.Save(ctx, d)
+	if err != nil {
+		t.Fatalf("s.Save() got err: %v", err)
+	}
+	if strings.Contains(v, "alice") {
+		t.Errorf("s.Save(): got %q, want the values to be encrypted", v)
+	}
+	got, err := s.Load(ctx, v)
+	if err != nil {
+		t.Fatalf("s.Load() got err: %v", err)
+	}
+	if got.ID != d.ID || got.Values["user"] != "alice" {
+		t.Errorf("s.Load(): got %+v, want %+v", got, d)
+	}
+
+	tampered := []byte(v)
+	tampered[len(tampered)/2] ^= 1
+	expired := *d
+	expired.Expires = time.Now().Add(-time.Minute)
+	expiredV, err := s.Save(ctx, &expired)
+	if err != nil {
+		t.Fatalf("s.Save() got err: %v", err)
+	}
+	for _, bad := range []string{string(tampered), "abc", "", expiredV} {
+		if _, err := s.Load(ctx, bad); !errors.Is(err, ErrNotFound) {
+			t.Errorf("s.Load(%q): got err %v, want ErrNotFound", bad, err)
+		}
+	}
+}
+
+func TestCookieStoreTooLarge(t *testing.T) {
+	s, err := NewCookieStore([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("NewCookieStore() got err: %v", err)
+	}
+	d := &Data{ID: "id", Values: map[string]string{"k": strings.Repeat("a", 4096)}}
+	if _, err := s.Save(context.Background(), d); err == nil {
+		t.Error("s.Save(): got nil err, want error")
+	}
+}
+
+func TestNewCookieStoreInvalidKey(t *testing.T) {
+	if _, err := NewCookieStore([]byte("short")); err == nil {
+		t.Error("NewCookieStore(): got nil err, want error")
+	}
+}
+
+func TestCookieStoreKeyRotation(t *testing.T) {
+	old, err := NewCookieStore([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("NewCookieStore() got err: %v", err)
+	}
+	ctx := context.Background()
+	v, err := old.Save(ctx, &Data{ID: "id", Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("old.Save() got err: %v", err)
+	}
+	rotated, err := NewCookieStore([]byte("fedcba9876543210"), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("NewCookieStore() got err: %v", err)
+	}
+	if _, err := rotated.Load(ctx, v); err != nil {
+		t.Errorf("rotated.Load(session sealed with old key): got err %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// sweepEvery is the number of saves after which the MemoryStore removes the
+// expired sessions.
+const sweepEvery = 1000
+
+// MemoryStore is a Store keeping sessions in memory. Sessions are lost when
+// the program exits and are not shared across instances, so it's mostly
+// useful for tests and single-instance deployments.
+//
+// The zero value is ready to use.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]Data
+	saves    int
+}
+
+var _ Store = &MemoryStore{}
+
+// Load returns the session with the given ID, which is the value of the
+// session cookie.
+func (m *MemoryStore) Load(_ context.Context, cookie string) (*Data, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.sessions[cookie]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !now().Before(d.Expires) {
+		delete(m.sessions, cookie)
+		return nil, ErrNotFound
+	}
+	d.Values = copyValues(d.Values)
+	return &d, nil
+}
+
+// Save stores the session and returns its ID as the cookie value.
+func (m *MemoryStore) Save(_ context.Context, d *Data) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sessions == nil {
+		m.sessions = map[string]Data{}
+	}
+	m.saves++
+	if m.saves%sweepEvery == 0 {
+		t := now()
+		for id, s := range m.sessions {
+			if !t.Before(s.Expires) {
+				delete(m.sessions, id)
+			}
+		}
+	}
+	stored := *d
+	stored.Values = copyValues(d.Values)
+	m.sessions[d.ID] = stored
+	return d.ID, nil
+}
+
+// Delete removes the session.
+func (m *MemoryStore) Delete(_ context.Context, d *Data) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, d.ID)
+	return nil
+}
+
+func copyValues(v map[string]string) map[string]string {
+	if v == nil {
+		return nil
+	}
+	c := make(map[string]string, len(v))
+	for k, val := range v {
+		c[k] = val
+	}
+	return c
+}
+
+// sealedName is the name the CookieStore seals sessions for. It doesn't
+// depend on Interceptor.CookieName, which the store doesn't know about.
+const sealedName = "session"
+
+// CookieStore is a Store keeping sessions in the session cookie itself,
+// sealed with a safehttp.CookieCodec, so that clients can neither read nor
+// tamper with them.
+//
+// Since the session is held by the client, deleting it only removes the
+// cookie: a copy of the cookie taken before remains valid until the session
+// expires. Sessions must also be small enough to fit in a cookie.
+type CookieStore struct {
+	codec *safehttp.CookieCodec
+}
+
+var _ Store = &CookieStore{}
+
+// NewCookieStore creates a CookieStore sealing sessions with the given keys,
+// which must be 16, 24 or 32 bytes long. Sessions are sealed with the first
+// key and opened with any of them, see safehttp.NewCookieCodec.
+func NewCookieStore(keys ...[]byte) (*CookieStore, error) {
+	codec, err := safehttp.NewCookieCodec(keys...)
+	if err != nil {
+		return nil, err
+	}
+	return &CookieStore{codec: codec}, nil
+}
+
+// Load opens the session held by the cookie.
+func (s *CookieStore) Load(_ context.Context, cookie string) (*Data, error) {
+	plain, err := s.codec.Open(sealedName, cookie)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var d Data
+	if err := json.Unmarshal(plain, &d); err != nil {
+		return nil, ErrNotFound
+	}
+	if !now().Before(d.Expires) {
+		return nil, ErrNotFound
+	}
+	return &d, nil
+}
+
+// Save seals the session, returning it as the cookie value. It fails if the
+// result doesn't fit in a cookie.
+func (s *CookieStore) Save(_ context.Context, d *Data) (string, error) {
+	plain, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	return s.codec.Seal(sealedName, plain)
+}
+
+// Delete is a no-op, as the session is removed with its cookie.
+func (s *CookieStore) Delete(context.Context, *Data) error {
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package strictrequest provides a plugin that rejects malformed or ambiguous
+// requests, which lenient reverse proxies may forward and interpret
+// differently from the server.
+//
+// This is a defense in depth mechanism against HTTP request smuggling
+// (https://portswigger.net/web-security/request-smuggling) and path or header
+// injection through control characters.
+package strictrequest
+
+import (
+	"strings"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/restricted"
+)
+
+// DefaultMaxURLLength is the default maximum length of the request target.
+const DefaultMaxURLLength = 8 << 10 // 8 KiB
+
+// DefaultMethods are the HTTP methods allowed by the Default Interceptor.
+// TRACE is not one of them, as it echoes the request, including its
+// credentials, back to the client.
+var DefaultMethods = []string{
+	safehttp.MethodConnect,
+	safehttp.MethodDelete,
+	safehttp.MethodGet,
+	safehttp.MethodHead,
+	safehttp.MethodOptions,
+	safehttp.MethodPatch,
+	safehttp.MethodPost,
+	safehttp.MethodPut,
+}
+
+// Interceptor rejects requests that are malformed or ambiguous:
+//  - requests with an unknown method are rejected with 501 Not Implemented,
+//  - requests with a target longer than MaxURLLength are rejected with 414
+//    Request-URI Too Long,
+//  - requests with both a Content-Length and a Transfer-Encoding, with several
+//    Content-Length values or, if RejectChunked is set, with a chunked body
+//    are rejected with 400 Bad Request,
+//  - requests with NUL bytes or other control characters in the path, raw or
+//    percent-encoded, or in header values are rejected with 400 Bad Request.
+type Interceptor struct {
+	// MaxURLLength is the maximum length of the request target. If zero,
+	// there's no limit.
+	MaxURLLength int
+	// RejectChunked rejects HTTP/1.1 requests with a chunked body. It can be
+	// set if the server is behind a proxy that doesn't support chunked bodies
+	// and may rely on a Content-Length header that the server ignores.
+	// Chunked bodies are otherwise allowed and, like any other body, limited
+	// by the maximum request body size of the ServeMux.
+	RejectChunked bool
+
+	methods map[string]bool
+}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+// New creates an Interceptor that only allows the given HTTP methods, in
+// addition to rejecting all the other malformed requests.
+func New(methods ...string) Interceptor {
+	it := Interceptor{MaxURLLength: DefaultMaxURLLength, methods: map[string]bool{}}
+	for _, m := range methods {
+		it.methods[m] = true
+	}
+	return it
+}
+
+// Default creates an Interceptor allowing the DefaultMethods.
+func Default() Interceptor {
+	return New(DefaultMethods...)
+}
+
+// Before rejects the request if it's malformed or ambiguous.
+func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+	if code := it.check(r); code != safehttp.StatusOK {
+		return w.WriteError(code)
+	}
+	return safehttp.NotWritten()
+}
+
+// check returns the status code of the error response for the request, or
+// StatusOK if it's valid.
+func (it Interceptor) check(r *safehttp.IncomingRequest) safehttp.StatusCode {
+	raw := restricted.RawRequest(r)
+	if !it.methods[raw.Method] {
+		return safehttp.StatusNotImplemented
+	}
+	if it.MaxURLLength > 0 && len(raw.RequestURI) > it.MaxURLLength {
+		return safehttp.StatusRequestURITooLong
+	}
+	chunked := len(raw.TransferEncoding) > 0
+	if len(raw.Header["Content-Length"]) > 1 || chunked && (len(raw.Header["Content-Length"]) > 0 || it.RejectChunked) {
+		return safehttp.StatusBadRequest
+	}
+	if hasControl(raw.RequestURI) || hasControl(raw.URL.Path) {
+		return safehttp.StatusBadRequest
+	}
+	for _, vs := range raw.Header {
+		for _, v := range vs {
+			if hasControl(strings.ReplaceAll(v, "\t", "")) {
+				return safehttp.StatusBadRequest
+			}
+		}
+	}
+	return safehttp.StatusOK
+}
+
+// hasControl reports whether s contains ASCII control characters, including
+// NUL and DEL.
+func hasControl(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return r < ' ' || r == 0x7f }) >= 0
+}
+
+// Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
+}
+
+// Match returns false since there are no supported configurations.
+func (Interceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
@@ -0,0 +1,209 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strictrequest_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/strictrequest"
+	"github.com/google/safehtml"
+)
+
+func TestInterceptor(t *testing.T) {
+	tests := []struct {
+		name          string
+		req           func() *http.Request
+		rejectChunked bool
+		wantStatus    safehttp.StatusCode
+	}{
+		{
+			name: "Valid",
+			req: func() *http.Request {
+				r := httptest.NewRequest(safehttp.MethodPost, "http://foo.com/path?q=a%00b", strings.NewReader("body"))
+				r.Header.Set("User-Agent", "Mozilla/5.0\t(X11)")
+				return r
+			},
+			wantStatus: safehttp.StatusOK,
+		},
+		{
+			name: "Unknown method",
+			req: func() *http.Request {
+				return httptest.NewRequest("PROPFIND", "http://foo.com/path", nil)
+			},
+			wantStatus: safehttp.StatusNotImplemented,
+		},
+		{
+			name: "URL too long",
+			req: func() *http.Request {
+				return httptest.NewRequest(safehttp.MethodGet, "http://foo.com/"+strings.Repeat("a", strictrequest.DefaultMaxURLLength), nil)
+			},
+			wantStatus: safehttp.StatusRequestURITooLong,
+		},
+		{
+			name: "Content-Length and Transfer-Encoding",
+			req: func() *http.Request {
+				r := httptest.NewRequest(safehttp.MethodPost, "http://foo.com/path", strings.NewReader("body"))
+				r.TransferEncoding = []string{"chunked"}
+				r.Header.Set("Content-Length", "4")
+				return r
+			},
+			wantStatus: safehttp.StatusBadRequest,
+		},
+		{
+			name: "Multiple Content-Length",
+			req: func() *http.Request {
+				r := httptest.NewRequest(safehttp.MethodPost, "http://foo.com/path", strings.NewReader("body"))
+				r.Header["Content-Length"] = []string{"4", "4"}
+				return r
+			},
+			wantStatus: safehttp.StatusBadRequest,
+		},
+		{
+			name: "Chunked",
+			req: func() *http.Request {
+				r := httptest.NewRequest(safehttp.MethodPost, "http://foo.com/path", strings.NewReader("body"))
+				r.TransferEncoding = []string{"chunked"}
+				return r
+			},
+			wantStatus: safehttp.StatusOK,
+		},
+		{
+			name: "Chunked rejected",
+			req: func() *http.Request {
+				r := httptest.NewRequest(safehttp.MethodPost, "http://foo.com/path", strings.NewReader("body"))
+				r.TransferEncoding = []string{"chunked"}
+				return r
+			},
+			rejectChunked: true,
+			wantStatus:    safehttp.StatusBadRequest,
+		},
+		{
+			name: "TRACE",
+			req: func() *http.Request {
+				return httptest.NewRequest(safehttp.MethodTrace, "http://foo.com/path", nil)
+			},
+			wantStatus: safehttp.StatusNotImplemented,
+		},
+		{
+			name: "Encoded NUL in path",
+			req: func() *http.Request {
+				return httptest.NewRequest(safehttp.MethodGet, "http://foo.com/path%00.html", nil)
+			},
+			wantStatus: safehttp.StatusBadRequest,
+		},
+		{
+			name: "Encoded newline in path",
+			req: func() *http.Request {
+				return httptest.NewRequest(safehttp.MethodGet, "http://foo.com/path%0d%0aX-Injected:%20a", nil)
+			},
+			wantStatus: safehttp.StatusBadRequest,
+		},
+		{
+			name: "Control character in header",
+			req: func() *http.Request {
+				r := httptest.NewRequest(safehttp.MethodGet, "http://foo.com/path", nil)
+				r.Header.Set("X-Forwarded-For", "1.2.3.4\x00")
+				return r
+			},
+			wantStatus: safehttp.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it := strictrequest.Default()
+			it.RejectChunked = tt.rejectChunked
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.Intercept(it)
+			mux := mb.Mux()
+
+			h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return w.Write(safehtml.HTMLEscaped("hello"))
+			})
+			mux.Handle("/", safehttp.MethodGet, h)
+			mux.Handle("/", safehttp.MethodPost, h)
+			mux.Handle("/", "PROPFIND", h)
+			mux.Handle("/", safehttp.MethodTrace, h)
+
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, tt.req())
+
+			if rw.Code != int(tt.wantStatus) {
+				t.Errorf("rw.Code: got %v want %v", rw.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestInterceptorChunkedBodyLimit(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(strictrequest.Default())
+	mb.MaxRequestBodySize(10)
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodPost, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		if _, err := ioutil.ReadAll(r.Body()); err != nil {
+			return w.WriteError(safehttp.StatusRequestEntityTooLarge)
+		}
+		return w.Write(safehtml.HTMLEscaped("hello"))
+	}))
+
+	tests := []struct {
+		body       string
+		wantStatus safehttp.StatusCode
+	}{
+		{body: "0123456789", wantStatus: safehttp.StatusOK},
+		{body: "0123456789a", wantStatus: safehttp.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(safehttp.MethodPost, "http://foo.com/", strings.NewReader(tt.body))
+		req.TransferEncoding = []string{"chunked"}
+		req.ContentLength = -1
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, req)
+		if rw.Code != int(tt.wantStatus) {
+			t.Errorf("body of length %d: rw.Code: got %v want %v", len(tt.body), rw.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestInterceptorCustomMethods(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(strictrequest.New(safehttp.MethodGet, "PROPFIND"))
+	mux := mb.Mux()
+	h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehtml.HTMLEscaped("hello"))
+	})
+	mux.Handle("/", "PROPFIND", h)
+	mux.Handle("/", safehttp.MethodPost, h)
+
+	tests := []struct {
+		method     string
+		wantStatus safehttp.StatusCode
+	}{
+		{method: "PROPFIND", wantStatus: safehttp.StatusOK},
+		{method: safehttp.MethodPost, wantStatus: safehttp.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, httptest.NewRequest(tt.method, "http://foo.com/", nil))
+		if rw.Code != int(tt.wantStatus) {
+			t.Errorf("%s: rw.Code: got %v want %v", tt.method, rw.Code, tt.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// SpanContext identifies a span across process boundaries, as propagated by
+// the W3C traceparent header (https://www.w3.org/TR/trace-context/).
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	// Flags are the trace flags, e.g. FlagSampled.
+	Flags byte
+}
+
+// FlagSampled is the trace flag set when the caller may have recorded the
+// trace.
+const FlagSampled byte = 0x01
+
+// IsValid reports whether the trace and span IDs are non-zero.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent returns the value of the traceparent header propagating sc, or
+// the empty string if sc is not valid.
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return fmt.Sprintf("00-%x-%x-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses the value of a traceparent header.
+func ParseTraceparent(v string) (SpanContext, error) {
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	const size = 2 + 1 + 32 + 1 + 16 + 1 + 2
+	if len(v) < size || v[2] != '-' || v[35] != '-' || v[52] != '-' {
+		return SpanContext{}, fmt.Errorf("malformed traceparent %q", v)
+	}
+	var version [1]byte
+	if err := decodeHex(version[:], v[0:2]); err != nil {
+		return SpanContext{}, err
+	}
+	switch {
+	case version[0] == 0xff:
+		return SpanContext{}, errors.New("invalid traceparent version ff")
+	case version[0] == 0 && len(v) != size:
+		return SpanContext{}, fmt.Errorf("malformed traceparent %q", v)
+	case len(v) > size && v[size] != '-':
+		// Future versions can only append fields.
+		return SpanContext{}, fmt.Errorf("malformed traceparent %q", v)
+	}
+
+	var sc SpanContext
+	var flags [1]byte
+	if err := decodeHex(sc.TraceID[:], v[3:35]); err != nil {
+		return SpanContext{}, err
+	}
+	if err := decodeHex(sc.SpanID[:], v[36:52]); err != nil {
+		return SpanContext{}, err
+	}
+	if err := decodeHex(flags[:], v[53:55]); err != nil {
+		return SpanContext{}, err
+	}
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("traceparent %q has zero IDs", v)
+	}
+	return sc, nil
+}
+
+// decodeHex decodes lowercase hex-encoded s into dst.
+func decodeHex(dst []byte, s string) error {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return fmt.Errorf("invalid hex %q in traceparent", s)
+		}
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing_test
+
+import (
+	"testing"
+
+	"github.com/google/go-safeweb/safehttp/plugins/tracing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const v = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := tracing.ParseTraceparent(v)
+	if err != nil {
+		t.Fatalf("tracing.ParseTraceparent(%q) got err: %v", v, err)
+	}
+	if sc.Flags != tracing.FlagSampled {
+		t.Errorf("sc.Flags: got %x, want %x", sc.Flags, tracing.FlagSampled)
+	}
+	if got := sc.Traceparent(); got != v {
+		t.Errorf("sc.Traceparent(): got %q, want %q", got, v)
+	}
+
+	future := "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra"
+	if _, err := tracing.ParseTraceparent(future); err != nil {
+		t.Errorf("tracing.ParseTraceparent(%q) got err: %v", future, err)
+	}
+}
+
+func TestParseTraceparentInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01extra",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01",
+	}
+	for _, v := range tests {
+		if sc, err := tracing.ParseTraceparent(v); err == nil {
+			t.Errorf("tracing.ParseTraceparent(%q): got %v, want error", v, sc)
+		}
+	}
+}
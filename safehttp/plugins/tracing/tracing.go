@@ -0,0 +1,243 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing provides a safehttp.Interceptor starting a server span for
+// every request, and helpers for handlers to create child spans.
+//
+// The plugin doesn't depend on a tracing library: spans are created by a
+// Tracer, which can be implemented on top of OpenTelemetry in a few lines,
+// e.g.:
+//
+//	type otelTracer struct{ t trace.Tracer }
+//
+//	func (o otelTracer) Start(ctx context.Context, name string, opts tracing.StartOptions) tracing.Span {
+//		if p, ok := opts.Parent.(otelSpan); ok {
+//			ctx = trace.ContextWithSpan(ctx, p.Span)
+//		} else if opts.Remote.IsValid() {
+//			ctx = trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+//				TraceID: opts.Remote.TraceID, SpanID: opts.Remote.SpanID,
+//				TraceFlags: trace.TraceFlags(opts.Remote.Flags), Remote: true,
+//			}))
+//		}
+//		kind := trace.SpanKindInternal
+//		if opts.Kind == tracing.KindServer {
+//			kind = trace.SpanKindServer
+//		}
+//		_, s := o.t.Start(ctx, name, trace.WithSpanKind(kind))
+//		return otelSpan{s}
+//	}
+//
+// where otelSpan wraps a trace.Span to implement Span.
+//
+// The traceparent header (https://www.w3.org/TR/trace-context/) of requests
+// is only honored if they come from a trusted source, by default a proxy
+// trusted with safehttp.ServeMuxConfig.TrustProxies. Otherwise, anyone could
+// join their requests to existing traces or force them to be sampled.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/requestid"
+)
+
+// Kind is the kind of a span.
+type Kind int
+
+const (
+	// KindInternal is the kind of spans of operations within the server,
+	// e.g. created by StartSpan.
+	KindInternal Kind = iota
+	// KindServer is the kind of the spans of requests.
+	KindServer
+)
+
+// StartOptions are the options of a span being started.
+type StartOptions struct {
+	Kind Kind
+	// Parent is the parent span, or nil for the span of a request.
+	Parent Span
+	// Remote is the span context propagated by the client of a request, if
+	// trusted. It's only set for server spans and it's not valid if the trace
+	// wasn't propagated.
+	Remote SpanContext
+}
+
+// Attribute is a key/value attribute of a span.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SpanContext returns the span context propagated to downstream services.
+	SpanContext() SpanContext
+	// SetAttributes sets attributes of the span.
+	SetAttributes(attrs ...Attribute)
+	// RecordError records an error and marks the span as failed.
+	RecordError(err error)
+	// End ends the span.
+	End()
+}
+
+// Tracer starts spans.
+type Tracer interface {
+	// Start starts a span with the given name. The context is the one of the
+	// request or the one passed to StartSpan.
+	Start(ctx context.Context, name string, opts StartOptions) Span
+}
+
+type serverSpanKey struct{}
+
+type spanKey struct{}
+
+type tracerKey struct{}
+
+// SpanFromContext returns the innermost span in the context, either started
+// with StartSpan or the span of the request, or nil if there is none.
+func SpanFromContext(ctx context.Context) Span {
+	if s, ok := ctx.Value(spanKey{}).(Span); ok {
+		return s
+	}
+	fv := safehttp.FlightValues(ctx)
+	if fv == nil {
+		return nil
+	}
+	s, _ := fv.Get(serverSpanKey{}).(Span)
+	return s
+}
+
+// SpanFromRequest returns the span of the request, or nil if there is none.
+func SpanFromRequest(r *safehttp.IncomingRequest) Span {
+	return SpanFromContext(r.Context())
+}
+
+// StartSpan starts a span as a child of the innermost span in the context,
+// using the Tracer of the Interceptor that started the span of the request.
+// The returned context holds the new span, which must be ended by the caller.
+//
+// If the request has no span, e.g. because the Interceptor is not installed,
+// StartSpan returns ctx and a Span that does nothing.
+func StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	parent := SpanFromContext(ctx)
+	var t Tracer
+	if fv := safehttp.FlightValues(ctx); fv != nil {
+		t, _ = fv.Get(tracerKey{}).(Tracer)
+	}
+	if parent == nil || t == nil {
+		return ctx, noopSpan{}
+	}
+	s := t.Start(ctx, name, StartOptions{Kind: KindInternal, Parent: parent})
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Traceparent returns the traceparent header value propagating the innermost
+// span in the context to downstream services, or the empty string if there
+// is none.
+func Traceparent(ctx context.Context) string {
+	s := SpanFromContext(ctx)
+	if s == nil {
+		return ""
+	}
+	return s.SpanContext().Traceparent()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SpanContext() SpanContext         { return SpanContext{} }
+func (noopSpan) SetAttributes(attrs ...Attribute) {}
+func (noopSpan) RecordError(err error)            {}
+func (noopSpan) End()                             {}
+
+// Interceptor starts a server span for every request routed to a handler and
+// ends it once the response has been written, recording the status code.
+// Responses with a 5xx status code mark the span as failed.
+//
+// The Interceptor should be installed before any interceptor that can reject
+// requests, so that rejected requests have spans too, and after the
+// requestid.Interceptor, if any, so that spans record the ID of requests.
+//
+// Spans are named after the method and the route pattern, e.g.
+// "GET /users/{id}", rather than the path, to keep the number of span names
+// bounded.
+type Interceptor struct {
+	// Tracer starts the spans. It must be set.
+	Tracer Tracer
+	// TrustTraceparent reports whether the traceparent header of the request
+	// can be trusted. If nil, it's only trusted if the request comes from a
+	// trusted proxy, as reported by IncomingRequest.FromTrustedProxy.
+	TrustTraceparent func(r *safehttp.IncomingRequest) bool
+}
+
+var (
+	_ safehttp.Interceptor = Interceptor{}
+	_ safehttp.Observer    = Interceptor{}
+)
+
+// Before starts the span of the request.
+func (it Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+	opts := StartOptions{Kind: KindServer}
+	trust := it.TrustTraceparent
+	if trust == nil {
+		trust = (*safehttp.IncomingRequest).FromTrustedProxy
+	}
+	if v := r.Header.Get("Traceparent"); v != "" && trust(r) {
+		if sc, err := ParseTraceparent(v); err == nil {
+			opts.Remote = sc
+		}
+	}
+	s := it.Tracer.Start(r.Context(), r.Method()+" "+r.Pattern(), opts)
+	s.SetAttributes(
+		Attribute{Key: "http.method", Value: r.Method()},
+		Attribute{Key: "http.route", Value: r.Pattern()},
+		Attribute{Key: "http.target", Value: r.URL().Path()},
+	)
+	if id := requestid.FromRequest(r); id != "" {
+		s.SetAttributes(Attribute{Key: "http.request_id", Value: id})
+	}
+	fv := safehttp.FlightValues(r.Context())
+	fv.Put(serverSpanKey{}, s)
+	fv.Put(tracerKey{}, it.Tracer)
+	return safehttp.NotWritten()
+}
+
+// Commit is a no-op, required to satisfy the safehttp.Interceptor interface.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
+}
+
+// Match returns false since there are no supported configurations.
+func (Interceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
+
+// Observe records the status code of the response and ends the span of the
+// request. Spans of requests whose processing panics are not ended.
+func (Interceptor) Observe(r *safehttp.IncomingRequest, info safehttp.ResponseInfo, _ safehttp.InterceptorConfig) {
+	s, ok := safehttp.FlightValues(r.Context()).Get(serverSpanKey{}).(Span)
+	if !ok {
+		// Before didn't run, the request was rejected by another interceptor.
+		return
+	}
+	s.SetAttributes(
+		Attribute{Key: "http.status_code", Value: int(info.Code)},
+		Attribute{Key: "http.response_content_length", Value: info.BytesWritten},
+	)
+	if info.Code >= 500 {
+		s.RecordError(fmt.Errorf("HTTP %d %s", info.Code, info.Code.String()))
+	}
+	s.End()
+}
@@ -0,0 +1,204 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/requestid"
+	"github.com/google/go-safeweb/safehttp/plugins/tracing"
+)
+
+type fakeSpan struct {
+	name   string
+	opts   tracing.StartOptions
+	sc     tracing.SpanContext
+	attrs  map[string]interface{}
+	errs   []string
+	ended  bool
+	parent string
+}
+
+func (s *fakeSpan) SpanContext() tracing.SpanContext { return s.sc }
+
+func (s *fakeSpan) SetAttributes(attrs ...tracing.Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *fakeSpan) RecordError(err error) { s.errs = append(s.errs, err.Error()) }
+
+func (s *fakeSpan) End() { s.ended = true }
+
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string, opts tracing.StartOptions) tracing.Span {
+	s := &fakeSpan{name: name, opts: opts, attrs: map[string]interface{}{}}
+	s.sc.TraceID = opts.Remote.TraceID
+	if p, ok := opts.Parent.(*fakeSpan); ok {
+		s.parent = p.name
+		s.sc.TraceID = p.sc.TraceID
+	}
+	if s.sc.TraceID == [16]byte{} {
+		s.sc.TraceID[0] = 0xaa
+	}
+	s.sc.SpanID[7] = byte(len(t.spans) + 1)
+	t.spans = append(t.spans, s)
+	return s
+}
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func newMux(t *testing.T, tr *fakeTracer, h safehttp.Handler) *safehttp.ServeMux {
+	t.Helper()
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.TrustProxies(safehttp.ProxyPolicy{TrustedProxies: []*net.IPNet{proxies}})
+	mb.Intercept(
+		requestid.Interceptor{Generate: func() string { return "req-1" }},
+		tracing.Interceptor{Tracer: tr},
+	)
+	m := mb.Mux()
+	m.Handle("/users/{id}", safehttp.MethodGet, h)
+	return m
+}
+
+func TestInterceptor(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		wantRemote string
+	}{
+		{name: "Trusted proxy", remoteAddr: "10.0.0.1:1234", wantRemote: traceparent},
+		{name: "Untrusted client", remoteAddr: "192.0.2.1:1234", wantRemote: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &fakeTracer{}
+			m := newMux(t, tr, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return w.Write(safehttp.NoContentResponse{})
+			}))
+			req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com/users/42", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("Traceparent", traceparent)
+			m.ServeHTTP(httptest.NewRecorder(), req)
+
+			if len(tr.spans) != 1 {
+				t.Fatalf("len(tr.spans): got %d, want 1", len(tr.spans))
+			}
+			s := tr.spans[0]
+			if want := "GET /users/{id}"; s.name != want {
+				t.Errorf("s.name: got %q, want %q", s.name, want)
+			}
+			if s.opts.Kind != tracing.KindServer {
+				t.Errorf("s.opts.Kind: got %v, want KindServer", s.opts.Kind)
+			}
+			if got := s.opts.Remote.Traceparent(); got != tt.wantRemote {
+				t.Errorf("s.opts.Remote.Traceparent(): got %q, want %q", got, tt.wantRemote)
+			}
+			wantAttrs := map[string]interface{}{
+				"http.method":                  "GET",
+				"http.route":                   "/users/{id}",
+				"http.target":                  "/users/42",
+				"http.request_id":              "req-1",
+				"http.status_code":             204,
+				"http.response_content_length": int64(0),
+			}
+			if diff := cmp.Diff(wantAttrs, s.attrs); diff != "" {
+				t.Errorf("s.attrs mismatch (-want +got):\n%s", diff)
+			}
+			if !s.ended {
+				t.Error("s.ended: got false, want true")
+			}
+			if len(s.errs) != 0 {
+				t.Errorf("s.errs: got %v, want none", s.errs)
+			}
+		})
+	}
+}
+
+func TestInterceptorServerError(t *testing.T) {
+	tr := &fakeTracer{}
+	m := newMux(t, tr, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.WriteError(safehttp.StatusServiceUnavailable)
+	}))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(safehttp.MethodGet, "https://foo.com/users/42", nil))
+
+	s := tr.spans[0]
+	if want := []string{"HTTP 503 Service Unavailable"}; !cmp.Equal(want, s.errs) {
+		t.Errorf("s.errs: got %v, want %v", s.errs, want)
+	}
+	if !s.ended {
+		t.Error("s.ended: got false, want true")
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+	tr := &fakeTracer{}
+	var tp string
+	m := newMux(t, tr, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		ctx, db := tracing.StartSpan(r.Context(), "db")
+		_, query := tracing.StartSpan(ctx, "query")
+		query.RecordError(errors.New("timeout"))
+		query.End()
+		tp = tracing.Traceparent(ctx)
+		db.End()
+		return w.Write(safehttp.NoContentResponse{})
+	}))
+	req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com/users/42", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("Traceparent", traceparent)
+	m.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(tr.spans) != 3 {
+		t.Fatalf("len(tr.spans): got %d, want 3", len(tr.spans))
+	}
+	if got, want := tr.spans[1].parent, "GET /users/{id}"; got != want {
+		t.Errorf("db span parent: got %q, want %q", got, want)
+	}
+	if got, want := tr.spans[2].parent, "db"; got != want {
+		t.Errorf("query span parent: got %q, want %q", got, want)
+	}
+	if got, want := tr.spans[2].errs, []string{"timeout"}; !cmp.Equal(want, got) {
+		t.Errorf("query span errs: got %v, want %v", got, want)
+	}
+	if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000002-00"; tp != want {
+		t.Errorf("tracing.Traceparent(): got %q, want %q", tp, want)
+	}
+}
+
+func TestStartSpanWithoutInterceptor(t *testing.T) {
+	ctx := context.Background()
+	gotCtx, s := tracing.StartSpan(ctx, "op")
+	if gotCtx != ctx {
+		t.Error("tracing.StartSpan(): got a new context, want the same")
+	}
+	s.End()
+	if got := tracing.Traceparent(ctx); got != "" {
+		t.Errorf("tracing.Traceparent(): got %q, want empty", got)
+	}
+}
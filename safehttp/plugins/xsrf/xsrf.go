@@ -14,12 +14,45 @@
 
 // Package xsrf contains helper functions for the safehttp.Interceptor that
 // provide protection against Cross-Site Request Forgery attacks.
+//
+// Different groups of handlers served by the same ServeMux can use different
+// schemes, e.g. xsrfhtml for server-side rendered forms and xsrfangular for
+// single page applications, by installing each interceptor only on its handlers
+// with safehttp.WithInterceptors. The default cookie names of the two schemes
+// are distinct. When changing them, make sure they still differ: the Angular
+// cookie is set on the / path, so a cookie with the same name would be sent to
+// and overwritten by every handler of the other group, invalidating the tokens
+// of one of the schemes.
 package xsrf
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+
 	"github.com/google/go-safeweb/safehttp"
 )
 
+const (
+	// DefaultEntropy is the number of random bytes used for XSRF cookie values
+	// unless configured otherwise.
+	DefaultEntropy = 20
+	// MinEntropy is the minimum number of random bytes that can be configured
+	// for XSRF cookie values.
+	MinEntropy = 16
+)
+
+const (
+	// DefaultLifetime is how long XSRF tokens are valid unless configured
+	// otherwise.
+	DefaultLifetime = 24 * time.Hour
+	// MinLifetime is the minimum lifetime that can be configured for XSRF
+	// tokens.
+	MinLifetime = 10 * time.Minute
+)
+
 var statePreservingMethods = map[string]bool{
 	safehttp.MethodGet:     true,
 	safehttp.MethodHead:    true,
@@ -30,3 +63,108 @@ var statePreservingMethods = map[string]bool{
 func StatePreserving(r *safehttp.IncomingRequest) bool {
 	return statePreservingMethods[r.Method()]
 }
+
+type disable struct{}
+
+// Disable returns a configuration that disables XSRF protection for the
+// handler it's passed to, e.g. for webhook receivers or APIs authenticated with
+// bearer tokens rather than cookies. State changing requests are let through
+// without checking the token, while tokens are still issued as usual. The
+// reason should explain why the handler is not affected by XSRF.
+//
+// It's recognized by the xsrfhtml and xsrfangular interceptors.
+func Disable(reason string) safehttp.InterceptorConfig {
+	return disable{}
+}
+
+// Disabled reports whether cfg is a configuration returned by Disable.
+func Disabled(cfg safehttp.InterceptorConfig) bool {
+	_, ok := cfg.(disable)
+	return ok
+}
+
+// ValidateEntropy returns an error if n random bytes are not enough to be used
+// for XSRF cookie values.
+func ValidateEntropy(n int) error {
+	if n < MinEntropy {
+		return fmt.Errorf("entropy of %d bytes is below the minimum of %d bytes", n, MinEntropy)
+	}
+	return nil
+}
+
+// ValidateLifetime returns an error if d is too short to be used as the
+// lifetime of XSRF tokens.
+func ValidateLifetime(d time.Duration) error {
+	if d < MinLifetime {
+		return fmt.Errorf("token lifetime of %v is below the minimum of %v", d, MinLifetime)
+	}
+	return nil
+}
+
+// RandomValue returns the base64 encoding of n cryptographically secure random
+// bytes. If n is 0, DefaultEntropy is used.
+func RandomValue(n int) (string, error) {
+	if n == 0 {
+		n = DefaultEntropy
+	}
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("crypto/rand.Read: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// ValidValue reports whether v is well-formed, i.e. whether it's the base64
+// encoding of n bytes as returned by RandomValue. If n is 0, DefaultEntropy is
+// used.
+func ValidValue(v string, n int) bool {
+	if n == 0 {
+		n = DefaultEntropy
+	}
+	if len(v) != base64.StdEncoding.EncodedLen(n) {
+		return false
+	}
+	buf, err := base64.StdEncoding.DecodeString(v)
+	return err == nil && len(buf) == n
+}
+
+// KeyProvider provides the secret keys used to sign and validate XSRF tokens.
+// It allows rotating keys without downtime: tokens signed with a previous key
+// are still accepted until the key is retired.
+//
+// Implementations must be safe for concurrent use.
+type KeyProvider interface {
+	// Keys returns the current key, used to sign new tokens, and the previous
+	// keys, which are only used to validate tokens.
+	Keys() (current string, previous []string)
+}
+
+// StaticKey is a KeyProvider with a single key that never changes.
+type StaticKey string
+
+// Keys returns the key as the current one, without any previous keys.
+func (k StaticKey) Keys() (current string, previous []string) {
+	return string(k), nil
+}
+
+type cookieMintedKey struct{}
+
+// MarkCookieMinted records that a new XSRF cookie has been set in the response
+// to the given request. It should be called by XSRF interceptors during their
+// Commit phase.
+func MarkCookieMinted(r *safehttp.IncomingRequest) {
+	safehttp.FlightValues(r.Context()).Put(cookieMintedKey{}, true)
+}
+
+// CookieMintedFromContext reports whether a new XSRF cookie has been set in the
+// response to the request with the given context, rather than the one sent by
+// the client being reused. Clients that never persist the cookie cause a new
+// one to be minted on every request.
+func CookieMintedFromContext(ctx context.Context) bool {
+	fv := safehttp.FlightValues(ctx)
+	if fv == nil {
+		return false
+	}
+	v, _ := fv.Get(cookieMintedKey{}).(bool)
+	return v
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xsrf_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/xsrf"
+	"github.com/google/go-safeweb/safehttp/plugins/xsrf/xsrfangular"
+	"github.com/google/go-safeweb/safehttp/plugins/xsrf/xsrfhtml"
+	"github.com/google/safehtml"
+)
+
+func TestRouteGroupSchemes(t *testing.T) {
+	mux := safehttp.NewServeMuxConfig(nil).Mux()
+	h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehtml.HTMLEscaped("ok"))
+	})
+	forms := safehttp.WithInterceptors{&xsrfhtml.Interceptor{SecretAppKey: "testSecretAppKey"}}
+	spa := safehttp.WithInterceptors{xsrfangular.Default()}
+	for _, m := range []string{safehttp.MethodGet, safehttp.MethodPost} {
+		mux.Handle("/forms/", m, h, forms)
+		mux.Handle("/spa/", m, h, spa)
+	}
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	cookieNames := func(rr *httptest.ResponseRecorder) map[string]bool {
+		names := map[string]bool{}
+		for _, c := range rr.Result().Cookies() {
+			names[c.Name] = true
+		}
+		return names
+	}
+
+	formsCookies := cookieNames(serve(httptest.NewRequest(safehttp.MethodGet, "http://foo.com/forms/", nil)))
+	spaRR := serve(httptest.NewRequest(safehttp.MethodGet, "http://foo.com/spa/", nil))
+	spaCookies := cookieNames(spaRR)
+	if len(formsCookies) != 1 || len(spaCookies) != 1 {
+		t.Fatalf("cookies: got %v for forms and %v for spa, want one each", formsCookies, spaCookies)
+	}
+	for name := range formsCookies {
+		if spaCookies[name] {
+			t.Errorf("cookie %q is used by both schemes", name)
+		}
+	}
+
+	var spaToken string
+	for _, c := range spaRR.Result().Cookies() {
+		spaToken = c.Value
+	}
+	post := func(path string) *http.Request {
+		req := httptest.NewRequest(safehttp.MethodPost, "http://foo.com"+path, nil)
+		req.Header.Set("Cookie", "XSRF-TOKEN="+spaToken)
+		req.Header.Set("X-XSRF-TOKEN", spaToken)
+		return req
+	}
+	if got, want := serve(post("/spa/")).Code, int(safehttp.StatusOK); got != want {
+		t.Errorf("POST /spa/ with Angular token: rr.Code got %v, want %v", got, want)
+	}
+	if got, want := serve(post("/forms/")).Code, int(safehttp.StatusForbidden); got != want {
+		t.Errorf("POST /forms/ with Angular token: rr.Code got %v, want %v", got, want)
+	}
+}
+
+func TestDisable(t *testing.T) {
+	tests := []struct {
+		name        string
+		interceptor safehttp.Interceptor
+	}{
+		{name: "xsrfhtml", interceptor: &xsrfhtml.Interceptor{SecretAppKey: "testSecretAppKey"}},
+		{name: "xsrfangular", interceptor: xsrfangular.Default()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.Intercept(tt.interceptor)
+			mux := mb.Mux()
+			h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return w.Write(safehtml.HTMLEscaped("ok"))
+			})
+			mux.Handle("/webhook", safehttp.MethodPost, h, xsrf.Disable("authenticated with a signature"))
+			mux.Handle("/form", safehttp.MethodPost, h)
+
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest(safehttp.MethodPost, "https://foo.com/webhook", nil))
+			if got, want := rr.Code, int(safehttp.StatusOK); got != want {
+				t.Errorf("POST /webhook: rr.Code got %v, want %v", got, want)
+			}
+
+			rr = httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest(safehttp.MethodPost, "https://foo.com/form", nil))
+			if got, want := rr.Code, int(safehttp.StatusForbidden); got != want {
+				t.Errorf("POST /form: rr.Code got %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -15,9 +15,8 @@
 package xsrfangular
 
 import (
-	"crypto/rand"
-	"encoding/base64"
-	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/go-safeweb/safehttp"
@@ -33,10 +32,35 @@ type Interceptor struct {
 	TokenCookieName string
 	// TokenHeaderName is the name of the HTTP header that holds the XSRF token.
 	TokenHeaderName string
+	// Partitioned makes the token cookie partitioned (CHIPS), for applications
+	// running in embedded third-party contexts. Partitioned cookies are sent
+	// with SameSite=None, so they have to be Secure.
+	Partitioned bool
+	// RejectionHeaderName, if set, is the name of a response header added with
+	// the RejectionDetail value whenever a request is rejected because of a
+	// missing or invalid XSRF token. Clients can use it to tell these errors
+	// apart from other authorization failures and recover, e.g. by reloading to
+	// get a new cookie. The value is the same regardless of which check failed.
+	RejectionHeaderName string
+	// OnRejected, if set, is called with the request and the status code of
+	// the error response whenever Before rejects a request, e.g. to log or
+	// count XSRF failures.
+	OnRejected func(r *safehttp.IncomingRequest, code safehttp.StatusCode)
+
+	// entropy is the number of random bytes used for the token.
+	entropy int
+	// lifetime is how long the token cookie is valid, xsrf.DefaultLifetime if
+	// zero.
+	lifetime time.Duration
 }
 
+var now = time.Now
+
 var _ safehttp.Interceptor = &Interceptor{}
 
+// RejectionDetail is the value of the RejectionHeaderName header.
+const RejectionDetail = "xsrf-token-invalid"
+
 // Default creates an Interceptor with TokenCookieName set to XSRF-TOKEN and
 // TokenHeaderName set to X-XSRF-TOKEN, their default values. However, in order
 // to prevent collisions when multiple applications share the same domain or
@@ -52,15 +76,16 @@ func Default() *Interceptor {
 
 // Before checks for the presence of a matching XSRF token, generated on the
 // first page access, in both a cookie and a header. Their names should be set
-// when the Interceptor is created.
-func (it *Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
-	if xsrf.StatePreserving(r) {
+// when the Interceptor is created. Handlers registered with xsrf.Disable
+// are not checked.
+func (it *Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	if xsrf.StatePreserving(r) || xsrf.Disabled(cfg) {
 		return safehttp.NotWritten()
 	}
 
 	c, err := r.Cookie(it.TokenCookieName)
 	if err != nil || c.Value() == "" {
-		return w.WriteError(safehttp.StatusForbidden)
+		return it.reject(w, r, safehttp.StatusForbidden)
 	}
 
 	tok := r.Header.Get(it.TokenHeaderName)
@@ -68,23 +93,86 @@ func (it *Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingReq
 		// JavaScript has access only to cookies from the domain it's running
 		// on. Hence, if the same token is found in both the cookie and the
 		// header, the request can be trusted.
-		return w.WriteError(safehttp.StatusUnauthorized)
+		return it.reject(w, r, safehttp.StatusUnauthorized)
 	}
 
 	return safehttp.NotWritten()
 }
 
+// reject writes an error response with the given code, adding the rejection
+// header and calling OnRejected if configured.
+func (it *Interceptor) reject(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, code safehttp.StatusCode) safehttp.Result {
+	if it.OnRejected != nil {
+		it.OnRejected(r, code)
+	}
+	if it.RejectionHeaderName != "" {
+		w.Header().Set(it.RejectionHeaderName, RejectionDetail)
+	}
+	return w.WriteError(code)
+}
+
+// SetEntropy sets the number of random bytes used to generate the token. It
+// returns an error if n is lower than xsrf.MinEntropy, in which case the
+// configuration is left unchanged. By default, xsrf.DefaultEntropy bytes are
+// used.
+func (it *Interceptor) SetEntropy(n int) error {
+	if err := xsrf.ValidateEntropy(n); err != nil {
+		return err
+	}
+	it.entropy = n
+	return nil
+}
+
+// SetTokenLifetime sets how long the token cookie is valid. It returns an
+// error if d is lower than xsrf.MinLifetime, in which case the configuration is
+// left unchanged. By default, the cookie is valid for xsrf.DefaultLifetime.
+//
+// The token is rotated automatically: a fresh cookie is issued on the first
+// state preserving request received after half of its lifetime.
+func (it *Interceptor) SetTokenLifetime(d time.Duration) error {
+	if err := xsrf.ValidateLifetime(d); err != nil {
+		return err
+	}
+	it.lifetime = d
+	return nil
+}
+
+func (it *Interceptor) tokenLifetime() time.Duration {
+	if it.lifetime == 0 {
+		return xsrf.DefaultLifetime
+	}
+	return it.lifetime
+}
+
+// needsRotation reports whether the token is past half of its lifetime. Tokens
+// store the time they were issued at after their last dot, tokens without it
+// are never rotated and are only replaced once the cookie expires.
+func (it *Interceptor) needsRotation(tok string) bool {
+	i := strings.LastIndexByte(tok, '.')
+	if i < 0 {
+		return false
+	}
+	issued, err := strconv.ParseInt(tok[i+1:], 10, 64)
+	if err != nil {
+		return false
+	}
+	return now().Sub(time.Unix(issued, 0)) >= it.tokenLifetime()/2
+}
+
 func (it *Interceptor) addTokenCookie(w safehttp.ResponseHeadersWriter) error {
-	tok := make([]byte, 20)
-	if _, err := rand.Read(tok); err != nil {
-		return fmt.Errorf("crypto/rand.Read: %v", err)
+	tok, err := xsrf.RandomValue(it.entropy)
+	if err != nil {
+		return err
 	}
-	c := safehttp.NewCookie(it.TokenCookieName, base64.StdEncoding.EncodeToString(tok))
+	c := safehttp.NewCookie(it.TokenCookieName, tok+"."+strconv.FormatInt(now().Unix(), 10))
 
 	c.SameSite(safehttp.SameSiteStrictMode)
+	if it.Partitioned {
+		c.SameSite(safehttp.SameSiteNoneMode)
+		c.SetPartitioned(true)
+	}
 	c.Path("/")
-	day := 24 * time.Hour
-	c.SetMaxAge(int(day.Seconds()))
+	c.SetMaxAge(int(it.tokenLifetime().Seconds()))
 	// Needed in order to make the cookie accessible by JavaScript
 	// running on the same domain.
 	c.DisableHTTPOnly()
@@ -95,15 +183,16 @@ func (it *Interceptor) addTokenCookie(w safehttp.ResponseHeadersWriter) error {
 // Commit generates a cryptographically secure random cookie on the first state
 // preserving request (GET, HEAD or OPTION) and sets it in the response. On
 // every subsequent request the cookie is expected alongside a header that
-// matches its value.
+// matches its value. The cookie is reissued on state preserving requests once
+// it's past half of its lifetime.
 func (it *Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
-	if c, err := r.Cookie(it.TokenCookieName); err == nil && c.Value() != "" {
-		// The XSRF cookie is there so we don't need to do anything else.
+	if !xsrf.StatePreserving(r) {
+		// Not a state preserving request, so we won't be adding the cookie.
 		return
 	}
 
-	if !xsrf.StatePreserving(r) {
-		// Not a state preserving request, so we won't be adding the cookie.
+	if c, err := r.Cookie(it.TokenCookieName); err == nil && c.Value() != "" && !it.needsRotation(c.Value()) {
+		// The XSRF cookie is there so we don't need to do anything else.
 		return
 	}
 
@@ -111,9 +200,10 @@ func (it *Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.Inco
 		// This is a server misconfiguration.
 		panic("cannot add token cookie")
 	}
+	xsrf.MarkCookieMinted(r)
 }
 
-// Match returns false since there are no supported configurations.
-func (*Interceptor) Match(safehttp.InterceptorConfig) bool {
-	return false
+// Match recognizes the configuration returned by xsrf.Disable.
+func (*Interceptor) Match(cfg safehttp.InterceptorConfig) bool {
+	return xsrf.Disabled(cfg)
 }
@@ -15,10 +15,15 @@
 package xsrfangular
 
 import (
+	"encoding/base64"
+	"fmt"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/xsrf"
 	"github.com/google/go-safeweb/safehttp/safehttptest"
 )
 
@@ -64,6 +69,43 @@ func TestAddCookie(t *testing.T) {
 	}
 }
 
+func TestAddCookiePartitioned(t *testing.T) {
+	req := safehttptest.NewRequest(safehttp.MethodGet, "/", nil)
+	fakeRW, _ := safehttptest.NewFakeResponseWriter()
+	it := Default()
+	it.Partitioned = true
+	it.Commit(fakeRW, req, nil, nil)
+
+	if len(fakeRW.Cookies) != 1 {
+		t.Fatalf("len(Cookies) = %v, want 1", len(fakeRW.Cookies))
+	}
+	if got, want := fakeRW.Cookies[0].String(), "Path=/; Max-Age=86400; Secure; SameSite=None; Partitioned"; !strings.Contains(got, want) {
+		t.Errorf("XSRF cookie got %q, want to contain %q", got, want)
+	}
+}
+
+func TestCookieMinted(t *testing.T) {
+	it := Default()
+
+	req := safehttptest.NewRequest(safehttp.MethodGet, "/", nil)
+	fakeRW, _ := safehttptest.NewFakeResponseWriter()
+	it.Commit(fakeRW, req, nil, nil)
+	if !xsrf.CookieMintedFromContext(req.Context()) {
+		t.Error("first request: xsrf.CookieMintedFromContext got false, want true")
+	}
+	if len(fakeRW.Cookies) != 1 {
+		t.Fatalf("len(Cookies) = %v, want 1", len(fakeRW.Cookies))
+	}
+
+	req = safehttptest.NewRequest(safehttp.MethodGet, "/", nil)
+	req.Header.Set("Cookie", cookieName+"="+fakeRW.Cookies[0].Value())
+	fakeRW, _ = safehttptest.NewFakeResponseWriter()
+	it.Commit(fakeRW, req, nil, nil)
+	if xsrf.CookieMintedFromContext(req.Context()) {
+		t.Error("follow-up request: xsrf.CookieMintedFromContext got true, want false")
+	}
+}
+
 func TestAddCookieFail(t *testing.T) {
 	req := safehttptest.NewRequest(safehttp.MethodGet, "/", nil)
 	fakeRW, _ := safehttptest.NewFakeResponseWriter()
@@ -154,3 +196,211 @@ func TestPostProtection(t *testing.T) {
 		})
 	}
 }
+
+func TestRejectionHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        *safehttp.IncomingRequest
+		wantStatus safehttp.StatusCode
+		wantHeader []string
+	}{
+		{
+			name: "Same cookie and header",
+			req: func() *safehttp.IncomingRequest {
+				req := safehttptest.NewRequest(safehttp.MethodPost, "/", nil)
+				req.Header.Set("Cookie", cookieName+"="+"1234")
+				req.Header.Set(headerName, "1234")
+				return req
+			}(),
+			wantStatus: safehttp.StatusOK,
+			wantHeader: nil,
+		},
+		{
+			name: "Different cookie and header",
+			req: func() *safehttp.IncomingRequest {
+				req := safehttptest.NewRequest(safehttp.MethodPost, "/", nil)
+				req.Header.Set("Cookie", cookieName+"="+"5768")
+				req.Header.Set(headerName, "1234")
+				return req
+			}(),
+			wantStatus: safehttp.StatusUnauthorized,
+			wantHeader: []string{RejectionDetail},
+		},
+		{
+			name: "Missing cookie",
+			req: func() *safehttp.IncomingRequest {
+				req := safehttptest.NewRequest(safehttp.MethodPost, "/", nil)
+				req.Header.Set(headerName, "1234")
+				return req
+			}(),
+			wantStatus: safehttp.StatusForbidden,
+			wantHeader: []string{RejectionDetail},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			i := Default()
+			i.RejectionHeaderName = "X-XSRF-Rejected"
+			i.Before(fakeRW, test.req, nil)
+
+			if got := rr.Code; got != int(test.wantStatus) {
+				t.Errorf("rr.Code: got %v, want %v", got, test.wantStatus)
+			}
+			if diff := cmp.Diff(test.wantHeader, rr.Header()["X-Xsrf-Rejected"]); diff != "" {
+				t.Errorf("rr.Header()[\"X-Xsrf-Rejected\"] mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRejectionHeaderDisabledByDefault(t *testing.T) {
+	req := safehttptest.NewRequest(safehttp.MethodPost, "/", nil)
+	fakeRW, rr := safehttptest.NewFakeResponseWriter()
+	Default().Before(fakeRW, req, nil)
+
+	if got, want := rr.Code, int(safehttp.StatusForbidden); got != want {
+		t.Errorf("rr.Code: got %v, want %v", got, want)
+	}
+	for name, values := range rr.Header() {
+		for _, v := range values {
+			if v == RejectionDetail {
+				t.Errorf("rr.Header()[%q]: got %q, want no rejection detail", name, v)
+			}
+		}
+	}
+}
+
+func TestTokenEntropy(t *testing.T) {
+	for _, n := range []int{0, xsrf.MinEntropy, 32} {
+		t.Run(fmt.Sprintf("%d bytes", n), func(t *testing.T) {
+			req := safehttptest.NewRequest(safehttp.MethodGet, "/", nil)
+			fakeRW, _ := safehttptest.NewFakeResponseWriter()
+
+			it := Default()
+			want := xsrf.DefaultEntropy
+			if n != 0 {
+				if err := it.SetEntropy(n); err != nil {
+					t.Fatalf("it.SetEntropy(%d): got err %v, want nil", n, err)
+				}
+				want = n
+			}
+			it.Commit(fakeRW, req, nil, nil)
+
+			if len(fakeRW.Cookies) != 1 {
+				t.Fatalf("len(Cookies) = %v, want 1", len(fakeRW.Cookies))
+			}
+			// The random token is followed by the time it was issued at.
+			tok := strings.SplitN(fakeRW.Cookies[0].Value(), ".", 2)[0]
+			b, err := base64.StdEncoding.DecodeString(tok)
+			if err != nil {
+				t.Fatalf("base64.StdEncoding.DecodeString(cookie value): %v", err)
+			}
+			if got := len(b); got != want {
+				t.Errorf("decoded token length: got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestSetEntropyBelowMinimum(t *testing.T) {
+	it := Default()
+	if err := it.SetEntropy(xsrf.MinEntropy - 1); err == nil {
+		t.Errorf("it.SetEntropy(%d): got nil, want error", xsrf.MinEntropy-1)
+	}
+}
+
+func TestTokenLifetime(t *testing.T) {
+	it := Default()
+	if err := it.SetTokenLifetime(time.Hour); err != nil {
+		t.Fatalf("it.SetTokenLifetime(time.Hour): got err %v, want nil", err)
+	}
+	req := safehttptest.NewRequest(safehttp.MethodGet, "/", nil)
+	fakeRW, _ := safehttptest.NewFakeResponseWriter()
+	it.Commit(fakeRW, req, nil, nil)
+
+	if len(fakeRW.Cookies) != 1 {
+		t.Fatalf("len(Cookies) = %v, want 1", len(fakeRW.Cookies))
+	}
+	if got, want := fakeRW.Cookies[0].String(), "Max-Age=3600"; !strings.Contains(got, want) {
+		t.Errorf("XSRF cookie got %q, want to contain %q", got, want)
+	}
+}
+
+func TestSetTokenLifetimeBelowMinimum(t *testing.T) {
+	it := Default()
+	if err := it.SetTokenLifetime(xsrf.MinLifetime - time.Second); err == nil {
+		t.Error("it.SetTokenLifetime() got nil err, want error")
+	}
+	if it.lifetime != 0 {
+		t.Errorf("it.lifetime: got %v, want unchanged", it.lifetime)
+	}
+}
+
+func TestTokenRotation(t *testing.T) {
+	defer func() { now = time.Now }()
+	start := time.Now()
+	now = func() time.Time { return start }
+
+	it := Default()
+	req := safehttptest.NewRequest(safehttp.MethodGet, "/", nil)
+	fakeRW, _ := safehttptest.NewFakeResponseWriter()
+	it.Commit(fakeRW, req, nil, nil)
+	if len(fakeRW.Cookies) != 1 {
+		t.Fatalf("len(Cookies) = %v, want 1", len(fakeRW.Cookies))
+	}
+	tok := fakeRW.Cookies[0].Value()
+
+	tests := []struct {
+		name       string
+		method     string
+		cookie     string
+		elapsed    time.Duration
+		wantNewTok bool
+	}{
+		{name: "Fresh token", method: safehttp.MethodGet, cookie: tok, elapsed: xsrf.DefaultLifetime/2 - time.Second},
+		{name: "Past half-life", method: safehttp.MethodGet, cookie: tok, elapsed: xsrf.DefaultLifetime / 2, wantNewTok: true},
+		{name: "Past half-life, state changing request", method: safehttp.MethodPost, cookie: tok, elapsed: xsrf.DefaultLifetime / 2},
+		{name: "Token without issue time", method: safehttp.MethodGet, cookie: "1234", elapsed: xsrf.DefaultLifetime},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now = func() time.Time { return start.Add(test.elapsed) }
+			req := safehttptest.NewRequest(test.method, "/", nil)
+			req.Header.Set("Cookie", cookieName+"="+test.cookie)
+			fakeRW, _ := safehttptest.NewFakeResponseWriter()
+			it.Commit(fakeRW, req, nil, nil)
+
+			if got := len(fakeRW.Cookies) == 1; got != test.wantNewTok {
+				t.Fatalf("new cookie issued: got %v, want %v", got, test.wantNewTok)
+			}
+			if test.wantNewTok && fakeRW.Cookies[0].Value() == tok {
+				t.Errorf("rotated cookie: got the same token %q", tok)
+			}
+		})
+	}
+}
+
+func TestOnRejected(t *testing.T) {
+	var got []safehttp.StatusCode
+	it := Default()
+	it.OnRejected = func(r *safehttp.IncomingRequest, code safehttp.StatusCode) {
+		got = append(got, code)
+	}
+
+	for _, cookie := range []string{"", "1234", "5678"} {
+		req := safehttptest.NewRequest(safehttp.MethodPost, "/", nil)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookieName+"="+cookie)
+		}
+		req.Header.Set(headerName, "1234")
+		fakeRW, _ := safehttptest.NewFakeResponseWriter()
+		it.Before(fakeRW, req, nil)
+	}
+
+	want := []safehttp.StatusCode{safehttp.StatusForbidden, safehttp.StatusUnauthorized}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("OnRejected codes mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xsrfhtml
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// NewSealedInterceptor creates an Interceptor whose tokens are self-contained:
+// each token holds the identifier of the user, the host it was generated for
+// and its expiry, encrypted and authenticated with AES-GCM using the given key,
+// which must be 16, 24 or 32 bytes long.
+//
+// Tokens sealed with the previous keys are still accepted, which allows
+// rotating keys without downtime: new tokens are only sealed with key.
+//
+// When SessionID is set, validating the tokens of authenticated users doesn't
+// depend on the cookie ID, which might not be sent, e.g. because of strict
+// SameSite settings in cross-subdomain flows. Requests without a session still
+// rely on the cookie ID.
+//
+// KeyProvider and SecretAppKey are not used by the returned Interceptor.
+func NewSealedInterceptor(key []byte, previous ...[]byte) (*Interceptor, error) {
+	s, err := newSealer(key, previous...)
+	if err != nil {
+		return nil, err
+	}
+	return &Interceptor{sealer: s}, nil
+}
+
+// sealer generates and validates encrypted tokens.
+type sealer struct {
+	// aeads seal tokens with the first one and open them with any of them.
+	aeads []cipher.AEAD
+}
+
+func newSealer(key []byte, previous ...[]byte) (*sealer, error) {
+	s := &sealer{}
+	for _, k := range append([][]byte{key}, previous...) {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token key: %v", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		s.aeads = append(s.aeads, aead)
+	}
+	return s, nil
+}
+
+// seal returns a token for the given user and action, valid until expiry. The
+// plaintext is the expiry in Unix seconds, the length of userID, userID and
+// actionID.
+func (s *sealer) seal(userID, actionID string, expiry time.Time) (string, error) {
+	plain := make([]byte, 12, 12+len(userID)+len(actionID))
+	binary.BigEndian.PutUint64(plain, uint64(expiry.Unix()))
+	binary.BigEndian.PutUint32(plain[8:], uint32(len(userID)))
+	plain = append(plain, userID...)
+	plain = append(plain, actionID...)
+
+	aead := s.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("crypto/rand.Read: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+// valid reports whether tok has been generated by seal, with the current or
+// one of the previous keys, for the given user and action and is not expired
+// at the given time.
+func (s *sealer) valid(tok, userID, actionID string, now time.Time) bool {
+	buf, err := base64.RawURLEncoding.DecodeString(tok)
+	if err != nil {
+		return false
+	}
+	plain, ok := s.open(buf)
+	if !ok || len(plain) < 12 {
+		return false
+	}
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(plain)), 0)
+	n := binary.BigEndian.Uint32(plain[8:])
+	rest := plain[12:]
+	if uint64(n) > uint64(len(rest)) {
+		return false
+	}
+	gotUser, gotAction := rest[:n], rest[n:]
+	return now.Before(expiry) &&
+		subtle.ConstantTimeCompare(gotUser, []byte(userID)) == 1 &&
+		subtle.ConstantTimeCompare(gotAction, []byte(actionID)) == 1
+}
+
+// open decrypts the sealed token buf with the first key that authenticates it.
+func (s *sealer) open(buf []byte) ([]byte, bool) {
+	for _, aead := range s.aeads {
+		if len(buf) < aead.NonceSize() {
+			continue
+		}
+		nonce, sealed := buf[:aead.NonceSize()], buf[aead.NonceSize():]
+		if plain, err := aead.Open(nil, nonce, sealed, nil); err == nil {
+			return plain, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,172 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xsrfhtml
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/safehttptest"
+)
+
+func TestSealedInterceptor(t *testing.T) {
+	defer func() { now = time.Now }()
+	start := time.Now()
+	now = func() time.Time { return start }
+
+	it, err := NewSealedInterceptor([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("NewSealedInterceptor() got err: %v", err)
+	}
+	it.SessionID = func(r *safehttp.IncomingRequest) (string, bool) {
+		sid := r.Header.Get("X-Session")
+		return sid, sid != ""
+	}
+
+	req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+	req.Header.Set("X-Session", "session1")
+	fakeRW, _ := safehttptest.NewFakeResponseWriter()
+	resp := &safehttp.TemplateResponse{}
+	it.Commit(fakeRW, req, resp, nil)
+	if len(fakeRW.Cookies) != 0 {
+		t.Errorf("len(fakeRW.Cookies): got %d, want 0", len(fakeRW.Cookies))
+	}
+	tok := resp.FuncMap["XSRFToken"].(func() string)()
+	tampered := []byte(tok)
+	tampered[len(tampered)/2] ^= 1
+
+	tests := []struct {
+		name, host, session, tok string
+		elapsed                  time.Duration
+		wantStatus               safehttp.StatusCode
+	}{
+		{name: "Valid token", host: "foo.com", session: "session1", tok: tok, wantStatus: safehttp.StatusOK},
+		{name: "Other session", host: "foo.com", session: "session2", tok: tok, wantStatus: safehttp.StatusForbidden},
+		{name: "Other host", host: "bar.com", session: "session1", tok: tok, wantStatus: safehttp.StatusForbidden},
+		{name: "Expired token", host: "foo.com", session: "session1", tok: tok, elapsed: 24 * time.Hour, wantStatus: safehttp.StatusForbidden},
+		{name: "Tampered token", host: "foo.com", session: "session1", tok: string(tampered), wantStatus: safehttp.StatusForbidden},
+		{name: "Malformed token", host: "foo.com", session: "session1", tok: "abc", wantStatus: safehttp.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now = func() time.Time { return start.Add(test.elapsed) }
+			req := safehttptest.NewRequest(safehttp.MethodPost, "https://"+test.host+"/", strings.NewReader(TokenKey+"="+test.tok))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("X-Session", test.session)
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			it.Before(fakeRW, req, nil)
+
+			if got := rr.Code; got != int(test.wantStatus) {
+				t.Errorf("rr.Code: got %v, want %v", got, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestSealedInterceptorCookieID(t *testing.T) {
+	it, err := NewSealedInterceptor([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("NewSealedInterceptor() got err: %v", err)
+	}
+	req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+	req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+	fakeRW, _ := safehttptest.NewFakeResponseWriter()
+	resp := &safehttp.TemplateResponse{}
+	it.Commit(fakeRW, req, resp, nil)
+	tok := resp.FuncMap["XSRFToken"].(func() string)()
+
+	for _, cookie := range []string{testCookieID, "MDEyMzQ1Njc4OWFiY2RlZmdoaWp="} {
+		req = safehttptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader(TokenKey+"="+tok))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Cookie", cookieIDKey+"="+cookie)
+		fakeRW, rr := safehttptest.NewFakeResponseWriter()
+		it.Before(fakeRW, req, nil)
+
+		want := safehttp.StatusOK
+		if cookie != testCookieID {
+			want = safehttp.StatusForbidden
+		}
+		if got := rr.Code; got != int(want) {
+			t.Errorf("cookie %q: rr.Code got %v, want %v", cookie, got, want)
+		}
+	}
+}
+
+func TestSealedInterceptorKeyRotation(t *testing.T) {
+	oldKey, newKey := []byte("0123456789abcdef"), []byte("fedcba9876543210")
+	token := func(it *Interceptor) string {
+		req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+		req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+		fakeRW, _ := safehttptest.NewFakeResponseWriter()
+		resp := &safehttp.TemplateResponse{}
+		it.Commit(fakeRW, req, resp, nil)
+		return resp.FuncMap["XSRFToken"].(func() string)()
+	}
+	post := func(it *Interceptor, tok string) int {
+		req := safehttptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader(TokenKey+"="+tok))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+		fakeRW, rr := safehttptest.NewFakeResponseWriter()
+		it.Before(fakeRW, req, nil)
+		return rr.Code
+	}
+
+	old, err := NewSealedInterceptor(oldKey)
+	if err != nil {
+		t.Fatalf("NewSealedInterceptor() got err: %v", err)
+	}
+	rotated, err := NewSealedInterceptor(newKey, oldKey)
+	if err != nil {
+		t.Fatalf("NewSealedInterceptor() got err: %v", err)
+	}
+	retired, err := NewSealedInterceptor(newKey)
+	if err != nil {
+		t.Fatalf("NewSealedInterceptor() got err: %v", err)
+	}
+	oldTok, newTok := token(old), token(rotated)
+
+	tests := []struct {
+		name       string
+		it         *Interceptor
+		tok        string
+		wantStatus safehttp.StatusCode
+	}{
+		{name: "Current key", it: rotated, tok: newTok, wantStatus: safehttp.StatusOK},
+		{name: "Previous key", it: rotated, tok: oldTok, wantStatus: safehttp.StatusOK},
+		{name: "Retired key", it: retired, tok: oldTok, wantStatus: safehttp.StatusForbidden},
+		{name: "New tokens use the current key", it: old, tok: newTok, wantStatus: safehttp.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := post(test.it, test.tok); got != int(test.wantStatus) {
+				t.Errorf("rr.Code: got %v, want %v", got, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestNewSealedInterceptorInvalidPreviousKey(t *testing.T) {
+	if _, err := NewSealedInterceptor([]byte("0123456789abcdef"), []byte("short")); err == nil {
+		t.Error("NewSealedInterceptor() got nil err, want error")
+	}
+}
+
+func TestNewSealedInterceptorInvalidKey(t *testing.T) {
+	if _, err := NewSealedInterceptor([]byte("short")); err == nil {
+		t.Error("NewSealedInterceptor() got nil err, want error")
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xsrfhtml
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+	"time"
+
+	"golang.org/x/net/xsrftoken"
+)
+
+const (
+	// tokenCacheTTL is how long a generated token is reused for requests
+	// carrying the same cookie ID. It is kept much shorter than
+	// xsrftoken.Timeout so that reused tokens remain valid for almost as long
+	// as freshly generated ones.
+	tokenCacheTTL = time.Minute
+	// tokenCacheSize is the maximum number of cached tokens. Once it's
+	// reached, the least recently used tokens are evicted.
+	tokenCacheSize = 10000
+	// tokenCacheShards is the number of independently locked parts of the
+	// cache, so that concurrent requests of different users rarely contend.
+	tokenCacheShards = 16
+)
+
+var now = time.Now
+
+// tokenCacheKey identifies a token. All the inputs of xsrftoken.Generate are
+// part of the key so that tokens are never shared across secret keys, users or
+// actions.
+type tokenCacheKey struct {
+	key, userID, actionID string
+}
+
+type cachedToken struct {
+	key     tokenCacheKey
+	tok     string
+	created time.Time
+}
+
+// tokenCache avoids the cost of generating XSRF tokens on every state
+// preserving request of a user. The zero value is ready to use.
+type tokenCache struct {
+	once   sync.Once
+	seed   maphash.Seed
+	shards [tokenCacheShards]tokenCacheShard
+}
+
+// tokenCacheShard is a least recently used cache of tokens.
+type tokenCacheShard struct {
+	mu     sync.Mutex
+	tokens map[tokenCacheKey]*list.Element
+	// lru holds the cachedTokens, most recently used first.
+	lru list.List
+}
+
+// generate returns a recently generated token for the given inputs, or
+// generates a new one using xsrftoken.Generate.
+func (c *tokenCache) generate(key, userID, actionID string) string {
+	k := tokenCacheKey{key: key, userID: userID, actionID: actionID}
+	t := now()
+	s := c.shard(k)
+	if tok, ok := s.get(k, t); ok {
+		return tok
+	}
+	// Tokens are generated without holding the lock, so that generating one
+	// doesn't block the requests of other users.
+	tok := xsrftoken.Generate(key, userID, actionID)
+	return s.add(cachedToken{key: k, tok: tok, created: t})
+}
+
+// len returns the number of cached tokens.
+func (c *tokenCache) len() int {
+	n := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		n += len(s.tokens)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// shard returns the shard holding the token with the given key.
+func (c *tokenCache) shard(k tokenCacheKey) *tokenCacheShard {
+	c.once.Do(func() { c.seed = maphash.MakeSeed() })
+	var h maphash.Hash
+	h.SetSeed(c.seed)
+	h.WriteString(k.userID)
+	h.WriteByte(0)
+	h.WriteString(k.actionID)
+	return &c.shards[h.Sum64()%tokenCacheShards]
+}
+
+// get returns the token with the given key, if it's still fresh at t.
+func (s *tokenCacheShard) get(k tokenCacheKey, t time.Time) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.tokens[k]
+	if !ok {
+		return "", false
+	}
+	ct := e.Value.(cachedToken)
+	if t.Sub(ct.created) >= tokenCacheTTL {
+		return "", false
+	}
+	s.lru.MoveToFront(e)
+	return ct.tok, true
+}
+
+// add caches ct, evicting the least recently used token if the shard is full,
+// and returns the cached token. If a fresh token for the same key has been
+// added concurrently, it's kept and returned instead.
+func (s *tokenCacheShard) add(ct cachedToken) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens == nil {
+		s.tokens = map[tokenCacheKey]*list.Element{}
+	}
+	if e, ok := s.tokens[ct.key]; ok {
+		if old := e.Value.(cachedToken); ct.created.Sub(old.created) < tokenCacheTTL {
+			s.lru.MoveToFront(e)
+			return old.tok
+		}
+		e.Value = ct
+		s.lru.MoveToFront(e)
+		return ct.tok
+	}
+	if len(s.tokens) >= tokenCacheSize/tokenCacheShards {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.tokens, oldest.Value.(cachedToken).key)
+	}
+	s.tokens[ct.key] = s.lru.PushFront(ct)
+	return ct.tok
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xsrfhtml
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/safehttptest"
+	"golang.org/x/net/xsrftoken"
+)
+
+func commitToken(t *testing.T, it *Interceptor, target string) string {
+	t.Helper()
+	fakeRW, _ := safehttptest.NewFakeResponseWriter()
+	req := safehttptest.NewRequest(safehttp.MethodGet, target, nil)
+	req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+	tr := &safehttp.TemplateResponse{}
+	it.Commit(fakeRW, req, tr, nil)
+	return tr.FuncMap["XSRFToken"].(func() string)()
+}
+
+func TestTokenCacheReuse(t *testing.T) {
+	it := &Interceptor{SecretAppKey: "testSecretAppKey"}
+	first := commitToken(t, it, "https://foo.com/pizza")
+	if second := commitToken(t, it, "https://foo.com/pasta"); first != second {
+		t.Errorf("second token: got %q, want %q", second, first)
+	}
+	if !xsrftoken.Valid(first, "testSecretAppKey", testCookieID, "foo.com") {
+		t.Errorf("xsrftoken.Valid(%q): got false, want true", first)
+	}
+}
+
+func TestTokenCacheKeys(t *testing.T) {
+	c := &tokenCache{}
+	tok := c.generate("key", "user", "action")
+	tests := []struct {
+		name                  string
+		key, userID, actionID string
+	}{
+		{name: "Different secret key", key: "otherkey", userID: "user", actionID: "action"},
+		{name: "Different user", key: "key", userID: "otheruser", actionID: "action"},
+		{name: "Different action", key: "key", userID: "user", actionID: "otheraction"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := c.generate(test.key, test.userID, test.actionID)
+			if got == tok {
+				t.Errorf("c.generate(%q, %q, %q): got the cached token for different inputs", test.key, test.userID, test.actionID)
+			}
+			if !xsrftoken.Valid(got, test.key, test.userID, test.actionID) {
+				t.Errorf("xsrftoken.Valid(%q): got false, want true", got)
+			}
+		})
+	}
+}
+
+func TestTokenCacheExpiry(t *testing.T) {
+	defer func() { now = time.Now }()
+	start := time.Now()
+	now = func() time.Time { return start }
+
+	c := &tokenCache{}
+	first := c.generate("key", "user", "action")
+
+	now = func() time.Time { return start.Add(tokenCacheTTL - time.Second) }
+	if got := c.generate("key", "user", "action"); got != first {
+		t.Errorf("token before expiry: got %q, want %q", got, first)
+	}
+
+	now = func() time.Time { return start.Add(tokenCacheTTL) }
+	// Tokens embed the current time in milliseconds.
+	time.Sleep(2 * time.Millisecond)
+	if got := c.generate("key", "user", "action"); got == first {
+		t.Errorf("token after expiry: got the cached token %q", got)
+	}
+}
+
+func TestTokenCacheSize(t *testing.T) {
+	c := &tokenCache{}
+	for i := 0; i < tokenCacheSize+1; i++ {
+		c.generate("key", string(rune(i)), "action")
+	}
+	if got := c.len(); got > tokenCacheSize {
+		t.Errorf("c.len(): got %d, want at most %d", got, tokenCacheSize)
+	}
+}
+
+func TestTokenCacheLRU(t *testing.T) {
+	c := &tokenCache{}
+	first := c.generate("key", "user", "action")
+	// Tokens embed the current time in milliseconds.
+	time.Sleep(2 * time.Millisecond)
+	for i := 0; i < 2*tokenCacheSize; i++ {
+		c.generate("key", fmt.Sprintf("other%d", i), "action")
+		if got := c.generate("key", "user", "action"); got != first {
+			t.Fatalf("recently used token after %d other tokens: got %q, want %q", i+1, got, first)
+		}
+	}
+}
+
+func BenchmarkTokenGenerate(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		xsrftoken.Generate("testSecretAppKey", testCookieID, "foo.com")
+	}
+}
+
+func BenchmarkTokenCacheGenerate(b *testing.B) {
+	c := &tokenCache{}
+	for i := 0; i < b.N; i++ {
+		c.generate("testSecretAppKey", testCookieID, "foo.com")
+	}
+}
+
+func BenchmarkTokenCacheGenerateParallel(b *testing.B) {
+	c := &tokenCache{}
+	var n int64
+	b.RunParallel(func(pb *testing.PB) {
+		// Every goroutine is a different user.
+		userID := fmt.Sprintf("user%d", atomic.AddInt64(&n, 1))
+		for pb.Next() {
+			c.generate("testSecretAppKey", userID, "foo.com")
+		}
+	})
+}
+
+func BenchmarkTokenCacheGenerateParallelDistinct(b *testing.B) {
+	c := &tokenCache{}
+	var n int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			// Every request carries a different cookie ID, so every token is
+			// generated.
+			c.generate("testSecretAppKey", strconv.FormatInt(atomic.AddInt64(&n, 1), 10), "foo.com")
+		}
+	})
+}
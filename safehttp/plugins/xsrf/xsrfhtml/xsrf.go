@@ -15,13 +15,30 @@
 package xsrfhtml
 
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"bytes"
+	"container/list"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template/parse"
+	"time"
 
 	"github.com/google/go-safeweb/safehttp"
 	"github.com/google/go-safeweb/safehttp/plugins/htmlinject"
 	"github.com/google/go-safeweb/safehttp/plugins/xsrf"
+	"github.com/google/go-safeweb/safehttp/restricted"
+	"github.com/google/safehtml/template"
+	"golang.org/x/net/http/httpguts"
 	"golang.org/x/net/xsrftoken"
 )
 
@@ -30,6 +47,12 @@ const (
 	// request.
 	TokenKey    = "xsrf-token"
 	cookieIDKey = "xsrf-cookie"
+	// defaultJSONMaxBytes is the maximum size of JSON bodies read looking for the
+	// token, unless configured otherwise.
+	defaultJSONMaxBytes = 1 << 20
+	// defaultMultipartMaxMemory is the maximum number of bytes of multipart
+	// forms stored in memory, unless configured otherwise.
+	defaultMultipartMaxMemory = 32 << 20
 )
 
 // Interceptor implements XSRF protection.
@@ -37,17 +60,312 @@ type Interceptor struct {
 	// SecretAppKey uniquely identifies each registered service and should have
 	// high entropy as it is used for generating the XSRF token.
 	SecretAppKey string
+
+	// KeyProvider, if set, provides the keys used to sign and validate tokens
+	// instead of SecretAppKey. New tokens are signed with the current key and
+	// tokens signed with the previous keys are still accepted, which allows
+	// rotating keys without downtime.
+	KeyProvider xsrf.KeyProvider
+
+	// Audit makes the XSRFToken template function return a Token instead of a
+	// string. Templates can then only print the token and the rendering fails
+	// if the token is passed to functions that expect a string, e.g. ones that
+	// convert it to safehtml types and would bypass contextual autoescaping.
+	Audit bool
+
+	// TokenHeaderName is the name of an optional HTTP header that can be used
+	// to send the XSRF token instead of the TokenKey form field, e.g. by
+	// JavaScript clients. If both the header and the form field are present,
+	// their values have to match.
+	TokenHeaderName string
+
+	// EnforceWhen, if set, is called on state changing requests to decide
+	// whether XSRF protection should be enforced. When it returns false, the
+	// request is let through without checking the token, e.g. for API requests
+	// authenticated with a bearer token rather than with cookies. Commit runs
+	// regardless. If nil, protection is always enforced.
+	EnforceWhen func(*safehttp.IncomingRequest) bool
+
+	// SessionID, if set, returns the identifier of the authenticated session
+	// of the request, e.g. stored in the request context by an authentication
+	// interceptor, and whether there is one. Tokens are then bound to the
+	// session rather than to the cookie ID, so that they are invalidated on
+	// logout and can't be replayed across sessions. Requests without a session
+	// fall back to the cookie ID. It's ignored by interceptors created with
+	// StatelessDoubleSubmit.
+	SessionID func(*safehttp.IncomingRequest) (string, bool)
+
+	// TrustedOrigins, if set, lists origins (e.g. "https://example.com") from
+	// which state changing requests without a token are accepted. The origin
+	// of the request is taken from the Origin header or, if missing, from the
+	// Referer header. This allows migrating legacy forms gradually while still
+	// blocking cross-site requests. Requests carrying a token are always
+	// validated against it.
+	TrustedOrigins []string
+
+	// MultipartMaxMemory is the maximum number of bytes of a multipart form
+	// that are stored in memory while looking for the token, the rest being
+	// stored on disk in temporary files. If zero, 32 MB are used.
+	MultipartMaxMemory int64
+
+	// DisableMultipart makes state changing requests with multipart forms
+	// fail with 400 Bad Request, unless the token is sent in the
+	// TokenHeaderName header. This is useful for applications that don't
+	// accept file uploads, to bound the resources used by hostile requests.
+	DisableMultipart bool
+
+	// JSONTokenField, if set, is the name of a top-level field of JSON request
+	// bodies (sent with Content-Type application/json) holding the token, as
+	// an alternative to TokenHeaderName for JSON endpoints. The body is
+	// buffered, so handlers can still decode it.
+	JSONTokenField string
+
+	// JSONMaxBytes is the maximum size of the JSON bodies read looking for the
+	// token: larger requests fail with 400 Bad Request. If zero, 1 MB is used.
+	// No more than JSONMaxBytes+1 bytes of the body are read.
+	JSONMaxBytes int64
+
+	// OnRejected, if set, is called whenever a request is rejected because of
+	// a missing or invalid token, with the status code of the error response.
+	// It allows applications to log, emit metrics or alert on XSRF rejections.
+	OnRejected func(r *safehttp.IncomingRequest, code safehttp.StatusCode)
+
+	// entropy is the number of random bytes used for the cookie ID.
+	entropy int
+	// lifetime is how long tokens are valid, xsrf.DefaultLifetime if zero.
+	lifetime time.Duration
+	// sealer, if set, is used to generate and validate tokens instead of
+	// xsrftoken, see NewSealedInterceptor.
+	sealer *sealer
+	// headerOnly makes Before only accept tokens sent in the TokenHeaderName
+	// header, see NewHeaderInterceptor.
+	headerOnly bool
+	// doubleSubmit makes the cookie ID itself the token, see
+	// StatelessDoubleSubmit.
+	doubleSubmit bool
+	// tokenRespHeader is the name of the response header the token is sent
+	// in, if any.
+	tokenRespHeader string
+
+	tokens tokenCache
+	// usesTokenCache caches whether the templates of template responses call
+	// the XSRF token function, see usesToken.
+	usesTokenCache templateCache
 }
 
 var _ safehttp.Interceptor = &Interceptor{}
 
-func addCookieID(w safehttp.ResponseHeadersWriter) (*safehttp.Cookie, error) {
-	buf := make([]byte, 20)
-	if _, err := rand.Read(buf); err != nil {
-		return nil, fmt.Errorf("crypto/rand.Read: %v", err)
+// StatelessDoubleSubmit creates an Interceptor that doesn't need a secret key,
+// for deployments that can't share one across replicas. It implements the
+// double-submit cookie pattern: a random token is set in a cookie and injected
+// in forms, and state changing requests are only allowed if the token sent in
+// the form (or in the TokenHeaderName header) matches the cookie.
+//
+// Unlike signed tokens, double-submit tokens are not bound to the request
+// host, so this mode relies on attackers being unable to set cookies for the
+// application, e.g. from a compromised or untrusted subdomain. Prefer the
+// default mode when a secret key can be used.
+func StatelessDoubleSubmit() *Interceptor {
+	return &Interceptor{doubleSubmit: true}
+}
+
+// Token is an XSRF token injected in templates when the Interceptor runs in
+// audit mode.
+type Token struct {
+	tok string
+}
+
+// String returns the XSRF token.
+func (t Token) String() string {
+	return t.tok
+}
+
+// SetEntropy sets the number of random bytes used to generate the cookie ID.
+// It returns an error if n is lower than xsrf.MinEntropy, in which case the
+// configuration is left unchanged. By default, xsrf.DefaultEntropy bytes are
+// used.
+func (it *Interceptor) SetEntropy(n int) error {
+	if err := xsrf.ValidateEntropy(n); err != nil {
+		return err
+	}
+	it.entropy = n
+	return nil
+}
+
+// SetTokenLifetime sets how long tokens are valid. It returns an error if d is
+// lower than xsrf.MinLifetime, in which case the configuration is left
+// unchanged. By default, tokens are valid for xsrf.DefaultLifetime.
+//
+// Tokens are rotated automatically, as a fresh one is injected in every page
+// (tokens are only reused for a minute). The cookie ID is rotated too: a fresh
+// one is issued on the first state preserving request using the token received
+// after half of its lifetime. The replaced cookie ID is kept in a second
+// cookie, so that tokens bound to it, e.g. in pages already open in other tabs,
+// are still accepted until they expire. This also renews the tokens of an
+// Interceptor created with StatelessDoubleSubmit, which don't expire
+// otherwise.
+func (it *Interceptor) SetTokenLifetime(d time.Duration) error {
+	if err := xsrf.ValidateLifetime(d); err != nil {
+		return err
 	}
+	it.lifetime = d
+	return nil
+}
+
+// SetTokenResponseHeader makes Commit send the XSRF token in the response
+// header with the given name, in addition to injecting it in templates. This
+// allows frontends that fetch data from an API to read the token and echo it
+// back in state changing requests, e.g. using TokenHeaderName. The header is
+// only set on responses to state preserving requests (GET, HEAD and OPTIONS).
+//
+// It returns an error if name is not a valid header name, in which case the
+// configuration is left unchanged. An empty name disables the header.
+func (it *Interceptor) SetTokenResponseHeader(name string) error {
+	if name == "" {
+		it.tokenRespHeader = ""
+		return nil
+	}
+	if !httpguts.ValidHeaderFieldName(name) {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	if name == "Set-Cookie" {
+		return errors.New("the XSRF token can't be sent in the Set-Cookie header")
+	}
+	it.tokenRespHeader = name
+	return nil
+}
+
+// NewHeaderInterceptor creates an Interceptor for single page applications
+// that send state changing requests with fetch or XHR, e.g. with JSON bodies,
+// rather than with forms. The token is sent in the response header with the
+// given name (see SetTokenResponseHeader) and has to be sent back in a request
+// header with the same name. Form fields are ignored and request bodies are
+// never parsed, so handlers don't need to return a TemplateResponse. The token
+// is sent with any response to a state preserving request, including template
+// responses whose templates don't call XSRFToken.
+//
+// It returns an error if headerName is not a valid header name.
+func NewHeaderInterceptor(appKey, headerName string) (*Interceptor, error) {
+	it := &Interceptor{SecretAppKey: appKey, headerOnly: true}
+	if err := it.SetTokenResponseHeader(headerName); err != nil {
+		return nil, err
+	}
+	it.TokenHeaderName = it.tokenRespHeader
+	return it, nil
+}
+
+// Override is a safehttp.InterceptorConfig that changes, for a specific
+// handler, the name of the cookie holding the cookie ID and the form key used
+// to send the token. This allows isolating groups of handlers, e.g. belonging
+// to different tenants, so that they don't share a token namespace.
+//
+// Note that templates served by handlers with a custom TokenKey need to be
+// transformed with htmlinject.XSRFTokens using a matching input name.
+type Override struct {
+	// CookieIDKey is the name of the cookie holding the cookie ID. If empty,
+	// the default name is used.
+	CookieIDKey string
+	// TokenKey is the form key used when sending the token as part of POST
+	// requests. If empty, TokenKey is used.
+	TokenKey string
+	// StateChangingMethods lists methods that are normally state preserving
+	// (GET, HEAD or OPTIONS) but that should be protected for this handler,
+	// e.g. while remediating legacy endpoints that wrongly change state on
+	// GET. For these methods the token is read from the URL query (or from the
+	// TokenHeaderName header, if configured).
+	//
+	// Note that this breaks simple links to the handler: every link has to
+	// include the token, which can then leak through logs or the Referer
+	// header.
+	StateChangingMethods []string
+}
+
+// keys returns the cookie ID name and the token form key to be used for the
+// given configuration.
+func keys(cfg safehttp.InterceptorConfig) (cookieKey, tokenKey string) {
+	cookieKey, tokenKey = cookieIDKey, TokenKey
+	if o, ok := cfg.(Override); ok {
+		if o.CookieIDKey != "" {
+			cookieKey = o.CookieIDKey
+		}
+		if o.TokenKey != "" {
+			tokenKey = o.TokenKey
+		}
+	}
+	return cookieKey, tokenKey
+}
+
+// sessionUserID returns the identifier the tokens are bound to when the user
+// has an authenticated session, see SessionID.
+func (it *Interceptor) sessionUserID(r *safehttp.IncomingRequest) (string, bool) {
+	if it.SessionID == nil || it.doubleSubmit {
+		return "", false
+	}
+	sid, ok := it.SessionID(r)
+	if !ok || sid == "" {
+		return "", false
+	}
+	// Cookie IDs are base64 encoded, so the prefix prevents session IDs from
+	// ever being mistaken for them.
+	return "session:" + sid, true
+}
 
-	c := safehttp.NewCookie(cookieIDKey, base64.StdEncoding.EncodeToString(buf))
+// previousCookieIDKey returns the name of the cookie holding the cookie ID
+// replaced by the last rotation, see SetTokenLifetime.
+func previousCookieIDKey(cookieKey string) string {
+	return cookieKey + "-previous"
+}
+
+// cookieID returns the cookie holding the cookie ID. Malformed cookie IDs, e.g.
+// corrupted or crafted by the client, are treated as missing so that a new one
+// is issued instead of failing every request.
+func (it *Interceptor) cookieID(r *safehttp.IncomingRequest, cookieKey string) (*safehttp.Cookie, error) {
+	c, err := r.Cookie(cookieKey)
+	if err != nil {
+		return nil, err
+	}
+	if !it.validCookieID(c.Value()) {
+		return nil, fmt.Errorf("malformed cookie ID in cookie %q", cookieKey)
+	}
+	return c, nil
+}
+
+// validCookieID reports whether v is a cookie ID generated by addCookieID.
+// Cookie IDs store the time they were issued at after their last dot; cookie
+// IDs without it were issued before rotation was supported and are still
+// accepted.
+func (it *Interceptor) validCookieID(v string) bool {
+	if i := strings.LastIndexByte(v, '.'); i >= 0 {
+		if _, err := strconv.ParseInt(v[i+1:], 10, 64); err != nil {
+			return false
+		}
+		v = v[:i]
+	}
+	return xsrf.ValidValue(v, it.entropy)
+}
+
+// needsRotation reports whether the cookie ID is past half of the token
+// lifetime. Cookie IDs without the time they were issued at are never rotated.
+func (it *Interceptor) needsRotation(cookieID string) bool {
+	i := strings.LastIndexByte(cookieID, '.')
+	if i < 0 {
+		return false
+	}
+	issued, err := strconv.ParseInt(cookieID[i+1:], 10, 64)
+	if err != nil {
+		return false
+	}
+	return now().Sub(time.Unix(issued, 0)) >= it.tokenLifetime()/2
+}
+
+func (it *Interceptor) addCookieID(w safehttp.ResponseHeadersWriter, cookieKey string) (*safehttp.Cookie, error) {
+	v, err := xsrf.RandomValue(it.entropy)
+	if err != nil {
+		return nil, err
+	}
+
+	c := safehttp.NewCookie(cookieKey, v+"."+strconv.FormatInt(now().Unix(), 10))
 	c.SameSite(safehttp.SameSiteStrictMode)
 	if err := w.AddCookie(c); err != nil {
 		return nil, err
@@ -55,40 +373,258 @@ func addCookieID(w safehttp.ResponseHeadersWriter) (*safehttp.Cookie, error) {
 	return c, nil
 }
 
+// rotateCookieID replaces the cookie ID old with a new one, keeping old in the
+// previous cookie ID cookie until the tokens bound to it expire.
+func (it *Interceptor) rotateCookieID(w safehttp.ResponseHeadersWriter, cookieKey string, old *safehttp.Cookie) (*safehttp.Cookie, error) {
+	prev := safehttp.NewCookie(previousCookieIDKey(cookieKey), old.Value())
+	prev.SameSite(safehttp.SameSiteStrictMode)
+	prev.SetMaxAge(int(it.tokenLifetime().Seconds()))
+	if err := w.AddCookie(prev); err != nil {
+		return nil, err
+	}
+	return it.addCookieID(w, cookieKey)
+}
+
+// keys returns the KeyProvider to be used, defaulting to SecretAppKey.
+func (it *Interceptor) keys() xsrf.KeyProvider {
+	if it.KeyProvider != nil {
+		return it.KeyProvider
+	}
+	return xsrf.StaticKey(it.SecretAppKey)
+}
+
+// tokenLifetime returns how long tokens are valid.
+func (it *Interceptor) tokenLifetime() time.Duration {
+	if it.lifetime == 0 {
+		return xsrf.DefaultLifetime
+	}
+	return it.lifetime
+}
+
+// validToken reports whether tok has been signed with either the current or one
+// of the previous keys.
+func (it *Interceptor) validToken(tok, userID, actionID string) bool {
+	lifetime := it.tokenLifetime()
+	current, previous := it.keys().Keys()
+	if xsrftoken.ValidFor(tok, current, userID, actionID, lifetime) {
+		return true
+	}
+	for _, k := range previous {
+		if xsrftoken.ValidFor(tok, k, userID, actionID, lifetime) {
+			return true
+		}
+	}
+	return false
+}
+
+// stateChanging reports whether the request should be checked for a valid XSRF
+// token under the given configuration.
+func stateChanging(r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) bool {
+	if !xsrf.StatePreserving(r) {
+		return true
+	}
+	if o, ok := cfg.(Override); ok {
+		for _, m := range o.StateChangingMethods {
+			if m == r.Method() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Before checks for the presence of a XSRF token in the body of state changing
-// requests (all except GET, HEAD and OPTIONS) and validates it.
-func (it *Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
-	if xsrf.StatePreserving(r) {
+// requests (all except GET, HEAD and OPTIONS, unless listed in
+// Override.StateChangingMethods) and validates it. If
+// TokenHeaderName is set, the token can also be sent in that header. Requests
+// without a token are accepted if they come from one of the TrustedOrigins.
+// Requests for which EnforceWhen returns false and handlers registered with
+// xsrf.Disable are not checked.
+func (it *Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	if !stateChanging(r, cfg) {
 		return safehttp.NotWritten()
 	}
+	if xsrf.Disabled(cfg) || (it.EnforceWhen != nil && !it.EnforceWhen(r)) {
+		return safehttp.NotWritten()
+	}
+
+	cookieKey, tokenKey := keys(cfg)
+	userID, ok := it.sessionUserID(r)
+	userIDs := []string{userID}
+	if !ok {
+		cookieID, err := it.cookieID(r, cookieKey)
+		if err != nil {
+			return it.reject(w, r, safehttp.StatusForbidden)
+		}
+		userIDs = []string{cookieID.Value()}
+		// Tokens bound to the cookie ID replaced by the last rotation are
+		// still valid.
+		if prev, err := it.cookieID(r, previousCookieIDKey(cookieKey)); err == nil {
+			userIDs = append(userIDs, prev.Value())
+		}
+	}
 
-	cookieID, err := r.Cookie(cookieIDKey)
+	var tok string
+	if it.TokenHeaderName != "" {
+		tok = r.Header.Get(it.TokenHeaderName)
+	}
+	var (
+		formTok string
+		err     error
+	)
+	if !it.headerOnly {
+		formTok, err = it.formToken(r, tokenKey)
+	}
+	switch {
+	case tok == "" && err != nil:
+		return it.reject(w, r, safehttp.StatusBadRequest)
+	case tok == "":
+		tok = formTok
+	case formTok != "" && formTok != tok:
+		return it.reject(w, r, safehttp.StatusForbidden)
+	}
+	if tok == "" {
+		if it.trustedOrigin(r) {
+			return safehttp.NotWritten()
+		}
+		return it.reject(w, r, safehttp.StatusUnauthorized)
+	}
+
+	for _, userID := range userIDs {
+		if it.boundToken(tok, userID, r.URL().Host()) {
+			return safehttp.NotWritten()
+		}
+	}
+	return it.reject(w, r, safehttp.StatusForbidden)
+}
+
+// boundToken reports whether tok is a valid token for the given user and
+// action, according to the mode of the Interceptor.
+func (it *Interceptor) boundToken(tok, userID, actionID string) bool {
+	switch {
+	case it.doubleSubmit:
+		return subtle.ConstantTimeCompare([]byte(tok), []byte(userID)) == 1
+	case it.sealer != nil:
+		return it.sealer.valid(tok, userID, actionID, now())
+	default:
+		return it.validToken(tok, userID, actionID)
+	}
+}
+
+// reject writes an error response with the given code, after calling
+// OnRejected if set.
+func (it *Interceptor) reject(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, code safehttp.StatusCode) safehttp.Result {
+	if it.OnRejected != nil {
+		it.OnRejected(r, code)
+	}
+	return w.WriteError(code)
+}
+
+// jsonToken returns the XSRF token sent in the JSONTokenField field of the JSON
+// request body, if any. At most JSONMaxBytes+1 bytes of the body are read, and
+// they are put back in front of the rest of the body, so that it can still be
+// read by the handler.
+func (it *Interceptor) jsonToken(r *safehttp.IncomingRequest) (string, error) {
+	maxBytes := it.JSONMaxBytes
+	if maxBytes == 0 {
+		maxBytes = defaultJSONMaxBytes
+	}
+	req := restricted.RawRequest(r)
+	src := req.Body
+	if src == nil {
+		src = http.NoBody
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(src, maxBytes+1))
+	req.Body = &readCloser{Reader: io.MultiReader(bytes.NewReader(body), src), Closer: src}
 	if err != nil {
-		return w.WriteError(safehttp.StatusForbidden)
+		return "", err
+	}
+	if int64(len(body)) > maxBytes {
+		return "", fmt.Errorf("JSON body larger than %d bytes", maxBytes)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return "", err
 	}
+	raw, ok := fields[it.JSONTokenField]
+	if !ok {
+		return "", nil
+	}
+	var tok string
+	if err := json.Unmarshal(raw, &tok); err != nil {
+		return "", fmt.Errorf("JSON field %q: %v", it.JSONTokenField, err)
+	}
+	return tok, nil
+}
+
+// readCloser combines a Reader and a Closer into an io.ReadCloser.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// trustedOrigin reports whether the request has been sent from one of the
+// TrustedOrigins, according to its Origin or Referer header.
+func (it *Interceptor) trustedOrigin(r *safehttp.IncomingRequest) bool {
+	if len(it.TrustedOrigins) == 0 {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		u, err := url.Parse(r.Header.Get("Referer"))
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+	for _, o := range it.TrustedOrigins {
+		if origin == o {
+			return true
+		}
+	}
+	return false
+}
 
+// formToken returns the XSRF token sent as part of the request form, if any.
+func (it *Interceptor) formToken(r *safehttp.IncomingRequest, tokenKey string) (string, error) {
+	if xsrf.StatePreserving(r) {
+		// State preserving requests have no body, so the token can only be
+		// sent in the URL query.
+		q, err := r.URL().Query()
+		if err != nil {
+			return "", err
+		}
+		return q.String(tokenKey, ""), nil
+	}
+	if it.JSONTokenField != "" {
+		if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mt == "application/json" {
+			return it.jsonToken(r)
+		}
+	}
 	f, err := r.PostForm()
 	if err != nil {
+		if it.DisableMultipart {
+			return "", err
+		}
 		// We fallback to checking whether the form is multipart. Both types
 		// are valid in an incoming request as long as the XSRF token is
 		// present.
-		mf, err := r.MultipartForm(32 << 20)
+		maxMemory := it.MultipartMaxMemory
+		if maxMemory == 0 {
+			maxMemory = defaultMultipartMaxMemory
+		}
+		mf, err := r.MultipartForm(maxMemory)
 		if err != nil {
-			return w.WriteError(safehttp.StatusBadRequest)
+			return "", err
 		}
 		f = &mf.Form
 	}
 
-	tok := f.String(TokenKey, "")
-	if f.Err() != nil || tok == "" {
-		return w.WriteError(safehttp.StatusUnauthorized)
-	}
-
-	if ok := xsrftoken.Valid(tok, it.SecretAppKey, cookieID.Value(), r.URL().Host()); !ok {
-		return w.WriteError(safehttp.StatusForbidden)
+	tok := f.String(tokenKey, "")
+	if f.Err() != nil {
+		return "", nil
 	}
-
-	return safehttp.NotWritten()
+	return tok, nil
 }
 
 // Commit adds XSRF protection in the response, so the interceptor can
@@ -103,36 +639,232 @@ func (it *Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingReq
 //
 // For every authorized request, the interceptor also generates a
 // cryptographically-safe XSRF token using the appKey, the cookie and the path
-// visited. This is then injected as a hidden input field in HTML forms.
-func (it *Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
-	cookieID, err := r.Cookie(cookieIDKey)
-	if err != nil {
-		if !xsrf.StatePreserving(r) {
-			// Not a state preserving request, so we won't be adding the cookie.
-			return
-		}
-		cookieID, err = addCookieID(w)
+// visited. This is then injected as a hidden input field in HTML forms. Tokens
+// are reused for a short time for requests carrying the same cookie, in order
+// to avoid generating a new one on every request. If configured with
+// SetTokenResponseHeader, the token is also sent in a response header. An
+// Interceptor created with StatelessDoubleSubmit uses the cookie ID itself as
+// the token.
+//
+// The token is only generated when it's used, i.e. when the template calls the
+// XSRFToken function or when it's sent in a response header. Template responses
+// whose templates never call XSRFToken are left untouched and, unless the token
+// is sent in a response header, don't get the cookie either, as they can't
+// submit a token.
+func (it *Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+	tmplResp, isTmpl := resp.(*safehttp.TemplateResponse)
+	inject := isTmpl && it.usesToken(tmplResp)
+	sendHeader := it.tokenRespHeader != "" && xsrf.StatePreserving(r)
+	if isTmpl && !inject && !sendHeader {
+		// The page can't submit the token, so there is no need to set the
+		// cookie either.
+		return
+	}
+
+	userID, ok := it.sessionUserID(r)
+	if !ok {
+		cookieKey, _ := keys(cfg)
+		cookieID, err := it.cookieID(r, cookieKey)
 		if err != nil {
-			// This is a server misconfiguration.
-			panic("cannot add cookie ID")
+			if !xsrf.StatePreserving(r) {
+				// Not a state preserving request, so we won't be adding the cookie.
+				return
+			}
+			cookieID, err = it.addCookieID(w, cookieKey)
+			if err != nil {
+				// This is a server misconfiguration.
+				panic("cannot add cookie ID")
+			}
+			xsrf.MarkCookieMinted(r)
+		} else if xsrf.StatePreserving(r) && it.needsRotation(cookieID.Value()) {
+			cookieID, err = it.rotateCookieID(w, cookieKey, cookieID)
+			if err != nil {
+				// This is a server misconfiguration.
+				panic("cannot rotate cookie ID")
+			}
+			xsrf.MarkCookieMinted(r)
 		}
+		userID = cookieID.Value()
 	}
 
-	tmplResp, ok := resp.(*safehttp.TemplateResponse)
-	if !ok {
-		// If it's not a template response, we cannot inject the token.
+	key, _ := it.keys().Keys()
+	var (
+		once sync.Once
+		tok  string
+	)
+	token := func() string {
+		once.Do(func() {
+			if it.doubleSubmit {
+				tok = userID
+				return
+			}
+			if it.sealer != nil {
+				var err error
+				tok, err = it.sealer.seal(userID, r.URL().Host(), now().Add(it.tokenLifetime()))
+				if err != nil {
+					// This is a server misconfiguration.
+					panic("cannot seal token")
+				}
+				return
+			}
+			tok = it.tokens.generate(key, userID, r.URL().Host())
+		})
+		return tok
+	}
+	if sendHeader {
+		w.Header().Set(it.tokenRespHeader, token())
+	}
+
+	if !inject {
+		// If it's not a template response, or the template doesn't use the
+		// token, we cannot inject the token.
 		// TODO: should this be an error?
 		return
 	}
 
-	tok := xsrftoken.Generate(it.SecretAppKey, cookieID.Value(), r.URL().Host())
+	// The token is injected in the body, which must not be compressed if
+	// BREACH mitigations are enabled.
+	safehttp.MarkSecret(r)
 	if tmplResp.FuncMap == nil {
 		tmplResp.FuncMap = map[string]interface{}{}
 	}
-	tmplResp.FuncMap[htmlinject.XSRFTokensDefaultFuncName] = func() string { return tok }
+	if it.Audit {
+		tmplResp.FuncMap[htmlinject.XSRFTokensDefaultFuncName] = func() Token { return Token{tok: token()} }
+		return
+	}
+	tmplResp.FuncMap[htmlinject.XSRFTokensDefaultFuncName] = token
+}
+
+// usesToken reports whether the template of the response, or any template
+// associated with it, calls the XSRF token function. Templates of unknown types
+// are assumed to use it. The result is cached per template, as templates can't
+// be parsed further once they have been executed.
+func (it *Interceptor) usesToken(resp *safehttp.TemplateResponse) bool {
+	t, ok := resp.Template.(*template.Template)
+	if !ok {
+		return true
+	}
+	if uses, ok := it.usesTokenCache.get(t); ok {
+		return uses
+	}
+	uses := false
+	for _, tt := range t.Templates() {
+		if tt.Tree != nil && usesIdent(tt.Tree.Root, htmlinject.XSRFTokensDefaultFuncName) {
+			uses = true
+			break
+		}
+	}
+	it.usesTokenCache.add(t, uses)
+	return uses
+}
+
+// templateCacheSize is the maximum number of templates in a templateCache.
+const templateCacheSize = 1000
+
+// templateCache is a least recently used cache of whether templates call the
+// XSRF token function. It's bounded, so that applications parsing or cloning
+// templates for every request don't grow it indefinitely. The zero value is
+// ready to use.
+type templateCache struct {
+	mu        sync.Mutex
+	templates map[*template.Template]*list.Element
+	// lru holds the templateCacheEntries, most recently used first.
+	lru list.List
+}
+
+type templateCacheEntry struct {
+	t    *template.Template
+	uses bool
+}
+
+func (c *templateCache) get(t *template.Template) (uses, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.templates[t]
+	if !ok {
+		return false, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(templateCacheEntry).uses, true
+}
+
+func (c *templateCache) add(t *template.Template, uses bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.templates == nil {
+		c.templates = map[*template.Template]*list.Element{}
+	}
+	if _, ok := c.templates[t]; ok {
+		return
+	}
+	if len(c.templates) >= templateCacheSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.templates, oldest.Value.(templateCacheEntry).t)
+	}
+	c.templates[t] = c.lru.PushFront(templateCacheEntry{t: t, uses: uses})
 }
 
-// Match returns false since there are no supported configurations.
-func (*Interceptor) Match(safehttp.InterceptorConfig) bool {
+// len returns the number of cached templates.
+func (c *templateCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.templates)
+}
+
+// usesIdent reports whether the parse tree rooted at n contains the given
+// function identifier.
+func usesIdent(n parse.Node, name string) bool {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, c := range n.Nodes {
+			if usesIdent(c, name) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return usesIdent(n.Pipe, name)
+	case *parse.IfNode:
+		return usesBranchIdent(&n.BranchNode, name)
+	case *parse.RangeNode:
+		return usesBranchIdent(&n.BranchNode, name)
+	case *parse.WithNode:
+		return usesBranchIdent(&n.BranchNode, name)
+	case *parse.TemplateNode:
+		return usesIdent(n.Pipe, name)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, c := range n.Cmds {
+			if usesIdent(c, name) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			if usesIdent(a, name) {
+				return true
+			}
+		}
+	case *parse.ChainNode:
+		return usesIdent(n.Node, name)
+	case *parse.IdentifierNode:
+		return n.Ident == name
+	}
 	return false
 }
+
+func usesBranchIdent(n *parse.BranchNode, name string) bool {
+	return usesIdent(n.Pipe, name) || usesIdent(n.List, name) || usesIdent(n.ElseList, name)
+}
+
+// Match recognizes Override configurations and the configuration returned by
+// xsrf.Disable.
+func (*Interceptor) Match(cfg safehttp.InterceptorConfig) bool {
+	_, ok := cfg.(Override)
+	return ok || xsrf.Disabled(cfg)
+}
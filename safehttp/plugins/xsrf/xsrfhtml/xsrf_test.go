@@ -15,14 +15,31 @@
 package xsrfhtml
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/xsrf"
 	"github.com/google/go-safeweb/safehttp/safehttptest"
+	"github.com/google/safehtml"
+	"github.com/google/safehtml/template"
+	"github.com/google/safehtml/template/uncheckedconversions"
 	"golang.org/x/net/xsrftoken"
 )
 
+// testCookieID is a well-formed cookie ID, i.e. the base64 encoding of
+// xsrf.DefaultEntropy bytes.
+const testCookieID = "MDEyMzQ1Njc4OWFiY2RlZmdoaWo="
+
 var (
 	formTokenTests = []struct {
 		name, cookieVal, host string
@@ -30,13 +47,13 @@ var (
 	}{
 		{
 			name:       "Valid token",
-			cookieVal:  "abcdef",
+			cookieVal:  testCookieID,
 			host:       "go.dev",
 			wantStatus: safehttp.StatusOK,
 		},
 		{
 			name:       "Invalid host in token generation",
-			cookieVal:  "abcdef",
+			cookieVal:  testCookieID,
 			host:       "google.com",
 			wantStatus: safehttp.StatusForbidden,
 		},
@@ -56,7 +73,7 @@ func TestTokenPost(t *testing.T) {
 			tok := xsrftoken.Generate("testSecretAppKey", test.cookieVal, test.host)
 			req := safehttptest.NewRequest(safehttp.MethodPost, "https://go.dev/", strings.NewReader(TokenKey+"="+tok))
 			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-			req.Header.Set("Cookie", cookieIDKey+"=abcdef")
+			req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
 
 			i := Interceptor{SecretAppKey: "testSecretAppKey"}
 			i.Before(fakeRW, req, nil)
@@ -80,7 +97,7 @@ func TestTokenMultipart(t *testing.T) {
 				"--123--\r\n"
 			req := safehttptest.NewRequest(safehttp.MethodPost, "https://go.dev/", strings.NewReader(b))
 			req.Header.Set("Content-Type", `multipart/form-data; boundary="123"`)
-			req.Header.Set("Cookie", cookieIDKey+"=abcdef")
+			req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
 
 			i := Interceptor{SecretAppKey: "testSecretAppKey"}
 			i.Before(fakeRW, req, nil)
@@ -96,7 +113,7 @@ func TestMalformedForm(t *testing.T) {
 	fakeRW, rr := safehttptest.NewFakeResponseWriter()
 	req := safehttptest.NewRequest(safehttp.MethodPost, "https://foo.com/pizza", nil)
 	req.Header.Set("Content-Type", "wrong")
-	req.Header.Set("Cookie", cookieIDKey+"=abcdef")
+	req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
 
 	i := Interceptor{SecretAppKey: "testSecretAppKey"}
 	i.Before(fakeRW, req, nil)
@@ -116,7 +133,7 @@ func TestMissingTokenInBody(t *testing.T) {
 			req: func() *safehttp.IncomingRequest {
 				req := safehttptest.NewRequest(safehttp.MethodPost, "/", strings.NewReader("foo=bar"))
 				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-				req.Header.Set("Cookie", cookieIDKey+"=abcdef")
+				req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
 				return req
 			}(),
 		},
@@ -125,7 +142,7 @@ func TestMissingTokenInBody(t *testing.T) {
 			req: func() *safehttp.IncomingRequest {
 				req := safehttptest.NewRequest(safehttp.MethodPatch, "/", strings.NewReader("foo=bar"))
 				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-				req.Header.Set("Cookie", cookieIDKey+"=abcdef")
+				req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
 				return req
 			}(),
 		},
@@ -139,7 +156,7 @@ func TestMissingTokenInBody(t *testing.T) {
 					"--123--\r\n"
 				req := safehttptest.NewRequest(safehttp.MethodPost, "/", strings.NewReader(b))
 				req.Header.Set("Content-Type", `multipart/form-data; boundary="123"`)
-				req.Header.Set("Cookie", cookieIDKey+"=abcdef")
+				req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
 				return req
 			}(),
 		},
@@ -153,7 +170,7 @@ func TestMissingTokenInBody(t *testing.T) {
 					"--123--\r\n"
 				req := safehttptest.NewRequest(safehttp.MethodPatch, "/", strings.NewReader(b))
 				req.Header.Set("Content-Type", `multipart/form-data; boundary="123"`)
-				req.Header.Set("Cookie", cookieIDKey+"=abcdef")
+				req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
 				return req
 			}(),
 		},
@@ -275,3 +292,1208 @@ func TestCommitNotTemplateResponse(t *testing.T) {
 		t.Errorf("rr.Body.String(): got %q want %q", got, want)
 	}
 }
+
+func TestCookieIDEntropy(t *testing.T) {
+	for _, n := range []int{0, xsrf.MinEntropy, 32} {
+		t.Run(fmt.Sprintf("%d bytes", n), func(t *testing.T) {
+			fakeRW, _ := safehttptest.NewFakeResponseWriter()
+			req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/pizza", nil)
+
+			i := Interceptor{SecretAppKey: "testSecretAppKey"}
+			want := xsrf.DefaultEntropy
+			if n != 0 {
+				if err := i.SetEntropy(n); err != nil {
+					t.Fatalf("i.SetEntropy(%d): got err %v, want nil", n, err)
+				}
+				want = n
+			}
+			i.Commit(fakeRW, req, nil, nil)
+
+			if len(fakeRW.Cookies) != 1 {
+				t.Fatalf("len(Cookies) = %v, want 1", len(fakeRW.Cookies))
+			}
+			// The cookie ID is followed by the time it was issued at.
+			v := fakeRW.Cookies[0].Value()
+			v = v[:strings.LastIndexByte(v, '.')]
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				t.Fatalf("base64.StdEncoding.DecodeString(cookie value): %v", err)
+			}
+			if got := len(b); got != want {
+				t.Errorf("decoded cookie ID length: got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestSetEntropyBelowMinimum(t *testing.T) {
+	i := Interceptor{SecretAppKey: "testSecretAppKey"}
+	if err := i.SetEntropy(xsrf.MinEntropy - 1); err == nil {
+		t.Errorf("i.SetEntropy(%d): got nil, want error", xsrf.MinEntropy-1)
+	}
+}
+
+func TestCommitAuditTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr bool
+	}{
+		{
+			name: "Token in attribute value",
+			src:  `<form><input type="hidden" name="xsrf-token" value="{{XSRFToken}}"></form>`,
+		},
+		{
+			name:    "Token converted to HTML",
+			src:     `<form>{{XSRFToken | safeHTML}}</form>`,
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fns := map[string]interface{}{
+				"XSRFToken": func() string { return "" },
+				"safeHTML":  func(s string) safehtml.HTML { return safehtml.HTMLEscaped(s) },
+			}
+			tpl := template.Must(template.New("").Funcs(fns).ParseFromTrustedTemplate(
+				uncheckedconversions.TrustedTemplateFromStringKnownToSatisfyTypeContract(test.src)))
+
+			fakeRW, _ := safehttptest.NewFakeResponseWriter()
+			req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/pizza", nil)
+			i := Interceptor{SecretAppKey: "testSecretAppKey", Audit: true}
+			tr := &safehttp.TemplateResponse{Template: tpl}
+			i.Commit(fakeRW, req, tr, nil)
+
+			rr := httptest.NewRecorder()
+			err := safehttp.DefaultDispatcher{}.Write(rr, tr)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("DefaultDispatcher.Write: got nil err, want error; body %q", rr.Body.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DefaultDispatcher.Write: got err %v", err)
+			}
+			if got := rr.Body.String(); !strings.Contains(got, `value="`) || strings.Contains(got, `value=""`) {
+				t.Errorf("response body: got %q, want the token injected in the value attribute", got)
+			}
+		})
+	}
+}
+
+func TestOverrideIsolatesHandlers(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(&Interceptor{SecretAppKey: "testSecretAppKey"})
+	mux := mb.Mux()
+
+	tpl := template.Must(template.New("").Funcs(map[string]interface{}{
+		"XSRFToken": func() string { return "" },
+	}).ParseFromTrustedTemplate(uncheckedconversions.TrustedTemplateFromStringKnownToSatisfyTypeContract(`{{XSRFToken}}`)))
+	get := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.ExecuteTemplate(w, tpl, nil)
+	})
+	post := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.NotWritten()
+	})
+	overrides := map[string]Override{
+		"/a": {CookieIDKey: "a-cookie", TokenKey: "a-token"},
+		"/b": {CookieIDKey: "b-cookie", TokenKey: "b-token"},
+	}
+	cookies := map[string]*http.Cookie{}
+	tokens := map[string]string{}
+	for path, o := range overrides {
+		mux.Handle(path, safehttp.MethodGet, get, o)
+		mux.Handle(path, safehttp.MethodPost, post, o)
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(safehttp.MethodGet, "https://foo.com"+path, nil))
+		resp := rr.Result()
+		if len(resp.Cookies()) != 1 {
+			t.Fatalf("GET %s: got %d cookies, want 1", path, len(resp.Cookies()))
+		}
+		if got, want := resp.Cookies()[0].Name, o.CookieIDKey; got != want {
+			t.Errorf("GET %s: got cookie %q, want %q", path, got, want)
+		}
+		cookies[path] = resp.Cookies()[0]
+		tokens[path] = rr.Body.String()
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		tokenFrom  string
+		wantStatus safehttp.StatusCode
+	}{
+		{name: "Own token on /a", path: "/a", tokenFrom: "/a", wantStatus: safehttp.StatusNoContent},
+		{name: "Own token on /b", path: "/b", tokenFrom: "/b", wantStatus: safehttp.StatusNoContent},
+		{name: "Token of /b on /a", path: "/a", tokenFrom: "/b", wantStatus: safehttp.StatusForbidden},
+		{name: "Token of /a on /b", path: "/b", tokenFrom: "/a", wantStatus: safehttp.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := overrides[test.path].TokenKey + "=" + tokens[test.tokenFrom]
+			req := httptest.NewRequest(safehttp.MethodPost, "https://foo.com"+test.path, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.AddCookie(cookies["/a"])
+			req.AddCookie(cookies["/b"])
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if got, want := rr.Code, int(test.wantStatus); got != want {
+				t.Errorf("rr.Code: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestTokenHeader(t *testing.T) {
+	tok := xsrftoken.Generate("testSecretAppKey", testCookieID, "go.dev")
+	otherTok := xsrftoken.Generate("testSecretAppKey", "evilvalue", "go.dev")
+	tests := []struct {
+		name        string
+		contentType string
+		header      string
+		form        string
+		wantStatus  safehttp.StatusCode
+	}{
+		{
+			name:        "Header only",
+			contentType: "application/json",
+			header:      tok,
+			wantStatus:  safehttp.StatusOK,
+		},
+		{
+			name:        "Invalid header only",
+			contentType: "application/json",
+			header:      otherTok,
+			wantStatus:  safehttp.StatusForbidden,
+		},
+		{
+			name:        "Form only",
+			contentType: "application/x-www-form-urlencoded",
+			form:        tok,
+			wantStatus:  safehttp.StatusOK,
+		},
+		{
+			name:        "Header and form matching",
+			contentType: "application/x-www-form-urlencoded",
+			header:      tok,
+			form:        tok,
+			wantStatus:  safehttp.StatusOK,
+		},
+		{
+			name:        "Header and form mismatching",
+			contentType: "application/x-www-form-urlencoded",
+			header:      tok,
+			form:        otherTok,
+			wantStatus:  safehttp.StatusForbidden,
+		},
+		{
+			name:        "Neither header nor form",
+			contentType: "application/json",
+			wantStatus:  safehttp.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			body := "{}"
+			if test.form != "" {
+				body = TokenKey + "=" + test.form
+			}
+			req := safehttptest.NewRequest(safehttp.MethodPost, "https://go.dev/", strings.NewReader(body))
+			req.Header.Set("Content-Type", test.contentType)
+			req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+			if test.header != "" {
+				req.Header.Set("X-XSRF-Token", test.header)
+			}
+
+			i := Interceptor{SecretAppKey: "testSecretAppKey", TokenHeaderName: "X-XSRF-Token"}
+			i.Before(fakeRW, req, nil)
+
+			if got := rr.Code; got != int(test.wantStatus) {
+				t.Errorf("rr.Code: got %v, want %v", got, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCommitOnRecoveredPanic(t *testing.T) {
+	var reported bool
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(&Interceptor{SecretAppKey: "testSecretAppKey"})
+	mb.RecoverPanics(func(*safehttp.IncomingRequest, interface{}) { reported = true })
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		panic("handler")
+	}))
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil))
+
+	if want := int(safehttp.StatusInternalServerError); rr.Code != want {
+		t.Errorf("rr.Code: got %v, want %v", rr.Code, want)
+	}
+	if !reported {
+		t.Error("panic not reported")
+	}
+	if cookies := rr.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != cookieIDKey {
+		t.Errorf("rr.Result().Cookies(): got %v, want a single %q cookie", cookies, cookieIDKey)
+	}
+}
+
+func TestCookieMinted(t *testing.T) {
+	it := &Interceptor{SecretAppKey: "testSecretAppKey"}
+
+	req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+	fakeRW, _ := safehttptest.NewFakeResponseWriter()
+	it.Commit(fakeRW, req, nil, nil)
+	if !xsrf.CookieMintedFromContext(req.Context()) {
+		t.Error("first request: xsrf.CookieMintedFromContext got false, want true")
+	}
+	if len(fakeRW.Cookies) != 1 {
+		t.Fatalf("len(fakeRW.Cookies): got %d, want 1", len(fakeRW.Cookies))
+	}
+
+	req = safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+	req.Header.Set("Cookie", fakeRW.Cookies[0].Name()+"="+fakeRW.Cookies[0].Value())
+	fakeRW, _ = safehttptest.NewFakeResponseWriter()
+	it.Commit(fakeRW, req, nil, nil)
+	if xsrf.CookieMintedFromContext(req.Context()) {
+		t.Error("follow-up request: xsrf.CookieMintedFromContext got true, want false")
+	}
+}
+
+func TestTokenResponseHeader(t *testing.T) {
+	it := &Interceptor{SecretAppKey: "testSecretAppKey", TokenHeaderName: "X-XSRF-Token"}
+	if err := it.SetTokenResponseHeader("x-xsrf-token"); err != nil {
+		t.Fatalf("it.SetTokenResponseHeader() got err: %v", err)
+	}
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(it)
+	mux := mb.Mux()
+	h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehtml.HTMLEscaped("ok"))
+	})
+	mux.Handle("/", safehttp.MethodGet, h)
+	mux.Handle("/", safehttp.MethodPost, h)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil))
+	tok := rr.Header().Get("X-Xsrf-Token")
+	if tok == "" {
+		t.Fatal(`GET: rr.Header().Get("X-Xsrf-Token") got empty, want token`)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("GET: got %d cookies, want 1", len(cookies))
+	}
+
+	req := httptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-XSRF-Token", tok)
+	req.AddCookie(cookies[0])
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if got, want := rr.Code, int(safehttp.StatusOK); got != want {
+		t.Errorf("POST: rr.Code: got %v, want %v", got, want)
+	}
+	if got := rr.Header().Get("X-Xsrf-Token"); got != "" {
+		t.Errorf(`POST: rr.Header().Get("X-Xsrf-Token") got %q, want empty`, got)
+	}
+}
+
+func TestSetTokenResponseHeaderInvalid(t *testing.T) {
+	for _, name := range []string{"X-XSRF Token", "X-XSRF-Token\n", "Set-Cookie"} {
+		it := &Interceptor{SecretAppKey: "testSecretAppKey"}
+		if err := it.SetTokenResponseHeader(name); err == nil {
+			t.Errorf("it.SetTokenResponseHeader(%q) got nil err, want error", name)
+		}
+	}
+}
+
+type rotatingKeys struct {
+	current  string
+	previous []string
+}
+
+func (k rotatingKeys) Keys() (string, []string) {
+	return k.current, k.previous
+}
+
+func TestKeyRotation(t *testing.T) {
+	it := &Interceptor{KeyProvider: rotatingKeys{current: "new", previous: []string{"old"}}}
+	tests := []struct {
+		name       string
+		key        string
+		wantStatus safehttp.StatusCode
+	}{
+		{name: "Current key", key: "new", wantStatus: safehttp.StatusOK},
+		{name: "Previous key", key: "old", wantStatus: safehttp.StatusOK},
+		{name: "Retired key", key: "retired", wantStatus: safehttp.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tok := xsrftoken.Generate(test.key, testCookieID, "foo.com")
+			req := safehttptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader(TokenKey+"="+tok))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			it.Before(fakeRW, req, nil)
+
+			if got := rr.Code; got != int(test.wantStatus) {
+				t.Errorf("rr.Code: got %v, want %v", got, test.wantStatus)
+			}
+		})
+	}
+
+	t.Run("New tokens use the current key", func(t *testing.T) {
+		req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+		req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+		fakeRW, _ := safehttptest.NewFakeResponseWriter()
+		resp := &safehttp.TemplateResponse{}
+		it.Commit(fakeRW, req, resp, nil)
+
+		tok := resp.FuncMap["XSRFToken"].(func() string)()
+		if !xsrftoken.Valid(tok, "new", testCookieID, "foo.com") {
+			t.Errorf("token %q was not signed with the current key", tok)
+		}
+	})
+}
+
+func TestEnforceWhen(t *testing.T) {
+	it := &Interceptor{
+		SecretAppKey: "testSecretAppKey",
+		EnforceWhen: func(r *safehttp.IncomingRequest) bool {
+			return !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+		},
+	}
+	tests := []struct {
+		name       string
+		req        func() *safehttp.IncomingRequest
+		wantStatus safehttp.StatusCode
+	}{
+		{
+			name: "Bearer auth bypasses",
+			req: func() *safehttp.IncomingRequest {
+				req := safehttptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader("{}"))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Authorization", "Bearer abcdef")
+				return req
+			},
+			wantStatus: safehttp.StatusOK,
+		},
+		{
+			name: "Cookie auth enforces",
+			req: func() *safehttp.IncomingRequest {
+				req := safehttptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader(TokenKey+"=invalid"))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.Header.Set("Cookie", cookieIDKey+"="+testCookieID+"; session=foo")
+				return req
+			},
+			wantStatus: safehttp.StatusForbidden,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			it.Before(fakeRW, test.req(), nil)
+
+			if got := rr.Code; got != int(test.wantStatus) {
+				t.Errorf("rr.Code: got %v, want %v", got, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestStateChangingMethods(t *testing.T) {
+	tok := xsrftoken.Generate("testSecretAppKey", testCookieID, "foo.com")
+	enforceGet := Override{StateChangingMethods: []string{safehttp.MethodGet}}
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		cfg        safehttp.InterceptorConfig
+		wantStatus safehttp.StatusCode
+	}{
+		{
+			name:       "GET bypassed by default",
+			method:     safehttp.MethodGet,
+			target:     "https://foo.com/",
+			wantStatus: safehttp.StatusOK,
+		},
+		{
+			name:       "GET enforced without token",
+			method:     safehttp.MethodGet,
+			target:     "https://foo.com/",
+			cfg:        enforceGet,
+			wantStatus: safehttp.StatusUnauthorized,
+		},
+		{
+			name:       "GET enforced with invalid token",
+			method:     safehttp.MethodGet,
+			target:     "https://foo.com/?" + TokenKey + "=invalid",
+			cfg:        enforceGet,
+			wantStatus: safehttp.StatusForbidden,
+		},
+		{
+			name:       "GET enforced with valid token",
+			method:     safehttp.MethodGet,
+			target:     "https://foo.com/?" + TokenKey + "=" + tok,
+			cfg:        enforceGet,
+			wantStatus: safehttp.StatusOK,
+		},
+		{
+			name:       "HEAD not enforced",
+			method:     safehttp.MethodHead,
+			target:     "https://foo.com/",
+			cfg:        enforceGet,
+			wantStatus: safehttp.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := safehttptest.NewRequest(test.method, test.target, nil)
+			req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			it := &Interceptor{SecretAppKey: "testSecretAppKey"}
+			it.Before(fakeRW, req, test.cfg)
+
+			if got := rr.Code; got != int(test.wantStatus) {
+				t.Errorf("rr.Code: got %v, want %v", got, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCommitOnlyWhenTokenUsed(t *testing.T) {
+	tests := []struct {
+		name       string
+		src        string
+		respHeader string
+		wantCookie bool
+		wantInject bool
+	}{
+		{
+			name:       "Token used",
+			src:        `<form method="post"><input name="xsrf-token" value="{{XSRFToken}}"></form>`,
+			wantCookie: true,
+			wantInject: true,
+		},
+		{
+			name:       "Token used in associated template",
+			src:        `{{define "form"}}{{if true}}{{XSRFToken}}{{end}}{{end}}<p>{{template "form"}}</p>`,
+			wantCookie: true,
+			wantInject: true,
+		},
+		{
+			name: "Token not used",
+			src:  `<p>{{.}}</p>`,
+		},
+		{
+			name:       "Token not used, response header",
+			src:        `<p>{{.}}</p>`,
+			respHeader: "X-XSRF-Token",
+			wantCookie: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tpl := template.Must(template.New("").Funcs(map[string]interface{}{
+				"XSRFToken": func() string { return "" },
+			}).ParseFromTrustedTemplate(uncheckedconversions.TrustedTemplateFromStringKnownToSatisfyTypeContract(test.src)))
+			resp := &safehttp.TemplateResponse{Template: tpl}
+			req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+			fakeRW, _ := safehttptest.NewFakeResponseWriter()
+
+			it := &Interceptor{SecretAppKey: "testSecretAppKey"}
+			if err := it.SetTokenResponseHeader(test.respHeader); err != nil {
+				t.Fatalf("SetTokenResponseHeader(%q): %v", test.respHeader, err)
+			}
+			it.Commit(fakeRW, req, resp, nil)
+
+			if got := len(fakeRW.Cookies) == 1; got != test.wantCookie {
+				t.Errorf("cookie set: got %v, want %v", got, test.wantCookie)
+			}
+			if _, got := resp.FuncMap["XSRFToken"]; got != test.wantInject {
+				t.Errorf("XSRFToken injected: got %v, want %v", got, test.wantInject)
+			}
+			if test.respHeader != "" && fakeRW.Header().Get(test.respHeader) == "" {
+				t.Errorf("%s response header: got none, want token", test.respHeader)
+			}
+		})
+	}
+}
+
+func TestCommitTemplateCacheBounded(t *testing.T) {
+	it := &Interceptor{SecretAppKey: "testSecretAppKey"}
+	for i := 0; i < 2*templateCacheSize; i++ {
+		// Templates parsed for every request are distinct.
+		tpl := template.Must(template.New("").Funcs(map[string]interface{}{
+			"XSRFToken": func() string { return "" },
+		}).ParseFromTrustedTemplate(uncheckedconversions.TrustedTemplateFromStringKnownToSatisfyTypeContract(`<p>{{XSRFToken}}</p>`)))
+		resp := &safehttp.TemplateResponse{Template: tpl}
+		req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+		fakeRW, _ := safehttptest.NewFakeResponseWriter()
+		it.Commit(fakeRW, req, resp, nil)
+		if _, ok := resp.FuncMap["XSRFToken"]; !ok {
+			t.Fatalf("template %d: XSRFToken not injected", i)
+		}
+	}
+	if got := it.usesTokenCache.len(); got > templateCacheSize {
+		t.Errorf("cached templates: got %d, want at most %d", got, templateCacheSize)
+	}
+}
+
+func TestCommitLazyToken(t *testing.T) {
+	req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+	req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+	fakeRW, _ := safehttptest.NewFakeResponseWriter()
+	resp := &safehttp.TemplateResponse{}
+
+	it := &Interceptor{SecretAppKey: "testSecretAppKey"}
+	it.Commit(fakeRW, req, resp, nil)
+
+	if got := it.tokens.len(); got != 0 {
+		t.Errorf("tokens generated before render: got %d, want 0", got)
+	}
+	tok := resp.FuncMap["XSRFToken"].(func() string)()
+	if !xsrftoken.Valid(tok, "testSecretAppKey", testCookieID, "foo.com") {
+		t.Errorf("invalid token %q", tok)
+	}
+	if got := it.tokens.len(); got != 1 {
+		t.Errorf("tokens generated after render: got %d, want 1", got)
+	}
+}
+
+func TestMalformedCookieID(t *testing.T) {
+	tests := []struct {
+		name, cookieVal string
+	}{
+		{name: "Not base64", cookieVal: "not*base64!"},
+		{name: "Too short", cookieVal: "abcdef"},
+		{name: "Wrong length", cookieVal: base64.StdEncoding.EncodeToString(make([]byte, xsrf.DefaultEntropy+1))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			it := &Interceptor{SecretAppKey: "testSecretAppKey"}
+
+			tok := xsrftoken.Generate("testSecretAppKey", test.cookieVal, "foo.com")
+			req := safehttptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader(TokenKey+"="+tok))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Cookie", cookieIDKey+"="+test.cookieVal)
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			it.Before(fakeRW, req, nil)
+			if want := safehttp.StatusForbidden; rr.Code != int(want) {
+				t.Errorf("POST with malformed cookie: rr.Code got %v, want %v", rr.Code, want)
+			}
+
+			req = safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+			req.Header.Set("Cookie", cookieIDKey+"="+test.cookieVal)
+			fakeRW, _ = safehttptest.NewFakeResponseWriter()
+			resp := &safehttp.TemplateResponse{}
+			it.Commit(fakeRW, req, resp, nil)
+			if len(fakeRW.Cookies) != 1 {
+				t.Fatalf("GET with malformed cookie: len(fakeRW.Cookies) got %d, want 1", len(fakeRW.Cookies))
+			}
+			c := fakeRW.Cookies[0]
+			if c.Name() != cookieIDKey || c.Value() == test.cookieVal {
+				t.Errorf("GET with malformed cookie: got cookie %q, want a new %q cookie", c.String(), cookieIDKey)
+			}
+			if !xsrf.CookieMintedFromContext(req.Context()) {
+				t.Error("xsrf.CookieMintedFromContext got false, want true")
+			}
+
+			// The client recovers when retrying with the new cookie.
+			tok = resp.FuncMap["XSRFToken"].(func() string)()
+			req = safehttptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader(TokenKey+"="+tok))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Cookie", cookieIDKey+"="+c.Value())
+			fakeRW, rr = safehttptest.NewFakeResponseWriter()
+			it.Before(fakeRW, req, nil)
+			if want := safehttp.StatusOK; rr.Code != int(want) {
+				t.Errorf("retried POST: rr.Code got %v, want %v", rr.Code, want)
+			}
+		})
+	}
+}
+
+func TestStatelessDoubleSubmit(t *testing.T) {
+	it := StatelessDoubleSubmit()
+
+	req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+	fakeRW, _ := safehttptest.NewFakeResponseWriter()
+	resp := &safehttp.TemplateResponse{}
+	it.Commit(fakeRW, req, resp, nil)
+	if len(fakeRW.Cookies) != 1 {
+		t.Fatalf("len(fakeRW.Cookies): got %d, want 1", len(fakeRW.Cookies))
+	}
+	cookie := fakeRW.Cookies[0].Value()
+	if tok := resp.FuncMap["XSRFToken"].(func() string)(); tok != cookie {
+		t.Errorf("XSRFToken(): got %q, want the cookie value %q", tok, cookie)
+	}
+
+	tests := []struct {
+		name, cookie, tok string
+		wantStatus        safehttp.StatusCode
+	}{
+		{name: "Matching token", cookie: cookie, tok: cookie, wantStatus: safehttp.StatusOK},
+		{name: "Mismatching token", cookie: cookie, tok: testCookieID, wantStatus: safehttp.StatusForbidden},
+		{name: "Missing token", cookie: cookie, wantStatus: safehttp.StatusUnauthorized},
+		{name: "Missing cookie", tok: cookie, wantStatus: safehttp.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := safehttptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader(TokenKey+"="+url.QueryEscape(test.tok)))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.cookie != "" {
+				req.Header.Set("Cookie", cookieIDKey+"="+test.cookie)
+			}
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			it.Before(fakeRW, req, nil)
+
+			if got := rr.Code; got != int(test.wantStatus) {
+				t.Errorf("rr.Code: got %v, want %v", got, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHeaderInterceptor(t *testing.T) {
+	it, err := NewHeaderInterceptor("testSecretAppKey", "X-CSRF-Token")
+	if err != nil {
+		t.Fatalf("NewHeaderInterceptor() got err: %v", err)
+	}
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(it)
+	mux := mb.Mux()
+	h := safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		body, err := ioutil.ReadAll(r.Body())
+		if err != nil {
+			return w.WriteError(safehttp.StatusBadRequest)
+		}
+		return w.Write(safehtml.HTMLEscaped(string(body)))
+	})
+	mux.Handle("/", safehttp.MethodGet, h)
+	mux.Handle("/", safehttp.MethodPost, h)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil))
+	tok := rr.Header().Get("X-Csrf-Token")
+	if tok == "" {
+		t.Fatal(`GET: rr.Header().Get("X-Csrf-Token") got empty, want token`)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("GET: got %d cookies, want 1", len(cookies))
+	}
+
+	tests := []struct {
+		name, contentType, body, header string
+		wantStatus                      safehttp.StatusCode
+		wantBody                        string
+	}{
+		{
+			name:        "JSON body with header",
+			contentType: "application/json",
+			body:        `{"a":1}`,
+			header:      tok,
+			wantStatus:  safehttp.StatusOK,
+			wantBody:    "{&#34;a&#34;:1}",
+		},
+		{
+			name:        "JSON body without header",
+			contentType: "application/json",
+			body:        `{"a":1}`,
+			wantStatus:  safehttp.StatusUnauthorized,
+			wantBody:    "Unauthorized\n",
+		},
+		{
+			name:        "Form token is ignored",
+			contentType: "application/x-www-form-urlencoded",
+			body:        TokenKey + "=" + tok,
+			wantStatus:  safehttp.StatusUnauthorized,
+			wantBody:    "Unauthorized\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", test.contentType)
+			if test.header != "" {
+				req.Header.Set("X-CSRF-Token", test.header)
+			}
+			req.AddCookie(cookies[0])
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if got := rr.Code; got != int(test.wantStatus) {
+				t.Errorf("rr.Code: got %v, want %v", got, test.wantStatus)
+			}
+			if got := rr.Body.String(); got != test.wantBody {
+				t.Errorf("rr.Body: got %q, want %q", got, test.wantBody)
+			}
+		})
+	}
+}
+
+func TestHeaderInterceptorTemplateResponse(t *testing.T) {
+	it, err := NewHeaderInterceptor("testSecretAppKey", "X-CSRF-Token")
+	if err != nil {
+		t.Fatalf("NewHeaderInterceptor() got err: %v", err)
+	}
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(it)
+	mux := mb.Mux()
+	// The shell of a single page application, which doesn't use XSRFToken.
+	tpl := template.Must(template.New("").Parse(`<div id="app"></div>`))
+	mux.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.ExecuteTemplate(w, tpl, nil)
+	}))
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil))
+
+	if rr.Code != int(safehttp.StatusOK) {
+		t.Errorf("rr.Code: got %v, want %v", rr.Code, safehttp.StatusOK)
+	}
+	tok := rr.Header().Get("X-Csrf-Token")
+	if tok == "" {
+		t.Fatal(`rr.Header().Get("X-Csrf-Token") got empty, want token`)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	if !xsrftoken.Valid(tok, "testSecretAppKey", cookies[0].Value, "foo.com") {
+		t.Errorf("invalid token %q", tok)
+	}
+}
+
+func TestNewHeaderInterceptorInvalid(t *testing.T) {
+	if _, err := NewHeaderInterceptor("testSecretAppKey", "X-CSRF Token"); err == nil {
+		t.Error("NewHeaderInterceptor() got nil err, want error")
+	}
+}
+
+func TestTrustedOrigins(t *testing.T) {
+	tests := []struct {
+		name, origin, referer, tok string
+		wantStatus                 safehttp.StatusCode
+	}{
+		{name: "Trusted origin", origin: "https://foo.com", wantStatus: safehttp.StatusOK},
+		{name: "Trusted referer", referer: "https://foo.com/form?a=b", wantStatus: safehttp.StatusOK},
+		{name: "Untrusted origin", origin: "https://evil.com", wantStatus: safehttp.StatusUnauthorized},
+		{name: "Untrusted origin, trusted referer", origin: "https://evil.com", referer: "https://foo.com/", wantStatus: safehttp.StatusUnauthorized},
+		{name: "Different scheme", origin: "http://foo.com", wantStatus: safehttp.StatusUnauthorized},
+		{name: "Opaque origin", origin: "null", wantStatus: safehttp.StatusUnauthorized},
+		{name: "Untrusted referer", referer: "https://evil.com/https://foo.com", wantStatus: safehttp.StatusUnauthorized},
+		{name: "No origin", wantStatus: safehttp.StatusUnauthorized},
+		{name: "Trusted origin, invalid token", origin: "https://foo.com", tok: "invalid", wantStatus: safehttp.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			it := &Interceptor{SecretAppKey: "testSecretAppKey", TrustedOrigins: []string{"https://foo.com"}}
+			req := safehttptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader(TokenKey+"="+test.tok))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+			if test.referer != "" {
+				req.Header.Set("Referer", test.referer)
+			}
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			it.Before(fakeRW, req, nil)
+
+			if got := rr.Code; got != int(test.wantStatus) {
+				t.Errorf("rr.Code: got %v, want %v", got, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestTokenLifetime(t *testing.T) {
+	it := &Interceptor{SecretAppKey: "testSecretAppKey"}
+	tok := xsrftoken.Generate("testSecretAppKey", testCookieID, "foo.com")
+	post := func() int {
+		req := safehttptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader(TokenKey+"="+tok))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+		fakeRW, rr := safehttptest.NewFakeResponseWriter()
+		it.Before(fakeRW, req, nil)
+		return rr.Code
+	}
+
+	if got, want := post(), int(safehttp.StatusOK); got != want {
+		t.Errorf("default lifetime: rr.Code got %v, want %v", got, want)
+	}
+	// Bypass SetTokenLifetime, as waiting for xsrf.MinLifetime is not viable.
+	it.lifetime = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	if got, want := post(), int(safehttp.StatusForbidden); got != want {
+		t.Errorf("expired token: rr.Code got %v, want %v", got, want)
+	}
+}
+
+func TestCookieIDRotation(t *testing.T) {
+	defer func() { now = time.Now }()
+	start := time.Now()
+	now = func() time.Time { return start }
+
+	it := &Interceptor{SecretAppKey: "testSecretAppKey"}
+	get := func(cookies string) (*safehttptest.FakeResponseWriter, string, bool) {
+		req := safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+		if cookies != "" {
+			req.Header.Set("Cookie", cookies)
+		}
+		fakeRW, _ := safehttptest.NewFakeResponseWriter()
+		resp := &safehttp.TemplateResponse{}
+		it.Commit(fakeRW, req, resp, nil)
+		return fakeRW, resp.FuncMap["XSRFToken"].(func() string)(), xsrf.CookieMintedFromContext(req.Context())
+	}
+	post := func(cookies, tok string) int {
+		req := safehttptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader(TokenKey+"="+tok))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Cookie", cookies)
+		fakeRW, rr := safehttptest.NewFakeResponseWriter()
+		it.Before(fakeRW, req, nil)
+		return rr.Code
+	}
+
+	fakeRW, oldTok, _ := get("")
+	if len(fakeRW.Cookies) != 1 {
+		t.Fatalf("len(fakeRW.Cookies): got %d, want 1", len(fakeRW.Cookies))
+	}
+	oldCookie := cookieIDKey + "=" + fakeRW.Cookies[0].Value()
+
+	now = func() time.Time { return start.Add(xsrf.DefaultLifetime/2 - time.Second) }
+	if fakeRW, _, minted := get(oldCookie); len(fakeRW.Cookies) != 0 || minted {
+		t.Errorf("before half of the lifetime: got %d cookies (minted: %v), want none", len(fakeRW.Cookies), minted)
+	}
+
+	now = func() time.Time { return start.Add(xsrf.DefaultLifetime / 2) }
+	fakeRW, newTok, minted := get(oldCookie)
+	if !minted {
+		t.Error("xsrf.CookieMintedFromContext: got false, want true")
+	}
+	if len(fakeRW.Cookies) != 2 {
+		t.Fatalf("len(fakeRW.Cookies): got %d, want 2", len(fakeRW.Cookies))
+	}
+	prev, cur := fakeRW.Cookies[0], fakeRW.Cookies[1]
+	if got, want := prev.Name()+"="+prev.Value(), previousCookieIDKey(cookieIDKey)+"="+oldCookie[len(cookieIDKey)+1:]; got != want {
+		t.Errorf("previous cookie ID: got %q, want %q", got, want)
+	}
+	if cur.Name() != cookieIDKey || cookieIDKey+"="+cur.Value() == oldCookie {
+		t.Errorf("rotated cookie ID: got %q=%q, want a new value", cur.Name(), cur.Value())
+	}
+	newCookies := cookieIDKey + "=" + cur.Value() + "; " + prev.Name() + "=" + prev.Value()
+
+	tests := []struct {
+		name, cookies, tok string
+		wantStatus         safehttp.StatusCode
+	}{
+		{name: "New token", cookies: newCookies, tok: newTok, wantStatus: safehttp.StatusOK},
+		{name: "Token bound to the previous cookie ID", cookies: newCookies, tok: oldTok, wantStatus: safehttp.StatusOK},
+		{name: "Previous cookie ID missing", cookies: cookieIDKey + "=" + cur.Value(), tok: oldTok, wantStatus: safehttp.StatusForbidden},
+		{name: "Previous cookie ID only", cookies: prev.Name() + "=" + prev.Value(), tok: oldTok, wantStatus: safehttp.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := post(test.cookies, test.tok); got != int(test.wantStatus) {
+				t.Errorf("rr.Code: got %v, want %v", got, test.wantStatus)
+			}
+		})
+	}
+
+	t.Run("Cookie IDs without issue time", func(t *testing.T) {
+		now = func() time.Time { return start.Add(xsrf.DefaultLifetime) }
+		if fakeRW, _, _ := get(cookieIDKey + "=" + testCookieID); len(fakeRW.Cookies) != 0 {
+			t.Errorf("len(fakeRW.Cookies): got %d, want 0", len(fakeRW.Cookies))
+		}
+	})
+}
+
+func TestSetTokenLifetimeBelowMinimum(t *testing.T) {
+	it := &Interceptor{SecretAppKey: "testSecretAppKey"}
+	if err := it.SetTokenLifetime(xsrf.MinLifetime - time.Second); err == nil {
+		t.Error("it.SetTokenLifetime() got nil err, want error")
+	}
+	if err := it.SetTokenLifetime(time.Hour); err != nil {
+		t.Errorf("it.SetTokenLifetime(time.Hour): got err %v, want nil", err)
+	}
+}
+
+type sessionKey struct{}
+
+func TestSessionID(t *testing.T) {
+	it := &Interceptor{
+		SecretAppKey: "testSecretAppKey",
+		SessionID: func(r *safehttp.IncomingRequest) (string, bool) {
+			sid, ok := safehttp.FlightValues(r.Context()).Get(sessionKey{}).(string)
+			return sid, ok
+		},
+	}
+	withSession := func(r *safehttp.IncomingRequest, sid string) *safehttp.IncomingRequest {
+		if sid != "" {
+			safehttp.FlightValues(r.Context()).Put(sessionKey{}, sid)
+		}
+		return r
+	}
+
+	req := withSession(safehttptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil), "session1")
+	fakeRW, _ := safehttptest.NewFakeResponseWriter()
+	resp := &safehttp.TemplateResponse{}
+	it.Commit(fakeRW, req, resp, nil)
+	if len(fakeRW.Cookies) != 0 {
+		t.Errorf("len(fakeRW.Cookies): got %d, want 0", len(fakeRW.Cookies))
+	}
+	sessionTok := resp.FuncMap["XSRFToken"].(func() string)()
+	cookieTok := xsrftoken.Generate("testSecretAppKey", testCookieID, "foo.com")
+
+	tests := []struct {
+		name, session, cookie, tok string
+		wantStatus                 safehttp.StatusCode
+	}{
+		{name: "Same session", session: "session1", tok: sessionTok, wantStatus: safehttp.StatusOK},
+		{name: "Other session", session: "session2", tok: sessionTok, wantStatus: safehttp.StatusForbidden},
+		{name: "Logged out", cookie: testCookieID, tok: sessionTok, wantStatus: safehttp.StatusForbidden},
+		{name: "Logged out, cookie token", cookie: testCookieID, tok: cookieTok, wantStatus: safehttp.StatusOK},
+		{name: "Logged in, cookie token", session: "session1", cookie: testCookieID, tok: cookieTok, wantStatus: safehttp.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := safehttptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader(TokenKey+"="+test.tok))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.cookie != "" {
+				req.Header.Set("Cookie", cookieIDKey+"="+test.cookie)
+			}
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			it.Before(fakeRW, withSession(req, test.session), nil)
+
+			if got := rr.Code; got != int(test.wantStatus) {
+				t.Errorf("rr.Code: got %v, want %v", got, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestOnRejected(t *testing.T) {
+	var got []safehttp.StatusCode
+	it := &Interceptor{
+		SecretAppKey: "testSecretAppKey",
+		OnRejected: func(r *safehttp.IncomingRequest, code safehttp.StatusCode) {
+			got = append(got, code)
+		},
+	}
+
+	tok := xsrftoken.Generate("testSecretAppKey", testCookieID, "foo.com")
+	for _, body := range []string{TokenKey + "=" + tok, "", TokenKey + "=invalid"} {
+		req := safehttptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+		fakeRW, _ := safehttptest.NewFakeResponseWriter()
+		it.Before(fakeRW, req, nil)
+	}
+
+	want := []safehttp.StatusCode{safehttp.StatusUnauthorized, safehttp.StatusForbidden}
+	if len(got) != len(want) {
+		t.Fatalf("OnRejected calls: got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("OnRejected call %d: got code %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMultipartLimits(t *testing.T) {
+	tok := xsrftoken.Generate("testSecretAppKey", testCookieID, "go.dev")
+	// Non-file values can exceed the memory limit by at most 10 MB.
+	filler := strings.Repeat("a", 10<<20+1<<10)
+	tests := []struct {
+		name       string
+		it         *Interceptor
+		filler     string
+		header     bool
+		wantStatus safehttp.StatusCode
+	}{
+		{
+			name:       "Default limit",
+			it:         &Interceptor{SecretAppKey: "testSecretAppKey"},
+			filler:     filler,
+			wantStatus: safehttp.StatusOK,
+		},
+		{
+			name:       "Custom limit exceeded",
+			it:         &Interceptor{SecretAppKey: "testSecretAppKey", MultipartMaxMemory: 1 << 10},
+			filler:     filler,
+			wantStatus: safehttp.StatusBadRequest,
+		},
+		{
+			name:       "Multipart disabled",
+			it:         &Interceptor{SecretAppKey: "testSecretAppKey", DisableMultipart: true},
+			wantStatus: safehttp.StatusBadRequest,
+		},
+		{
+			name:       "Multipart disabled, token in header",
+			it:         &Interceptor{SecretAppKey: "testSecretAppKey", DisableMultipart: true, TokenHeaderName: "X-XSRF-Token"},
+			header:     true,
+			wantStatus: safehttp.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := "--123\r\n" +
+				"Content-Disposition: form-data; name=\"filler\"\r\n" +
+				"\r\n" +
+				test.filler + "\r\n" +
+				"--123\r\n" +
+				"Content-Disposition: form-data; name=\"xsrf-token\"\r\n" +
+				"\r\n" +
+				tok + "\r\n" +
+				"--123--\r\n"
+			req := safehttptest.NewRequest(safehttp.MethodPost, "https://go.dev/", strings.NewReader(b))
+			req.Header.Set("Content-Type", `multipart/form-data; boundary="123"`)
+			req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+			if test.header {
+				req.Header.Set("X-XSRF-Token", tok)
+			}
+			fakeRW, rr := safehttptest.NewFakeResponseWriter()
+			test.it.Before(fakeRW, req, nil)
+
+			if got := rr.Code; got != int(test.wantStatus) {
+				t.Errorf("rr.Code: got %v, want %v", got, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestJSONToken(t *testing.T) {
+	tok := xsrftoken.Generate("testSecretAppKey", testCookieID, "foo.com")
+	tests := []struct {
+		name       string
+		it         *Interceptor
+		body       string
+		header     string
+		wantStatus safehttp.StatusCode
+		wantBody   string
+	}{
+		{
+			name:       "Token in JSON field",
+			it:         &Interceptor{SecretAppKey: "testSecretAppKey", JSONTokenField: "xsrf"},
+			body:       `{"xsrf":"` + tok + `","a":"pizza"}`,
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "pizza",
+		},
+		{
+			name:       "Token in header",
+			it:         &Interceptor{SecretAppKey: "testSecretAppKey", JSONTokenField: "xsrf", TokenHeaderName: "X-XSRF-Token"},
+			body:       `{"a":"pizza"}`,
+			header:     tok,
+			wantStatus: safehttp.StatusOK,
+			wantBody:   "pizza",
+		},
+		{
+			name:       "Missing token",
+			it:         &Interceptor{SecretAppKey: "testSecretAppKey", JSONTokenField: "xsrf"},
+			body:       `{"a":"pizza"}`,
+			wantStatus: safehttp.StatusUnauthorized,
+			wantBody:   "Unauthorized\n",
+		},
+		{
+			name:       "Invalid token",
+			it:         &Interceptor{SecretAppKey: "testSecretAppKey", JSONTokenField: "xsrf"},
+			body:       `{"xsrf":"invalid","a":"pizza"}`,
+			wantStatus: safehttp.StatusForbidden,
+			wantBody:   "Forbidden\n",
+		},
+		{
+			name:       "Token not a string",
+			it:         &Interceptor{SecretAppKey: "testSecretAppKey", JSONTokenField: "xsrf"},
+			body:       `{"xsrf":1,"a":"pizza"}`,
+			wantStatus: safehttp.StatusBadRequest,
+			wantBody:   "Bad Request\n",
+		},
+		{
+			name:       "Body too large",
+			it:         &Interceptor{SecretAppKey: "testSecretAppKey", JSONTokenField: "xsrf", JSONMaxBytes: 64},
+			body:       `{"xsrf":"` + tok + `","a":"` + strings.Repeat("pizza", 20) + `"}`,
+			wantStatus: safehttp.StatusBadRequest,
+			wantBody:   "Bad Request\n",
+		},
+		{
+			name:       "JSON disabled",
+			it:         &Interceptor{SecretAppKey: "testSecretAppKey"},
+			body:       `{"xsrf":"` + tok + `","a":"pizza"}`,
+			wantStatus: safehttp.StatusBadRequest,
+			wantBody:   "Bad Request\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mb := safehttp.NewServeMuxConfig(nil)
+			mb.Intercept(test.it)
+			mux := mb.Mux()
+			mux.Handle("/", safehttp.MethodPost, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				var v struct{ A string }
+				if err := json.NewDecoder(r.Body()).Decode(&v); err != nil {
+					return w.WriteError(safehttp.StatusInternalServerError)
+				}
+				return w.Write(safehtml.HTMLEscaped(v.A))
+			}))
+
+			req := httptest.NewRequest(safehttp.MethodPost, "https://foo.com/", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", "application/json; charset=utf-8")
+			req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+			if test.header != "" {
+				req.Header.Set("X-XSRF-Token", test.header)
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if got := rr.Code; got != int(test.wantStatus) {
+				t.Errorf("rr.Code: got %v, want %v", got, test.wantStatus)
+			}
+			if got := rr.Body.String(); got != test.wantBody {
+				t.Errorf("rr.Body: got %q, want %q", got, test.wantBody)
+			}
+		})
+	}
+}
+
+// countingReader counts the bytes read from the wrapped reader.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestJSONTokenReadLimit(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.Intercept(&Interceptor{SecretAppKey: "testSecretAppKey", JSONTokenField: "xsrf", JSONMaxBytes: 64})
+	mux := mb.Mux()
+	mux.Handle("/", safehttp.MethodPost, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehtml.HTMLEscaped("ok"))
+	}))
+
+	body := &countingReader{Reader: strings.NewReader(`{"a":"` + strings.Repeat("a", 1<<20) + `"}`)}
+	req := httptest.NewRequest(safehttp.MethodPost, "https://foo.com/", body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Cookie", cookieIDKey+"="+testCookieID)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if got, want := rr.Code, int(safehttp.StatusBadRequest); got != want {
+		t.Errorf("rr.Code: got %v, want %v", got, want)
+	}
+	if got, want := body.n, 65; got != want {
+		t.Errorf("bytes read from the body: got %d, want %d", got, want)
+	}
+}
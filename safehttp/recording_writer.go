@@ -0,0 +1,108 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// recordingWriter records the status code and the number of body bytes of a
+// response written to the underlying http.ResponseWriter.
+type recordingWriter struct {
+	http.ResponseWriter
+	code  StatusCode
+	bytes int64
+}
+
+// newRecordingWriter wraps rw in a recordingWriter. The returned
+// http.ResponseWriter implements http.Flusher and http.Hijacker only if rw
+// does, so that the dispatcher behaves the same on both.
+func newRecordingWriter(rw http.ResponseWriter) (http.ResponseWriter, *recordingWriter) {
+	w := &recordingWriter{ResponseWriter: rw}
+	_, flusher := rw.(http.Flusher)
+	_, hijacker := rw.(http.Hijacker)
+	switch {
+	case flusher && hijacker:
+		return flushHijackRecorder{w}, w
+	case flusher:
+		return flushRecorder{w}, w
+	case hijacker:
+		return hijackRecorder{w}, w
+	}
+	return w, w
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if w.code == 0 && !informational(code) {
+		w.code = StatusCode(code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// informational reports whether code is the status code of an informational
+// response, which is followed by the final response, e.g. 103 Early Hints.
+func informational(code int) bool {
+	return code >= 100 && code < 200 && code != int(StatusSwitchingProtocols)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *recordingWriter) flush() {
+	if w.code == 0 {
+		w.code = StatusOK
+	}
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *recordingWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil && w.code == 0 {
+		w.code = StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// info returns the ResponseInfo of the recorded response, without Duration.
+func (w *recordingWriter) info() ResponseInfo {
+	code := w.code
+	if code == 0 {
+		// Nothing was written, net/http responds with 200 OK.
+		code = StatusOK
+	}
+	return ResponseInfo{Code: code, BytesWritten: w.bytes}
+}
+
+type flushRecorder struct{ *recordingWriter }
+
+func (w flushRecorder) Flush() { w.flush() }
+
+type hijackRecorder struct{ *recordingWriter }
+
+func (w hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) { return w.hijack() }
+
+type flushHijackRecorder struct{ *recordingWriter }
+
+func (w flushHijackRecorder) Flush() { w.flush() }
+
+func (w flushHijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) { return w.hijack() }
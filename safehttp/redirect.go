@@ -0,0 +1,92 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// RedirectPolicy validates the destinations of redirects to prevent open
+// redirects, i.e. attacker-controlled redirects to other origins. It should
+// be used whenever the destination comes from the request, e.g. from a
+// "next" query parameter.
+//
+// The zero value only allows redirects to paths on the same origin.
+type RedirectPolicy struct {
+	// AllowedHosts are the hosts, e.g. "accounts.example.com", that absolute
+	// http and https redirect URLs are allowed to point to. Hosts are matched
+	// ignoring case and port.
+	AllowedHosts []string
+}
+
+// Check returns an error if location is not a path on the same origin nor an
+// http or https URL pointing to one of the allowed hosts.
+//
+// Locations with backslashes, control characters or leading whitespace are
+// always rejected, as browsers normalize them in ways that can make them
+// point to other origins (e.g. "/\evil.com" or "/\t/evil.com").
+func (p RedirectPolicy) Check(location string) error {
+	if location == "" {
+		return errors.New("empty redirect location")
+	}
+	if strings.Contains(location, `\`) || strings.HasPrefix(location, " ") || strings.IndexFunc(location, isControl) >= 0 {
+		return fmt.Errorf("redirect location %q contains disallowed characters", location)
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return fmt.Errorf("invalid redirect location %q: %v", location, err)
+	}
+	if u.Scheme == "" && u.Host == "" && !strings.HasPrefix(location, "//") {
+		return nil
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("redirect location %q has disallowed scheme %q", location, u.Scheme)
+	}
+	if u.User != nil {
+		return fmt.Errorf("redirect location %q has user information", location)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, h := range p.AllowedHosts {
+		if strings.ToLower(h) == host {
+			return nil
+		}
+	}
+	return fmt.Errorf("redirect location %q points to disallowed host %q", location, host)
+}
+
+// Redirect writes a redirect to location, like the package-level Redirect,
+// if it's allowed by the policy. Otherwise, it writes a 400 Bad Request error.
+// If the given code is not a valid Redirect code this function will panic.
+func (p RedirectPolicy) Redirect(w ResponseWriter, r *IncomingRequest, location string, code StatusCode) Result {
+	if err := p.Check(location); err != nil {
+		return w.WriteError(StatusBadRequest)
+	}
+	return Redirect(w, r, location, code)
+}
+
+// LocalRedirect writes a redirect to location if it's a path on the same
+// origin. Otherwise, it writes a 400 Bad Request error. It is equivalent to
+// calling Redirect on the zero RedirectPolicy.
+func LocalRedirect(w ResponseWriter, r *IncomingRequest, location string, code StatusCode) Result {
+	return RedirectPolicy{}.Redirect(w, r, location, code)
+}
+
+// isControl reports whether r is an ASCII control character.
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
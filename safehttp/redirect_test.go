@@ -0,0 +1,102 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+func TestRedirectPolicyCheck(t *testing.T) {
+	p := safehttp.RedirectPolicy{AllowedHosts: []string{"accounts.example.com"}}
+	tests := []struct {
+		location string
+		wantErr  bool
+	}{
+		{location: "/notes/"},
+		{location: "/notes?id=1#top"},
+		{location: "notes/1"},
+		{location: "?page=2"},
+		{location: "https://accounts.example.com/login"},
+		{location: "http://ACCOUNTS.example.com:8080/login"},
+		{location: "", wantErr: true},
+		{location: "//evil.com", wantErr: true},
+		{location: "///evil.com", wantErr: true},
+		{location: `/\evil.com`, wantErr: true},
+		{location: "/\t/evil.com", wantErr: true},
+		{location: " //evil.com", wantErr: true},
+		{location: "https://evil.com", wantErr: true},
+		{location: "https://accounts.example.com.evil.com", wantErr: true},
+		{location: "https://accounts.example.com@evil.com", wantErr: true},
+		{location: "https://user@accounts.example.com", wantErr: true},
+		{location: "javascript:alert(1)", wantErr: true},
+		{location: "JavaScript://accounts.example.com/%0aalert(1)", wantErr: true},
+		{location: "data:text/html,<script>alert(1)</script>", wantErr: true},
+	}
+	for _, tt := range tests {
+		err := p.Check(tt.location)
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("p.Check(%q): got error %v, want error %v", tt.location, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLocalRedirect(t *testing.T) {
+	tests := []struct {
+		name         string
+		location     string
+		wantCode     safehttp.StatusCode
+		wantLocation string
+	}{
+		{
+			name:         "Path",
+			location:     "/notes/",
+			wantCode:     safehttp.StatusSeeOther,
+			wantLocation: "/notes/",
+		},
+		{
+			name:     "Other origin",
+			location: "https://evil.com",
+			wantCode: safehttp.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := safehttp.NewServeMuxConfig(nil).Mux()
+			m.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				q, err := r.URL().Query()
+				if err != nil {
+					return w.WriteError(safehttp.StatusBadRequest)
+				}
+				return safehttp.LocalRedirect(w, r, q.String("next", ""), safehttp.StatusSeeOther)
+			}))
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil)
+			q := req.URL.Query()
+			q.Set("next", tt.location)
+			req.URL.RawQuery = q.Encode()
+			m.ServeHTTP(rr, req)
+
+			if got := rr.Code; got != int(tt.wantCode) {
+				t.Errorf("rr.Code: got %v, want %v", got, tt.wantCode)
+			}
+			if got := rr.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location: got %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
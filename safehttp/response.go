@@ -17,6 +17,9 @@ package safehttp
 import (
 	"fmt"
 	"io"
+	"time"
+
+	"github.com/google/safehtml"
 )
 
 // Response should encapsulate the data passed to the ResponseWriter to be
@@ -110,6 +113,114 @@ func ExecuteNamedTemplateWithFuncs(w ResponseWriter, t Template, name string, da
 	return w.Write(&TemplateResponse{Template: t, Name: name, Data: data, FuncMap: fm})
 }
 
+// StreamWriter writes the body of a StreamResponse.
+type StreamWriter interface {
+	// Write writes a chunk of the body. The data may be buffered until Flush
+	// is called.
+	Write(p []byte) (int, error)
+	// Flush sends the data written so far to the client. It returns an error
+	// if the underlying connection doesn't support flushing.
+	Flush() error
+	// SetTrailer sets the value of a trailer declared in
+	// StreamResponse.Trailers. Trailers are only sent once the body is
+	// complete, i.e. if the Stream function returns without an error. It
+	// returns an error if the trailer wasn't declared.
+	SetTrailer(name, value string) error
+}
+
+// StreamResponse is used to write a response body in chunks, flushing them to
+// the client as they are produced, e.g. for long downloads. As with any other
+// response, the Commit phases of the interceptors run before the first byte
+// is written.
+type StreamResponse struct {
+	// ContentType is the Content-Type of the response. If empty,
+	// "application/octet-stream" is used. The Dispatcher is responsible for
+	// rejecting content types that could be rendered as active content, use
+	// HTMLStreamResponse to stream HTML.
+	ContentType string
+	// Stream is called by the Dispatcher to write the body.
+	Stream func(w StreamWriter) error
+	// Trailers are the names of the trailers that can be set with
+	// StreamWriter.SetTrailer, e.g. a checksum of the body. They are announced
+	// in the Trailer header before the body is written. Hop-by-hop headers and
+	// headers used for framing, routing, authentication or cookies can't be
+	// sent as trailers.
+	Trailers []string
+}
+
+// WriteStream creates a StreamResponse and writes it to w.
+func WriteStream(w ResponseWriter, contentType string, stream func(StreamWriter) error) Result {
+	return w.Write(StreamResponse{ContentType: contentType, Stream: stream})
+}
+
+// HTMLStreamWriter writes the body of an HTMLStreamResponse.
+type HTMLStreamWriter interface {
+	// WriteHTML writes a chunk of the body. The data may be buffered until
+	// Flush is called.
+	WriteHTML(h safehtml.HTML) error
+	// Flush sends the data written so far to the client. It returns an error
+	// if the underlying connection doesn't support flushing.
+	Flush() error
+}
+
+// HTMLStreamResponse is used to write an HTML response in chunks, flushing
+// them to the client as they are produced, e.g. for progressive rendering.
+// As with any other response, the Commit phases of the interceptors run before
+// the first byte is written.
+type HTMLStreamResponse struct {
+	// Stream is called by the Dispatcher to write the body.
+	Stream func(w HTMLStreamWriter) error
+}
+
+// WriteHTMLStream creates an HTMLStreamResponse and writes it to w.
+func WriteHTMLStream(w ResponseWriter, stream func(HTMLStreamWriter) error) Result {
+	return w.Write(HTMLStreamResponse{Stream: stream})
+}
+
+// Event is a Server-Sent Event, as specified by
+// https://html.spec.whatwg.org/multipage/server-sent-events.html.
+type Event struct {
+	// ID is the optional event ID, which browsers send back in the
+	// Last-Event-ID header when reconnecting. It must not contain newlines or
+	// NUL characters.
+	ID string
+	// Type is the optional event type. If empty, browsers dispatch the event as
+	// a "message" event. It must not contain newlines.
+	Type string
+	// Data is the event data. It can contain newlines, which are encoded as
+	// multiple data fields so that they can't be used to inject other fields
+	// or events.
+	Data string
+	// Retry, if positive, sets the time browsers wait before reconnecting when
+	// the connection is lost.
+	Retry time.Duration
+}
+
+// EventStreamResponse is used to write a text/event-stream response, sending
+// Server-Sent Events to the client as they are produced. As with any other
+// response, the Commit phases of the interceptors run before the first event
+// is written.
+//
+// The response ends when the Events channel is closed or when the context of
+// the Request is done, e.g. because the client disconnected. Timeouts set with
+// WithTimeout also apply, so they should be disabled for long-lived streams.
+type EventStreamResponse struct {
+	// Events are the events to send to the client.
+	Events <-chan Event
+	// Heartbeat, if positive, is the interval at which comments are sent on
+	// the stream when there are no events, to keep the connection open and
+	// detect disconnected clients.
+	Heartbeat time.Duration
+	// Request is the matching request for which this response is being
+	// written. Its context is used to detect when the client disconnects.
+	Request *IncomingRequest
+}
+
+// WriteEventStream creates an EventStreamResponse and writes it to w.
+func WriteEventStream(w ResponseWriter, r *IncomingRequest, events <-chan Event, heartbeat time.Duration) Result {
+	return w.Write(EventStreamResponse{Events: events, Heartbeat: heartbeat, Request: r})
+}
+
 // NoContentResponse is used to write a "No Content" response.
 type NoContentResponse struct{}
 
@@ -126,6 +237,9 @@ type RedirectResponse struct {
 
 // Redirect creates a RedirectResponse and writes it to w.
 // If the given code is not a valid Redirect code this function will panic.
+//
+// The location is not validated, so it must not be controlled by the client.
+// Use LocalRedirect or RedirectPolicy otherwise.
 func Redirect(w ResponseWriter, r *IncomingRequest, location string, code StatusCode) Result {
 	if code < 300 || code >= 400 {
 		panic(fmt.Sprintf("wrong method called: redirect with status %d", code))
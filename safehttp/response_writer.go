@@ -30,6 +30,15 @@ type ResponseWriter interface {
 	//
 	// If the ResponseWriter has already been written to, then this method panics.
 	WriteError(resp ErrorResponse) Result
+
+	// WriteEarlyHints sends a 103 Early Hints informational response with
+	// Link headers for the given links, before the response is written. The
+	// Link headers are also added to the response. It can be called multiple
+	// times.
+	//
+	// An error is returned if a link is invalid, if the Link header has been
+	// claimed or if the ResponseWriter has already been written to.
+	WriteEarlyHints(links ...Link) error
 }
 
 // ResponseHeadersWriter is used to alter the HTTP response headers.
@@ -42,7 +51,7 @@ type ResponseHeadersWriter interface {
 	Header() Header
 
 	// AddCookie adds a Set-Cookie header to the provided ResponseWriter's headers.
-	// The provided cookie must have a valid Name, otherwise an error will be
-	// returned.
+	// The provided cookie must have a valid Name and attributes consistent
+	// with its prefix, otherwise an error will be returned.
 	AddCookie(c *Cookie) error
 }
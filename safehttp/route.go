@@ -0,0 +1,227 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// route describes the registered pattern a request has been routed to.
+type route struct {
+	pattern string
+	params  map[string]string
+}
+
+// paramRoute is a registered pattern containing path parameters, e.g.
+// "/users/{id}/posts/{postID}".
+type paramRoute struct {
+	host string
+	// segments are the slash-separated segments of the path, without the
+	// leading slash. Parameters are stored as "{name}".
+	segments []string
+	handler  *registeredHandler
+}
+
+// isParamPattern reports whether the pattern contains path parameters.
+func isParamPattern(pattern string) bool {
+	return strings.Contains(pattern, "{")
+}
+
+// parseParamPattern parses a pattern containing path parameters. It panics if
+// the pattern is malformed.
+func parseParamPattern(pattern string) *paramRoute {
+	i := strings.Index(pattern, "/")
+	if i < 0 {
+		panic(fmt.Sprintf("invalid pattern %q: missing path", pattern))
+	}
+	pr := &paramRoute{host: pattern[:i], segments: strings.Split(pattern[i+1:], "/")}
+	if strings.Contains(pr.host, "{") {
+		panic(fmt.Sprintf("invalid pattern %q: parameters are not allowed in the host", pattern))
+	}
+	names := map[string]bool{}
+	for _, s := range pr.segments {
+		if !strings.ContainsAny(s, "{}") {
+			continue
+		}
+		name, ok := paramName(s)
+		if !ok {
+			panic(fmt.Sprintf("invalid pattern %q: parameters must span a whole path segment and be named with letters, digits and underscores, got %q", pattern, s))
+		}
+		if names[name] {
+			panic(fmt.Sprintf("invalid pattern %q: duplicate parameter %q", pattern, name))
+		}
+		names[name] = true
+	}
+	return pr
+}
+
+// paramName returns the name of the parameter in segment, if segment is of the
+// form "{name}".
+func paramName(segment string) (string, bool) {
+	if len(segment) < 3 || segment[0] != '{' || segment[len(segment)-1] != '}' {
+		return "", false
+	}
+	name := segment[1 : len(segment)-1]
+	for i, c := range name {
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// less reports whether pr takes precedence over other. Host-specific patterns
+// take precedence over general ones and, at the first segment where the two
+// patterns differ, a fixed segment takes precedence over a parameter.
+func (pr *paramRoute) less(other *paramRoute) bool {
+	if (pr.host == "") != (other.host == "") {
+		return pr.host != ""
+	}
+	if len(pr.segments) != len(other.segments) {
+		return len(pr.segments) > len(other.segments)
+	}
+	for i, s := range pr.segments {
+		_, param := paramName(s)
+		_, otherParam := paramName(other.segments[i])
+		if param != otherParam {
+			return !param
+		}
+	}
+	return false
+}
+
+// conflicts reports whether pr and other match exactly the same paths.
+func (pr *paramRoute) conflicts(other *paramRoute) bool {
+	if pr.host != other.host || len(pr.segments) != len(other.segments) {
+		return false
+	}
+	for i, s := range pr.segments {
+		_, param := paramName(s)
+		_, otherParam := paramName(other.segments[i])
+		if param != otherParam || (!param && s != other.segments[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// match returns the parameters of the request if its host and escaped path
+// segments match the route.
+func (pr *paramRoute) match(host string, segments []string) (map[string]string, bool) {
+	if (pr.host != "" && pr.host != host) || len(segments) != len(pr.segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, s := range pr.segments {
+		v, err := url.PathUnescape(segments[i])
+		if err != nil {
+			return nil, false
+		}
+		if name, ok := paramName(s); ok {
+			if v == "" {
+				return nil, false
+			}
+			params[name] = v
+			continue
+		}
+		if s != v {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// cleanPath returns the canonical path for p, eliminating . and .. elements,
+// the same way net/http.ServeMux does.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	np := path.Clean(p)
+	if p[len(p)-1] == '/' && np != "/" {
+		np += "/"
+	}
+	return np
+}
+
+// stripHostPort returns h without any trailing ":<port>".
+func stripHostPort(h string) string {
+	if !strings.Contains(h, ":") {
+		return h
+	}
+	host, _, err := net.SplitHostPort(h)
+	if err != nil {
+		return h
+	}
+	return host
+}
+
+// matchParamRoute returns the handler of the pattern with path parameters
+// matching the request, if any, along with the values of the parameters.
+//
+// Requests with unclean paths and requests matching a fixed pattern exactly
+// are left to the underlying http.ServeMux.
+func (m *ServeMux) matchParamRoute(r *http.Request) (*paramRoute, map[string]string) {
+	if len(m.paramRoutes) == 0 || r.Method == MethodConnect {
+		return nil, nil
+	}
+	if cleanPath(r.URL.Path) != r.URL.Path {
+		return nil, nil
+	}
+	host := stripHostPort(r.Host)
+	if _, pattern := m.mux.Handler(r); pattern == r.URL.Path || pattern == host+r.URL.Path {
+		return nil, nil
+	}
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	for _, pr := range m.paramRoutes {
+		if params, ok := pr.match(host, segments); ok {
+			return pr, params
+		}
+	}
+	return nil, nil
+}
+
+// addParamRoute registers a pattern containing path parameters.
+func (m *ServeMux) addParamRoute(pr *paramRoute) {
+	i := 0
+	for ; i < len(m.paramRoutes); i++ {
+		other := m.paramRoutes[i]
+		if pr.conflicts(other) {
+			panic(fmt.Sprintf("pattern %q conflicts with pattern %q", pr.handler.pattern, other.handler.pattern))
+		}
+		if pr.less(other) {
+			break
+		}
+	}
+	for _, other := range m.paramRoutes[i:] {
+		if pr.conflicts(other) {
+			panic(fmt.Sprintf("pattern %q conflicts with pattern %q", pr.handler.pattern, other.handler.pattern))
+		}
+	}
+	m.paramRoutes = append(m.paramRoutes, nil)
+	copy(m.paramRoutes[i+1:], m.paramRoutes[i:])
+	m.paramRoutes[i] = pr
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttptest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// RecordedResponse is the response produced by RunInterceptor.
+type RecordedResponse struct {
+	// Code is the status code of the response.
+	Code int
+	// Header contains the headers of the response, except Set-Cookie.
+	Header http.Header
+	// Cookies contains the cookies added to the response.
+	Cookies []*safehttp.Cookie
+	// Body is the body of the response.
+	Body string
+}
+
+// RunInterceptor drives the given interceptor through the whole lifecycle of
+// the given request, as a safehttp.ServeMux would: its Before phase runs first
+// and, unless it writes a response, a handler writing resp is called (a 204 No
+// Content is written instead if resp is nil). The Commit phase runs before the
+// response is written using safehttp.DefaultDispatcher.
+//
+// An error is returned if writing the response fails or if the interceptor
+// writes more than one response.
+func RunInterceptor(it safehttp.Interceptor, r *safehttp.IncomingRequest, resp safehttp.Response) (RecordedResponse, error) {
+	rec := httptest.NewRecorder()
+	w := &harnessWriter{
+		it:      it,
+		req:     r,
+		rec:     rec,
+		headers: safehttp.NewHeader(rec.Header()),
+	}
+
+	it.Before(w, r, nil)
+	if !w.written {
+		if resp == nil {
+			resp = safehttp.NoContentResponse{}
+		}
+		w.Write(resp)
+	}
+	if w.err != nil {
+		return RecordedResponse{}, w.err
+	}
+
+	h := rec.Header().Clone()
+	h.Del("Set-Cookie")
+	return RecordedResponse{
+		Code:    rec.Code,
+		Header:  h,
+		Cookies: w.cookies,
+		Body:    rec.Body.String(),
+	}, nil
+}
+
+// harnessWriter is the safehttp.ResponseWriter used by RunInterceptor.
+type harnessWriter struct {
+	it      safehttp.Interceptor
+	req     *safehttp.IncomingRequest
+	rec     *httptest.ResponseRecorder
+	headers safehttp.Header
+	cookies []*safehttp.Cookie
+
+	written bool
+	err     error
+}
+
+var _ safehttp.ResponseWriter = (*harnessWriter)(nil)
+
+func (w *harnessWriter) Header() safehttp.Header {
+	return w.headers
+}
+
+func (w *harnessWriter) AddCookie(c *safehttp.Cookie) error {
+	if c.String() == "" {
+		return errors.New("invalid cookie name")
+	}
+	w.cookies = append(w.cookies, c)
+	return nil
+}
+
+func (w *harnessWriter) Write(resp safehttp.Response) safehttp.Result {
+	if !w.startWrite() {
+		return safehttp.Result{}
+	}
+	w.it.Commit(w, w.req, resp, nil)
+	if err := (safehttp.DefaultDispatcher{}).Write(w.rec, resp); err != nil {
+		w.err = err
+	}
+	return safehttp.Result{}
+}
+
+func (w *harnessWriter) WriteError(resp safehttp.ErrorResponse) safehttp.Result {
+	if !w.startWrite() {
+		return safehttp.Result{}
+	}
+	w.it.Commit(w, w.req, resp, nil)
+	if err := (safehttp.DefaultDispatcher{}).Error(w.rec, resp); err != nil {
+		w.err = err
+	}
+	return safehttp.Result{}
+}
+
+// WriteEarlyHints is a no-op, early hints are not recorded.
+func (w *harnessWriter) WriteEarlyHints(links ...safehttp.Link) error {
+	if w.written {
+		return errors.New("ResponseWriter was already written to")
+	}
+	return nil
+}
+
+// startWrite marks the response as written. It reports whether this is the
+// first write, recording an error otherwise.
+func (w *harnessWriter) startWrite() bool {
+	if w.written {
+		w.err = errors.New("ResponseWriter was already written to")
+		return false
+	}
+	w.written = true
+	return true
+}
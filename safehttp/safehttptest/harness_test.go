@@ -0,0 +1,134 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttptest_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/safehttptest"
+	"github.com/google/safehtml"
+)
+
+type testInterceptor struct {
+	reject  bool
+	twice   bool
+	commits *int
+}
+
+func (it testInterceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, _ safehttp.InterceptorConfig) safehttp.Result {
+	w.Header().Set("Before", "foo")
+	if it.reject {
+		return w.WriteError(safehttp.StatusForbidden)
+	}
+	return safehttp.NotWritten()
+}
+
+func (it testInterceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, _ safehttp.InterceptorConfig) {
+	*it.commits++
+	w.Header().Set("Commit", "bar")
+	w.AddCookie(safehttp.NewCookie("name", "value"))
+	if it.twice {
+		w.(safehttp.ResponseWriter).Write(resp)
+	}
+}
+
+func (testInterceptor) Match(safehttp.InterceptorConfig) bool {
+	return false
+}
+
+func TestRunInterceptor(t *testing.T) {
+	tests := []struct {
+		name       string
+		reject     bool
+		resp       safehttp.Response
+		wantCode   int
+		wantHeader http.Header
+		wantBody   string
+	}{
+		{
+			name:     "Response",
+			resp:     safehtml.HTMLEscaped("<p>"),
+			wantCode: http.StatusOK,
+			wantHeader: http.Header{
+				"Before":       {"foo"},
+				"Commit":       {"bar"},
+				"Content-Type": {"text/html; charset=utf-8"},
+			},
+			wantBody: "&lt;p&gt;",
+		},
+		{
+			name:     "No response",
+			wantCode: http.StatusNoContent,
+			wantHeader: http.Header{
+				"Before": {"foo"},
+				"Commit": {"bar"},
+			},
+		},
+		{
+			name:     "Rejected in Before",
+			reject:   true,
+			resp:     safehtml.HTMLEscaped("<p>"),
+			wantCode: http.StatusForbidden,
+			wantHeader: http.Header{
+				"Before":                 {"foo"},
+				"Commit":                 {"bar"},
+				"Content-Type":           {"text/plain; charset=utf-8"},
+				"X-Content-Type-Options": {"nosniff"},
+			},
+			wantBody: "Forbidden\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var commits int
+			it := testInterceptor{reject: tt.reject, commits: &commits}
+			req := safehttptest.NewRequest(safehttp.MethodGet, "/", nil)
+
+			got, err := safehttptest.RunInterceptor(it, req, tt.resp)
+			if err != nil {
+				t.Fatalf("safehttptest.RunInterceptor() got err: %v", err)
+			}
+
+			if got.Code != tt.wantCode {
+				t.Errorf("got.Code: got %v, want %v", got.Code, tt.wantCode)
+			}
+			if diff := cmp.Diff(tt.wantHeader, got.Header); diff != "" {
+				t.Errorf("got.Header mismatch (-want +got):\n%s", diff)
+			}
+			if got.Body != tt.wantBody {
+				t.Errorf("got.Body: got %q, want %q", got.Body, tt.wantBody)
+			}
+			if len(got.Cookies) != 1 || got.Cookies[0].Name() != "name" {
+				t.Errorf("got.Cookies: got %v, want a single cookie named %q", got.Cookies, "name")
+			}
+			if commits != 1 {
+				t.Errorf("Commit calls: got %d, want 1", commits)
+			}
+		})
+	}
+}
+
+func TestRunInterceptorDoubleWrite(t *testing.T) {
+	var commits int
+	it := testInterceptor{twice: true, commits: &commits}
+	req := safehttptest.NewRequest(safehttp.MethodGet, "/", nil)
+
+	if _, err := safehttptest.RunInterceptor(it, req, safehtml.HTMLEscaped("foo")); err == nil {
+		t.Error("safehttptest.RunInterceptor() got nil err, want error")
+	}
+}
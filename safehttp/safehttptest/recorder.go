@@ -38,6 +38,9 @@ type FakeResponseWriter struct {
 
 	// Response headers.
 	Headers safehttp.Header
+
+	// EarlyHints are the links passed to WriteEarlyHints() calls.
+	EarlyHints []safehttp.Link
 }
 
 // FakeDispatcher provides a minimal implementation of the Dispatcher to be used for testing Interceptors.
@@ -104,6 +107,12 @@ func (frw *FakeResponseWriter) NoContent() safehttp.Result {
 	return safehttp.Result{}
 }
 
+// WriteEarlyHints appends the given links to the EarlyHints field.
+func (frw *FakeResponseWriter) WriteEarlyHints(links ...safehttp.Link) error {
+	frw.EarlyHints = append(frw.EarlyHints, links...)
+	return nil
+}
+
 // WriteError forwards the error response to Dispatcher.WriteError.
 func (frw *FakeResponseWriter) WriteError(resp safehttp.ErrorResponse) safehttp.Result {
 	if err := frw.Dispatcher.Error(frw.ResponseWriter, resp); err != nil {
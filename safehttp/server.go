@@ -18,9 +18,16 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // Server is a safe wrapper for a standard HTTP server.
@@ -36,8 +43,10 @@ type Server struct {
 	// Mux is the ServeMux to use for the current server. A nil Mux is invalid.
 	Mux *ServeMux
 
-	// TODO(empijei): potentially consider exposing ReadHeaderTimeout for
-	// fine-grained handling (e.g. websocket endpoints).
+	// ReadHeaderTimeout is the amount of time allowed to read
+	// request headers. It protects against slow clients even when
+	// ReadTimeout is raised, e.g. for uploads.
+	ReadHeaderTimeout time.Duration
 
 	// ReadTimeout is the maximum duration for reading the entire
 	// request, including the body.
@@ -63,10 +72,20 @@ type Server struct {
 	// cloned on serving, so it's not possible to modify the
 	// configuration with methods like tls.Config.SetSessionTicketKeys.
 	//
-	// When the server is started the cloned configuration will be changed
-	// to set the minimum TLS version to 1.2 and to prefer Server Ciphers.
+	// When the server is started the settings of TLSProfile are applied to
+	// the fields of the cloned configuration that haven't been set. The
+	// server refuses to start if the configuration allows TLS versions or
+	// cipher suites that TLSProfile doesn't, unless AllowWeakTLSConfig is set.
 	TLSConfig *tls.Config
 
+	// TLSProfile is the preset TLS configuration of the server. The zero value
+	// is TLSIntermediate.
+	TLSProfile TLSProfile
+
+	// AllowWeakTLSConfig allows starting the server with a TLSConfig that is
+	// weaker than TLSProfile, e.g. to support legacy clients.
+	AllowWeakTLSConfig bool
+
 	// OnShutdown is a slice of functions to call on Shutdown.
 	// This can be used to gracefully shutdown connections that have undergone
 	// ALPN protocol upgrade or that have been hijacked.
@@ -77,8 +96,37 @@ type Server struct {
 	// DisableKeepAlives controls whether HTTP keep-alives should be disabled.
 	DisableKeepAlives bool
 
+	// ACME, if set, makes the server obtain and renew its TLS certificates
+	// with the ACME protocol. ListenAndServeTLS must then be called with
+	// empty certFile and keyFile.
+	ACME *ACMEConfig
+
+	// RedirectAddr, if set, is the TCP address of a plain HTTP server started
+	// by ListenAndServeTLS next to the TLS one, e.g. ":http". It doesn't serve
+	// the Mux: GET and HEAD requests are redirected to HTTPS and the other
+	// ones are rejected. If ACME is set, it also answers HTTP-01 challenges.
+	//
+	// When it's set, the hsts plugin must be installed on the Mux, so that
+	// browsers keep using HTTPS once redirected. Otherwise, ListenAndServeTLS
+	// returns an error.
+	RedirectAddr string
+
+	// HTTP2 optionally configures HTTP/2. If nil, the HTTP/2 defaults of the
+	// net/http package apply to TLS connections.
+	HTTP2 *HTTP2Config
+
+	// DrainDelay is the time Shutdown waits, after Mux.Draining starts
+	// reporting true, before it stops accepting connections. It gives load
+	// balancers polling a readiness check based on Mux.Draining the time to
+	// stop routing new requests to the server.
+	DrainDelay time.Duration
+
 	srv     *http.Server
 	started bool
+	// redirectSrv is the plain HTTP server started for RedirectAddr.
+	redirectSrv *http.Server
+	// systemd is set if the server was started with ServeSystemd.
+	systemd bool
 }
 
 func (s *Server) buildStd() error {
@@ -94,12 +142,16 @@ func (s *Server) buildStd() error {
 	}
 
 	srv := &http.Server{
-		Addr:           s.Addr,
-		Handler:        s.Mux,
-		ReadTimeout:    5 * time.Second,
-		WriteTimeout:   5 * time.Second,
-		IdleTimeout:    120 * time.Second,
-		MaxHeaderBytes: 10 * 1024,
+		Addr:              s.Addr,
+		Handler:           s.Mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       5 * time.Second,
+		WriteTimeout:      5 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    10 * 1024,
+	}
+	if s.ReadHeaderTimeout != 0 {
+		srv.ReadHeaderTimeout = s.ReadHeaderTimeout
 	}
 	if s.ReadTimeout != 0 {
 		srv.ReadTimeout = s.ReadTimeout
@@ -113,11 +165,42 @@ func (s *Server) buildStd() error {
 	if s.MaxHeaderBytes != 0 {
 		srv.MaxHeaderBytes = s.MaxHeaderBytes
 	}
-	if s.TLSConfig != nil {
-		cfg := s.TLSConfig.Clone()
-		cfg.MinVersion = tls.VersionTLS12
-		cfg.PreferServerCipherSuites = true
-		srv.TLSConfig = cfg
+	cfg, err := s.TLSProfile.config(s.TLSConfig, s.AllowWeakTLSConfig)
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = cfg
+	var m *autocert.Manager
+	if s.ACME != nil {
+		if m, err = s.ACME.manager(); err != nil {
+			return err
+		}
+		if err := configureACME(cfg, m); err != nil {
+			return err
+		}
+	}
+	if s.RedirectAddr != "" {
+		if !s.Mux.setsHSTS() {
+			return errors.New("RedirectAddr requires the hsts plugin to be installed on the Mux")
+		}
+		var h http.Handler = httpsRedirectHandler{port: tlsPort(s.Addr)}
+		if m != nil {
+			h = m.HTTPHandler(h)
+		}
+		s.redirectSrv = &http.Server{
+			Addr:              s.RedirectAddr,
+			Handler:           h,
+			ReadHeaderTimeout: srv.ReadHeaderTimeout,
+			ReadTimeout:       srv.ReadTimeout,
+			WriteTimeout:      srv.WriteTimeout,
+			IdleTimeout:       srv.IdleTimeout,
+			MaxHeaderBytes:    srv.MaxHeaderBytes,
+		}
+	}
+	if s.HTTP2 != nil {
+		if err := s.HTTP2.configure(srv); err != nil {
+			return err
+		}
 	}
 	for _, f := range s.OnShudown {
 		srv.RegisterOnShutdown(f)
@@ -135,6 +218,16 @@ func (s *Server) Clone() *Server {
 	cln.started = false
 	cln.TLSConfig = s.TLSConfig.Clone()
 	cln.srv = nil
+	cln.redirectSrv = nil
+	cln.systemd = false
+	if s.ACME != nil {
+		acme := *s.ACME
+		cln.ACME = &acme
+	}
+	if s.HTTP2 != nil {
+		h2 := *s.HTTP2
+		cln.HTTP2 = &h2
+	}
 	return &cln
 }
 
@@ -152,11 +245,27 @@ func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
 	if err := s.buildStd(); err != nil {
 		return err
 	}
+	if s.HTTP2 != nil && s.HTTP2.H2C {
+		return errors.New("h2c can't be used with TLS")
+	}
+	if s.ACME != nil && (certFile != "" || keyFile != "") {
+		return errors.New("certFile and keyFile must be empty when ACME is enabled")
+	}
+	if s.redirectSrv != nil {
+		l, err := net.Listen("tcp", s.redirectSrv.Addr)
+		if err != nil {
+			return err
+		}
+		go s.redirectSrv.Serve(l)
+	}
 	s.started = true
 	return s.srv.ListenAndServeTLS(certFile, keyFile)
 }
 
 // Serve is a wrapper for https://golang.org/pkg/net/http/#Server.Serve
+//
+// It can be used to serve on any net.Listener, e.g. one provided by a sidecar
+// or a wrapping listener, with the same safe defaults as ListenAndServe.
 func (s *Server) Serve(l net.Listener) error {
 	if err := s.buildStd(); err != nil {
 		return err
@@ -170,17 +279,85 @@ func (s *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
 	if err := s.buildStd(); err != nil {
 		return err
 	}
+	if s.HTTP2 != nil && s.HTTP2.H2C {
+		return errors.New("h2c can't be used with TLS")
+	}
 	s.started = true
 	return s.srv.ServeTLS(l, certFile, keyFile)
 }
 
-// Shutdown is a wrapper for https://golang.org/pkg/net/http/#Server.Shutdown
+// ListenAndServeUnix listens on the Unix domain socket at path and then calls
+// Serve to handle requests on incoming connections. It's meant for servers
+// behind a reverse proxy or a sidecar running on the same host.
+//
+// The socket file is created with the given permissions, or 0600 if mode is
+// zero, and removed when the server is closed. A stale socket file at path is
+// removed, but ListenAndServeUnix fails if path is any other kind of file.
+func (s *Server) ListenAndServeUnix(path string, mode os.FileMode) error {
+	if err := s.buildStd(); err != nil {
+		return err
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%q exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if mode == 0 {
+		mode = 0600
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return err
+	}
+	s.started = true
+	return s.srv.Serve(l)
+}
+
+// Shutdown gracefully shuts down the server, see
+// https://golang.org/pkg/net/http/#Server.Shutdown.
+//
+// Shutdown drains the server in stages: Mux.Draining starts reporting true and,
+// if DrainDelay is set, Shutdown waits for it; then the server stops accepting
+// connections and Shutdown waits for all the requests being served by the Mux
+// to finish, including the ones on hijacked connections, such as WebSockets.
+// Finally, the Shutdown method of every installed interceptor implementing
+// Shutdowner is called. If ctx expires before the requests have been served,
+// the context's error is returned, but the interceptors are still called.
 func (s *Server) Shutdown(ctx context.Context) error {
 	if !s.started {
 		return errors.New("shutting down unstarted server")
 	}
+	atomic.StoreInt32(&s.Mux.draining, 1)
+	if s.systemd {
+		NotifySystemd("STOPPING=1")
+	}
+	if s.DrainDelay > 0 {
+		t := time.NewTimer(s.DrainDelay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+		case <-t.C:
+		}
+	}
 	s.srv.SetKeepAlivesEnabled(false)
-	return s.srv.Shutdown(ctx)
+	if s.redirectSrv != nil {
+		defer s.redirectSrv.Close()
+	}
+	err := s.srv.Shutdown(ctx)
+	if werr := s.Mux.wait(ctx); err == nil {
+		err = werr
+	}
+	if serr := s.Mux.shutdownInterceptors(ctx); err == nil {
+		err = serr
+	}
+	return err
 }
 
 // Close is a wrapper for https://golang.org/pkg/net/http/#Server.Close
@@ -188,5 +365,59 @@ func (s *Server) Close() error {
 	if !s.started {
 		return errors.New("closing unstarted server")
 	}
+	if s.redirectSrv != nil {
+		s.redirectSrv.Close()
+	}
 	return s.srv.Close()
 }
+
+// tlsPort returns the port of addr, or an empty string if it's the default
+// HTTPS port.
+func tlsPort(addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "443" || port == "https" {
+		return ""
+	}
+	return port
+}
+
+// httpsRedirectHandler redirects GET and HEAD requests to the same URL over
+// HTTPS, on the given port, and rejects the other requests.
+type httpsRedirectHandler struct {
+	port string
+}
+
+func (h httpsRedirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if (r.Method != MethodGet && r.Method != MethodHead) || r.Host == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = strings.Trim(r.Host, "[]")
+	}
+	if h.port != "" {
+		host = net.JoinHostPort(host, h.port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	u := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+	http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+}
+
+// hstsSetter is implemented by interceptors setting the
+// Strict-Transport-Security header, like the hsts plugin.
+type hstsSetter interface {
+	StrictTransportSecurity() string
+}
+
+// setsHSTS reports whether an interceptor setting the Strict-Transport-Security
+// header is installed on m.
+func (m *ServeMux) setsHSTS() bool {
+	for _, it := range m.interceptors {
+		if _, ok := it.(hstsSetter); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -17,8 +17,14 @@ package safehttp
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/http/httputil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -72,4 +78,305 @@ func TestServer(t *testing.T) {
 	if s.srv.WriteTimeout != 5*time.Second {
 		t.Errorf("Builder did not set WriteTimeout: got %v want %v", s.srv.WriteTimeout, 5*time.Second)
 	}
+	if s.srv.ReadHeaderTimeout != 5*time.Second {
+		t.Errorf("Builder did not set ReadHeaderTimeout: got %v want %v", s.srv.ReadHeaderTimeout, 5*time.Second)
+	}
+}
+
+type shutdownInterceptor struct {
+	shutdown chan struct{}
+}
+
+func (shutdownInterceptor) Before(w ResponseWriter, r *IncomingRequest, cfg InterceptorConfig) Result {
+	return NotWritten()
+}
+
+func (shutdownInterceptor) Commit(w ResponseHeadersWriter, r *IncomingRequest, resp Response, cfg InterceptorConfig) {
+}
+
+func (shutdownInterceptor) Match(InterceptorConfig) bool {
+	return false
+}
+
+func (it shutdownInterceptor) Shutdown(ctx context.Context) error {
+	close(it.shutdown)
+	return nil
+}
+
+func TestServerShutdownDrains(t *testing.T) {
+	it := shutdownInterceptor{shutdown: make(chan struct{})}
+	mc := NewServeMuxConfig(nil)
+	mc.Intercept(it)
+	mux := mc.Mux()
+	started, release := make(chan struct{}), make(chan struct{})
+	mux.Handle("/", "GET", HandlerFunc(func(w ResponseWriter, r *IncomingRequest) Result {
+		close(started)
+		<-release
+		return w.Write(safehtml.HTMLEscaped("response"))
+	}))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	s := Server{Mux: mux, DrainDelay: 10 * time.Millisecond}
+	go s.Serve(l)
+	defer s.Close()
+
+	respErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+		respErr <- err
+	}()
+	<-started
+
+	if mux.Draining() {
+		t.Error("mux.Draining() before Shutdown: got true, want false")
+	}
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- s.Shutdown(context.Background())
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if !mux.Draining() {
+		t.Error("mux.Draining() during Shutdown: got false, want true")
+	}
+	select {
+	case <-it.shutdown:
+		t.Fatal("interceptor shut down while a request was in flight")
+	default:
+	}
+
+	close(release)
+	if err := <-respErr; err != nil {
+		t.Errorf("in-flight request: got error %v", err)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("s.Shutdown: got error %v", err)
+	}
+	select {
+	case <-it.shutdown:
+	default:
+		t.Error("interceptor wasn't shut down")
+	}
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	mux := NewServeMuxConfig(nil).Mux()
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	mux.Handle("/", "GET", HandlerFunc(func(w ResponseWriter, r *IncomingRequest) Result {
+		close(started)
+		<-release
+		return NotWritten()
+	}))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	s := Server{Mux: mux}
+	go s.Serve(l)
+	defer s.Close()
+	go func() {
+		if resp, err := http.Get("http://" + l.Addr().String()); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("s.Shutdown: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// hstsInterceptor stands for the hsts plugin, which can't be imported here.
+type hstsInterceptor struct{}
+
+func (hstsInterceptor) Before(w ResponseWriter, r *IncomingRequest, cfg InterceptorConfig) Result {
+	w.Header().Claim("Strict-Transport-Security")([]string{hstsInterceptor{}.StrictTransportSecurity()})
+	return NotWritten()
+}
+
+func (hstsInterceptor) Commit(w ResponseHeadersWriter, r *IncomingRequest, resp Response, cfg InterceptorConfig) {
+}
+
+func (hstsInterceptor) Match(InterceptorConfig) bool {
+	return false
+}
+
+func (hstsInterceptor) StrictTransportSecurity() string {
+	return "max-age=3600"
+}
+
+// hstsMux returns a ServeMux with hstsInterceptor installed.
+func hstsMux() *ServeMux {
+	mc := NewServeMuxConfig(nil)
+	mc.Intercept(hstsInterceptor{})
+	return mc.Mux()
+}
+
+func TestServerRedirectAddr(t *testing.T) {
+	tests := []struct {
+		name         string
+		addr         string
+		method       string
+		target       string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Default port",
+			method:       http.MethodGet,
+			target:       "http://example.com/path?q=1",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "https://example.com/path?q=1",
+		},
+		{
+			name:         "Plain HTTP port stripped",
+			addr:         ":443",
+			method:       http.MethodHead,
+			target:       "http://example.com:80/",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "https://example.com/",
+		},
+		{
+			name:         "Custom TLS port",
+			addr:         ":8443",
+			method:       http.MethodGet,
+			target:       "http://example.com:8080/path",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "https://example.com:8443/path",
+		},
+		{
+			name:         "IPv6",
+			addr:         ":8443",
+			method:       http.MethodGet,
+			target:       "http://[::1]/",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "https://[::1]:8443/",
+		},
+		{
+			name:     "POST",
+			method:   http.MethodPost,
+			target:   "http://example.com/",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Server{
+				Addr:         tt.addr,
+				Mux:          hstsMux(),
+				RedirectAddr: ":http",
+			}
+			if err := s.buildStd(); err != nil {
+				t.Fatalf("s.buildStd(): got error %v", err)
+			}
+			rec := httptest.NewRecorder()
+			s.redirectSrv.Handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status: got %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location: got %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestServerRedirectAddrHSTS(t *testing.T) {
+	s := Server{Mux: NewServeMuxConfig(nil).Mux(), RedirectAddr: ":http"}
+	if err := s.buildStd(); err == nil {
+		t.Error("s.buildStd() without the hsts plugin: got nil, want error")
+	}
+
+	mux := hstsMux()
+	mux.Handle("/", "GET", HandlerFunc(func(w ResponseWriter, r *IncomingRequest) Result {
+		return w.Write(safehtml.HTMLEscaped("response"))
+	}))
+	s = Server{Mux: mux, RedirectAddr: ":http"}
+	if err := s.buildStd(); err != nil {
+		t.Fatalf("s.buildStd(): got error %v", err)
+	}
+	rec := httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+	// The header is set by the plugin, not overridden by the Server.
+	if got, want := rec.Header().Get("Strict-Transport-Security"), "max-age=3600"; got != want {
+		t.Errorf("Strict-Transport-Security: got %q, want %q", got, want)
+	}
+	if got, want := rec.Body.String(), "response"; got != want {
+		t.Errorf("response body: got %q, want %q", got, want)
+	}
+}
+
+func TestServerListenAndServeUnix(t *testing.T) {
+	mux := NewServeMuxConfig(nil).Mux()
+	mux.Handle("/", "GET", HandlerFunc(func(w ResponseWriter, r *IncomingRequest) Result {
+		return w.Write(safehtml.HTMLEscaped("response"))
+	}))
+	path := filepath.Join(t.TempDir(), "server.sock")
+	// A stale socket left by a previous server.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	s := Server{Mux: mux}
+	errc := make(chan error, 1)
+	go func() { errc <- s.ListenAndServeUnix(path, 0660) }()
+	defer s.Close()
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	var resp *http.Response
+	for i := 0; i < 100; i++ {
+		if resp, err = client.Get("http://unix/"); err == nil {
+			break
+		}
+		select {
+		case err := <-errc:
+			t.Fatalf("s.ListenAndServeUnix: got error %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if err != nil {
+		t.Fatalf("client.Get: %v", err)
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("resp.StatusCode: got %d, want %d", got, want)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	if got, want := fi.Mode().Perm(), os.FileMode(0660); got != want {
+		t.Errorf("socket permissions: got %v, want %v", got, want)
+	}
+}
+
+func TestServerListenAndServeUnixNotSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.sock")
+	if err := ioutil.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatalf("ioutil.WriteFile: %v", err)
+	}
+	s := Server{Mux: NewServeMuxConfig(nil).Mux()}
+	if err := s.ListenAndServeUnix(path, 0); err == nil {
+		t.Error("s.ListenAndServeUnix: got nil error, want error")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("regular file was removed: %v", err)
+	}
 }
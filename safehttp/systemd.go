@@ -0,0 +1,126 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd, see
+// sd_listen_fds(3).
+var listenFDsStart = 3
+
+// SystemdListeners returns the listening sockets passed to the process by
+// systemd socket activation, as described in sd_listen_fds(3), or none if the
+// process wasn't socket activated. The LISTEN_PID, LISTEN_FDS and
+// LISTEN_FDNAMES environment variables are unset, so that they are not
+// inherited by child processes.
+func SystemdListeners() ([]net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	if p, err := strconv.Atoi(pid); err != nil || p != os.Getpid() {
+		// The sockets were passed to another process.
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	ls := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, fmt.Errorf("file descriptor %d is not a listening socket: %v", fd, err)
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+// NotifySystemd sends a state change, such as "READY=1", to the systemd
+// service manager, as described in sd_notify(3). It's a no-op if the
+// NOTIFY_SOCKET environment variable is not set, e.g. because the service
+// isn't of Type=notify.
+func NotifySystemd(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	conn, err := net.Dial("unixgram", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// ServeSystemd serves on all the sockets passed by systemd socket activation,
+// which allows restarting the server without refusing connections. Once the
+// server is serving, "READY=1" is sent to the service manager and Shutdown
+// sends "STOPPING=1".
+//
+// The sockets are served with TLS if TLSConfig provides certificates or if
+// ACME is set. ServeSystemd returns an error if the process wasn't socket
+// activated.
+func (s *Server) ServeSystemd() error {
+	if err := s.buildStd(); err != nil {
+		return err
+	}
+	ls, err := SystemdListeners()
+	if err != nil {
+		return err
+	}
+	if len(ls) == 0 {
+		return errors.New("no sockets passed by systemd")
+	}
+	cfg := s.srv.TLSConfig
+	useTLS := len(cfg.Certificates) > 0 || cfg.GetCertificate != nil
+	if useTLS && s.HTTP2 != nil && s.HTTP2.H2C {
+		return errors.New("h2c can't be used with TLS")
+	}
+	s.started = true
+	s.systemd = true
+	errc := make(chan error, len(ls))
+	for _, l := range ls {
+		l := l
+		go func() {
+			if useTLS {
+				errc <- s.srv.ServeTLS(l, "", "")
+			} else {
+				errc <- s.srv.Serve(l)
+			}
+		}()
+	}
+	if err := NotifySystemd("READY=1"); err != nil {
+		s.srv.Close()
+		return err
+	}
+	return <-errc
+}
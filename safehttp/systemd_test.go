@@ -0,0 +1,138 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package safehttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/safehtml"
+)
+
+// activate simulates systemd socket activation with a new listener and
+// returns its address.
+func activate(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("l.File: %v", err)
+	}
+	defer f.Close()
+	// The descriptor is owned by SystemdListeners, which closes it.
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatalf("syscall.Dup: %v", err)
+	}
+	old := listenFDsStart
+	listenFDsStart = fd
+	t.Cleanup(func() { listenFDsStart = old })
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "1")
+	return l.Addr().String()
+}
+
+func TestServeSystemd(t *testing.T) {
+	addr := activate(t)
+	notify, err := net.ListenPacket("unixgram", filepath.Join(t.TempDir(), "notify"))
+	if err != nil {
+		t.Fatalf("net.ListenPacket: %v", err)
+	}
+	defer notify.Close()
+	os.Setenv("NOTIFY_SOCKET", notify.LocalAddr().String())
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	mux := NewServeMuxConfig(nil).Mux()
+	mux.Handle("/", "GET", HandlerFunc(func(w ResponseWriter, r *IncomingRequest) Result {
+		return w.Write(safehtml.HTMLEscaped("response"))
+	}))
+	s := Server{Mux: mux}
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeSystemd() }()
+
+	notify.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, _, err := notify.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("reading notification: %v", err)
+	}
+	if got, want := string(buf[:n]), "READY=1"; got != want {
+		t.Errorf("notification: got %q, want %q", got, want)
+	}
+	for _, env := range []string{"LISTEN_PID", "LISTEN_FDS"} {
+		if v, ok := os.LookupEnv(env); ok {
+			t.Errorf("%s: got %q, want unset", env, v)
+		}
+	}
+
+	resp, err := http.Get("http://" + addr)
+	if err != nil {
+		t.Fatalf("http.Get: %v", err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("resp.StatusCode: got %d, want %d", got, want)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("s.Shutdown: %v", err)
+	}
+	n, _, err = notify.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("reading notification: %v", err)
+	}
+	if got, want := string(buf[:n]), "STOPPING=1"; got != want {
+		t.Errorf("notification: got %q, want %q", got, want)
+	}
+	if err := <-errc; err != http.ErrServerClosed {
+		t.Errorf("s.ServeSystemd: got error %v, want %v", err, http.ErrServerClosed)
+	}
+}
+
+func TestServeSystemdNotActivated(t *testing.T) {
+	tests := []struct {
+		name string
+		pid  string
+	}{
+		{name: "No environment"},
+		{name: "Other process", pid: strconv.Itoa(os.Getpid() + 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.pid != "" {
+				os.Setenv("LISTEN_PID", tt.pid)
+				os.Setenv("LISTEN_FDS", "1")
+			}
+			s := Server{Mux: NewServeMuxConfig(nil).Mux()}
+			if err := s.ServeSystemd(); err == nil {
+				t.Error("s.ServeSystemd: got nil error, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// timeoutWriter guards the http.ResponseWriter of a request processed with a
+// deadline, see WithTimeout. The headers are written to a separate map, only
+// copied to the underlying http.ResponseWriter once the response is written,
+// so that a 503 Service Unavailable response can be written concurrently if
+// the deadline expires before that. Writes after the deadline then fail with
+// http.ErrHandlerTimeout.
+type timeoutWriter struct {
+	rw http.ResponseWriter
+	h  http.Header
+
+	mu       sync.Mutex
+	wrote    bool
+	timedOut bool
+}
+
+// newTimeoutWriter wraps rw in a timeoutWriter. The returned
+// http.ResponseWriter implements http.Flusher and http.Hijacker only if rw
+// does, so that the dispatcher behaves the same on both.
+func newTimeoutWriter(rw http.ResponseWriter) (http.ResponseWriter, *timeoutWriter) {
+	w := &timeoutWriter{rw: rw, h: rw.Header().Clone()}
+	_, flusher := rw.(http.Flusher)
+	_, hijacker := rw.(http.Hijacker)
+	switch {
+	case flusher && hijacker:
+		return flushHijackTimeoutWriter{w}, w
+	case flusher:
+		return flushTimeoutWriter{w}, w
+	case hijacker:
+		return hijackTimeoutWriter{w}, w
+	}
+	return w, w
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.wrote {
+		// Trailers are set after the response has been written.
+		return w.rw.Header()
+	}
+	return w.h
+}
+
+// start copies the headers to the underlying http.ResponseWriter and reports
+// whether the response can be written, i.e. whether the deadline hasn't
+// expired. Unless the response is informational, it can't time out anymore.
+// w.mu must be held.
+func (w *timeoutWriter) start(final bool) bool {
+	if w.timedOut {
+		return false
+	}
+	if !w.wrote {
+		dst := w.rw.Header()
+		for k := range dst {
+			delete(dst, k)
+		}
+		for k, v := range w.h {
+			dst[k] = v
+		}
+		w.wrote = final
+	}
+	return true
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.start(!informational(code)) {
+		w.rw.WriteHeader(code)
+	}
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.start(true) {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.rw.Write(b)
+}
+
+func (w *timeoutWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.start(true) {
+		w.rw.(http.Flusher).Flush()
+	}
+}
+
+func (w *timeoutWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.start(true) {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	return w.rw.(http.Hijacker).Hijack()
+}
+
+// timeout makes the response time out and reports whether it did, i.e.
+// whether nothing had been written yet. Once it has timed out, the response
+// can only be written to the underlying http.ResponseWriter.
+func (w *timeoutWriter) timeout() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.wrote {
+		return false
+	}
+	w.timedOut = true
+	return true
+}
+
+type flushTimeoutWriter struct{ *timeoutWriter }
+
+func (w flushTimeoutWriter) Flush() { w.flush() }
+
+type hijackTimeoutWriter struct{ *timeoutWriter }
+
+func (w hijackTimeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return w.hijack() }
+
+type flushHijackTimeoutWriter struct{ *timeoutWriter }
+
+func (w flushHijackTimeoutWriter) Flush() { w.flush() }
+
+func (w flushHijackTimeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return w.hijack() }
@@ -0,0 +1,116 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// TLSProfile is a preset TLS configuration for a Server, based on the Mozilla
+// Server Side TLS recommendations,
+// https://wiki.mozilla.org/Security/Server_Side_TLS.
+//
+// Both profiles keep TLS session tickets enabled and rely on crypto/tls to
+// rotate their keys automatically.
+type TLSProfile int
+
+const (
+	// TLSIntermediate supports TLS 1.2 with forward secret AEAD cipher
+	// suites, and TLS 1.3. It's compatible with almost all clients and it's
+	// the default profile.
+	TLSIntermediate TLSProfile = iota
+	// TLSModern only supports TLS 1.3, for services whose clients are all
+	// recent.
+	TLSModern
+)
+
+// intermediateCipherSuites are the TLS 1.2 cipher suites of the intermediate
+// profile. The cipher suites of TLS 1.3 are not configurable.
+var intermediateCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// profileCurves are the preferred curves of both profiles.
+var profileCurves = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}
+
+// minVersion returns the minimum TLS version of the profile.
+func (p TLSProfile) minVersion() uint16 {
+	if p == TLSModern {
+		return tls.VersionTLS13
+	}
+	return tls.VersionTLS12
+}
+
+// config returns a copy of cfg, or a new configuration if it's nil, with the
+// settings of the profile for all the fields that haven't been set.
+//
+// Unless allowWeak is true, it returns an error if cfg allows protocol
+// versions or cipher suites that the profile doesn't.
+func (p TLSProfile) config(cfg *tls.Config, allowWeak bool) (*tls.Config, error) {
+	if p != TLSIntermediate && p != TLSModern {
+		return nil, fmt.Errorf("unknown TLS profile %d", p)
+	}
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+	if !allowWeak {
+		if err := p.check(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = p.minVersion()
+	}
+	if cfg.CipherSuites == nil && p == TLSIntermediate {
+		cfg.CipherSuites = append([]uint16(nil), intermediateCipherSuites...)
+	}
+	if cfg.CurvePreferences == nil {
+		cfg.CurvePreferences = append([]tls.CurveID(nil), profileCurves...)
+	}
+	cfg.PreferServerCipherSuites = true
+	return cfg, nil
+}
+
+// check returns an error if cfg is weaker than the profile.
+func (p TLSProfile) check(cfg *tls.Config) error {
+	if cfg.MinVersion != 0 && cfg.MinVersion < p.minVersion() {
+		return fmt.Errorf("TLSConfig.MinVersion %#x is lower than the TLS profile minimum %#x", cfg.MinVersion, p.minVersion())
+	}
+	if cfg.MaxVersion != 0 && cfg.MaxVersion < p.minVersion() {
+		return fmt.Errorf("TLSConfig.MaxVersion %#x is lower than the TLS profile minimum %#x", cfg.MaxVersion, p.minVersion())
+	}
+	if p == TLSModern {
+		// Cipher suites are only used by TLS 1.2 and earlier.
+		return nil
+	}
+	allowed := make(map[uint16]bool, len(intermediateCipherSuites))
+	for _, c := range intermediateCipherSuites {
+		allowed[c] = true
+	}
+	for _, c := range cfg.CipherSuites {
+		if !allowed[c] {
+			return fmt.Errorf("TLSConfig.CipherSuites contains %s, which isn't allowed by the TLS profile", tls.CipherSuiteName(c))
+		}
+	}
+	return nil
+}
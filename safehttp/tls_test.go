@@ -0,0 +1,133 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestServerTLSProfile(t *testing.T) {
+	tests := []struct {
+		name         string
+		profile      TLSProfile
+		cfg          *tls.Config
+		wantMin      uint16
+		wantCiphers  []uint16
+		wantNextProt []string
+	}{
+		{
+			name:        "Intermediate default",
+			wantMin:     tls.VersionTLS12,
+			wantCiphers: intermediateCipherSuites,
+		},
+		{
+			name:    "Modern",
+			profile: TLSModern,
+			wantMin: tls.VersionTLS13,
+		},
+		{
+			name: "User fields are kept",
+			cfg: &tls.Config{
+				MinVersion:   tls.VersionTLS13,
+				CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+				NextProtos:   []string{"h2"},
+			},
+			wantMin:      tls.VersionTLS13,
+			wantCiphers:  []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			wantNextProt: []string{"h2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Server{
+				Mux:        NewServeMuxConfig(nil).Mux(),
+				TLSConfig:  tt.cfg,
+				TLSProfile: tt.profile,
+			}
+			if err := s.buildStd(); err != nil {
+				t.Fatalf("s.buildStd(): got error %v", err)
+			}
+			cfg := s.srv.TLSConfig
+			if cfg.MinVersion != tt.wantMin {
+				t.Errorf("MinVersion: got %#x, want %#x", cfg.MinVersion, tt.wantMin)
+			}
+			if diff := cmp.Diff(tt.wantCiphers, cfg.CipherSuites); diff != "" {
+				t.Errorf("CipherSuites mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(profileCurves, cfg.CurvePreferences); diff != "" {
+				t.Errorf("CurvePreferences mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantNextProt, cfg.NextProtos); diff != "" {
+				t.Errorf("NextProtos mismatch (-want +got):\n%s", diff)
+			}
+			if tt.cfg != nil && cfg == tt.cfg {
+				t.Error("TLSConfig wasn't cloned")
+			}
+		})
+	}
+}
+
+func TestServerWeakTLSConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile TLSProfile
+		cfg     *tls.Config
+	}{
+		{
+			name: "TLS 1.0",
+			cfg:  &tls.Config{MinVersion: tls.VersionTLS10},
+		},
+		{
+			name:    "TLS 1.2 on modern",
+			profile: TLSModern,
+			cfg:     &tls.Config{MinVersion: tls.VersionTLS12},
+		},
+		{
+			name: "Max version",
+			cfg:  &tls.Config{MaxVersion: tls.VersionTLS11},
+		},
+		{
+			name: "CBC cipher suite",
+			cfg:  &tls.Config{CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA}},
+		},
+		{
+			name: "RSA key exchange",
+			cfg:  &tls.Config{CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_GCM_SHA256}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Server{
+				Mux:        NewServeMuxConfig(nil).Mux(),
+				TLSConfig:  tt.cfg,
+				TLSProfile: tt.profile,
+			}
+			if err := s.buildStd(); err == nil {
+				t.Error("s.buildStd(): got nil error, want error")
+			}
+
+			s.AllowWeakTLSConfig = true
+			if err := s.buildStd(); err != nil {
+				t.Fatalf("s.buildStd() with AllowWeakTLSConfig: got error %v", err)
+			}
+			if got, want := s.srv.TLSConfig.MinVersion, tt.cfg.MinVersion; want != 0 && got != want {
+				t.Errorf("MinVersion: got %#x, want %#x", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMaxFileSize is the maximum size of each uploaded file, unless
+	// overridden with UploadOptions.MaxFileSize.
+	DefaultMaxFileSize = 10 << 20 // 10 MiB
+	// DefaultMaxUploadSize is the maximum size of all the parts of a
+	// multipart request, unless overridden with UploadOptions.MaxTotalSize.
+	DefaultMaxUploadSize = 32 << 20 // 32 MiB
+	// maxFilenameLength is the maximum length, in bytes, of sanitized file
+	// names.
+	maxFilenameLength = 255
+)
+
+// UploadOptions configure how IncomingRequest.Uploads handles uploaded files.
+type UploadOptions struct {
+	// AllowedContentTypes are the media types, e.g. "image/png", that
+	// uploaded files are allowed to have. The type of each file is sniffed
+	// from its contents with http.DetectContentType, ignoring the
+	// Content-Type sent by the client. It must not be empty.
+	AllowedContentTypes []string
+	// MaxFileSize is the maximum size, in bytes, of each file. If zero,
+	// DefaultMaxFileSize is used.
+	MaxFileSize int64
+	// MaxTotalSize is the maximum size, in bytes, of all the files and form
+	// values. If zero, DefaultMaxUploadSize is used.
+	MaxTotalSize int64
+	// Dir is the directory where the files are stored when Destination is
+	// nil. If empty, the default directory for temporary files is used.
+	Dir string
+	// Destination, if set, is called for each file to get the writer its
+	// contents are streamed to, instead of a temporary file. Size is not yet
+	// set when it is called.
+	Destination func(f *UploadedFile) (io.Writer, error)
+}
+
+// UploadedFile is a file uploaded in a multipart request.
+type UploadedFile struct {
+	// Param is the name of the form parameter of the file.
+	Param string
+	// Filename is the name of the file provided by the client, stripped of
+	// directories and of characters that are dangerous in file systems and
+	// in HTML. It should still be treated as untrusted input and it is never
+	// used to name temporary files.
+	Filename string
+	// ContentType is the sniffed media type of the file.
+	ContentType string
+	// Size is the size of the file, in bytes.
+	Size int64
+	// Path is the path of the temporary file storing the contents, or empty
+	// if UploadOptions.Destination is set.
+	Path string
+}
+
+// Remove removes the temporary file storing the contents, if any.
+func (f *UploadedFile) Remove() error {
+	if f.Path == "" {
+		return nil
+	}
+	return os.Remove(f.Path)
+}
+
+// Uploads reads the body of a POST, PATCH or PUT request with Content-Type
+// multipart/form-data, returning its form values and the uploaded files.
+//
+// Unlike MultipartForm, files are streamed to their destination without being
+// held in memory, and the request is rejected if a file exceeds the per-file
+// size limit, if all the parts exceed the total size limit (with an error
+// wrapping ErrBodyTooLarge) or if the sniffed type of a file is not allowed.
+// When an error is returned, the temporary files created so far are removed.
+// Otherwise, the caller is responsible for removing them.
+func (r *IncomingRequest) Uploads(opts UploadOptions) (*Form, []*UploadedFile, error) {
+	if m := r.req.Method; m != MethodPost && m != MethodPatch && m != MethodPut {
+		return nil, nil, fmt.Errorf("got request method %s, want POST/PATCH/PUT", m)
+	}
+	if ct := r.req.Header.Get("Content-Type"); !strings.HasPrefix(ct, "multipart/form-data") {
+		return nil, nil, fmt.Errorf("invalid method called for Content-Type: %s", ct)
+	}
+	if len(opts.AllowedContentTypes) == 0 {
+		return nil, nil, errors.New("no allowed content types for uploaded files")
+	}
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = DefaultMaxFileSize
+	}
+	if opts.MaxTotalSize <= 0 {
+		opts.MaxTotalSize = DefaultMaxUploadSize
+	}
+	mr, err := r.req.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := map[string][]string{}
+	var files []*UploadedFile
+	fail := func(err error) (*Form, []*UploadedFile, error) {
+		for _, f := range files {
+			f.Remove()
+		}
+		return nil, nil, err
+	}
+	remaining := opts.MaxTotalSize
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+		name := p.FormName()
+		if name == "" {
+			p.Close()
+			continue
+		}
+		if p.FileName() == "" {
+			b, err := ioutil.ReadAll(io.LimitReader(p, remaining+1))
+			p.Close()
+			if err != nil {
+				return fail(err)
+			}
+			remaining -= int64(len(b))
+			if remaining < 0 {
+				return fail(ErrBodyTooLarge)
+			}
+			values[name] = append(values[name], string(b))
+			continue
+		}
+
+		f := &UploadedFile{Param: name, Filename: sanitizeFilename(p.FileName())}
+		files = append(files, f)
+		limit := opts.MaxFileSize
+		if remaining < limit {
+			limit = remaining
+		}
+		n, err := saveUpload(f, io.LimitReader(p, limit+1), opts)
+		p.Close()
+		if err != nil {
+			return fail(err)
+		}
+		if n > limit {
+			if limit == opts.MaxFileSize {
+				return fail(fmt.Errorf("uploaded file %q: %w", f.Filename, ErrBodyTooLarge))
+			}
+			return fail(ErrBodyTooLarge)
+		}
+		f.Size = n
+		remaining -= n
+	}
+	return &Form{values: values}, files, nil
+}
+
+// saveUpload sniffs the type of the file read from src and, if it's allowed,
+// copies it to its destination.
+func saveUpload(f *UploadedFile, src io.Reader, opts UploadOptions) (int64, error) {
+	br := bufio.NewReaderSize(src, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	mt, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return 0, err
+	}
+	if !containsFold(opts.AllowedContentTypes, mt) {
+		return 0, fmt.Errorf("uploaded file %q has disallowed content type %s", f.Filename, mt)
+	}
+	f.ContentType = mt
+
+	if opts.Destination != nil {
+		w, err := opts.Destination(f)
+		if err != nil {
+			return 0, err
+		}
+		return io.Copy(w, br)
+	}
+	tmp, err := ioutil.TempFile(opts.Dir, "upload-*")
+	if err != nil {
+		return 0, err
+	}
+	f.Path = tmp.Name()
+	n, err := io.Copy(tmp, br)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// containsFold reports whether list contains s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeFilename strips directories, control characters and characters
+// that have a special meaning in file systems, shells or HTML from the given
+// client-provided file name.
+func sanitizeFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"|?*'&;$%`+"`", r) || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" {
+		return "upload"
+	}
+	return name
+}
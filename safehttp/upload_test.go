@@ -0,0 +1,197 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-safeweb/safehttp"
+)
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+type uploadPart struct {
+	param, filename, content string
+}
+
+func newUploadRequest(t *testing.T, parts ...uploadPart) *safehttp.IncomingRequest {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, p := range parts {
+		var w io.Writer
+		var err error
+		if p.filename == "" {
+			w, err = mw.CreateFormField(p.param)
+		} else {
+			w, err = mw.CreateFormFile(p.param, p.filename)
+		}
+		if err != nil {
+			t.Fatalf("creating part: %v", err)
+		}
+		io.WriteString(w, p.content)
+	}
+	mw.Close()
+	req := httptest.NewRequest(safehttp.MethodPost, "/", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return safehttp.NewIncomingRequest(req)
+}
+
+func TestUploads(t *testing.T) {
+	dir := t.TempDir()
+	r := newUploadRequest(t,
+		uploadPart{param: "title", content: "holidays"},
+		uploadPart{param: "photo", filename: `..\..\evil<script>.png`, content: pngHeader + "data"},
+		uploadPart{param: "photo", filename: "notes.txt", content: "hello"},
+	)
+	form, files, err := r.Uploads(safehttp.UploadOptions{
+		AllowedContentTypes: []string{"image/png", "text/plain"},
+		Dir:                 dir,
+	})
+	if err != nil {
+		t.Fatalf("r.Uploads: got error %v", err)
+	}
+	if got, want := form.String("title", ""), "holidays"; got != want {
+		t.Errorf(`form.String("title", ""): got %q, want %q`, got, want)
+	}
+	if len(files) != 2 {
+		t.Fatalf("len(files): got %d, want 2", len(files))
+	}
+
+	type fileInfo struct {
+		Param, Filename, ContentType, Content string
+		Size                                  int64
+	}
+	want := []fileInfo{
+		{Param: "photo", Filename: "evilscript.png", ContentType: "image/png", Content: pngHeader + "data", Size: int64(len(pngHeader) + 4)},
+		{Param: "photo", Filename: "notes.txt", ContentType: "text/plain", Content: "hello", Size: 5},
+	}
+	var got []fileInfo
+	for _, f := range files {
+		if filepath.Dir(f.Path) != dir {
+			t.Errorf("f.Path: got %q, want a file in %q", f.Path, dir)
+		}
+		b, err := ioutil.ReadFile(f.Path)
+		if err != nil {
+			t.Fatalf("ioutil.ReadFile(%q): %v", f.Path, err)
+		}
+		got = append(got, fileInfo{Param: f.Param, Filename: f.Filename, ContentType: f.ContentType, Content: string(b), Size: f.Size})
+		if err := f.Remove(); err != nil {
+			t.Errorf("f.Remove(): got error %v", err)
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("files: mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestUploadsDestination(t *testing.T) {
+	r := newUploadRequest(t, uploadPart{param: "photo", filename: "a.png", content: pngHeader + "data"})
+	var buf bytes.Buffer
+	_, files, err := r.Uploads(safehttp.UploadOptions{
+		AllowedContentTypes: []string{"image/png"},
+		Destination: func(f *safehttp.UploadedFile) (io.Writer, error) {
+			return &buf, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("r.Uploads: got error %v", err)
+	}
+	if got, want := buf.String(), pngHeader+"data"; got != want {
+		t.Errorf("written contents: got %q, want %q", got, want)
+	}
+	if len(files) != 1 || files[0].Path != "" {
+		t.Errorf("files: got %+v, want a single file without path", files)
+	}
+}
+
+func TestUploadsRejected(t *testing.T) {
+	tests := []struct {
+		name      string
+		parts     []uploadPart
+		opts      safehttp.UploadOptions
+		wantLarge bool
+	}{
+		{
+			name:  "Disallowed content type",
+			parts: []uploadPart{{param: "photo", filename: "a.png", content: "<html><script>alert(1)</script>"}},
+			opts:  safehttp.UploadOptions{AllowedContentTypes: []string{"image/png"}},
+		},
+		{
+			name:  "No allowed content types",
+			parts: []uploadPart{{param: "photo", filename: "a.png", content: pngHeader}},
+		},
+		{
+			name:      "File too large",
+			parts:     []uploadPart{{param: "photo", filename: "a.png", content: pngHeader + strings.Repeat("a", 100)}},
+			opts:      safehttp.UploadOptions{AllowedContentTypes: []string{"image/png"}, MaxFileSize: 50},
+			wantLarge: true,
+		},
+		{
+			name: "Total too large",
+			parts: []uploadPart{
+				{param: "photo", filename: "a.png", content: pngHeader + strings.Repeat("a", 40)},
+				{param: "photo", filename: "b.png", content: pngHeader + strings.Repeat("a", 40)},
+			},
+			opts:      safehttp.UploadOptions{AllowedContentTypes: []string{"image/png"}, MaxFileSize: 60, MaxTotalSize: 80},
+			wantLarge: true,
+		},
+		{
+			name:      "Values too large",
+			parts:     []uploadPart{{param: "title", content: strings.Repeat("a", 100)}},
+			opts:      safehttp.UploadOptions{AllowedContentTypes: []string{"image/png"}, MaxTotalSize: 80},
+			wantLarge: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.opts.Dir = dir
+			r := newUploadRequest(t, tt.parts...)
+			_, _, err := r.Uploads(tt.opts)
+			if err == nil {
+				t.Fatal("r.Uploads: got nil, want error")
+			}
+			if got := errors.Is(err, safehttp.ErrBodyTooLarge); got != tt.wantLarge {
+				t.Errorf("errors.Is(err, safehttp.ErrBodyTooLarge): got %v, want %v", got, tt.wantLarge)
+			}
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("ioutil.ReadDir: %v", err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("temporary files were not removed: %v", entries)
+			}
+		})
+	}
+}
+
+func TestUploadsNotMultipart(t *testing.T) {
+	req := httptest.NewRequest(safehttp.MethodPost, "/", strings.NewReader("a=b"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r := safehttp.NewIncomingRequest(req)
+	if _, _, err := r.Uploads(safehttp.UploadOptions{AllowedContentTypes: []string{"image/png"}}); err == nil {
+		t.Error("r.Uploads: got nil, want error")
+	}
+}
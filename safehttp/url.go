@@ -74,6 +74,11 @@ func (u URL) Path() string {
 	return u.url.Path
 }
 
+// RawQuery returns the encoded query string of the URL, without the '?'.
+func (u URL) RawQuery() string {
+	return u.url.RawQuery
+}
+
 // ParseURL parses a raw URL string into a URL structure.
 //
 // The raw URl may be relative (a path, without a host) or absolute (starting
@@ -0,0 +1,504 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// defaultWebSocketReadLimit is the maximum size of the messages read from
+	// a WebSocket connection when no ReadLimit is set.
+	defaultWebSocketReadLimit = 32 << 10
+	// defaultWebSocketPingInterval is the interval at which pings are sent
+	// when no PingInterval is set.
+	defaultWebSocketPingInterval = 30 * time.Second
+	// webSocketWriteWait is the time allowed to write a frame to the peer.
+	webSocketWriteWait = 10 * time.Second
+	// webSocketGUID is used to compute the Sec-WebSocket-Accept header, as
+	// specified by RFC 6455, Section 1.3.
+	webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// WebSocketMessageType is the type of a WebSocket data message.
+type WebSocketMessageType int
+
+const (
+	// WebSocketText is a message with UTF-8 encoded text data.
+	WebSocketText WebSocketMessageType = 1
+	// WebSocketBinary is a message with binary data.
+	WebSocketBinary WebSocketMessageType = 2
+)
+
+const (
+	wsContinuation = 0x0
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// Close codes, as specified by RFC 6455, Section 7.4.1.
+const (
+	wsCloseNormal          = 1000
+	wsCloseProtocolError   = 1002
+	wsCloseInvalidData     = 1007
+	wsCloseMessageTooBig   = 1009
+	wsCloseInternalError   = 1011
+	wsCloseNoStatusPresent = 1005
+)
+
+// WebSocketResponse is used to upgrade the connection to the WebSocket
+// protocol, as specified by RFC 6455.
+//
+// As with any other response, the Before phases of the interceptors run
+// before the handler and the Commit phases run before the upgrade, so
+// requests rejected by interceptors are never upgraded.
+//
+// Use WriteWebSocket to write this response, as it validates the handshake
+// and the Origin of the request first.
+type WebSocketResponse struct {
+	// Request is the matching request for which this response is being
+	// written. It is used to build the handshake response.
+	Request *IncomingRequest
+	// AllowedOrigins are the origins, e.g. "https://app.example.com", that are
+	// allowed to open the connection in addition to the origin of the request
+	// itself. Origins are compared by scheme, host and port, so
+	// "https://app.example.com:443" and "https://app.example.com" are the same
+	// origin.
+	AllowedOrigins []string
+	// ReadLimit is the maximum size, in bytes, of the messages read from the
+	// connection. If zero, a limit of 32 KiB is used.
+	ReadLimit int64
+	// PingInterval is the interval at which pings are sent to the peer. If it
+	// doesn't reply with a pong within two intervals, reads from the
+	// connection fail. If zero, an interval of 30 seconds is used. A negative
+	// value disables pings.
+	PingInterval time.Duration
+	// Serve is called with the connection once it has been upgraded. When it
+	// returns, the connection is closed with a normal closure status, or with
+	// an internal error status if it returned an error.
+	Serve func(*WebSocketConn) error
+}
+
+// WriteWebSocket creates a WebSocketResponse that calls serve once the
+// connection is upgraded and writes it to w. Requests that are not valid
+// WebSocket handshakes are rejected with 400 Bad Request, and cross-origin
+// requests from origins not in allowedOrigins are rejected with 403 Forbidden.
+func WriteWebSocket(w ResponseWriter, r *IncomingRequest, serve func(*WebSocketConn) error, allowedOrigins ...string) Result {
+	resp := WebSocketResponse{
+		Request:        r,
+		AllowedOrigins: allowedOrigins,
+		Serve:          serve,
+	}
+	if err := resp.validate(); err != nil {
+		return w.WriteError(err.(webSocketHandshakeError).code)
+	}
+	return w.Write(resp)
+}
+
+// webSocketHandshakeError is returned when the request is not an acceptable
+// WebSocket handshake.
+type webSocketHandshakeError struct {
+	code StatusCode
+	msg  string
+}
+
+func (e webSocketHandshakeError) Error() string {
+	return "websocket: " + e.msg
+}
+
+// validate checks that the request is a valid WebSocket handshake, as
+// specified by RFC 6455, Section 4.2.1, and that its Origin is allowed.
+func (resp WebSocketResponse) validate() error {
+	if resp.Request == nil {
+		return webSocketHandshakeError{StatusInternalServerError, "missing request"}
+	}
+	r := resp.Request.req
+	if r.Method != MethodGet {
+		return webSocketHandshakeError{StatusBadRequest, "the handshake method is not GET"}
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return webSocketHandshakeError{StatusBadRequest, "not an upgrade request"}
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return webSocketHandshakeError{StatusBadRequest, "unsupported Sec-WebSocket-Version"}
+	}
+	if k, err := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key")); err != nil || len(k) != 16 {
+		return webSocketHandshakeError{StatusBadRequest, "invalid Sec-WebSocket-Key"}
+	}
+	if !resp.allowedOrigin() {
+		return webSocketHandshakeError{StatusForbidden, "origin not allowed"}
+	}
+	return nil
+}
+
+// allowedOrigin reports whether the Origin of the request, if any, is the
+// origin the request was sent to or one of the AllowedOrigins. Requests without
+// an Origin are not sent by browsers and are allowed.
+//
+// The scheme of the request is https if it was received over TLS, or if a
+// proxy trusted by the ProxyPolicy of the ServeMux reports it with the
+// X-Forwarded-Proto header.
+func (resp WebSocketResponse) allowedOrigin() bool {
+	r := resp.Request
+	origin := r.req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	o, ok := normalizeOrigin(origin)
+	if !ok {
+		return false
+	}
+	for _, a := range resp.AllowedOrigins {
+		if a, ok := normalizeOrigin(a); ok && a == o {
+			return true
+		}
+	}
+	scheme := "http"
+	if r.req.TLS != nil {
+		scheme = "https"
+	} else if p := r.req.Header.Get("X-Forwarded-Proto"); p != "" && r.FromTrustedProxy() {
+		// The first proxy, facing the client, is the leftmost.
+		scheme = strings.TrimSpace(strings.Split(p, ",")[0])
+	}
+	self, ok := normalizeOrigin(scheme + "://" + r.req.Host)
+	return ok && self == o
+}
+
+// normalizeOrigin returns the serialized origin, e.g. "https://example.com:443",
+// with the scheme and host in lower case and the default port of the scheme
+// made explicit. It returns false if origin isn't a valid http or https
+// origin.
+func normalizeOrigin(origin string) (string, bool) {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	port := u.Port()
+	switch {
+	case scheme == "http" && port == "":
+		port = "80"
+	case scheme == "https" && port == "":
+		port = "443"
+	case scheme != "http" && scheme != "https":
+		return "", false
+	}
+	return scheme + "://" + net.JoinHostPort(strings.ToLower(u.Hostname()), port), true
+}
+
+// headerContainsToken reports whether the comma-separated values of the
+// header with the given name contain token, ignoring case.
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeWebSocket upgrades the connection and serves it with resp.Serve.
+func writeWebSocket(rw http.ResponseWriter, resp WebSocketResponse) error {
+	if err := resp.validate(); err != nil {
+		return err
+	}
+	h, ok := rw.(http.Hijacker)
+	if !ok {
+		return errors.New("websocket: the http.ResponseWriter doesn't support hijacking")
+	}
+	sum := sha1.Sum([]byte(resp.Request.req.Header.Get("Sec-WebSocket-Key") + webSocketGUID))
+	hdr := rw.Header()
+	hdr.Set("Upgrade", "websocket")
+	hdr.Set("Connection", "Upgrade")
+	hdr.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(sum[:]))
+
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return err
+	}
+	conn.SetWriteDeadline(time.Now().Add(webSocketWriteWait))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	hdr.Write(brw)
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return err
+	}
+	conn.SetWriteDeadline(time.Time{})
+
+	c := &WebSocketConn{
+		conn:      conn,
+		br:        brw.Reader,
+		readLimit: resp.ReadLimit,
+		pingEvery: resp.PingInterval,
+		done:      make(chan struct{}),
+	}
+	if c.readLimit <= 0 {
+		c.readLimit = defaultWebSocketReadLimit
+	}
+	if c.pingEvery == 0 {
+		c.pingEvery = defaultWebSocketPingInterval
+	}
+	if c.pingEvery > 0 {
+		c.extendReadDeadline()
+		go c.ping()
+	}
+
+	code := wsCloseNormal
+	if resp.Serve != nil {
+		if err := resp.Serve(c); err != nil {
+			code = wsCloseInternalError
+		}
+	}
+	c.close(code)
+	// The connection has been hijacked, so there is no way to report errors
+	// to the client other than the close status.
+	return nil
+}
+
+// WebSocketConn is an upgraded WebSocket connection.
+//
+// Messages can be written concurrently, but only one goroutine should read
+// from the connection at a time. Control frames are only processed while
+// reading, so handlers should keep reading from the connection for as long
+// as it is in use.
+type WebSocketConn struct {
+	conn      net.Conn
+	br        *bufio.Reader
+	readLimit int64
+	pingEvery time.Duration
+	done      chan struct{}
+
+	mu     sync.Mutex // guards writes to conn and closed
+	closed bool
+}
+
+// ReadMessage reads the next data message from the connection, replying to
+// pings and handling the closing handshake. It returns io.EOF when the peer
+// closes the connection.
+//
+// Messages larger than the read limit, invalid frames and invalid text
+// messages make the connection fail with an error.
+func (c *WebSocketConn) ReadMessage() (WebSocketMessageType, []byte, error) {
+	var (
+		typ WebSocketMessageType
+		msg []byte
+	)
+	for {
+		fin, opcode, payload, err := c.readFrame(int64(len(msg)))
+		if err != nil {
+			return 0, nil, err
+		}
+		if c.pingEvery > 0 {
+			c.extendReadDeadline()
+		}
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			code := wsCloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			if code == wsCloseNoStatusPresent {
+				code = wsCloseNormal
+			}
+			c.close(code)
+			return 0, nil, io.EOF
+		case wsContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(wsCloseProtocolError, "unexpected continuation frame")
+			}
+		case int(WebSocketText), int(WebSocketBinary):
+			if typ != 0 {
+				return 0, nil, c.fail(wsCloseProtocolError, "expected continuation frame")
+			}
+			typ = WebSocketMessageType(opcode)
+		default:
+			return 0, nil, c.fail(wsCloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+		}
+		msg = append(msg, payload...)
+		if !fin {
+			continue
+		}
+		if typ == WebSocketText && !utf8.Valid(msg) {
+			return 0, nil, c.fail(wsCloseInvalidData, "invalid UTF-8 in text message")
+		}
+		return typ, msg, nil
+	}
+}
+
+// readFrame reads a single frame from the connection. read is the size of
+// the message read so far, used to enforce the read limit.
+func (c *WebSocketConn) readFrame(read int64) (fin bool, opcode int, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = hdr[0]&0x80 != 0
+	opcode = int(hdr[0] & 0x0f)
+	if hdr[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(wsCloseProtocolError, "unexpected reserved bits")
+	}
+	if hdr[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(wsCloseProtocolError, "unmasked client frame")
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= wsClose && (!fin || n > 125) {
+		return false, 0, nil, c.fail(wsCloseProtocolError, "invalid control frame")
+	}
+	if opcode < wsClose && n > uint64(c.readLimit-read) {
+		return false, 0, nil, c.fail(wsCloseMessageTooBig, "message exceeds the read limit")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage writes a data message of the given type to the connection.
+func (c *WebSocketConn) WriteMessage(typ WebSocketMessageType, data []byte) error {
+	if typ != WebSocketText && typ != WebSocketBinary {
+		return fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+	if typ == WebSocketText && !utf8.Valid(data) {
+		return errors.New("websocket: invalid UTF-8 in text message")
+	}
+	return c.writeFrame(int(typ), data)
+}
+
+// writeFrame writes a single unmasked frame to the connection.
+func (c *WebSocketConn) writeFrame(opcode int, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errors.New("websocket: use of closed connection")
+	}
+	return c.writeFrameLocked(opcode, payload)
+}
+
+func (c *WebSocketConn) writeFrameLocked(opcode int, payload []byte) error {
+	hdr := []byte{0x80 | byte(opcode), 0}
+	switch n := len(payload); {
+	case n <= 125:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		hdr = append(hdr, 0, 0)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = append(hdr, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(webSocketWriteWait))
+	if _, err := c.conn.Write(append(hdr, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close closes the connection with a normal closure status. It is called
+// automatically when the Serve function returns.
+func (c *WebSocketConn) Close() error {
+	return c.close(wsCloseNormal)
+}
+
+// close sends a close frame with the given status code, if the connection
+// isn't already closed, and closes the underlying connection.
+func (c *WebSocketConn) close(code int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	var payload [2]byte
+	binary.BigEndian.PutUint16(payload[:], uint16(code))
+	c.writeFrameLocked(wsClose, payload[:])
+	return c.conn.Close()
+}
+
+// fail closes the connection with the given status code and returns an error
+// describing the failure.
+func (c *WebSocketConn) fail(code int, msg string) error {
+	c.close(code)
+	return errors.New("websocket: " + msg)
+}
+
+// extendReadDeadline allows reads until two ping intervals have passed.
+func (c *WebSocketConn) extendReadDeadline() {
+	c.conn.SetReadDeadline(time.Now().Add(2 * c.pingEvery))
+}
+
+// ping sends pings to the peer until the connection is closed.
+func (c *WebSocketConn) ping() {
+	t := time.NewTicker(c.pingEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			if err := c.writeFrame(wsPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
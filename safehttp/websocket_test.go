@@ -0,0 +1,317 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safehttp_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// wsClient is a minimal WebSocket client used to test the server side.
+type wsClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, srv *httptest.Server, header map[string]string) (*wsClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/ws", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest: %v", err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for k, v := range header {
+		if v == "" {
+			req.Header.Del(k)
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("req.Write: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("http.ReadResponse: %v", err)
+	}
+	return &wsClient{conn: conn, br: br}, resp
+}
+
+func (c *wsClient) writeFrame(t *testing.T, opcode byte, payload []byte) {
+	t.Helper()
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, 0x80|byte(n))
+	default:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatalf("conn.Write: %v", err)
+	}
+}
+
+func (c *wsClient) readFrame(t *testing.T) (opcode byte, payload []byte) {
+	t.Helper()
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		t.Fatalf("reading frame header: %v", err)
+	}
+	n := int(hdr[1] & 0x7f)
+	if n == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			t.Fatalf("reading frame length: %v", err)
+		}
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		t.Fatalf("reading frame payload: %v", err)
+	}
+	return hdr[0] & 0x0f, payload
+}
+
+func newWebSocketServer(t *testing.T, h safehttp.Handler) *httptest.Server {
+	t.Helper()
+	mux := safehttp.NewServeMuxConfig(nil).Mux()
+	mux.Handle("/ws", safehttp.MethodGet, h)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func echo(c *safehttp.WebSocketConn) error {
+	for {
+		typ, msg, err := c.ReadMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.WriteMessage(typ, msg); err != nil {
+			return err
+		}
+	}
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	srv := newWebSocketServer(t, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.WriteWebSocket(w, r, echo)
+	}))
+	c, resp := dialWebSocket(t, srv, map[string]string{"Origin": srv.URL})
+
+	if got, want := resp.StatusCode, http.StatusSwitchingProtocols; got != want {
+		t.Fatalf("resp.StatusCode: got %v, want %v", got, want)
+	}
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf(`resp.Header.Get("Sec-WebSocket-Accept"): got %q, want %q`, got, want)
+	}
+
+	c.writeFrame(t, 0x9, []byte("ping"))
+	if op, payload := c.readFrame(t); op != 0xa || string(payload) != "ping" {
+		t.Errorf("reply to ping: got opcode %d and payload %q, want pong with %q", op, payload, "ping")
+	}
+	c.writeFrame(t, 0x1, []byte("hello"))
+	if op, payload := c.readFrame(t); op != 0x1 || string(payload) != "hello" {
+		t.Errorf("echo: got opcode %d and payload %q, want text with %q", op, payload, "hello")
+	}
+	long := strings.Repeat("a", 300)
+	c.writeFrame(t, 0x2, []byte(long))
+	if op, payload := c.readFrame(t); op != 0x2 || string(payload) != long {
+		t.Errorf("echo: got opcode %d and payload of length %d, want binary of length %d", op, len(payload), len(long))
+	}
+	c.writeFrame(t, 0x8, []byte{0x03, 0xe8})
+	if op, payload := c.readFrame(t); op != 0x8 || binary.BigEndian.Uint16(payload) != 1000 {
+		t.Errorf("close: got opcode %d and payload %v, want close with status 1000", op, payload)
+	}
+}
+
+func TestWebSocketRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   map[string]string
+		allowed  []string
+		wantCode int
+	}{
+		{
+			name:     "Cross origin",
+			header:   map[string]string{"Origin": "https://evil.example"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Cross origin, other origin allowed",
+			header:   map[string]string{"Origin": "https://evil.example"},
+			allowed:  []string{"https://app.example"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Allowed host, other scheme",
+			header:   map[string]string{"Origin": "http://app.example"},
+			allowed:  []string{"https://app.example"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Not an upgrade",
+			header:   map[string]string{"Upgrade": ""},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Unsupported version",
+			header:   map[string]string{"Sec-WebSocket-Version": "8"},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Invalid key",
+			header:   map[string]string{"Sec-WebSocket-Key": "short"},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			srv := newWebSocketServer(t, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+				return safehttp.WriteWebSocket(w, r, func(*safehttp.WebSocketConn) error {
+					called = true
+					return nil
+				}, tt.allowed...)
+			}))
+			_, resp := dialWebSocket(t, srv, tt.header)
+			if got := resp.StatusCode; got != tt.wantCode {
+				t.Errorf("resp.StatusCode: got %v, want %v", got, tt.wantCode)
+			}
+			if called {
+				t.Error("Serve was called for a rejected handshake")
+			}
+		})
+	}
+}
+
+func TestWebSocketAllowedOrigin(t *testing.T) {
+	srv := newWebSocketServer(t, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.WriteWebSocket(w, r, echo, "https://app.example")
+	}))
+	for _, origin := range []string{"https://app.example", "https://APP.example:443"} {
+		_, resp := dialWebSocket(t, srv, map[string]string{"Origin": origin})
+		if got, want := resp.StatusCode, http.StatusSwitchingProtocols; got != want {
+			t.Errorf("Origin %q: resp.StatusCode: got %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestWebSocketSameOrigin(t *testing.T) {
+	srv := newWebSocketServer(t, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.WriteWebSocket(w, r, echo)
+	}))
+	host := strings.TrimPrefix(srv.URL, "http://")
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		t.Fatalf("net.SplitHostPort(%q): %v", host, err)
+	}
+	tests := []struct {
+		name     string
+		origin   string
+		wantCode int
+	}{
+		{name: "Same origin", origin: srv.URL, wantCode: http.StatusSwitchingProtocols},
+		{name: "Upper case", origin: "HTTP://" + strings.ToUpper(host), wantCode: http.StatusSwitchingProtocols},
+		{name: "Other scheme", origin: "https://" + host, wantCode: http.StatusForbidden},
+		{name: "Other port", origin: "http://" + hostname, wantCode: http.StatusForbidden},
+		{name: "Not an origin", origin: "null", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, resp := dialWebSocket(t, srv, map[string]string{"Origin": tt.origin})
+			if got := resp.StatusCode; got != tt.wantCode {
+				t.Errorf("resp.StatusCode: got %v, want %v", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestWebSocketForwardedProto(t *testing.T) {
+	mb := safehttp.NewServeMuxConfig(nil)
+	mb.TrustProxies(safehttp.ProxyPolicy{Hops: 1})
+	mux := mb.Mux()
+	mux.Handle("/ws", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.WriteWebSocket(w, r, echo)
+	}))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	origin := "https://" + strings.TrimPrefix(srv.URL, "http://")
+	_, resp := dialWebSocket(t, srv, map[string]string{"Origin": origin, "X-Forwarded-Proto": "https"})
+	if got, want := resp.StatusCode, http.StatusSwitchingProtocols; got != want {
+		t.Errorf("resp.StatusCode: got %v, want %v", got, want)
+	}
+}
+
+func TestWebSocketReadLimit(t *testing.T) {
+	srv := newWebSocketServer(t, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehttp.WebSocketResponse{Request: r, ReadLimit: 4, Serve: echo})
+	}))
+	c, _ := dialWebSocket(t, srv, nil)
+
+	c.writeFrame(t, 0x1, []byte("too long"))
+	if op, payload := c.readFrame(t); op != 0x8 || binary.BigEndian.Uint16(payload) != 1009 {
+		t.Errorf("got opcode %d and payload %v, want close with status 1009", op, payload)
+	}
+}
+
+func TestWebSocketPing(t *testing.T) {
+	srv := newWebSocketServer(t, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(safehttp.WebSocketResponse{Request: r, PingInterval: 10 * time.Millisecond, Serve: echo})
+	}))
+	c, _ := dialWebSocket(t, srv, nil)
+
+	if op, _ := c.readFrame(t); op != 0x9 {
+		t.Errorf("got opcode %d, want ping", op)
+	}
+}
+
+func TestWebSocketInvalidHandshakeResponse(t *testing.T) {
+	rw := httptest.NewRecorder()
+	req := safehttp.NewIncomingRequest(httptest.NewRequest(http.MethodGet, "/ws", nil))
+	d := &safehttp.DefaultDispatcher{}
+	if err := d.Write(rw, safehttp.WebSocketResponse{Request: req}); err == nil {
+		t.Error("d.Write(rw, resp): got nil, want error")
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package raw is used to provide a bypass mechanism to implement the unchecked conversions package.
+// This package works as a proxy between safeurl and the uncheckedconversions package.
+//
+// The way it functions is to expect safeurl to provide the unexported constructor for TrustedURL at init() time.
+// Since this package is in internal/ it can only be imported by a parent package, so it is known at compile time that
+// this constructor is not unsafely passed around.
+package raw
+
+// TrustedURL is the constructor for a TrustedURL to be used by the unchecked conversions package.
+// This variable will be assigned by the safeurl package at init time.
+// The reason why this is an empty interface is to avoid cyclic dependency between safeurl and this package.
+var TrustedURL interface{}
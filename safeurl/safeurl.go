@@ -0,0 +1,135 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package safeurl provides TrustedURL, a URL that is known to be under the
+// control of the programmer, so that href and src values flowing into
+// templates and redirects can't be controlled by attackers. It follows the
+// same approach as the safesql package.
+//
+// TrustedURLs can only be constructed from compile-time constants. Untrusted
+// values can only be added as escaped path segments or query parameters, so
+// they can't change the scheme, host or the existing path of the URL:
+//
+//	u, err := safeurl.New("https://example.com/users").JoinPath(userID)
+//	if err != nil {
+//		...
+//	}
+//	u = u.WithQuery("tab", tab)
+//
+// If the URLs of the service are stored in a trusted runtime-only source that
+// cannot be controlled by a user, the uncheckedconversions package can be used
+// to assert that those strings are under the programmer control. Note that
+// unchecked conversions should be very limited, ideally never used, as they
+// pose a security risk.
+package safeurl
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safeurl/internal/raw"
+	"github.com/google/safehtml"
+	"github.com/google/safehtml/uncheckedconversions"
+)
+
+func init() {
+	// Initialize the bypass mechanism for unchecked conversions.
+	raw.TrustedURL = func(unsafe string) TrustedURL { return TrustedURL{unsafe} }
+}
+
+type stringConstant string
+
+// TrustedURL is a URL that is known to be safe and not controlled by
+// potentially malicious inputs.
+type TrustedURL struct {
+	s string
+}
+
+// New constructs a TrustedURL from a compile-time constant string.
+// Since the stringConstant type is unexported the only way to call this function outside of this package is to pass
+// a string literal or an untyped string const.
+func New(text stringConstant) TrustedURL { return TrustedURL{string(text)} }
+
+// String returns the URL.
+func (u TrustedURL) String() string {
+	return u.s
+}
+
+// split splits the URL in the part before the query or fragment and the rest.
+func (u TrustedURL) split() (base, rest string) {
+	if i := strings.IndexAny(u.s, "?#"); i >= 0 {
+		return u.s[:i], u.s[i:]
+	}
+	return u.s, ""
+}
+
+// JoinPath returns a TrustedURL with the given segments appended to the path,
+// each of them path-escaped, so that they can contain arbitrary values. It
+// returns an error if a segment is empty, "." or "..", as these would change
+// the meaning of the existing path.
+func (u TrustedURL) JoinPath(segments ...string) (TrustedURL, error) {
+	base, rest := u.split()
+	var b strings.Builder
+	b.WriteString(strings.TrimSuffix(base, "/"))
+	for _, s := range segments {
+		if s == "" || s == "." || s == ".." {
+			return TrustedURL{}, fmt.Errorf("invalid path segment %q", s)
+		}
+		b.WriteString("/")
+		b.WriteString(url.PathEscape(s))
+	}
+	b.WriteString(rest)
+	return TrustedURL{b.String()}, nil
+}
+
+// WithQuery returns a TrustedURL with the given query parameter appended,
+// with both the key and the value query-escaped.
+func (u TrustedURL) WithQuery(key, value string) TrustedURL {
+	s, fragment := u.s, ""
+	if i := strings.Index(s, "#"); i >= 0 {
+		s, fragment = s[:i], s[i:]
+	}
+	sep := "?"
+	if strings.Contains(s, "?") {
+		sep = "&"
+		if strings.HasSuffix(s, "?") || strings.HasSuffix(s, "&") {
+			sep = ""
+		}
+	}
+	return TrustedURL{s + sep + url.QueryEscape(key) + "=" + url.QueryEscape(value) + fragment}
+}
+
+// URL converts u to a safehtml.URL, to be used in templates, e.g. as an href
+// attribute. URLs with a scheme other than http, https or mailto are replaced
+// by an innocuous value, as safehtml.URLSanitized does.
+func (u TrustedURL) URL() safehtml.URL {
+	return safehtml.URLSanitized(u.s)
+}
+
+// TrustedResourceURL converts u to a safehtml.TrustedResourceURL, to be used
+// in templates as the source of scripts and other resources.
+func (u TrustedURL) TrustedResourceURL() safehtml.TrustedResourceURL {
+	// The URL is built from a compile-time constant or from strings known to
+	// be trusted, with untrusted values only added as escaped path segments
+	// and query parameters.
+	return uncheckedconversions.TrustedResourceURLFromStringKnownToSatisfyTypeContract(u.s)
+}
+
+// Redirect writes a redirect to u. If the given code is not a valid Redirect
+// code this function will panic.
+func Redirect(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, u TrustedURL, code safehttp.StatusCode) safehttp.Result {
+	return safehttp.Redirect(w, r, u.s, code)
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package safeurl
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+func TestJoinPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     TrustedURL
+		segments []string
+		want     string
+	}{
+		{
+			name:     "Plain segments",
+			base:     New("https://example.com/users"),
+			segments: []string{"alice", "notes"},
+			want:     "https://example.com/users/alice/notes",
+		},
+		{
+			name:     "Trailing slash",
+			base:     New("/users/"),
+			segments: []string{"alice"},
+			want:     "/users/alice",
+		},
+		{
+			name:     "Escaped segments",
+			base:     New("https://example.com/users"),
+			segments: []string{"a/b?c#d", "%2e%2e", "//evil.com"},
+			want:     "https://example.com/users/a%2Fb%3Fc%23d/%252e%252e/%2F%2Fevil.com",
+		},
+		{
+			name:     "Query and fragment are kept",
+			base:     New("/search?q=1#results"),
+			segments: []string{"all"},
+			want:     "/search/all?q=1#results",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.base.JoinPath(tt.segments...)
+			if err != nil {
+				t.Fatalf("JoinPath: got error %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("JoinPath: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoinPathInvalid(t *testing.T) {
+	for _, s := range []string{"", ".", ".."} {
+		if _, err := New("/users").JoinPath("alice", s); err == nil {
+			t.Errorf("JoinPath(%q): got nil, want error", s)
+		}
+	}
+}
+
+func TestWithQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		base       TrustedURL
+		key, value string
+		want       string
+	}{
+		{
+			name:  "No query",
+			base:  New("https://example.com/search"),
+			key:   "q",
+			value: "a&b=c",
+			want:  "https://example.com/search?q=a%26b%3Dc",
+		},
+		{
+			name:  "Existing query",
+			base:  New("/search?lang=en"),
+			key:   "q",
+			value: "x y",
+			want:  "/search?lang=en&q=x+y",
+		},
+		{
+			name:  "Trailing question mark",
+			base:  New("/search?"),
+			key:   "q",
+			value: "x",
+			want:  "/search?q=x",
+		},
+		{
+			name:  "Fragment",
+			base:  New("/search#top"),
+			key:   "q",
+			value: "#x",
+			want:  "/search?q=%23x#top",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.base.WithQuery(tt.key, tt.value); got.String() != tt.want {
+				t.Errorf("WithQuery: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafeHTMLConversions(t *testing.T) {
+	u := New("https://example.com/script.js")
+	if got, want := u.URL().String(), "https://example.com/script.js"; got != want {
+		t.Errorf("u.URL(): got %q, want %q", got, want)
+	}
+	if got, want := u.TrustedResourceURL().String(), "https://example.com/script.js"; got != want {
+		t.Errorf("u.TrustedResourceURL(): got %q, want %q", got, want)
+	}
+	if got, want := New("javascript:alert(1)").URL().String(), "about:invalid#zGoSafez"; got != want {
+		t.Errorf("u.URL(): got %q, want %q", got, want)
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	m := safehttp.NewServeMuxConfig(nil).Mux()
+	m.Handle("/", safehttp.MethodGet, safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		u, err := New("https://accounts.example.com/users").JoinPath("alice")
+		if err != nil {
+			return w.WriteError(safehttp.StatusBadRequest)
+		}
+		return Redirect(w, r, u, safehttp.StatusSeeOther)
+	}))
+	rr := httptest.NewRecorder()
+	m.ServeHTTP(rr, httptest.NewRequest(safehttp.MethodGet, "https://foo.com/", nil))
+
+	if got, want := rr.Code, int(safehttp.StatusSeeOther); got != want {
+		t.Errorf("rr.Code: got %v, want %v", got, want)
+	}
+	if got, want := rr.Header().Get("Location"), "https://accounts.example.com/users/alice"; got != want {
+		t.Errorf("Location: got %q, want %q", got, want)
+	}
+}
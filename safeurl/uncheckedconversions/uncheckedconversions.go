@@ -0,0 +1,33 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package uncheckedconversions provides functions to create values of package safeurl types from plain strings.
+// Uses of these functions could potentially result in instances of safeurl types that violate their type contracts, and hence result in security vulnerabilities.
+package uncheckedconversions
+
+import (
+	"github.com/google/go-safeweb/safeurl"
+	"github.com/google/go-safeweb/safeurl/internal/raw"
+)
+
+var trustedURLCtor = raw.TrustedURL.(func(string) safeurl.TrustedURL)
+
+// TrustedURLFromStringKnownToSatisfyTypeContract promotes the given string to a trusted URL.
+// Only strings known to be under the programmer control should be passed to this function.
+//
+// One example of correct use of this function would be to cast a URL that was retrieved from a configuration storage
+// under the programmer control, which user input cannot be put into.
+func TrustedURLFromStringKnownToSatisfyTypeContract(trusted string) safeurl.TrustedURL {
+	return trustedURLCtor(trusted)
+}
@@ -64,7 +64,8 @@ func TestMuxDefaultDispatcher(t *testing.T) {
 				return safehttp.WriteJSON(w, data)
 			}),
 			wantHeaders: map[string][]string{
-				"Content-Type": {"application/json; charset=utf-8"},
+				"Content-Type":           {"application/json; charset=utf-8"},
+				"X-Content-Type-Options": {"nosniff"},
 			},
 			wantBody: ")]}',\n{\"field\":\"myField\"}\n",
 		},